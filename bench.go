@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/upload"
+	"github.com/thatisuday/commando"
+)
+
+// benchUpload is the outcome of one synthetic file posted by bench.
+type benchUpload struct {
+	size    int64
+	latency time.Duration
+	id      string
+	err     error
+}
+
+// bench posts --files synthetic files of the --sizes to the scratch ticket
+// given by --ticket, --concurrency at a time, and reports the throughput and
+// upload latency percentiles, overall and per size, so operators can predict
+// how long the pending uploads of the database will take. The files are
+// removed from the ticket afterwards unless --keep is given.
+func bench(flags map[string]commando.FlagValue) error {
+	jiraURL := flags["jira-url"].Value.(string)
+	jiraSecret := flags["jira-secret"].Value.(string)
+	ticket := flags["ticket"].Value.(string)
+	count := flags["files"].Value.(int)
+	concurrency := flags["concurrency"].Value.(int)
+	keep := flags["keep"].Value.(bool)
+
+	if ticket == "none" {
+		return configError(fmt.Errorf("--ticket must be specified"))
+	}
+	if count < 1 {
+		return configError(fmt.Errorf("invalid --files %d, must be at least 1", count))
+	}
+	if concurrency < 1 {
+		return configError(fmt.Errorf("invalid --concurrency %d, must be at least 1", concurrency))
+	}
+	var sizes []int64
+	for _, size := range strings.Split(flags["sizes"].Value.(string), ",") {
+		parsed, err := parseSize(strings.TrimSpace(size))
+		if err != nil {
+			return configError(fmt.Errorf("invalid --sizes: %w", err))
+		}
+		if parsed < 1 {
+			return configError(fmt.Errorf("invalid --sizes %q, every size must be at least 1 byte", size))
+		}
+		sizes = append(sizes, parsed)
+	}
+
+	jiraClient, err := newJIRAClient(jiraSecret, jiraURL)
+	if err != nil {
+		return configError(err)
+	}
+	target := client.NewJIRATarget(client.NewJIRA(jiraClient))
+
+	meta, err := getAttachmentMeta(jiraClient)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for i := 0; i < count; i++ {
+		size := sizes[i%len(sizes)]
+		if meta.UploadLimit > 0 && size > meta.UploadLimit {
+			return fmt.Errorf("--sizes includes %s, over the JIRA upload limit of %s", formatSize(size), formatSize(meta.UploadLimit))
+		}
+		total += size
+	}
+
+	changes := []string{fmt.Sprintf("upload %d synthetic files totaling %s to %s", count, formatSize(total), ticket)}
+	if !keep {
+		changes = append(changes, "delete them again once measured")
+	}
+	err = confirmChanges(&changeSummary{instance: jiraURL, projects: []string{keyProject(ticket)}, changes: changes}, flags["yes"].Value.(bool))
+	if err != nil {
+		return err
+	}
+
+	contents := make(map[int64][]byte)
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, size := range sizes {
+		if _, ok := contents[size]; !ok {
+			// Random content keeps compressing proxies from flattering the
+			// throughput.
+			contents[size] = make([]byte, size)
+			random.Read(contents[size])
+		}
+	}
+
+	fmt.Printf("Uploading %d files totaling %s to %s, %d at a time\n", count, formatSize(total), ticket, concurrency)
+	jobs := make(chan int)
+	results := make([]*benchUpload, count)
+	var wg sync.WaitGroup
+	started := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				size := sizes[i%len(sizes)]
+				name := fmt.Sprintf("bench-%s-%03d.bin", audit.RunID, i+1)
+				start := time.Now()
+				attached, err := target.PostAttachment(context.Background(), ticket, bytes.NewReader(contents[size]), name)
+				result := &benchUpload{size: size, latency: time.Since(start), err: err}
+				if err == nil {
+					result.id = attached.ID
+				}
+				results[i] = result
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	var uploaded []*benchUpload
+	for i, result := range results {
+		if result.err != nil {
+			fmt.Printf("Failed uploading file %d of %s: %s\n", i+1, formatSize(result.size), result.err)
+			continue
+		}
+		uploaded = append(uploaded, result)
+	}
+
+	if !keep {
+		fmt.Printf("Removing the benchmark files from %s\n", ticket)
+		for _, result := range uploaded {
+			err := target.DeleteAttachment(context.Background(), ticket, result.id)
+			if err != nil {
+				fmt.Printf("Failed removing attachment %s from %s: %s\n", result.id, ticket, err)
+			}
+		}
+	}
+
+	if len(uploaded) == 0 {
+		return fmt.Errorf("none of the %d files uploaded", count)
+	}
+	throughput := reportBench(uploaded, sizes, elapsed)
+	estimateMigration(throughput)
+	if len(uploaded) < count {
+		return partialFailure(fmt.Errorf("%d of the %d files failed to upload", count-len(uploaded), count))
+	}
+	return nil
+}
+
+// reportBench prints the throughput and latency percentiles of the uploads,
+// overall and per size when there are several, and returns the overall
+// throughput in bytes per second.
+func reportBench(uploaded []*benchUpload, sizes []int64, elapsed time.Duration) float64 {
+	var bytes int64
+	for _, result := range uploaded {
+		bytes += result.size
+	}
+	throughput := float64(bytes) / elapsed.Seconds()
+	fmt.Printf("\nUploaded %d files, %s, in %s\n", len(uploaded), formatSize(bytes), elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput: %s/s, %.1f files/s\n", formatSize(int64(throughput)), float64(len(uploaded))/elapsed.Seconds())
+	fmt.Printf("%-12s %6s %10s %10s %10s %10s\n", "Size", "Files", "p50", "p90", "p99", "Max")
+	printLatencies := func(label string, results []*benchUpload) {
+		var latencies []time.Duration
+		for _, result := range results {
+			latencies = append(latencies, result.latency)
+		}
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+		fmt.Printf("%-12s %6d %10s %10s %10s %10s\n", label, len(latencies),
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), latencies[len(latencies)-1].Round(time.Millisecond))
+	}
+	seen := make(map[int64]bool)
+	var distinct []int64
+	for _, size := range sizes {
+		if !seen[size] {
+			seen[size] = true
+			distinct = append(distinct, size)
+		}
+	}
+	if len(distinct) > 1 {
+		for _, size := range distinct {
+			var results []*benchUpload
+			for _, result := range uploaded {
+				if result.size == size {
+					results = append(results, result)
+				}
+			}
+			if len(results) > 0 {
+				printLatencies(formatSize(size), results)
+			}
+		}
+	}
+	printLatencies("All", uploaded)
+	return throughput
+}
+
+// percentile returns the nearest-rank percentile of the sorted latencies.
+func percentile(latencies []time.Duration, p int) time.Duration {
+	rank := (p*len(latencies) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return latencies[rank-1].Round(time.Millisecond)
+}
+
+// estimateMigration prints how long the attachments pending in the database
+// would take to upload at the throughput measured, when there is a database.
+func estimateMigration(throughput float64) {
+	db, err := store.Load()
+	if err != nil {
+		return
+	}
+	filter, err := store.ParseIssueFilter("all", "none")
+	if err != nil {
+		return
+	}
+	var pending int64
+	for _, attachments := range upload.Pending(db, filter) {
+		for _, attachment := range attachments {
+			size, err := attachmentSize(attachment, db.Unstaged)
+			if err != nil {
+				continue
+			}
+			pending += size
+		}
+	}
+	if pending == 0 {
+		return
+	}
+	estimate := time.Duration(float64(pending) / throughput * float64(time.Second))
+	if estimate >= time.Minute {
+		estimate = estimate.Round(time.Second)
+	}
+	fmt.Printf("At this throughput the %s pending in %s would take about %s to upload\n", formatSize(pending), store.DatabaseFile, estimate.Round(time.Millisecond))
+}
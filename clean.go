@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/download"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// cleanedStateFiles are the workspace state files kept with the database that
+// clean --db removes along with it. The audit log and the snapshots are kept,
+// so the run can still be accounted for and the database restored.
+var cleanedStateFiles = []string{
+	collect.ArchiveChecksumsFile,
+	rewritesFile,
+	renamesFile,
+	githubCache,
+}
+
+// cleanTarget is a file or directory clean is about to remove.
+type cleanTarget struct {
+	what  string
+	path  string
+	files int
+	bytes int64
+}
+
+func (t *cleanTarget) String() string {
+	if t.files == 1 {
+		return fmt.Sprintf("delete the %s %s (%s)", t.what, t.path, formatSize(t.bytes))
+	}
+	return fmt.Sprintf("delete the %s %s (%d files, %s)", t.what, t.path, t.files, formatSize(t.bytes))
+}
+
+// clean removes the parts of the workspace selected by --stage, --archive,
+// --db, or --all once the operator confirms them. The staging directory and
+// the database are only removed while attachments are still waiting to
+// upload when --force is passed, as the staged files and the database are
+// what the remaining uploads need.
+func clean(flags map[string]commando.FlagValue) error {
+	all := flags["all"].Value.(bool)
+	stage := all || flags["stage"].Value.(bool)
+	archive := all || flags["archive"].Value.(bool)
+	database := all || flags["db"].Value.(bool)
+	if !stage && !archive && !database {
+		return configError(fmt.Errorf("nothing to clean, pass --stage, --archive, --db, or --all"))
+	}
+
+	if (stage || database) && !flags["force"].Value.(bool) {
+		err := checkUploadsFinished(stage, database)
+		if err != nil {
+			return err
+		}
+	}
+
+	var targets []*cleanTarget
+	add := func(what, path string) error {
+		target, err := measureTarget(what, path)
+		if err != nil || target == nil {
+			return err
+		}
+		targets = append(targets, target)
+		return nil
+	}
+	if stage {
+		err := add("staging directory", store.StageDir)
+		if err != nil {
+			return err
+		}
+	}
+	if archive {
+		err := add("archive directory", archiveDir)
+		if err != nil {
+			return err
+		}
+		err = add("download directory", download.CacheDir)
+		if err != nil {
+			return err
+		}
+	}
+	if database {
+		err := add("database", store.DatabaseFile)
+		if err != nil {
+			return err
+		}
+		for _, name := range cleanedStateFiles {
+			err = add("state file", store.StatePath(name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Println("Nothing to clean")
+		return nil
+	}
+
+	workspace, err := filepath.Abs(filepath.Dir(store.DatabaseFile))
+	if err != nil {
+		return fmt.Errorf("failed resolving workspace: %w", err)
+	}
+	changes := make([]string, len(targets))
+	for i, target := range targets {
+		err = checkCleanable(target.path, workspace)
+		if err != nil {
+			return err
+		}
+		changes[i] = target.String()
+	}
+	err = confirmChanges(&changeSummary{instance: "the workspace in " + workspace, changes: changes}, flags["yes"].Value.(bool))
+	if err != nil {
+		return err
+	}
+
+	if database {
+		path, err := takeSnapshot("none", "before clean")
+		if err != nil {
+			return fmt.Errorf("failed snapshotting workspace: %w", err)
+		}
+		if path != "" {
+			fmt.Printf("Saved workspace state to %s\n", path)
+		}
+	}
+
+	var freed int64
+	for _, target := range targets {
+		fmt.Printf("Deleting %s\n", target.path)
+		err = os.RemoveAll(target.path)
+		if err != nil {
+			return fmt.Errorf("failed deleting %s: %w", target.path, err)
+		}
+		freed += target.bytes
+	}
+	fmt.Printf("Freed %s\n", formatSize(freed))
+	return nil
+}
+
+// checkUploadsFinished fails when the database still has attachments
+// waiting to upload, or quarantined after failing, that removing the
+// staging directory or the database would strand.
+func checkUploadsFinished(stage, database bool) error {
+	if _, err := os.Stat(store.DatabaseFile); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+	// Attachments streamed out of the archive do not need the staged files.
+	if !database && db.Unstaged {
+		return nil
+	}
+	s := summarizeStatus(db)
+	if s.Pending == 0 && s.Failed == 0 {
+		return nil
+	}
+	what := "the staging directory"
+	if database {
+		what = "the database"
+	}
+	return fmt.Errorf("the database still has %d attachments pending upload and %d failed, which %s is needed to upload; finish the migration or pass --force to delete it anyway", s.Pending, s.Failed, what)
+}
+
+// measureTarget counts the files and bytes at path, returning nil when there
+// is nothing there to remove.
+func measureTarget(what, path string) (*cleanTarget, error) {
+	target := &cleanTarget{what: what, path: path}
+	err := filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		target.files++
+		target.bytes += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed measuring %s: %w", path, err)
+	}
+	return target, nil
+}
+
+// checkCleanable refuses to remove a directory holding the workspace itself,
+// such as a staging directory configured as the working directory.
+func checkCleanable(path, workspace string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed resolving %s: %w", path, err)
+	}
+	if abs == workspace || strings.HasPrefix(workspace, abs+string(filepath.Separator)) {
+		return configError(fmt.Errorf("refusing to delete %s, which holds the workspace in %s", path, workspace))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// jiraTimeLayout is the layout of timestamps returned by the JIRA REST API.
+const jiraTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// getServerClock reads the server time and estimates the skew against the
+// local clock at the midpoint of the request.
+func getServerClock(client *jira.Client) (*store.ServerClock, error) {
+	req, err := client.NewRequest("GET", "rest/api/2/serverInfo", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request: %w", err)
+	}
+	var info struct {
+		ServerTime string `json:"serverTime"`
+	}
+	start := time.Now()
+	resp, err := client.Do(req, &info)
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving server info: %w", jiraRefused(resp, err))
+	}
+	end := time.Now()
+
+	serverTime, err := time.Parse(jiraTimeLayout, info.ServerTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing server time %q: %w", info.ServerTime, err)
+	}
+	local := start.Add(end.Sub(start) / 2)
+	_, offset := serverTime.Zone()
+	return &store.ServerClock{
+		Zone:    serverTime.Format("-07:00"),
+		Offset:  offset,
+		Skew:    serverTime.Sub(local).Round(time.Second),
+		Checked: local.UTC(),
+	}, nil
+}
+
+// checkServerClock records the server clock in the database and warns when
+// it is skewed from the local clock.
+func checkServerClock(client *jira.Client, db *store.Database) (*store.ServerClock, error) {
+	clock, err := getServerClock(client)
+	if err != nil {
+		return nil, err
+	}
+	db.ServerClock = clock
+	if clock.Skewed() {
+		fmt.Printf("Warning: the JIRA server clock is %s from the local clock, timestamps are reported in server time\n", clock.Skew)
+	}
+	return clock, nil
+}
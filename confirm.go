@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/upload"
+	"github.com/thatisuday/commando"
+	"golang.org/x/term"
+)
+
+// changeSummary describes what a run is about to change on the tracker, for
+// the operator to confirm before anything is changed.
+type changeSummary struct {
+	instance string
+	projects []string
+	changes  []string
+}
+
+// confirmChanges prints the instance, projects, and changes of the summary
+// and asks the operator to confirm them, unless yes is set by --yes. Runs
+// without a terminal to ask on must pass --yes, so automation never blocks
+// on the prompt.
+func confirmChanges(summary *changeSummary, yes bool) error {
+	scope := ""
+	switch len(summary.projects) {
+	case 0:
+	case 1:
+		scope = " in project " + summary.projects[0]
+	default:
+		scope = " in projects " + strings.Join(summary.projects, ", ")
+	}
+	// The changes are shown even with -q when they are to be confirmed.
+	out := io.Writer(os.Stdout)
+	if !yes {
+		out = output.Console
+	}
+	fmt.Fprintf(out, "This run will change %s%s:\n", summary.instance, scope)
+	for _, change := range summary.changes {
+		fmt.Fprintf(out, "  %s\n", change)
+	}
+	if yes {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("standard input is not a terminal to confirm the changes on, pass --yes to make them without confirmation")
+	}
+	fmt.Fprint(out, "Proceed? [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed reading confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("the changes were not confirmed")
+}
+
+// confirmUpload asks the operator to confirm the tickets created and the
+// attachments uploaded by upload, counted from the plan when one is
+// executed. Nothing is asked when there is nothing to upload.
+func confirmUpload(flags map[string]commando.FlagValue, db *store.Database, filter *store.IssueFilter, approved *uploadPlan, creator *ticketCreator) error {
+	summary := &changeSummary{}
+	projects := make(map[string]bool)
+	uploads, bytes, tickets := 0, int64(0), 0
+	if approved != nil {
+		uploads, bytes, tickets = approved.Uploads, approved.Bytes, len(approved.Tickets)
+		for _, planned := range approved.Tickets {
+			projects[keyProject(planned.Ticket)] = true
+		}
+	} else {
+		pending := upload.Pending(db, filter)
+		switch {
+		case flags["retry-failed"].Value.(bool):
+			pending = upload.Failed(db, filter)
+		case flags["rescreen"].Value.(bool):
+			pending = upload.Flagged(db, filter)
+		}
+		for title, attachments := range pending {
+			for _, attachment := range attachments {
+				size, err := attachmentSize(attachment, db.Unstaged)
+				if err != nil {
+					return err
+				}
+				bytes += size
+			}
+			uploads += len(attachments)
+			tickets++
+			projects[keyProject(db.Tickets[title].Key)] = true
+		}
+	}
+
+	created := 0
+	if creator != nil {
+		missing := missingTickets(db, filter)
+		created = len(missing)
+		for _, attachment := range db.Attachments {
+			if missing[attachment.IssueNumber] && !attachment.Deleted && attachment.DuplicateOf == "" && attachment.Failure == nil && attachment.Flagged == nil {
+				size, err := attachmentSize(attachment, db.Unstaged)
+				if err != nil {
+					return err
+				}
+				bytes += size
+				uploads++
+			}
+		}
+		if created > 0 {
+			projects[creator.project] = true
+			summary.changes = append(summary.changes, fmt.Sprintf("create %d tickets for unmatched issues", created))
+		}
+	}
+	if uploads == 0 && created == 0 {
+		return nil
+	}
+	summary.changes = append(summary.changes, fmt.Sprintf("upload %d attachments totaling %s to %d tickets", uploads, formatSize(bytes), tickets+created))
+
+	switch db.TargetName() {
+	case store.TargetJIRA:
+		summary.instance = flags["jira-url"].Value.(string)
+		summary.projects = sortedKeys(projects)
+	case store.TargetConfluence:
+		summary.instance = flags["confluence-url"].Value.(string)
+		summary.projects = []string{flags["confluence-space"].Value.(string)}
+	default:
+		summary.instance = flags["azure-devops-url"].Value.(string)
+		summary.projects = []string{flags["azure-devops-project"].Value.(string)}
+	}
+	return confirmChanges(summary, flags["yes"].Value.(bool))
+}
+
+// keyProject returns the project of a JIRA ticket key such as PROJ-12.
+func keyProject(key string) string {
+	if i := strings.LastIndex(key, "-"); i > 0 {
+		return key[:i]
+	}
+	return key
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/upload"
+)
+
+// contentMigration copies the archived descriptions and comments of the
+// GitHub issues onto their tickets as comments, uploading the attachments of
+// each post right after it so the ticket history reads in order.
+type contentMigration struct {
+	posts map[int][]*collect.Post
+	clock *store.ServerClock
+	users userMap
+}
+
+// newContentMigration loads the archived posts of the issues of the pending
+// tickets, whose authors are mentioned by the JIRA users they map to.
+func newContentMigration(db *store.Database, pending map[string][]*store.Attachment, clock *store.ServerClock, users userMap) (*contentMigration, error) {
+	numbers := make(map[int]bool)
+	for title := range pending {
+		numbers[db.Issues[title].Number] = true
+	}
+	posts, err := collect.LoadPosts(numbers)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading archived issue content: %w", err)
+	}
+	return &contentMigration{posts: posts, clock: clock, users: users}, nil
+}
+
+// migrate posts each description and comment of the ticket's issue not
+// already copied, followed by the attachments referenced from it, then the
+// attachments belonging to no post. Each post is recorded on the ticket once
+// copied, so a retried ticket does not copy it again, and the ticket is only
+// marked uploaded once all of them are through.
+func (m *contentMigration) migrate(jiraClient *jira.Client, target client.Target, db *store.Database, title string, attachments []*store.Attachment, opts *upload.Options) error {
+	ticket := db.Tickets[title]
+	posts := m.posts[db.Issues[title].Number]
+	if len(posts) == 0 {
+		fmt.Printf("No archived description or comments of GitHub issue %d to copy to %s\n", db.Issues[title].Number, ticket.Key)
+	}
+
+	byPost := make(map[string][]*store.Attachment)
+	for _, attachment := range attachments {
+		byPost[attachment.URL] = append(byPost[attachment.URL], attachment)
+	}
+
+	failed := false
+	uploadGroup := func(group []*store.Attachment) error {
+		if len(group) == 0 {
+			return nil
+		}
+		err := upload.Ticket(target, db, title, group, opts)
+		if err != nil && !opts.ContinueOnError {
+			return err
+		}
+		failed = failed || err != nil
+		ticket.Uploaded = false
+		return nil
+	}
+
+	for _, post := range posts {
+		if _, ok := ticket.Posts[post.URL]; !ok {
+			fmt.Printf("Copying %s to %s\n", post.URL, ticket.Key)
+			comment, resp, err := jiraClient.Issue.AddComment(ticket.Key, &jira.Comment{Body: m.body(post)})
+			if err != nil {
+				return fmt.Errorf("failed copying %s to %s: %w", post.URL, ticket.Key, jiraRefused(resp, err))
+			}
+			err = audit.Record(&audit.Entry{Action: audit.ActionCommented, Ticket: ticket.Key, ID: comment.ID, Detail: post.URL})
+			if err != nil {
+				return err
+			}
+			if ticket.Posts == nil {
+				ticket.Posts = make(map[string]string)
+			}
+			ticket.Posts[post.URL] = comment.ID
+			err = store.Save(db)
+			if err != nil {
+				return err
+			}
+		}
+		err := uploadGroup(byPost[post.URL])
+		if err != nil {
+			return err
+		}
+		delete(byPost, post.URL)
+	}
+
+	var rest []*store.Attachment
+	for _, attachment := range attachments {
+		if _, ok := byPost[attachment.URL]; ok {
+			rest = append(rest, attachment)
+		}
+	}
+	err := uploadGroup(rest)
+	if err != nil {
+		return err
+	}
+
+	ticket.Uploaded = !failed && upload.Complete(db, title)
+	err = store.Save(db)
+	if err != nil {
+		return err
+	}
+	if failed {
+		return fmt.Errorf("%d attachments failed to upload to %s", countFailed(attachments), ticket.Key)
+	}
+	return nil
+}
+
+// body renders the post as a JIRA comment prefixed with its author, mentioned
+// when they map to a JIRA user, and when it was written, in the server's time
+// zone.
+func (m *contentMigration) body(post *collect.Post) string {
+	action := "wrote"
+	if post.Comment {
+		action = "commented"
+	}
+	written := post.Created.In(m.clock.Location()).Format(time.RFC3339)
+	return fmt.Sprintf("%s %s on [%s|%s]:\n\n%s", m.users.mention(post.Author), action, written, post.URL, post.Body)
+}
+
+// countFailed returns how many of the attachments are quarantined.
+func countFailed(attachments []*store.Attachment) int {
+	failed := 0
+	for _, attachment := range attachments {
+		if attachment.Failure != nil {
+			failed++
+		}
+	}
+	return failed
+}
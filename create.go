@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// defaultTicketTemplate is the description of created tickets when no
+// --template is given, linking back to the GitHub issue in JIRA markup.
+const defaultTicketTemplate = `Migrated from GitHub issue [#{{.Number}}|{{.URL}}].`
+
+// maxSummaryLength is the longest summary JIRA accepts.
+const maxSummaryLength = 255
+
+// ticketCreator creates JIRA tickets for the issues with attachments that
+// matched no ticket, so their attachments have somewhere to go.
+type ticketCreator struct {
+	project     string
+	issueType   string
+	description *template.Template
+	// users maps the authors of the issues to the reporters of their tickets.
+	users userMap
+}
+
+// ticketFields are the fields of the GitHub issue the description template
+// is executed with.
+type ticketFields struct {
+	Title  string
+	URL    string
+	Number int
+	Labels []string
+}
+
+// newTicketCreator reads the --create-missing, --project, --issue-type, and
+// --template flags of upload, returning nil when tickets are not created.
+func newTicketCreator(flags map[string]commando.FlagValue, users userMap) (*ticketCreator, error) {
+	project := flags["project"].Value.(string)
+	issueType := flags["issue-type"].Value.(string)
+	templatePath := flags["template"].Value.(string)
+	if !flags["create-missing"].Value.(bool) {
+		if project != "none" || templatePath != "none" {
+			return nil, fmt.Errorf("--project and --template only apply to --create-missing")
+		}
+		return nil, nil
+	}
+	if project == "none" {
+		return nil, fmt.Errorf("--project must be specified with --create-missing")
+	}
+
+	text := defaultTicketTemplate
+	if templatePath != "none" {
+		bytes, err := os.ReadFile(templatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading ticket template: %w", err)
+		}
+		text = string(bytes)
+	}
+	description, err := template.New("ticket").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticket template: %w", err)
+	}
+	return &ticketCreator{
+		project:     strings.ToUpper(project),
+		issueType:   issueType,
+		description: description,
+		users:       users,
+	}, nil
+}
+
+// createMissing creates a ticket for each issue passing the filter that has
+// attachments to upload but no ticket, summarized by the issue title,
+// labelled with its labels, and reported by the JIRA user its author maps to
+// when there is one, and links it to the issue in the database. The
+// database is saved after each ticket, so an interrupted run does not create
+// it again.
+func (c *ticketCreator) createMissing(client *jira.Client, db *store.Database, filter *store.IssueFilter) (int, error) {
+	missing := missingTickets(db, filter)
+	var posts map[int][]*collect.Post
+	if c.users != nil && len(missing) > 0 {
+		var err error
+		posts, err = collect.LoadPosts(missing)
+		if err != nil {
+			return 0, fmt.Errorf("failed loading archived issue authors: %w", err)
+		}
+	}
+
+	created := 0
+	for _, title := range sortedKeys(db.Issues) {
+		issue := db.Issues[title]
+		if _, ok := db.Tickets[title]; ok || !missing[issue.Number] {
+			continue
+		}
+		var description strings.Builder
+		err := c.description.Execute(&description, &ticketFields{
+			Title:  title,
+			URL:    issue.URL,
+			Number: issue.Number,
+			Labels: issue.Labels,
+		})
+		if err != nil {
+			return created, fmt.Errorf("failed rendering ticket description of issue %d: %w", issue.Number, err)
+		}
+
+		fields := &jira.IssueFields{
+			Project:     jira.Project{Key: c.project},
+			Type:        jira.IssueType{Name: c.issueType},
+			Summary:     ticketSummary(title),
+			Description: description.String(),
+			Labels:      jiraLabels(issue.Labels),
+		}
+		if reporter := c.users.reporter(issueAuthor(posts[issue.Number])); reporter != nil {
+			fields.Unknowns = map[string]interface{}{"reporter": reporter}
+		}
+		ticket, resp, err := client.Issue.Create(&jira.Issue{Fields: fields})
+		if err != nil {
+			return created, fmt.Errorf("failed creating ticket for issue %d: %w", issue.Number, jiraRefused(resp, err))
+		}
+		fmt.Printf("Created %s for GitHub issue %d\n", ticket.Key, issue.Number)
+		err = audit.Record(&audit.Entry{Action: audit.ActionTicketCreated, Ticket: ticket.Key, Detail: issue.URL})
+		if err != nil {
+			return created, err
+		}
+		db.Tickets[title] = &store.Ticket{Key: ticket.Key}
+		created++
+		err = store.Save(db)
+		if err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// missingTickets returns the numbers of the issues passing the filter that
+// have attachments to upload but no ticket.
+func missingTickets(db *store.Database, filter *store.IssueFilter) map[int]bool {
+	withAttachments := make(map[int]bool)
+	for _, attachment := range db.Attachments {
+		if !attachment.Deleted && attachment.DuplicateOf == "" {
+			withAttachments[attachment.IssueNumber] = true
+		}
+	}
+
+	missing := make(map[int]bool)
+	for title, issue := range db.Issues {
+		if _, ok := db.Tickets[title]; !ok && withAttachments[issue.Number] && filter.Allows(issue.Number) {
+			missing[issue.Number] = true
+		}
+	}
+	return missing
+}
+
+// issueAuthor returns the login of the author of the issue's description
+// among its posts, or an empty login when it was not archived.
+func issueAuthor(posts []*collect.Post) string {
+	for _, post := range posts {
+		if !post.Comment {
+			return post.Author
+		}
+	}
+	return ""
+}
+
+// ticketSummary returns the issue title as a JIRA summary, which is a single
+// line of at most maxSummaryLength characters.
+func ticketSummary(title string) string {
+	summary := []rune(strings.Join(strings.Fields(title), " "))
+	if len(summary) > maxSummaryLength {
+		summary = summary[:maxSummaryLength]
+	}
+	return string(summary)
+}
+
+// jiraLabels returns the GitHub labels as JIRA labels, which cannot contain
+// spaces.
+func jiraLabels(labels []string) []string {
+	var converted []string
+	for _, label := range labels {
+		label = strings.Join(strings.Fields(label), "-")
+		if label != "" {
+			converted = append(converted, label)
+		}
+	}
+	return converted
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// dashboardPage is the single page dashboard served by serve, which polls
+// the API for the status of the workspace it is pointed at.
+//
+//go:embed dashboard/index.html
+var dashboardPage []byte
+
+// issueMatch is a GitHub issue with attachments and the ticket it matched,
+// empty when it matched none.
+type issueMatch struct {
+	Issue       int    `json:"issue"`
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	Ticket      string `json:"ticket,omitempty"`
+	Attachments int    `json:"attachments"`
+}
+
+func (s *jobServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no such endpoint %s", r.URL.Path))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardPage)
+}
+
+// handleIssues lists the issues with attachments in the database given by
+// the db-path query parameter, and the tickets they matched.
+func (s *jobServer) handleIssues(w http.ResponseWriter, r *http.Request) {
+	db, err := s.loadWorkspace(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	attachments := make(map[int]int)
+	for _, attachment := range db.Attachments {
+		if !attachment.Deleted {
+			attachments[attachment.IssueNumber]++
+		}
+	}
+	issues := []*issueMatch{}
+	for title, issue := range db.Issues {
+		if attachments[issue.Number] == 0 {
+			continue
+		}
+		match := &issueMatch{Issue: issue.Number, Title: title, URL: issue.URL, Attachments: attachments[issue.Number]}
+		if ticket, ok := db.Tickets[title]; ok {
+			match.Ticket = ticket.Key
+		}
+		issues = append(issues, match)
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].Issue < issues[j].Issue
+	})
+	writeJSON(w, http.StatusOK, issues)
+}
+
+// retryParams are the flags of the upload job retrying the failed uploads of
+// the ticket. The JIRA secret serve was started with is one of them, so
+// commandLine passes it to the job in its environment rather than on its
+// command line.
+func (s *jobServer) retryParams(key string, query url.Values) *rpcParams {
+	flags := map[string]interface{}{
+		"retry-failed": true,
+		"ticket":       key,
+		"yes":          true,
+	}
+	for name, value := range s.jira {
+		flags[name] = value
+	}
+	for _, name := range []string{"db-path", "stage-dir"} {
+		if value := query.Get(name); value != "" {
+			flags[name] = value
+		}
+	}
+	return &rpcParams{Flags: flags}
+}
+
+// handleRetry starts an upload job retrying the failed uploads of the ticket
+// in the workspace given by the db-path and stage-dir query parameters, with
+// the JIRA flags serve was started with.
+func (s *jobServer) handleRetry(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST to retry a ticket")
+		return
+	}
+	if s.jira["jira-url"] == "" || s.jira["jira-secret"] == "" {
+		writeError(w, http.StatusConflict, "serve must be started with --jira-url and --jira-secret to retry uploads")
+		return
+	}
+	db, err := s.loadWorkspace(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if db.TargetName() != store.TargetJIRA {
+		writeError(w, http.StatusConflict, fmt.Sprintf("uploads can only be retried from the dashboard for the jira target, not %s", db.TargetName()))
+		return
+	}
+
+	params := s.retryParams(key, r.URL.Query())
+	err = s.checkJob("upload", params)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	args, env, err := params.commandLine("upload")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	j := s.start("upload", nil, params.Flags, args, env)
+	s.mu.Lock()
+	snapshot := j.snapshot()
+	s.mu.Unlock()
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+func dbCommand(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) error {
+	action := args["action"].Value
+	output := flags["output"].Value.(string)
+	if output != "text" && output != "json" {
+		return configError(fmt.Errorf("invalid output format %q, must be text or json", output))
+	}
+	var files []string
+	if args["files"].Value != "" {
+		files = strings.Split(args["files"].Value, ",")
+	}
+
+	switch action {
+	case "merge":
+		if len(files) < 3 {
+			return configError(fmt.Errorf("merge requires the output file followed by at least two databases to merge"))
+		}
+		if files[0] == store.DatabaseFile {
+			err := acquireLock("db merge", flags["force-unlock"].Value.(bool))
+			if err != nil {
+				return err
+			}
+			defer releaseLock()
+		}
+		return mergeDatabases(files[0], files[1:])
+	case "diff":
+		if len(files) != 2 {
+			return configError(fmt.Errorf("diff requires the earlier and later databases"))
+		}
+		return diffDatabases(files[0], files[1], output)
+	case "validate":
+		path := store.DatabaseFile
+		switch len(files) {
+		case 0:
+		case 1:
+			path = files[0]
+		default:
+			return configError(fmt.Errorf("validate checks a single database"))
+		}
+		return validateDatabase(path, output)
+	default:
+		return configError(fmt.Errorf("unknown action %q, must be merge, diff, or validate", action))
+	}
+}
+
+// mergeDatabases combines the databases collected by several operators into
+// out. Nothing is written when the databases conflict.
+func mergeDatabases(out string, paths []string) error {
+	dbs := make([]*store.Database, 0, len(paths))
+	for _, path := range paths {
+		db, err := store.LoadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed loading %s: %w", path, err)
+		}
+		dbs = append(dbs, db)
+	}
+
+	merged, conflicts, err := store.Merge(dbs...)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		for _, conflict := range conflicts {
+			fmt.Printf("Conflict: %s %s\n", conflict.Key, conflict.Reason)
+		}
+		return fmt.Errorf("found %d conflicts, resolve them in the source databases and merge again", len(conflicts))
+	}
+
+	if _, err := os.Stat(out); err == nil {
+		if out != store.DatabaseFile {
+			return fmt.Errorf("%s already exists", out)
+		}
+		_, err = takeSnapshot("none", "before db merge")
+		if err != nil {
+			return fmt.Errorf("failed snapshotting workspace: %w", err)
+		}
+	}
+	err = store.SaveFile(merged, out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged %d databases into %s: %d issues, %d tickets, %d attachments\n", len(dbs), out, len(merged.Issues), len(merged.Tickets), len(merged.Attachments))
+	return nil
+}
+
+// diffDatabases reports what changed from the database at before to the one
+// at after, such as a re-collect written elsewhere, for review before
+// uploading.
+func diffDatabases(before, after, output string) error {
+	older, err := store.LoadFile(before)
+	if err != nil {
+		return fmt.Errorf("failed loading %s: %w", before, err)
+	}
+	newer, err := store.LoadFile(after)
+	if err != nil {
+		return fmt.Errorf("failed loading %s: %w", after, err)
+	}
+
+	d := store.Compare(older, newer)
+	if output == "json" {
+		bytes, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed marshalling diff: %w", err)
+		}
+		fmt.Println(string(bytes))
+		return nil
+	}
+	printDiff(d)
+	return nil
+}
+
+func printDiff(d *store.Diff) {
+	if d.IsEmpty() {
+		fmt.Println("No changes")
+		return
+	}
+	sections := []struct {
+		title string
+		refs  []*store.AttachmentRef
+	}{
+		{"Attachments added", d.Added},
+		{"Attachments removed", d.Removed},
+		{"Attachments with changed content", d.Changed},
+	}
+	for _, section := range sections {
+		if len(section.refs) == 0 {
+			continue
+		}
+		fmt.Printf("%s: %d\n", section.title, len(section.refs))
+		for _, ref := range section.refs {
+			fmt.Printf("  %s (issue #%d)\n", ref.Path, ref.Issue)
+		}
+	}
+	if len(d.Matches) > 0 {
+		fmt.Printf("Changed matches: %d\n", len(d.Matches))
+		for _, match := range d.Matches {
+			fmt.Printf("  #%d %q: %s -> %s\n", match.Issue, match.Title, keyOrNone(match.Old), keyOrNone(match.New))
+		}
+	}
+	if len(d.Regressions) > 0 {
+		fmt.Printf("Status regressions: %d\n", len(d.Regressions))
+		for _, regression := range d.Regressions {
+			fmt.Printf("  %s %s\n", regression.Subject, regression.Reason)
+		}
+	}
+}
+
+func keyOrNone(key string) string {
+	if key == "" {
+		return "(unmatched)"
+	}
+	return key
+}
+
+// validateDatabase reports the structural problems of the database at path,
+// failing when any of them is an error.
+func validateDatabase(path, output string) error {
+	db, err := store.LoadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed loading %s: %w", path, err)
+	}
+
+	problems := store.Validate(db)
+	errors := 0
+	for _, problem := range problems {
+		if problem.Severity == store.SeverityError {
+			errors++
+		}
+	}
+	if output == "json" {
+		if problems == nil {
+			problems = []*store.Problem{}
+		}
+		bytes, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed marshalling problems: %w", err)
+		}
+		fmt.Println(string(bytes))
+	} else {
+		for _, problem := range problems {
+			fmt.Printf("[%s] %s: %s %s\n", strings.ToUpper(problem.Severity), problem.Check, problem.Subject, problem.Detail)
+		}
+	}
+
+	if errors > 0 {
+		return fmt.Errorf("found %d errors and %d warnings in %s", errors, len(problems)-errors, path)
+	}
+	if output == "text" {
+		fmt.Printf("%s is valid with %d warnings\n", path, len(problems))
+	}
+	return nil
+}
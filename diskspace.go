@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// existingDir returns path, or its nearest ancestor that exists, so the free
+// space of a directory can be read before it is created.
+func existingDir(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// ensureSpace fails when the volume holding path has fewer than required
+// bytes free for what is about to be written there.
+func ensureSpace(path string, required uint64, what string) error {
+	dir := existingDir(path)
+	free, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("failed reading free space of %s: %w", dir, err)
+	}
+	if required > free {
+		return fmt.Errorf("%s needs %d bytes but only %d bytes are free on the volume holding %s; free up space or choose another volume, or pass --no-space-check to try anyway", what, required, free, path)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the volume
+// containing path.
+func freeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// sameVolume reports whether the existing paths are on the same volume.
+func sameVolume(a, b string) bool {
+	var statA, statB syscall.Stat_t
+	if syscall.Stat(a, &statA) != nil || syscall.Stat(b, &statB) != nil {
+		return false
+	}
+	return statA.Dev == statB.Dev
+}
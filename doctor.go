@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/v47/github"
+	"github.com/lindluni/attachment-processor/pkg/export"
+	"github.com/lindluni/attachment-processor/pkg/oauth"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+)
+
+// checkResult is a single line of the doctor checklist.
+type checkResult struct {
+	name   string
+	status string
+	detail string
+}
+
+func pass(name, format string, a ...interface{}) *checkResult {
+	return &checkResult{name: name, status: checkPass, detail: fmt.Sprintf(format, a...)}
+}
+
+func warn(name, format string, a ...interface{}) *checkResult {
+	return &checkResult{name: name, status: checkWarn, detail: fmt.Sprintf(format, a...)}
+}
+
+func fail(name, format string, a ...interface{}) *checkResult {
+	return &checkResult{name: name, status: checkFail, detail: fmt.Sprintf(format, a...)}
+}
+
+func doctor(flags map[string]commando.FlagValue) error {
+	githubToken := flags["github-token"].Value.(string)
+	jiraURL := flags["jira-url"].Value.(string)
+	jiraSecret := flags["jira-secret"].Value.(string)
+	jiraKeys := flags["jira-keys"].Value.(string)
+	archive := flags["archive"].Value.(string)
+
+	var results []*checkResult
+
+	gh := newGitHubClient(githubToken)
+	results = append(results, checkReachable("GitHub API", gh.BaseURL.String()))
+	results = append(results, checkGitHubScopes(gh))
+
+	if !unset(jiraURL) {
+		results = append(results, checkReachable("JIRA API", jiraURL))
+	}
+	jira, err := newJIRAClient(jiraSecret, jiraURL)
+	if err != nil {
+		results = append(results, fail("JIRA client", "%s", err))
+	} else {
+		results = append(results, checkJIRABaseURL(jira, jiraSecret == oauth.Secret))
+		results = append(results, checkJIRACredentials(jira))
+		if jiraKeys != "none" {
+			for _, key := range strings.Split(strings.ReplaceAll(jiraKeys, " ", ""), ",") {
+				results = append(results, checkProjectVisibility(jira, key))
+				results = append(results, checkProjectPermission(jira, key))
+			}
+		}
+		results = append(results, checkAttachmentMeta(jira))
+		results = append(results, checkClockSkew(jira))
+	}
+
+	results = append(results, checkStagingSpace())
+	if archive != "none" {
+		archives, err := archiveList(archive)
+		if err != nil {
+			results = append(results, fail("Migration archive", "%s", err))
+		}
+		for _, archive := range archives {
+			path, err := localArchive(archive, "none")
+			if err != nil {
+				results = append(results, fail("Migration archive", "%s", err))
+			} else {
+				results = append(results, checkArchive(path))
+			}
+		}
+	}
+	results = append(results, checkDatabase())
+
+	failed, warned := 0, 0
+	for _, result := range results {
+		fmt.Printf("[%s] %s: %s\n", result.status, result.name, result.detail)
+		switch result.status {
+		case checkFail:
+			failed++
+		case checkWarn:
+			warned++
+		}
+	}
+	if failed > 0 {
+		// The checks' own responses say nothing of the run as a whole.
+		return &exitError{code: exitFailure, err: fmt.Errorf("%d of %d checks failed", failed, len(results))}
+	}
+	if warned > 0 {
+		fmt.Printf("All checks passed with %d warnings\n", warned)
+		return nil
+	}
+	fmt.Println("All checks passed")
+
+	return nil
+}
+
+// checkGitHubScopes verifies the token authenticates and, for classic
+// tokens, carries the repo scope needed to read private repositories.
+func checkGitHubScopes(client *github.Client) *checkResult {
+	name := "GitHub token"
+	user, resp, err := client.Users.Get(context.Background(), "")
+	if err != nil {
+		return fail(name, "%s", err)
+	}
+	scopes := resp.Header.Get("X-OAuth-Scopes")
+	if scopes == "" {
+		return pass(name, "authenticated as %s with a fine-grained token", user.GetLogin())
+	}
+	for _, scope := range strings.Split(scopes, ",") {
+		if strings.TrimSpace(scope) == "repo" {
+			return pass(name, "authenticated as %s with scopes %s", user.GetLogin(), scopes)
+		}
+	}
+	return fail(name, "authenticated as %s but the repo scope is missing from %s", user.GetLogin(), scopes)
+}
+
+// checkJIRABaseURL verifies the JIRA URL, including any context path such as
+// /jira, resolves to the REST API rather than a login page or another
+// application, and that it agrees with the base URL JIRA reports for itself.
+func checkJIRABaseURL(client *jira.Client, gateway bool) *checkResult {
+	name := "JIRA base URL"
+	endpoint := client.GetBaseURL()
+	req, err := client.NewRequest("GET", "rest/api/2/serverInfo", nil)
+	if err != nil {
+		return fail(name, "failed creating request: %s", err)
+	}
+	var info struct {
+		BaseURL string `json:"baseUrl"`
+		Version string `json:"version"`
+	}
+	_, err = client.Do(req, &info)
+	if err != nil || info.Version == "" {
+		if err == nil {
+			err = fmt.Errorf("the response is not JIRA server info")
+		}
+		return fail(name, "%s does not resolve to the JIRA REST API, check the URL includes any context path such as /jira: %s", endpoint.String(), err)
+	}
+	if gateway || info.BaseURL == "" {
+		return pass(name, "%s serves the REST API of JIRA %s", endpoint.String(), info.Version)
+	}
+	reported, err := url.Parse(info.BaseURL)
+	if err == nil && strings.TrimRight(reported.Path, "/") != strings.TrimRight(endpoint.Path, "/") {
+		return warn(name, "JIRA reports its base URL as %s but is reached at %s, a proxy may be rewriting the context path", info.BaseURL, endpoint.String())
+	}
+	return pass(name, "%s serves the REST API of JIRA %s", endpoint.String(), info.Version)
+}
+
+func checkJIRACredentials(client *jira.Client) *checkResult {
+	name := "JIRA credentials"
+	user, _, err := client.User.GetSelf()
+	if err != nil {
+		return fail(name, "%s", err)
+	}
+	if user.Name != "" {
+		return pass(name, "authenticated as %s", user.Name)
+	}
+	return pass(name, "authenticated as %s", user.DisplayName)
+}
+
+func checkProjectVisibility(client *jira.Client, key string) *checkResult {
+	name := fmt.Sprintf("JIRA project %s", key)
+	project, _, err := client.Project.Get(key)
+	if err != nil {
+		return fail(name, "project is not visible: %s", err)
+	}
+	return pass(name, "found %s", project.Name)
+}
+
+func checkProjectPermission(client *jira.Client, key string) *checkResult {
+	name := fmt.Sprintf("Create Attachments permission on %s", key)
+	endpoint := fmt.Sprintf("rest/api/2/mypermissions?projectKey=%s&permissions=CREATE_ATTACHMENTS", url.QueryEscape(key))
+	req, err := client.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return fail(name, "failed creating request: %s", err)
+	}
+	var permissions struct {
+		Permissions map[string]struct {
+			HavePermission bool `json:"havePermission"`
+		} `json:"permissions"`
+	}
+	_, err = client.Do(req, &permissions)
+	if err != nil {
+		return fail(name, "failed retrieving permissions: %s", err)
+	}
+	if !permissions.Permissions["CREATE_ATTACHMENTS"].HavePermission {
+		return fail(name, "permission not granted")
+	}
+	return pass(name, "granted")
+}
+
+func checkAttachmentMeta(client *jira.Client) *checkResult {
+	name := "JIRA attachment settings"
+	meta, err := getAttachmentMeta(client)
+	if err != nil {
+		return fail(name, "%s", err)
+	}
+	if !meta.Enabled {
+		return fail(name, "attachments are disabled")
+	}
+	return pass(name, "attachments enabled with an upload limit of %d bytes", meta.UploadLimit)
+}
+
+func checkClockSkew(client *jira.Client) *checkResult {
+	name := "JIRA server clock"
+	clock, err := getServerClock(client)
+	if err != nil {
+		return fail(name, "%s", err)
+	}
+	if clock.Skew > time.Hour || clock.Skew < -time.Hour {
+		return fail(name, "server clock is %s from the local clock in zone %s", clock.Skew, clock.Zone)
+	}
+	if clock.Skewed() {
+		return warn(name, "server clock is %s from the local clock in zone %s", clock.Skew, clock.Zone)
+	}
+	return pass(name, "server clock is within %s of the local clock in zone %s", store.MaxClockSkew, clock.Zone)
+}
+
+// checkStagingSpace reports the free space on the volume holding the staging
+// directory and fails when it cannot hold the files already staged again,
+// which is the space the archive command needs.
+func checkStagingSpace() *checkResult {
+	name := "Staging disk space"
+	free, err := freeSpace(existingDir(store.StageDir))
+	if err != nil {
+		return fail(name, "failed reading free space: %s", err)
+	}
+	staged, err := directorySize(store.StageDir)
+	if err != nil {
+		return fail(name, "failed measuring staging directory: %s", err)
+	}
+	if staged > free {
+		return fail(name, "%d bytes free but %d bytes are needed to archive the staged files", free, staged)
+	}
+	return pass(name, "%d bytes free", free)
+}
+
+// checkReachable verifies the API answers HTTP requests at all, before any
+// credentials are involved, and warns when it is slow to respond.
+func checkReachable(name, endpoint string) *checkResult {
+	client := &http.Client{Timeout: 30 * time.Second}
+	start := time.Now()
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return fail(name, "unreachable: %s", err)
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if elapsed > 5*time.Second {
+		return warn(name, "%s responded in %s", endpoint, elapsed)
+	}
+	return pass(name, "%s responded in %s", endpoint, elapsed)
+}
+
+// checkArchive reads every member header of the archive to confirm it is a
+// complete gzipped tarball, plain tarball, or zip archive.
+func checkArchive(path string) *checkResult {
+	name := "Migration archive"
+	r, err := export.Open(path)
+	if err != nil {
+		return fail(name, "%s", err)
+	}
+	defer r.Close()
+
+	members := 0
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(name, "failed reading %s after %d members: %s", path, members, err)
+		}
+		members++
+	}
+	return pass(name, "%s is a %s archive holding %d files", path, r.Format, members)
+}
+
+// checkDatabase confirms an existing database loads and that the staged
+// files it references are still present, unless it was collected without
+// staging them or gc pruned them.
+func checkDatabase() *checkResult {
+	name := "Database"
+	if _, err := os.Stat(store.DatabaseFile); os.IsNotExist(err) {
+		return pass(name, "no database yet")
+	}
+	db, err := store.Load()
+	if err != nil {
+		return fail(name, "%s", err)
+	}
+
+	missing := 0
+	for _, attachment := range db.Attachments {
+		if attachment.Deleted || attachment.Pruned || db.Unstaged {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(store.StageDir, attachment.Path)); err != nil {
+			missing++
+		}
+	}
+	if missing > 0 {
+		return warn(name, "%d of %d attachments are missing from the staging directory", missing, len(db.Attachments))
+	}
+	return pass(name, "%d attachments, %d issues, and %d tickets recorded", len(db.Attachments), len(db.Issues), len(db.Tickets))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/downscale"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// downscaledDir holds the downscaled copies of images within the staging
+// directory, mirroring the paths of the originals.
+const downscaledDir = "downscaled"
+
+// downscaleImages stages a copy no larger than maxBytes of each pending PNG
+// and JPEG attachment over maxBytes, which is uploaded in place of the
+// original, and returns the attachments downscaled. Copies made by an earlier
+// run with a different threshold are dropped. An image that cannot be made
+// to fit is left alone with a warning, to be handled by the size check.
+func downscaleImages(pending map[string][]*store.Attachment, maxBytes int64, algorithm string) ([]*store.Attachment, error) {
+	var downscaled []*store.Attachment
+	for _, attachments := range pending {
+		for _, attachment := range attachments {
+			attachment.Downscaled = ""
+			attachment.DownscaledChecksum = ""
+			if maxBytes <= 0 {
+				continue
+			}
+			size, err := attachmentSize(attachment, false)
+			if err != nil {
+				return nil, err
+			}
+			if size <= maxBytes {
+				continue
+			}
+			err = detectType(attachment, false)
+			if err != nil {
+				return nil, err
+			}
+			if !downscale.IsSupported(attachment.ContentType) {
+				continue
+			}
+
+			copyPath := downscaledDir + "/" + attachment.Path
+			target := filepath.Join(store.StageDir, filepath.FromSlash(copyPath))
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+			if err != nil {
+				return nil, fmt.Errorf("failed creating directory %s: %w", filepath.Dir(target), err)
+			}
+			result, err := downscale.Fit(filepath.Join(store.StageDir, attachment.Path), target, maxBytes)
+			if err != nil {
+				fmt.Printf("Warning: unable to downscale %s: %s\n", attachment.Path, err)
+				continue
+			}
+			sum, err := fileSum(target, algorithm)
+			if err != nil {
+				return nil, err
+			}
+			attachment.Downscaled = copyPath
+			attachment.DownscaledChecksum = sum
+			downscaled = append(downscaled, attachment)
+			if result.Scaled {
+				output.Detailf("Downscaled image %s from %d to %d bytes at %dx%d\n", attachment.Path, size, result.Bytes, result.Width, result.Height)
+			} else {
+				output.Detailf("Re-encoded image %s from %d to %d bytes\n", attachment.Path, size, result.Bytes)
+			}
+		}
+	}
+	sort.Slice(downscaled, func(i, j int) bool {
+		return downscaled[i].Path < downscaled[j].Path
+	})
+	return downscaled, nil
+}
+
+// fileSum returns the checksum of the file at path with the algorithm.
+func fileSum(path, algorithm string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed opening %s: %w", path, err)
+	}
+	defer file.Close()
+	sum, err := checksum.Sum(file, algorithm)
+	if err != nil {
+		return "", fmt.Errorf("failed hashing %s: %w", path, err)
+	}
+	return sum, nil
+}
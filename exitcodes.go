@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/v47/github"
+	"github.com/lindluni/attachment-processor/pkg/client"
+)
+
+// The exit codes of the commands, which scripts driving a migration branch
+// on. They are documented in the README and must not change meaning.
+const (
+	// exitClean is a run that did everything it set out to, including a
+	// run that found nothing to do.
+	exitClean = 0
+	// exitFailure is a failure of no more specific class.
+	exitFailure = 1
+	// exitConfig is a run stopped before contacting any service, by invalid
+	// flags, secrets, or input files.
+	exitConfig = 2
+	// exitAuth is a run stopped by a service refusing its credentials or
+	// their permissions.
+	exitAuth = 3
+	// exitPartial is a run that finished but failed part of its work, such
+	// as some uploads with --continue-on-error.
+	exitPartial = 4
+	// exitRateLimited is a run stopped by a service rate limiting it.
+	exitRateLimited = 5
+)
+
+// exitError is an error whose exit code is known where it is returned.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// partialFailure marks the error as the failure of part of a run that
+// otherwise finished.
+func partialFailure(err error) error {
+	return &exitError{code: exitPartial, err: err}
+}
+
+// configError marks the error as invalid flags, secrets, or input files
+// found before the run contacted any service.
+func configError(err error) error {
+	return &exitError{code: exitConfig, err: err}
+}
+
+// exitCode returns the exit code of a command that failed with err. Errors
+// of requests a service refused are marked where they are returned, and any
+// other error not marked is a plain failure, such as failing to write the
+// database.
+func exitCode(err error) int {
+	if err == nil {
+		return exitClean
+	}
+	var classified *exitError
+	if errors.As(err, &classified) {
+		return classified.code
+	}
+	var refused *client.RefusedError
+	if errors.As(err, &refused) {
+		if refused.RateLimited {
+			return exitRateLimited
+		}
+		return exitAuth
+	}
+	return exitFailure
+}
+
+// jiraRefused marks the error of a go-jira request JIRA refused, as the
+// adapters of the client package mark theirs.
+func jiraRefused(resp *jira.Response, err error) error {
+	return client.JIRARefused(resp, err)
+}
+
+// gitHubRefused marks the error of a go-github request GitHub refused.
+func gitHubRefused(resp *github.Response, err error) error {
+	return client.GitHubRefused(resp, err)
+}
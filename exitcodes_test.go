@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/thatisuday/commando"
+)
+
+// flagValues returns the flags of a command action with the given values.
+func flagValues(values map[string]interface{}) map[string]commando.FlagValue {
+	flags := make(map[string]commando.FlagValue, len(values))
+	for name, value := range values {
+		flags[name] = commando.FlagValue{Value: value}
+	}
+	return flags
+}
+
+// TestExitCodeOfValidationFailures checks invalid flags exit with the config
+// code from commands that do not send their requests through withTransport,
+// before they touch the workspace.
+func TestExitCodeOfValidationFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{"plan with bad order", func() error {
+			return plan(flagValues(map[string]interface{}{"output": "none", "order": "random", "retry-failed": false}))
+		}},
+		{"status with bad output", func() error {
+			return status(flagValues(map[string]interface{}{"output": "yaml"}))
+		}},
+		{"clean with nothing selected", func() error {
+			return clean(flagValues(map[string]interface{}{"all": false, "stage": false, "archive": false, "db": false}))
+		}},
+		{"db with unknown action", func() error {
+			return dbCommand(map[string]commando.ArgValue{"action": {Value: "compact"}, "files": {Value: ""}}, flagValues(map[string]interface{}{"output": "text"}))
+		}},
+	}
+	for _, test := range tests {
+		err := test.run()
+		if err == nil {
+			t.Errorf("%s: succeeded, want an error", test.name)
+			continue
+		}
+		if code := exitCode(err); code != exitConfig {
+			t.Errorf("%s: exit code %d, want %d", test.name, code, exitConfig)
+		}
+	}
+}
+
+// TestExitCodeOfRefusedRequests checks errors of requests a service refused
+// exit with the auth or rate-limit code through the errors wrapping them, and
+// that any other error is a plain failure whatever responses were received.
+func TestExitCodeOfRefusedRequests(t *testing.T) {
+	refused := func(status int, header http.Header) error {
+		resp := &http.Response{StatusCode: status, Status: http.StatusText(status), Header: header}
+		err := client.Refused(resp, fmt.Errorf("request failed. Status code: %d", status))
+		return fmt.Errorf("failed checking ticket PROJ-1: %w", err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"unmarked", errors.New("failed writing database: disk full"), exitFailure},
+		{"not found", refused(http.StatusNotFound, http.Header{}), exitFailure},
+		{"unauthorized", refused(http.StatusUnauthorized, http.Header{}), exitAuth},
+		{"forbidden", refused(http.StatusForbidden, http.Header{}), exitAuth},
+		{"rate limited", refused(http.StatusTooManyRequests, http.Header{}), exitRateLimited},
+		{"GitHub rate limit", refused(http.StatusForbidden, http.Header{"X-Ratelimit-Remaining": {"0"}}), exitRateLimited},
+		{"partial failure", partialFailure(refused(http.StatusUnauthorized, http.Header{})), exitPartial},
+	}
+	for _, test := range tests {
+		if code := exitCode(test.err); code != test.code {
+			t.Errorf("%s: exit code %d, want %d", test.name, code, test.code)
+		}
+	}
+}
+
+// TestExitCodeOfRefusedUpload checks an upload JIRA refuses the credentials
+// of exits with the auth code, through the errors the target wraps it in.
+func TestExitCodeOfRefusedUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Client must be authenticated to access this resource.", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	jiraClient, err := jira.NewClient(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := client.NewJIRATarget(client.NewJIRA(jiraClient))
+	_, err = target.PostAttachment(context.Background(), "PROJ-1", strings.NewReader("log"), "log.txt")
+	if err == nil {
+		t.Fatal("refused upload succeeded")
+	}
+	if code := exitCode(fmt.Errorf("failed uploading attachment: %w", err)); code != exitAuth {
+		t.Errorf("exit code %d, want %d", code, exitAuth)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lindluni/attachment-processor/pkg/archive"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// extract unpacks a processed archive built by the archive command, checks
+// its files against the manifest, and optionally rebuilds the database from
+// the manifest so the files can be uploaded on another machine.
+func extract(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) error {
+	path := args["archive"].Value
+	output := flags["output"].Value.(string)
+	rebuild := flags["database"].Value.(bool)
+
+	switch {
+	case rebuild && output != "none":
+		return configError(fmt.Errorf("--database extracts into the staging directory and cannot be used with --output"))
+	case rebuild:
+		output = store.StageDir
+	case output == "none":
+		output = "extracted"
+	}
+	if rebuild {
+		err := acquireLock("extract", flags["force-unlock"].Value.(bool))
+		if err != nil {
+			return err
+		}
+		defer releaseLock()
+	}
+
+	if _, err := os.Stat(output); os.IsNotExist(err) {
+		err = os.MkdirAll(output, 0755)
+		if err != nil {
+			return fmt.Errorf("failed creating output directory: %w", err)
+		}
+	}
+	empty, err := IsEmpty(output)
+	if err != nil {
+		return fmt.Errorf("failed checking if output directory empty: %w", err)
+	}
+	if !empty {
+		return fmt.Errorf("output directory %s is not empty", output)
+	}
+
+	path, err = localArchive(path, "none")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Extracting %s into %s\n", path, output)
+	entries, mismatches, err := archive.Extract(path, output)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		fmt.Printf("%d files do not match the manifest:\n", len(mismatches))
+		for _, mismatch := range mismatches {
+			fmt.Printf("  %s: %s\n", mismatch.File, mismatch.Reason)
+		}
+		return fmt.Errorf("%d of %d files do not match the manifest", len(mismatches), len(entries))
+	}
+	fmt.Printf("Verified %d files against the manifest\n", len(entries))
+	for _, entry := range entries {
+		err = audit.Record(&audit.Entry{Action: audit.ActionExtracted, Path: entry.File})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !rebuild {
+		return nil
+	}
+
+	db := archive.Database(entries)
+	unmatched := 0
+	for _, entry := range entries {
+		if entry.JIRAKey == "" {
+			unmatched++
+		}
+	}
+	if unmatched > 0 {
+		fmt.Printf("%d files were matched to no JIRA ticket and will not be uploaded\n", unmatched)
+	}
+
+	snapshotPath, err := takeSnapshot("none", "before extract")
+	if err != nil {
+		return fmt.Errorf("failed snapshotting workspace: %w", err)
+	}
+	if snapshotPath != "" {
+		fmt.Printf("Saved previous workspace state to %s\n", snapshotPath)
+	}
+
+	fmt.Println("Writing database to disk")
+	return store.Save(db)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// runFailure is a row of the failure summary printed at the end of an upload.
+type runFailure struct {
+	ticket string
+	path   string
+	err    string
+}
+
+// runFailures lists the attachments that failed during the run, followed by
+// the tickets that failed without an attachment failure to explain it.
+func runFailures(db *store.Database, pending map[string][]*store.Attachment, ticketErrors map[string]error, started time.Time) []*runFailure {
+	var failures []*runFailure
+	explained := make(map[string]bool)
+	for _, title := range sortedKeys(pending) {
+		key := db.Tickets[title].Key
+		for _, attachment := range pending[title] {
+			if attachment.Failure == nil || attachment.Failure.Time.Before(started) {
+				continue
+			}
+			failures = append(failures, &runFailure{ticket: key, path: attachment.Path, err: attachment.Failure.Error})
+			explained[key] = true
+		}
+	}
+	for _, title := range sortedKeys(ticketErrors) {
+		key := title
+		if ticket := db.Tickets[title]; ticket != nil && ticket.Key != "" {
+			key = ticket.Key
+		}
+		if !explained[key] {
+			failures = append(failures, &runFailure{ticket: key, err: ticketErrors[title].Error()})
+		}
+	}
+	return failures
+}
+
+func printFailures(failures []*runFailure) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  TICKET\tATTACHMENT\tERROR")
+	for _, failure := range failures {
+		path := failure.path
+		if path == "" {
+			path = "-"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", failure.ticket, path, strings.ReplaceAll(failure.err, "\n", " "))
+	}
+	w.Flush()
+}
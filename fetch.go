@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+	"golang.org/x/sync/errgroup"
+)
+
+func fetch(flags map[string]commando.FlagValue) error {
+	sourceName := flags["source"].Value.(string)
+	jiraURL := flags["jira-url"].Value.(string)
+	jiraUsername := flags["jira-username"].Value.(string)
+	jiraKeys := flags["jira-keys"].Value.(string)
+	target := flags["target"].Value.(string)
+	onlyIssues := flags["only-issues"].Value.(string)
+	skipIssues := flags["skip-issues"].Value.(string)
+	labels := flags["labels"].Value.(string)
+	state := flags["state"].Value.(string)
+	since := flags["since"].Value.(string)
+	until := flags["until"].Value.(string)
+	dedup := flags["dedup"].Value.(string)
+	hashAlgorithm := flags["hash"].Value.(string)
+	fips := flags["fips"].Value.(bool)
+
+	switch {
+	case flags["org"].Value.(string) == "none" || flags["repo"].Value.(string) == "none":
+		return configError(fmt.Errorf("--org and --repo must be specified"))
+	case target == store.TargetJIRA && (jiraURL == "none" || jiraUsername == "none"):
+		return configError(fmt.Errorf("--jira-url and --jira-username must be specified for the %s target", target))
+	case target == store.TargetJIRA && jiraKeys == "none" && flags["ticket-keys-file"].Value.(string) == "none" && flags["ticket-key-range"].Value.(string) == "none":
+		return configError(fmt.Errorf("--jira-keys, --ticket-keys-file, or --ticket-key-range must be specified for the %s target", target))
+	}
+
+	filter, err := store.ParseIssueFilter(onlyIssues, skipIssues)
+	if err != nil {
+		return configError(err)
+	}
+
+	since, incremental, err := resolveSince(since)
+	if err != nil {
+		return configError(err)
+	}
+
+	query, err := collect.ParseIssueQuery(labels, state, since, until)
+	if err != nil {
+		return configError(err)
+	}
+
+	matching, err := newMatchConfig(flags)
+	if err != nil {
+		return configError(err)
+	}
+
+	tickets, err := newTicketLister(flags, matching)
+	if err != nil {
+		return configError(err)
+	}
+
+	err = collect.ValidDedupPolicy(dedup)
+	if err != nil {
+		return configError(err)
+	}
+
+	err = checksum.Valid(hashAlgorithm, fips)
+	if err != nil {
+		return configError(err)
+	}
+
+	issueSource, err := newIssueSource(sourceName, flags)
+	if err != nil {
+		return configError(err)
+	}
+
+	err = os.MkdirAll(store.StageDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed creating staging directory: %w", err)
+	}
+
+	db := store.New(hashAlgorithm)
+	db.Target = target
+	db.Collected = time.Now().UTC()
+
+	var g errgroup.Group
+	listed := startListing(&g, tickets, issueSource, query)
+	g.Go(func() error {
+		fmt.Printf("Fetching %s attachments\n", issueSource.Name())
+		err := issueSource.FetchAttachments(filter, query, db)
+		if err != nil {
+			return fmt.Errorf("failed fetching attachments: %w", err)
+		}
+
+		fmt.Println("Computing attachment checksums")
+		err = collect.HashAttachments(db.Attachments, db.HashAlgorithm)
+		if err != nil {
+			return fmt.Errorf("failed computing checksums: %w", err)
+		}
+
+		duplicates := collect.DedupAttachments(db, dedup)
+		if duplicates > 0 {
+			fmt.Printf("Found %d attachments duplicating the content of another attachment\n", duplicates)
+		}
+		return nil
+	})
+	err = g.Wait()
+	if err != nil {
+		return err
+	}
+
+	return link(tickets, matching, listed, db, incremental)
+}
+
+// newIssueSource returns the issue tracker attachments are fetched from.
+// GitLab projects are addressed by the group path and project name given
+// as the organization and repository, and Bitbucket repositories by their
+// workspace and slug.
+func newIssueSource(name string, flags map[string]commando.FlagValue) (collect.Source, error) {
+	githubToken := flags["github-token"].Value.(string)
+	gitlabURL := flags["gitlab-url"].Value.(string)
+	gitlabToken := flags["gitlab-token"].Value.(string)
+	bitbucketURL := flags["bitbucket-url"].Value.(string)
+	bitbucketUsername := flags["bitbucket-username"].Value.(string)
+	bitbucketSecret := flags["bitbucket-secret"].Value.(string)
+	org := flags["org"].Value.(string)
+	repo := flags["repo"].Value.(string)
+	githubAPI := flags["github-api"].Value.(string)
+
+	switch name {
+	case collect.SourceGitHub:
+		if githubToken == "none" {
+			return nil, fmt.Errorf("--github-token, --github-token-file, or --use-keyring must be specified for the github source")
+		}
+		err := collect.ValidGitHubAPI(githubAPI)
+		if err != nil {
+			return nil, err
+		}
+		return &collect.GitHubSource{Client: client.NewGitHub(newGitHubClient(githubToken)), Token: githubToken, Org: org, Repo: repo, API: githubAPI}, nil
+	case collect.SourceGitLab:
+		if gitlabToken == "none" {
+			return nil, fmt.Errorf("--gitlab-token must be specified for the gitlab source")
+		}
+		return &collect.GitLabSource{Client: client.NewGitLab(gitlabURL, gitlabToken), Project: org + "/" + repo}, nil
+	case collect.SourceBitbucket:
+		if bitbucketSecret == "none" {
+			return nil, fmt.Errorf("--bitbucket-secret must be specified for the bitbucket source")
+		}
+		if bitbucketUsername == "none" {
+			bitbucketUsername = ""
+		}
+		return &collect.BitbucketSource{Client: client.NewBitbucket(bitbucketURL, bitbucketUsername, bitbucketSecret), Workspace: org, Repo: repo}, nil
+	}
+	return nil, fmt.Errorf("invalid source %q, must be github, gitlab, or bitbucket", name)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/lindluni/attachment-processor/pkg/filetype"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// newTypeFilter builds the file type filter from the --include-ext,
+// --exclude-ext, and --exclude-type flags shared by upload and archive.
+func newTypeFilter(flags map[string]commando.FlagValue) (*filetype.Filter, error) {
+	return filetype.ParseFilter(
+		flags["include-ext"].Value.(string),
+		flags["exclude-ext"].Value.(string),
+		flags["exclude-type"].Value.(string),
+	)
+}
+
+// applyTypeFilter detects the type of each attachment not yet typed and
+// returns the attachments the filter keeps and those it excludes, recording
+// why on each excluded attachment. Reasons left by earlier runs are cleared
+// for attachments the filter now keeps. The type of an attachment that was
+// not staged is taken from its name.
+func applyTypeFilter(attachments []*store.Attachment, filter *filetype.Filter, unstaged bool) ([]*store.Attachment, []*store.Attachment, error) {
+	var kept, excluded []*store.Attachment
+	for _, attachment := range attachments {
+		attachment.Excluded = ""
+		if filter.IsEmpty() {
+			kept = append(kept, attachment)
+			continue
+		}
+		err := detectType(attachment, unstaged)
+		if err != nil {
+			return nil, nil, err
+		}
+		attachment.Excluded = filter.Reason(attachment.Name(), attachment.ContentType)
+		if attachment.Excluded != "" {
+			excluded = append(excluded, attachment)
+			continue
+		}
+		kept = append(kept, attachment)
+	}
+	sort.Slice(excluded, func(i, j int) bool {
+		return excluded[i].Path < excluded[j].Path
+	})
+	return kept, excluded, nil
+}
+
+// detectType records the MIME type of the attachment unless it is already
+// known, sniffed from the staged file or, when it was not staged, taken from
+// its name.
+func detectType(attachment *store.Attachment, unstaged bool) error {
+	if attachment.ContentType != "" {
+		return nil
+	}
+	if unstaged {
+		attachment.ContentType = filetype.ByName(attachment.Name())
+		return nil
+	}
+	contentType, err := filetype.Sniff(filepath.Join(store.StageDir, attachment.Path))
+	if err != nil {
+		return fmt.Errorf("failed detecting the type of %s: %w", attachment.Path, err)
+	}
+	attachment.ContentType = contentType
+	return nil
+}
+
+// filterPendingTypes removes the attachments the file type filter excludes
+// from the pending uploads and returns them.
+func filterPendingTypes(pending map[string][]*store.Attachment, filter *filetype.Filter, unstaged bool) ([]*store.Attachment, error) {
+	var excluded []*store.Attachment
+	for title, attachments := range pending {
+		kept, dropped, err := applyTypeFilter(attachments, filter, unstaged)
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, dropped...)
+		if len(kept) == 0 {
+			delete(pending, title)
+			continue
+		}
+		pending[title] = kept
+	}
+	sort.Slice(excluded, func(i, j int) bool {
+		return excluded[i].Path < excluded[j].Path
+	})
+	return excluded, nil
+}
+
+// printExcluded itemizes the attachments the file type filter left out.
+func printExcluded(excluded []*store.Attachment) {
+	if len(excluded) == 0 {
+		return
+	}
+	fmt.Printf("Excluded %d attachments by file type:\n", len(excluded))
+	for _, attachment := range excluded {
+		fmt.Printf("  %s (%s)\n", attachment.Path, attachment.Excluded)
+	}
+}
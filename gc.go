@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// gc deletes the staged files of attachments already uploaded, to reclaim
+// the staging disk while the migration is still in flight. With --verified
+// only attachments verify found intact since they were uploaded are pruned,
+// and with --checksums only those whose content it compared by checksum.
+// Pruned attachments are marked in the database so the missing files are
+// not taken for a damaged workspace.
+func gc(flags map[string]commando.FlagValue) error {
+	checksums := flags["checksums"].Value.(bool)
+	verified := checksums || flags["verified"].Value.(bool)
+	dryRun := flags["dry-run"].Value.(bool)
+
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if db.Unstaged {
+		fmt.Println("The database was collected with --no-stage, there are no staged files to prune")
+		return nil
+	}
+
+	var pruned, files, unverified int
+	var freed int64
+	err = func() error {
+		for _, attachment := range db.Attachments {
+			if attachment.Pruned || attachment.Deleted || len(attachment.JIRAIDs) == 0 {
+				continue
+			}
+			if verified && !attachment.VerifiedSinceUpload(checksums) {
+				unverified++
+				continue
+			}
+			for _, path := range prunablePaths(attachment) {
+				info, err := os.Stat(path)
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed reading staged file %s: %w", path, err)
+				}
+				if dryRun {
+					fmt.Printf("Would delete %s\n", path)
+				} else {
+					err = os.Remove(path)
+					if err != nil {
+						return fmt.Errorf("failed deleting staged file %s: %w", path, err)
+					}
+				}
+				files++
+				freed += info.Size()
+			}
+			attachment.Pruned = !dryRun
+			pruned++
+		}
+		return nil
+	}()
+	// The attachments pruned before any failure are recorded all the same, as
+	// their files are gone.
+	if !dryRun && pruned > 0 {
+		saveErr := store.Save(db)
+		if saveErr != nil {
+			if err != nil {
+				return fmt.Errorf("%w\n%s", err, saveErr)
+			}
+			return saveErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	verb := "Pruned"
+	if dryRun {
+		verb = "Would prune"
+	}
+	fmt.Printf("%s %d uploaded attachments, deleting %d staged files and freeing %s\n", verb, pruned, files, formatSize(freed))
+	if unverified > 0 {
+		check := "verify"
+		if checksums {
+			check = "verify --checksums"
+		}
+		fmt.Printf("Kept %d uploaded attachments not yet checked by %s since they were uploaded\n", unverified, check)
+	}
+	return nil
+}
+
+// prunablePaths returns the staged files of the attachment: the file itself,
+// its split volumes, and its downscaled copy.
+func prunablePaths(attachment *store.Attachment) []string {
+	paths := []string{filepath.Join(store.StageDir, filepath.FromSlash(attachment.Path))}
+	for _, part := range attachment.Parts {
+		paths = append(paths, filepath.Join(store.StageDir, filepath.FromSlash(part)))
+	}
+	if attachment.Downscaled != "" {
+		paths = append(paths, filepath.Join(store.StageDir, filepath.FromSlash(attachment.Downscaled)))
+	}
+	return paths
+}
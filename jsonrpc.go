@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// rpcMethods are the commands that can be driven over JSON-RPC and run as
+// jobs by serve.
+var rpcMethods = map[string]bool{
+	"collect":  true,
+	"fetch":    true,
+	"match":    true,
+	"doctor":   true,
+	"upload":   true,
+	"plan":     true,
+	"status":   true,
+	"verify":   true,
+	"rewrite":  true,
+	"snapshot": true,
+	"db":       true,
+	"gc":       true,
+	"delete":   true,
+	"restore":  true,
+	"archive":  true,
+	"extract":  true,
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcParams are the arguments and flags of the command, with flags named as
+// on the command line without the leading dashes.
+type rpcParams struct {
+	Args  []string               `json:"args"`
+	Flags map[string]interface{} `json:"flags"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// rpcEvent is a line of command output streamed while a request runs.
+type rpcEvent struct {
+	Request json.RawMessage `json:"request"`
+	Stream  string          `json:"stream"`
+	Line    string          `json:"line"`
+}
+
+type rpcResult struct {
+	ExitCode int `json:"exit_code"`
+}
+
+// rpcServer reads newline delimited JSON-RPC requests and runs each one as
+// a child process of this executable, streaming its output as event
+// notifications before responding with the exit code.
+type rpcServer struct {
+	executable string
+	in         io.Reader
+	mu         sync.Mutex
+	out        *json.Encoder
+}
+
+func serveJSONRPC() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed locating executable: %w", err)
+	}
+	server := &rpcServer{
+		executable: executable,
+		in:         os.Stdin,
+		out:        json.NewEncoder(os.Stdout),
+	}
+	return server.serve()
+}
+
+func (s *rpcServer) serve() error {
+	scanner := bufio.NewScanner(s.in)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req rpcRequest
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		if err != nil {
+			s.fail(nil, rpcParseError, fmt.Sprintf("failed parsing request: %s", err))
+			continue
+		}
+		s.handle(&req)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed reading requests: %w", err)
+	}
+	return nil
+}
+
+func (s *rpcServer) handle(req *rpcRequest) {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		s.fail(req.ID, rpcInvalidRequest, "requests must set jsonrpc to 2.0 and name a method")
+		return
+	}
+	if !rpcMethods[req.Method] {
+		s.fail(req.ID, rpcMethodNotFound, fmt.Sprintf("unknown method %s", req.Method))
+		return
+	}
+	params := &rpcParams{}
+	if len(req.Params) > 0 {
+		err := json.Unmarshal(req.Params, params)
+		if err != nil {
+			s.fail(req.ID, rpcInvalidParams, fmt.Sprintf("failed parsing params: %s", err))
+			return
+		}
+	}
+	args, env, err := params.commandLine(req.Method)
+	if err != nil {
+		s.fail(req.ID, rpcInvalidParams, err.Error())
+		return
+	}
+
+	code, err := s.run(req.ID, args, env)
+	if err != nil {
+		s.fail(req.ID, rpcInternalError, err.Error())
+		return
+	}
+	s.send(&rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: &rpcResult{ExitCode: code}})
+}
+
+// commandLine converts the params into the command line of the method and
+// the environment variables added for it. True booleans become bare flags
+// and false booleans are left out. Secret flags are passed in environment
+// variables rather than on the command line, which every user of the
+// machine can read. Arguments starting with a dash are refused, as commando
+// would take them for flags and they would slip past the checks of the
+// flags.
+func (p *rpcParams) commandLine(method string) ([]string, []string, error) {
+	for _, arg := range p.Args {
+		if strings.HasPrefix(arg, "-") {
+			return nil, nil, fmt.Errorf("argument %s cannot start with a dash, pass flags in flags", arg)
+		}
+	}
+	secrets := make(map[string]bool)
+	for _, name := range referenceFlags {
+		secrets[name] = true
+	}
+	args := append([]string{method}, p.Args...)
+	var env []string
+	for _, name := range sortedKeys(p.Flags) {
+		var value string
+		switch v := p.Flags[name].(type) {
+		case bool:
+			if v {
+				args = append(args, "--"+name)
+			}
+			continue
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, nil, fmt.Errorf("flag %s must be a string, number, or boolean", name)
+		}
+		if secrets[name] {
+			env = append(env, secretEnv(name)+"="+value)
+			continue
+		}
+		args = append(args, "--"+name, value)
+	}
+	return args, env, nil
+}
+
+func (s *rpcServer) run(id json.RawMessage, args, env []string) (int, error) {
+	return runCommand(s.executable, args, env, func(stream, line string) {
+		s.send(&rpcNotification{
+			JSONRPC: "2.0",
+			Method:  "event",
+			Params:  &rpcEvent{Request: id, Stream: stream, Line: line},
+		})
+	})
+}
+
+// runCommand runs the command line as a child process of executable, with
+// env added to the environment it inherits, passing each line it writes to
+// stdout or stderr to output, and returns its exit code.
+func runCommand(executable string, args, env []string, output func(stream, line string)) (int, error) {
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), env...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed capturing output: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed capturing output: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return 0, fmt.Errorf("failed starting %s: %w", args[0], err)
+	}
+
+	var wg sync.WaitGroup
+	for stream, r := range map[string]io.Reader{"stdout": stdout, "stderr": stderr} {
+		wg.Add(1)
+		go func(stream string, r io.Reader) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				output(stream, scanner.Text())
+			}
+		}(stream, r)
+	}
+	wg.Wait()
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed running %s: %w", args[0], err)
+	}
+	return 0, nil
+}
+
+func (s *rpcServer) fail(id json.RawMessage, code int, message string) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	s.send(&rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}})
+}
+
+func (s *rpcServer) send(message interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Encode(message)
+}
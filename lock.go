@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+)
+
+// workflowLock names the transitions used to temporarily move a ticket out of
+// a status whose workflow properties forbid adding attachments.
+type workflowLock struct {
+	unlock string
+	relock string
+}
+
+// newWorkflowLock returns nil when no unlock transition is configured, in
+// which case blocked tickets are reported and skipped.
+func newWorkflowLock(unlock, relock string) (*workflowLock, error) {
+	if unlock == "none" && relock == "none" {
+		return nil, nil
+	}
+	if unlock == "none" || relock == "none" {
+		return nil, fmt.Errorf("--unlock-transition and --relock-transition must be specified together")
+	}
+	return &workflowLock{
+		unlock: unlock,
+		relock: relock,
+	}, nil
+}
+
+// prepareTicket verifies attachments can be added to the ticket. When its
+// status forbids attachments the ticket is moved through the unlock
+// transition, if configured, and relock is returned so the caller restores it
+// once the uploads finish. Otherwise the blocking status name is returned.
+func prepareTicket(client *jira.Client, key string, lock *workflowLock) (string, bool, error) {
+	allowed, err := canAttach(client, key)
+	if err != nil {
+		return "", false, err
+	}
+	if allowed {
+		return "", false, nil
+	}
+
+	issue, resp, err := client.Issue.Get(key, &jira.GetQueryOptions{Fields: "status"})
+	if err != nil {
+		return "", false, fmt.Errorf("failed retrieving status: %w", jiraRefused(resp, err))
+	}
+	status := "unknown"
+	if issue.Fields != nil && issue.Fields.Status != nil {
+		status = issue.Fields.Status.Name
+	}
+	if lock == nil {
+		return status, false, nil
+	}
+
+	fmt.Printf("Transitioning %s from %s through %s\n", key, status, lock.unlock)
+	err = transitionTicket(client, key, lock.unlock)
+	if err != nil {
+		return "", false, fmt.Errorf("failed unlocking ticket: %w", err)
+	}
+	allowed, err = canAttach(client, key)
+	if err != nil {
+		return "", true, err
+	}
+	if !allowed {
+		return status, true, nil
+	}
+
+	return "", true, nil
+}
+
+// canAttach reports whether the current user may add attachments to the
+// ticket. The permission check is evaluated against the ticket so that
+// workflow status properties such as jira.permission.attach.denied apply.
+func canAttach(client *jira.Client, key string) (bool, error) {
+	endpoint := fmt.Sprintf("rest/api/2/mypermissions?issueKey=%s&permissions=CREATE_ATTACHMENTS", url.QueryEscape(key))
+	req, err := client.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed creating request: %w", err)
+	}
+	var permissions struct {
+		Permissions map[string]struct {
+			HavePermission bool `json:"havePermission"`
+		} `json:"permissions"`
+	}
+	resp, err := client.Do(req, &permissions)
+	if err != nil {
+		return false, fmt.Errorf("failed retrieving permissions: %w", jiraRefused(resp, err))
+	}
+
+	return permissions.Permissions["CREATE_ATTACHMENTS"].HavePermission, nil
+}
+
+// transitionTicket applies the workflow transition with the given name.
+func transitionTicket(client *jira.Client, key, name string) error {
+	transitions, resp, err := client.Issue.GetTransitions(key)
+	if err != nil {
+		return fmt.Errorf("failed listing transitions: %w", jiraRefused(resp, err))
+	}
+	for _, transition := range transitions {
+		if strings.EqualFold(transition.Name, name) {
+			resp, err := client.Issue.DoTransition(key, transition.ID)
+			if err != nil {
+				return fmt.Errorf("failed applying transition %s: %w", name, jiraRefused(resp, err))
+			}
+			return audit.Record(&audit.Entry{Action: audit.ActionTransitioned, Ticket: key, Detail: transition.Name})
+		}
+	}
+
+	return fmt.Errorf("transition %s is not available", name)
+}
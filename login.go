@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/oauth"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/pkg/browser"
+	"github.com/thatisuday/commando"
+)
+
+// login authorizes an Atlassian OAuth app against a Jira Cloud site in the
+// browser and stores the tokens, so commands can authenticate with
+// --jira-secret oauth where API tokens are disabled. With --use-keyring it
+// instead stores the GitHub token and JIRA secret in the OS credential store.
+func login(flags map[string]commando.FlagValue) error {
+	useKeyring := flags["use-keyring"].Value.(bool)
+	clientID := flags["client-id"].Value.(string)
+	clientSecret := flags["client-secret"].Value.(string)
+	jiraURL := flags["jira-url"].Value.(string)
+	port := flags["port"].Value.(int)
+
+	if useKeyring {
+		if clientID != "none" || clientSecret != "none" {
+			return configError(fmt.Errorf("--use-keyring stores secrets rather than authorizing an OAuth app and cannot be used with --client-id or --client-secret"))
+		}
+		return storeKeyring()
+	}
+
+	if clientID == "none" || clientSecret == "none" {
+		return configError(fmt.Errorf("--client-id and --client-secret of the OAuth app must be specified"))
+	}
+	if jiraURL == "none" {
+		jiraURL = ""
+	}
+	output.AddSecret(clientSecret)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	creds, err := oauth.Login(ctx, clientID, clientSecret, jiraURL, port, func(url string) error {
+		fmt.Printf("Authorize the migrator in your browser, opening it at:\n\n%s\n\n", url)
+		if browser.OpenURL(url) != nil {
+			fmt.Println("Failed opening the browser, open the URL yourself")
+		}
+		fmt.Println("Waiting for authorization")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = oauth.Save(creds)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Authorized for %s, pass --jira-secret %s to authenticate with the stored token\n", creds.SiteURL, oauth.Secret)
+	return nil
+}
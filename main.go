@@ -1,312 +1,746 @@
 package main
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/andygrunwald/go-jira"
 	"github.com/google/go-github/v47/github"
+	"github.com/lindluni/attachment-processor/pkg/archive"
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/download"
+	"github.com/lindluni/attachment-processor/pkg/match"
+	"github.com/lindluni/attachment-processor/pkg/oauth"
+	"github.com/lindluni/attachment-processor/pkg/objectstore"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/tracing"
+	"github.com/lindluni/attachment-processor/pkg/upload"
 	"github.com/thatisuday/commando"
 	"golang.org/x/oauth2"
+	"golang.org/x/sync/errgroup"
 )
 
-type database struct {
-	Attachments []*attachment      `json:"attachments"`
-	Issues      map[string]*issue  `json:"issues"`
-	Tickets     map[string]*ticket `json:"tickets"`
-}
-
-type attachment struct {
-	Type          string `json:"type"`
-	URL           string `json:"url"`
-	IssueNumber   int    `json:"issue_number"`
-	CommentNumber int64  `json:"comment_number"`
-	Path          string `json:"path"`
-}
-
-type issue struct {
-	URL    string `json:"url"`
-	Number int    `json:"number"`
-}
-
-type ticket struct {
-	Key      string `json:"key"`
-	Uploaded bool   `json:"uploaded"`
-}
-
 func main() {
 	commando.
 		SetExecutableName("jira-attachment-migrator").
 		SetVersion("v1.0.0").
 		SetDescription("Utility for migrating GitHub issue attachments to JIRA attachments").
 		Register(nil).
+		AddFlag("jsonrpc-stdio", "Accept commands as JSON-RPC requests on stdin and stream their output as events on stdout", commando.Bool, false).
 		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			if flags["jsonrpc-stdio"].Value.(bool) {
+				err := serveJSONRPC()
+				if err != nil {
+					output.Errorf("Failed serving JSON-RPC: %s\n", err)
+					exit(exitCode(err))
+				}
+				return
+			}
+			// commando exits straight after printing the help.
+			output.Flush()
 			commando.Parse([]string{"help"})
 		})
 
 	commando.
-		Register("collect").
-		SetDescription("Creates the relationships between the attachments, GitHub issues, and JIRA tickets").
-		AddFlag("archive", "Path to GitHub repository archive", commando.String, "").
-		AddFlag("skip-archive", "Skip expanding the GitHub repository archive", commando.Bool, false).
-		AddFlag("github-token", "GitHub personal access token", commando.String, "").
-		AddFlag("org", "GitHub organization name", commando.String, "").
-		AddFlag("repo", "GitHub repository name", commando.String, "").
-		AddFlag("jira-url", "JIRA URL", commando.String, "").
-		AddFlag("jira-username", "JIRA username", commando.String, "").
-		AddFlag("jira-secret", "JIRA personal access token or password", commando.String, "").
-		AddFlag("jira-keys", "JIRA project key", commando.String, "").
+		Register("login").
+		SetDescription("Authorizes an OAuth app against a Jira Cloud site in the browser and stores its token for --jira-secret oauth, or stores secrets in the OS keyring").
+		AddFlag("use-keyring", "Prompt for the GitHub token and JIRA secret and store them in the OS credential store instead of authorizing an OAuth app", commando.Bool, false).
+		AddFlag("client-id", "Client ID of the Atlassian OAuth 2.0 (3LO) app", commando.String, "none").
+		AddFlag("client-secret", "Client secret of the Atlassian OAuth 2.0 (3LO) app", commando.String, "none").
+		AddFlag("jira-url", "Jira Cloud site URL, required when the app is authorized for more than one site", commando.String, "none").
+		AddFlag("port", "Local port receiving the authorization callback, the app's callback URL must be http://localhost:<port>/callback", commando.Int, 8085).
 		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
-			err := collect(flags)
+			err := login(flags)
 			if err != nil {
-				fmt.Printf("Failed collecting data: %s\n", err)
+				output.Errorf("Failed logging in: %s\n", err)
+				exit(exitCode(err))
 			}
 		})
 
 	commando.
-		Register("upload").
-		SetDescription("Uploads attachments to JIRA").
-		AddFlag("jira-url", "JIRA URL", commando.String, "").
-		AddFlag("jira-username", "JIRA username", commando.String, "").
-		AddFlag("jira-secret", "JIRA personal access token or password", commando.String, "").
-		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
-			err := upload(flags)
+		Register("collect").
+		SetDescription("Creates the relationships between the attachments, GitHub issues, and JIRA tickets").
+		AddFlag("archive", "Path or https://, s3://, gs://, or azblob:// URL of the GitHub repository archive: a gzipped tarball, plain tarball, or zip archive, or a comma separated list or glob pattern of the archives an export is split across", commando.String, "none").
+		AddFlag("archive-sha256", "SHA-256 checksum an archive downloaded from an https:// URL must match", commando.String, "none").
+		AddFlag("skip-archive", "Skip expanding the GitHub repository archive", commando.Bool, false).
+		AddFlag("skip-github", "Keep the issues of the existing database instead of listing them from GitHub", commando.Bool, false).
+		AddFlag("skip-jira", "Keep the tickets the existing database linked each issue to instead of listing and matching JIRA tickets", commando.Bool, false).
+		AddFlag("attachments-only", "Only rebuild the attachments from the archive, keeping the issues and tickets of the existing database, as --skip-github --skip-jira", commando.Bool, false).
+		AddFlag("no-stage", "Leave the attachments in the archive, only hashing them, so upload --no-stage streams them out of it without a staging copy", commando.Bool, false).
+		AddFlag("github-token", "GitHub personal access token", commando.String, "none").
+		AddFlag("github-token-file", "File holding the GitHub token, or - to read it from standard input", commando.String, "none").
+		AddFlag("org", "GitHub organization name", commando.String, "none").
+		AddFlag("repo", "GitHub repository name", commando.String, "none").
+		AddFlag("github-api", "GitHub API issues are listed through: graphql, or rest for GitHub Enterprise Server versions without GraphQL", commando.String, "graphql").
+		AddFlag("no-github-cache", "Send every GitHub REST request in full instead of revalidating the responses cached by earlier runs", commando.Bool, false).
+		AddFlag("jira-url", "JIRA URL", commando.String, "none").
+		AddFlag("jira-username", "JIRA username", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password, or oauth for the token stored by login", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the GitHub token and JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		AddFlag("proxy", "HTTP or HTTPS proxy URL the GitHub and JIRA requests are sent through, instead of the HTTPS_PROXY environment variable", commando.String, "none").
+		AddFlag("ca-cert", "PEM file of certificate authorities to trust in addition to the system ones, such as a private CA", commando.String, "none").
+		AddFlag("insecure-skip-verify", "Skip TLS certificate verification, only for testing since connections can be intercepted", commando.Bool, false).
+		AddFlag("jira-client-cert", "PEM client certificate presented to JIRA deployments requiring mutual TLS", commando.String, "none").
+		AddFlag("jira-client-key", "PEM private key of the JIRA client certificate", commando.String, "none").
+		AddFlag("jira-keys", "JIRA project key", commando.String, "none").
+		AddFlag("jira-search-workers", "Number of JIRA searches run at a time, each listing a range of ticket creation dates", commando.Int, 4).
+		AddFlag("target", "Tracker the attachments are uploaded to: jira, azure-devops, or confluence", commando.String, "jira").
+		AddFlag("azure-devops-url", "Azure DevOps organization URL such as https://dev.azure.com/my-org, for the azure-devops target", commando.String, "none").
+		AddFlag("azure-devops-project", "Azure DevOps project holding the work items, for the azure-devops target", commando.String, "none").
+		AddFlag("azure-devops-token", "Azure DevOps personal access token with the Work Items read and write scope, for the azure-devops target", commando.String, "none").
+		AddFlag("ticket-keys-file", "File listing one JIRA ticket key per line to fetch individually instead of searching the projects", commando.String, "none").
+		AddFlag("ticket-key-range", "Range of JIRA ticket keys such as PROJ-1..PROJ-5000 to fetch individually instead of searching the projects", commando.String, "none").
+		AddFlag("match-strategy", "How GitHub issues are matched to JIRA tickets: title-exact, title-normalized, custom-field, mapping-file, or regex-extract", commando.String, "title-exact").
+		AddFlag("match-field", "JIRA custom field such as customfield_10042 holding the GitHub issue URL or number, for the custom-field strategy", commando.String, "none").
+		AddFlag("match-mapping-file", "File listing one GitHub issue number and JIRA ticket key per line such as 12,PROJ-34, for the mapping-file strategy", commando.String, "none").
+		AddFlag("match-pattern", "Regular expression whose capture group extracts the GitHub issue number from JIRA ticket summaries, for the regex-extract strategy", commando.String, "none").
+		AddFlag("only-issues", "Comma separated GitHub issue numbers or ranges to include", commando.String, "all").
+		AddFlag("skip-issues", "Comma separated GitHub issue numbers or ranges to exclude", commando.String, "none").
+		AddFlag("labels", "Comma separated GitHub labels issues must have", commando.String, "all").
+		AddFlag("state", "GitHub issue state to collect: all, open, or closed", commando.String, "all").
+		AddFlag("since", "Only collect GitHub issues updated at or after this date (YYYY-MM-DD or RFC 3339), or last for the previous collect, merging into the existing database", commando.String, "none").
+		AddFlag("until", "Only collect GitHub issues updated at or before this date (YYYY-MM-DD or RFC 3339)", commando.String, "none").
+		AddFlag("edit-history", "Whether to include or exclude attachments only referenced by earlier edits of an issue or comment", commando.String, "include").
+		AddFlag("dedup", "Duplicate content policy: all uploads every copy, once uploads identical content only to the first ticket, skip-existing skips files already attached to a ticket with the same name and size", commando.String, "all").
+		AddFlag("hash", "Checksum algorithm used for dedup and verification: sha256, sha512, or blake3", commando.String, "sha256").
+		AddFlag("fips", "Only allow FIPS approved checksum algorithms", commando.Bool, false).
+		AddFlag("no-space-check", "Expand the archive without first checking the staging volume has room for the attachments", commando.Bool, false).
+		AddFlag("otlp-endpoint", "URL of an OTLP/HTTP collector such as http://localhost:4318 the run's GitHub and JIRA requests, pages, and attachments are exported to as OpenTelemetry spans", commando.String, "none").
+		AddFlag("record", "Record every HTTP response of the run to this cassette file, for --replay to reproduce the run", commando.String, "none").
+		AddFlag("replay", "Answer every HTTP request of the run from the responses recorded in this cassette file instead of sending it", commando.String, "none").
+		AddFlag("summary-file", "Write a JSON summary of the run's counts, duration, and failures to this file", commando.String, "none").
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("verbose,v", "Print every attachment and page processed as well as the progress of the run, or every HTTP request too with -vv", commando.Bool, false).
+		AddFlag("debug", "Print every HTTP request sent as well as every attachment and page processed, the same as -vv", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(withStateLock("collect", withTransport(withTracing(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			summary := newRunSummary("collect")
+			err := summary.write(flags["summary-file"].Value.(string), collectCommand(flags, summary))
 			if err != nil {
-				fmt.Printf("Failed uploading attachments: %s\n", err)
+				output.Errorf("Failed collecting data: %s\n", err)
+				exit(exitCode(err))
 			}
-		})
+		})))))))
 
 	commando.
-		Register("archive").
-		SetDescription("Generates an archive of the exported attachments").
-		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
-			err := archive()
+		Register("fetch").
+		SetDescription("Downloads attachments of GitHub, GitLab, or Bitbucket issues and creates the relationships between the attachments, issues, and JIRA tickets").
+		AddFlag("source", "Issue tracker to fetch attachments from: github, gitlab, or bitbucket", commando.String, "github").
+		AddFlag("github-token", "GitHub personal access token", commando.String, "none").
+		AddFlag("github-token-file", "File holding the GitHub token, or - to read it from standard input", commando.String, "none").
+		AddFlag("gitlab-url", "GitLab URL, for the gitlab source", commando.String, "https://gitlab.com").
+		AddFlag("gitlab-token", "GitLab personal access token with the read_api scope, for the gitlab source", commando.String, "none").
+		AddFlag("bitbucket-url", "Bitbucket REST API URL, for the bitbucket source", commando.String, "https://api.bitbucket.org/2.0").
+		AddFlag("bitbucket-username", "Bitbucket username the secret is an app password of, omit to use an access token", commando.String, "none").
+		AddFlag("bitbucket-secret", "Bitbucket app password or access token, for the bitbucket source", commando.String, "none").
+		AddFlag("org", "GitHub organization name, GitLab group path, or Bitbucket workspace", commando.String, "none").
+		AddFlag("repo", "GitHub repository name, GitLab project name, or Bitbucket repository slug", commando.String, "none").
+		AddFlag("github-api", "GitHub API issues are listed through: graphql, or rest for GitHub Enterprise Server versions without GraphQL", commando.String, "graphql").
+		AddFlag("no-github-cache", "Send every GitHub REST request in full instead of revalidating the responses cached by earlier runs", commando.Bool, false).
+		AddFlag("jira-url", "JIRA URL, for the jira target", commando.String, "none").
+		AddFlag("jira-username", "JIRA username, for the jira target", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password, or oauth for the token stored by login", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the GitHub token and JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		AddFlag("proxy", "HTTP or HTTPS proxy URL the GitHub and JIRA requests are sent through, instead of the HTTPS_PROXY environment variable", commando.String, "none").
+		AddFlag("ca-cert", "PEM file of certificate authorities to trust in addition to the system ones, such as a private CA", commando.String, "none").
+		AddFlag("insecure-skip-verify", "Skip TLS certificate verification, only for testing since connections can be intercepted", commando.Bool, false).
+		AddFlag("jira-client-cert", "PEM client certificate presented to JIRA deployments requiring mutual TLS", commando.String, "none").
+		AddFlag("jira-client-key", "PEM private key of the JIRA client certificate", commando.String, "none").
+		AddFlag("jira-keys", "JIRA project key, for the jira target", commando.String, "none").
+		AddFlag("jira-search-workers", "Number of JIRA searches run at a time, each listing a range of ticket creation dates", commando.Int, 4).
+		AddFlag("target", "Tracker the attachments are uploaded to: jira, azure-devops, or confluence", commando.String, "jira").
+		AddFlag("azure-devops-url", "Azure DevOps organization URL such as https://dev.azure.com/my-org, for the azure-devops target", commando.String, "none").
+		AddFlag("azure-devops-project", "Azure DevOps project holding the work items, for the azure-devops target", commando.String, "none").
+		AddFlag("azure-devops-token", "Azure DevOps personal access token with the Work Items read and write scope, for the azure-devops target", commando.String, "none").
+		AddFlag("ticket-keys-file", "File listing one JIRA ticket key per line to fetch individually instead of searching the projects", commando.String, "none").
+		AddFlag("ticket-key-range", "Range of JIRA ticket keys such as PROJ-1..PROJ-5000 to fetch individually instead of searching the projects", commando.String, "none").
+		AddFlag("match-strategy", "How GitHub issues are matched to JIRA tickets: title-exact, title-normalized, custom-field, mapping-file, or regex-extract", commando.String, "title-exact").
+		AddFlag("match-field", "JIRA custom field such as customfield_10042 holding the GitHub issue URL or number, for the custom-field strategy", commando.String, "none").
+		AddFlag("match-mapping-file", "File listing one GitHub issue number and JIRA ticket key per line such as 12,PROJ-34, for the mapping-file strategy", commando.String, "none").
+		AddFlag("match-pattern", "Regular expression whose capture group extracts the GitHub issue number from JIRA ticket summaries, for the regex-extract strategy", commando.String, "none").
+		AddFlag("only-issues", "Comma separated GitHub issue numbers or ranges to include", commando.String, "all").
+		AddFlag("skip-issues", "Comma separated GitHub issue numbers or ranges to exclude", commando.String, "none").
+		AddFlag("labels", "Comma separated GitHub labels issues must have", commando.String, "all").
+		AddFlag("state", "GitHub issue state to collect: all, open, or closed", commando.String, "all").
+		AddFlag("since", "Only collect GitHub issues updated at or after this date (YYYY-MM-DD or RFC 3339), or last for the previous collect, merging into the existing database", commando.String, "none").
+		AddFlag("until", "Only collect GitHub issues updated at or before this date (YYYY-MM-DD or RFC 3339)", commando.String, "none").
+		AddFlag("dedup", "Duplicate content policy: all uploads every copy, once uploads identical content only to the first ticket, skip-existing skips files already attached to a ticket with the same name and size", commando.String, "all").
+		AddFlag("hash", "Checksum algorithm used for dedup and verification: sha256, sha512, or blake3", commando.String, "sha256").
+		AddFlag("fips", "Only allow FIPS approved checksum algorithms", commando.Bool, false).
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("verbose,v", "Print every attachment and page processed as well as the progress of the run, or every HTTP request too with -vv", commando.Bool, false).
+		AddFlag("debug", "Print every HTTP request sent as well as every attachment and page processed, the same as -vv", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(withStateLock("fetch", withTransport(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := fetch(flags)
 			if err != nil {
-				fmt.Printf("Failed archiving attachments: %s\n", err)
+				output.Errorf("Failed fetching data: %s\n", err)
+				exit(exitCode(err))
 			}
-		})
+		}))))))
 
-	commando.Parse(nil)
-}
+	commando.
+		Register("match").
+		SetDescription("Previews how GitHub issues match JIRA tickets before any attachments are staged").
+		AddArgument("action", "Matching action to run, currently only preview", "").
+		AddFlag("issues-file", "JSON file listing GitHub issues as title, url, and number, instead of querying GitHub", commando.String, "none").
+		AddFlag("tickets-file", "JSON file listing JIRA tickets as summary and key, instead of querying JIRA", commando.String, "none").
+		AddFlag("github-token", "GitHub personal access token", commando.String, "none").
+		AddFlag("github-token-file", "File holding the GitHub token, or - to read it from standard input", commando.String, "none").
+		AddFlag("org", "GitHub organization name", commando.String, "none").
+		AddFlag("repo", "GitHub repository name", commando.String, "none").
+		AddFlag("github-api", "GitHub API issues are listed through: graphql, or rest for GitHub Enterprise Server versions without GraphQL", commando.String, "graphql").
+		AddFlag("no-github-cache", "Send every GitHub REST request in full instead of revalidating the responses cached by earlier runs", commando.Bool, false).
+		AddFlag("jira-url", "JIRA URL", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password, or oauth for the token stored by login", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the GitHub token and JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		AddFlag("proxy", "HTTP or HTTPS proxy URL the GitHub and JIRA requests are sent through, instead of the HTTPS_PROXY environment variable", commando.String, "none").
+		AddFlag("ca-cert", "PEM file of certificate authorities to trust in addition to the system ones, such as a private CA", commando.String, "none").
+		AddFlag("insecure-skip-verify", "Skip TLS certificate verification, only for testing since connections can be intercepted", commando.Bool, false).
+		AddFlag("jira-client-cert", "PEM client certificate presented to JIRA deployments requiring mutual TLS", commando.String, "none").
+		AddFlag("jira-client-key", "PEM private key of the JIRA client certificate", commando.String, "none").
+		AddFlag("jira-keys", "JIRA project key", commando.String, "none").
+		AddFlag("jira-search-workers", "Number of JIRA searches run at a time, each listing a range of ticket creation dates", commando.Int, 4).
+		AddFlag("match-strategy", "How GitHub issues are matched to JIRA tickets: title-exact, title-normalized, custom-field, mapping-file, or regex-extract", commando.String, "title-exact").
+		AddFlag("match-field", "JIRA custom field such as customfield_10042 holding the GitHub issue URL or number, for the custom-field strategy", commando.String, "none").
+		AddFlag("match-mapping-file", "File listing one GitHub issue number and JIRA ticket key per line such as 12,PROJ-34, for the mapping-file strategy", commando.String, "none").
+		AddFlag("match-pattern", "Regular expression whose capture group extracts the GitHub issue number from JIRA ticket summaries, for the regex-extract strategy", commando.String, "none").
+		SetAction(withTransport(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := matchCommand(args, flags)
+			if err != nil {
+				output.Errorf("Failed matching issues: %s\n", err)
+				exit(exitCode(err))
+			}
+		})))
 
-func newJIRAClient(secret, url string) (*jira.Client, error) {
-	tp := jira.BearerAuthTransport{
-		Token: secret,
-	}
+	commando.
+		Register("doctor").
+		SetDescription("Validates connectivity, credentials, permissions, disk space, the archive, and the database before a migration").
+		AddFlag("github-token", "GitHub personal access token", commando.String, "none").
+		AddFlag("github-token-file", "File holding the GitHub token, or - to read it from standard input", commando.String, "none").
+		AddFlag("jira-url", "JIRA URL", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password, or oauth for the token stored by login", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the GitHub token and JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		AddFlag("proxy", "HTTP or HTTPS proxy URL the GitHub and JIRA requests are sent through, instead of the HTTPS_PROXY environment variable", commando.String, "none").
+		AddFlag("ca-cert", "PEM file of certificate authorities to trust in addition to the system ones, such as a private CA", commando.String, "none").
+		AddFlag("insecure-skip-verify", "Skip TLS certificate verification, only for testing since connections can be intercepted", commando.Bool, false).
+		AddFlag("jira-client-cert", "PEM client certificate presented to JIRA deployments requiring mutual TLS", commando.String, "none").
+		AddFlag("jira-client-key", "PEM private key of the JIRA client certificate", commando.String, "none").
+		AddFlag("jira-keys", "JIRA project key", commando.String, "none").
+		AddFlag("archive", "Path or https:// or object storage URL of the GitHub repository archive to check is readable, or a comma separated list or glob pattern of the archives an export is split across", commando.String, "none").
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withPaths(withTransport(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := doctor(flags)
+			if err != nil {
+				output.Errorf("Failed diagnostics: %s\n", err)
+				exit(exitCode(err))
+			}
+		}))))
 
-	return jira.NewClient(tp.Client(), url)
-}
+	commando.
+		Register("bench").
+		SetDescription("Uploads synthetic files to a scratch JIRA ticket and reports the throughput and latency percentiles, to predict how long the migration will take").
+		AddFlag("jira-url", "JIRA URL", commando.String, "none").
+		AddFlag("jira-username", "JIRA username", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password, or oauth for the token stored by login", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		AddFlag("proxy", "HTTP or HTTPS proxy URL the GitHub and JIRA requests are sent through, instead of the HTTPS_PROXY environment variable", commando.String, "none").
+		AddFlag("ca-cert", "PEM file of certificate authorities to trust in addition to the system ones, such as a private CA", commando.String, "none").
+		AddFlag("insecure-skip-verify", "Skip TLS certificate verification, only for testing since connections can be intercepted", commando.Bool, false).
+		AddFlag("jira-client-cert", "PEM client certificate presented to JIRA deployments requiring mutual TLS", commando.String, "none").
+		AddFlag("jira-client-key", "PEM private key of the JIRA client certificate", commando.String, "none").
+		AddFlag("ticket", "Key of the scratch ticket the synthetic files are uploaded to", commando.String, "none").
+		AddFlag("files", "Number of synthetic files to upload", commando.Int, 20).
+		AddFlag("sizes", "Comma separated sizes of the synthetic files, such as 100KB,1MB,10MB, used in turn", commando.String, "1MB").
+		AddFlag("concurrency", "Number of files uploaded at the same time", commando.Int, 1).
+		AddFlag("keep", "Leave the synthetic files on the ticket instead of deleting them once measured", commando.Bool, false).
+		AddFlag("yes", "Upload without asking to confirm the ticket and the files first, for automation", commando.Bool, false).
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("verbose,v", "Print every attachment and page processed as well as the progress of the run, or every HTTP request too with -vv", commando.Bool, false).
+		AddFlag("debug", "Print every HTTP request sent as well as every attachment and page processed, the same as -vv", commando.Bool, false).
+		AddFlag("db-path", "Path of the database whose pending uploads the measured throughput estimates the duration of", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(withTransport(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := bench(flags)
+			if err != nil {
+				output.Errorf("Failed benchmarking uploads: %s\n", err)
+				exit(exitCode(err))
+			}
+		})))))
 
-func newGitHubClient(token string) *github.Client {
-	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
+	commando.
+		Register("upload").
+		SetDescription("Uploads attachments to JIRA, to Azure DevOps work items, or to Confluence pages").
+		AddFlag("jira-url", "JIRA URL, for the jira target", commando.String, "none").
+		AddFlag("jira-username", "JIRA username, for the jira target", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password, or oauth for the token stored by login", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		AddFlag("proxy", "HTTP or HTTPS proxy URL the GitHub and JIRA requests are sent through, instead of the HTTPS_PROXY environment variable", commando.String, "none").
+		AddFlag("ca-cert", "PEM file of certificate authorities to trust in addition to the system ones, such as a private CA", commando.String, "none").
+		AddFlag("insecure-skip-verify", "Skip TLS certificate verification, only for testing since connections can be intercepted", commando.Bool, false).
+		AddFlag("jira-client-cert", "PEM client certificate presented to JIRA deployments requiring mutual TLS", commando.String, "none").
+		AddFlag("jira-client-key", "PEM private key of the JIRA client certificate", commando.String, "none").
+		AddFlag("target", "Tracker the attachments are uploaded to: jira, azure-devops, or confluence", commando.String, "jira").
+		AddFlag("azure-devops-url", "Azure DevOps organization URL such as https://dev.azure.com/my-org, for the azure-devops target", commando.String, "none").
+		AddFlag("azure-devops-project", "Azure DevOps project holding the work items, for the azure-devops target", commando.String, "none").
+		AddFlag("azure-devops-token", "Azure DevOps personal access token with the Work Items read and write scope, for the azure-devops target", commando.String, "none").
+		AddFlag("confluence-url", "Confluence URL such as https://my-org.atlassian.net/wiki, for the confluence target", commando.String, "none").
+		AddFlag("confluence-space", "Key of the Confluence space the issue pages are created in, for the confluence target", commando.String, "none").
+		AddFlag("confluence-parent", "ID of the Confluence page the issue pages are created under, for the confluence target", commando.String, "none").
+		AddFlag("confluence-username", "Confluence username, omitted to authenticate with a personal access token, for the confluence target", commando.String, "none").
+		AddFlag("confluence-token", "Confluence API token or personal access token, for the confluence target", commando.String, "none").
+		AddFlag("storage-headroom", "Remaining JIRA attachment storage in bytes, used to warn before exceeding the quota", commando.Int, 0).
+		AddFlag("only-issues", "Comma separated GitHub issue numbers or ranges to include", commando.String, "all").
+		AddFlag("skip-issues", "Comma separated GitHub issue numbers or ranges to exclude", commando.String, "none").
+		AddFlag("ticket", "Comma separated keys of the tickets to upload, such as PROJ-123, leaving the rest of the database untouched", commando.String, "none").
+		AddFlag("issue", "Comma separated numbers of the GitHub issues to upload, leaving the rest of the database untouched", commando.String, "none").
+		AddFlag("create-missing", "Create a JIRA ticket for each GitHub issue with attachments that matched no ticket, summarized by the issue title, labelled with its labels, and linking back to it", commando.Bool, false).
+		AddFlag("project", "Key of the JIRA project tickets are created in with --create-missing", commando.String, "none").
+		AddFlag("issue-type", "JIRA issue type of the tickets created with --create-missing", commando.String, "Task").
+		AddFlag("template", "Go template file of the description of tickets created with --create-missing, executed with {{.Title}}, {{.URL}}, {{.Number}}, and {{.Labels}} of the GitHub issue", commando.String, "none").
+		AddFlag("migrate-content", "Copy the archived description and comments of each GitHub issue onto its ticket as comments prefixed with their author and time, uploading each one's attachments right after it", commando.Bool, false).
+		AddFlag("user-map", "CSV file mapping GitHub logins to JIRA users, as accountid:<id> on JIRA Cloud or a username on JIRA Server, who are mentioned in migrated comments and made the reporters of created tickets", commando.String, "none").
+		AddFlag("order", "Order the tickets are uploaded in: title, issue-asc, issue-desc, size-asc, or size-desc, where the size orders also order each ticket's attachments", commando.String, "title").
+		AddFlag("plan", "Plan file saved by plan --output to execute verbatim, uploading exactly its attachments in its order and refusing to run when the database no longer matches it", commando.String, "none").
+		AddFlag("unlock-transition", "Workflow transition used to reopen tickets whose status forbids attachments", commando.String, "none").
+		AddFlag("relock-transition", "Workflow transition used to return unlocked tickets to their original status", commando.String, "none").
+		AddFlag("render-policy", "JSON file listing extensions JIRA cannot preview or blocks, instead of the built-in list", commando.String, "none").
+		AddFlag("include-ext", "Comma separated file extensions to include, leaving out every other attachment", commando.String, "all").
+		AddFlag("exclude-ext", "Comma separated file extensions to leave out, such as exe,mp4", commando.String, "none").
+		AddFlag("exclude-type", "Comma separated MIME types detected from the file contents to leave out, such as video/* or application/x-executable", commando.String, "none").
+		AddFlag("name-template", "Go template naming each attachment file from {{.IssueNumber}}, {{.CommentNumber}}, {{.Name}}, {{.Base}}, {{.Ext}}, {{.Type}}, and {{.Key}}, such as {{.IssueNumber}}_{{.CommentNumber}}_{{.Name}}", commando.String, "none").
+		AddFlag("no-sanitize-names", "Keep attachment file names as they are instead of normalizing them to Unicode NFC, replacing characters Windows or JIRA reject, and truncating them to 240 bytes", commando.Bool, false).
+		AddFlag("retries", "Number of times to retry a failed attachment upload", commando.Int, 0).
+		AddFlag("stall-timeout", "Minutes an upload may make no progress before it is cancelled and restarted, 0 to wait indefinitely", commando.Int, 5).
+		AddFlag("continue-on-error", "Record failed uploads and move on, exiting non-zero with a summary of the failures at the end", commando.Bool, false).
+		AddFlag("retry-failed", "Only reattempt attachments that failed to upload in an earlier run", commando.Bool, false).
+		AddFlag("block-executables", "Quarantine attachments whose contents are programs or scripts instead of uploading them", commando.Bool, false).
+		AddFlag("clamd", "Unix socket path or tcp://host:port of a ClamAV daemon every attachment is scanned with before upload", commando.String, "none").
+		AddFlag("scan-command", "Command every attachment is scanned with before upload, with {file} standing for its path, exiting 1 when the file is infected", commando.String, "none").
+		AddFlag("rescreen", "Only screen again the attachments quarantined by screening in an earlier run and upload those now found clean", commando.Bool, false).
+		AddFlag("atomic", "Remove a ticket's attachments posted in this run when any of its uploads fail, so the ticket is retried as a whole", commando.Bool, false).
+		AddFlag("no-stage", "Stream the attachments out of the archive given by --archive instead of reading them from the staging directory, for databases collected with --no-stage", commando.Bool, false).
+		AddFlag("archive", "Path or https:// or object storage URL of the GitHub repository archive the attachments are streamed from with --no-stage", commando.String, "none").
+		AddFlag("archive-sha256", "SHA-256 checksum an archive downloaded from an https:// URL must match", commando.String, "none").
+		AddFlag("max-image-bytes", "Upload a re-encoded and, if need be, downscaled copy of PNG and JPEG attachments larger than this many bytes, keeping the original for the archive, 0 to upload images as they are", commando.Int, 0).
+		AddFlag("split-oversize", "Zip attachments over the JIRA upload limit into numbered volumes under the limit and upload every volume", commando.Bool, false).
+		AddFlag("bundle-per-ticket", "Zip all of a ticket's attachments into one file named after the GitHub issue and upload it in their place, for instances capping the attachments per ticket", commando.Bool, false).
+		AddFlag("provenance-comment", "Leave one comment per run on each ticket listing the attachments uploaded, their GitHub source, and when they were uploaded", commando.Bool, false).
+		AddFlag("provenance-update", "Keep a single provenance comment per ticket listing every migrated attachment, updated in place on later runs", commando.Bool, false).
+		AddFlag("remote-link", "Add a remote link on each ticket back to the GitHub issue it was migrated from", commando.Bool, false).
+		AddFlag("entity-property", "Key of an entity property, such as github.migration, written on each ticket with the GitHub issue, the migrated attachments, and the run ID", commando.String, "none").
+		AddFlag("comment-markers", "Regular expression matching the JIRA comment migrated from a GitHub comment, with {comment} standing for the GitHub comment ID and {url} for its URL, used to mark which attachments belong to the comment", commando.String, "none").
+		AddFlag("fips", "Refuse to upload a database whose checksums do not use a FIPS approved algorithm", commando.Bool, false).
+		AddFlag("on-conflict", "What to do when a file of the same name and size is already attached to the ticket: skip, rename, or replace", commando.String, "skip").
+		AddFlag("otlp-endpoint", "URL of an OTLP/HTTP collector such as http://localhost:4318 the run's GitHub and JIRA requests, pages, and attachments are exported to as OpenTelemetry spans", commando.String, "none").
+		AddFlag("record", "Record every HTTP response of the run to this cassette file, for --replay to reproduce the run", commando.String, "none").
+		AddFlag("replay", "Answer every HTTP request of the run from the responses recorded in this cassette file instead of sending it", commando.String, "none").
+		AddFlag("simulate", "Rehearse the upload against an in-process fake JIRA holding the database's tickets, on a copy of the database, leaving JIRA and the database untouched", commando.Bool, false).
+		AddFlag("simulate-failure-rate", "Percentage of uploads the fake JIRA of --simulate fails at random with 503 Service Unavailable, to rehearse retries", commando.Int, 10).
+		AddFlag("simulate-upload-limit", "Largest attachment the fake JIRA of --simulate accepts, such as 10MB, rejecting larger ones as JIRA does", commando.String, "10MB").
+		AddFlag("summary-file", "Write a JSON summary of the run's counts, duration, and failures to this file", commando.String, "none").
+		AddFlag("yes", "Upload without asking to confirm the instance, projects, and attachment counts first, for automation", commando.Bool, false).
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("verbose,v", "Print every attachment and page processed as well as the progress of the run, or every HTTP request too with -vv", commando.Bool, false).
+		AddFlag("debug", "Print every HTTP request sent as well as every attachment and page processed, the same as -vv", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(withStateLock("upload", withTransport(withTracing(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			summary := newRunSummary("upload")
+			err := summary.write(flags["summary-file"].Value.(string), uploadCommand(flags, summary))
+			if err != nil {
+				output.Errorf("Failed uploading attachments: %s\n", err)
+				exit(exitCode(err))
+			}
+		})))))))
 
-	return github.NewClient(tc)
-}
+	commando.
+		Register("plan").
+		SetDescription("Previews the uploads an upload would perform and saves them for upload --plan").
+		AddFlag("only-issues", "Comma separated GitHub issue numbers or ranges to include", commando.String, "all").
+		AddFlag("skip-issues", "Comma separated GitHub issue numbers or ranges to exclude", commando.String, "none").
+		AddFlag("ticket", "Comma separated keys of the tickets to upload, such as PROJ-123, leaving the rest of the database untouched", commando.String, "none").
+		AddFlag("issue", "Comma separated numbers of the GitHub issues to upload, leaving the rest of the database untouched", commando.String, "none").
+		AddFlag("order", "Order the tickets are uploaded in: title, issue-asc, issue-desc, size-asc, or size-desc, where the size orders also order each ticket's attachments", commando.String, "title").
+		AddFlag("retry-failed", "Only plan to reattempt attachments that failed to upload in an earlier run", commando.Bool, false).
+		AddFlag("output", "File to save the plan to, for upload --plan to execute", commando.String, "none").
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withPaths(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := plan(flags)
+			if err != nil {
+				output.Errorf("Failed planning uploads: %s\n", err)
+				exit(exitCode(err))
+			}
+		}))
 
-func expand(path string) error {
-	r, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("error opening tarball %s: %s", path, err)
-	}
+	commando.
+		Register("status").
+		SetDescription("Summarises the migration progress recorded in the database").
+		AddFlag("output", "Output format: text or json", commando.String, "text").
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withPaths(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := status(flags)
+			if err != nil {
+				output.Errorf("Failed reading status: %s\n", err)
+				exit(exitCode(err))
+			}
+		}))
 
-	gzr, err := gzip.NewReader(r)
-	if err != nil {
-		return err
-	}
-	defer gzr.Close()
+	commando.
+		Register("serve").
+		SetDescription("Serves an HTTP API starting collect, upload, and the other commands as jobs, streaming their output, and reporting per-ticket status, and a dashboard built on it").
+		AddFlag("listen", "Address the API listens on", commando.String, "localhost:8080").
+		AddFlag("token", "Bearer token every API request must present in its Authorization header, generated and printed when not given", commando.String, "none").
+		AddFlag("allowed-hosts", "Comma separated host names clients reach the API under besides the listen address, such as a DNS name when listening on 0.0.0.0", commando.String, "none").
+		AddFlag("workspace-root", "Directory the databases, staging directories, and other files named by requests and jobs must lie in", commando.String, ".").
+		AddFlag("jira-url", "JIRA URL the dashboard links tickets to and retries failed uploads against", commando.String, "none").
+		AddFlag("jira-username", "JIRA username the dashboard retries failed uploads as", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password the dashboard retries failed uploads with", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		SetAction(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := serve(flags)
+			if err != nil {
+				output.Errorf("Failed serving the API: %s\n", err)
+				exit(exitCode(err))
+			}
+		}))
 
-	tr := tar.NewReader(gzr)
+	commando.
+		Register("verify").
+		SetDescription("Verifies uploaded attachments exist in JIRA and, optionally, that their content matches the staged files").
+		AddFlag("jira-url", "JIRA URL", commando.String, "none").
+		AddFlag("jira-username", "JIRA username", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password, or oauth for the token stored by login", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		AddFlag("proxy", "HTTP or HTTPS proxy URL the GitHub and JIRA requests are sent through, instead of the HTTPS_PROXY environment variable", commando.String, "none").
+		AddFlag("ca-cert", "PEM file of certificate authorities to trust in addition to the system ones, such as a private CA", commando.String, "none").
+		AddFlag("insecure-skip-verify", "Skip TLS certificate verification, only for testing since connections can be intercepted", commando.Bool, false).
+		AddFlag("jira-client-cert", "PEM client certificate presented to JIRA deployments requiring mutual TLS", commando.String, "none").
+		AddFlag("jira-client-key", "PEM private key of the JIRA client certificate", commando.String, "none").
+		AddFlag("checksums", "Download each uploaded attachment and compare its checksum with the one recorded during collection", commando.Bool, false).
+		AddFlag("fips", "Refuse to verify a database whose checksums do not use a FIPS approved algorithm", commando.Bool, false).
+		AddFlag("chain", "Verify the checksums and sizes recorded from the archive through to JIRA agree at every hop", commando.Bool, false).
+		AddFlag("statement", "Write a JSON integrity statement of the verification to this file", commando.String, "none").
+		AddFlag("github-token", "GitHub personal access token, for marking the GitHub issues", commando.String, "none").
+		AddFlag("github-token-file", "File holding the GitHub token, or - to read it from standard input", commando.String, "none").
+		AddFlag("mark-source", "Label added to each GitHub issue once all of its ticket's attachments are verified, such as migrated", commando.String, "none").
+		AddFlag("mark-comment", "Comment on each GitHub issue with its ticket key once all of the ticket's attachments are verified", commando.Bool, false).
+		AddFlag("lock-source", "Lock each GitHub issue once all of its ticket's attachments are verified", commando.Bool, false).
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("verbose,v", "Print every attachment and page processed as well as the progress of the run, or every HTTP request too with -vv", commando.Bool, false).
+		AddFlag("debug", "Print every HTTP request sent as well as every attachment and page processed, the same as -vv", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(withStateLock("verify", withTransport(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := verify(flags)
+			if err != nil {
+				output.Errorf("Failed verifying attachments: %s\n", err)
+				exit(exitCode(err))
+			}
+		}))))))
 
-	for {
-		header, err := tr.Next()
-		switch {
-		case err == io.EOF:
-			return nil
-		case err != nil:
-			return fmt.Errorf("error reading tarball %s: %s", path, err)
-		case header == nil:
-			continue
-		}
+	commando.
+		Register("rewrite").
+		SetDescription("Replaces GitHub asset URLs in JIRA ticket descriptions and comments with references to the uploaded attachments, or rolls the edits back").
+		AddArgument("action", "Rewrite action to run: apply or rollback", "").
+		AddFlag("jira-url", "JIRA URL", commando.String, "none").
+		AddFlag("jira-username", "JIRA username", commando.String, "none").
+		AddFlag("jira-secret", "JIRA personal access token or password, or oauth for the token stored by login", commando.String, "none").
+		AddFlag("jira-secret-file", "File holding the JIRA secret, or - to read it from standard input", commando.String, "none").
+		AddFlag("use-keyring", "Read the JIRA secret from the OS credential store when not given, as stored by login --use-keyring", commando.Bool, false).
+		AddFlag("proxy", "HTTP or HTTPS proxy URL the GitHub and JIRA requests are sent through, instead of the HTTPS_PROXY environment variable", commando.String, "none").
+		AddFlag("ca-cert", "PEM file of certificate authorities to trust in addition to the system ones, such as a private CA", commando.String, "none").
+		AddFlag("insecure-skip-verify", "Skip TLS certificate verification, only for testing since connections can be intercepted", commando.Bool, false).
+		AddFlag("jira-client-cert", "PEM client certificate presented to JIRA deployments requiring mutual TLS", commando.String, "none").
+		AddFlag("jira-client-key", "PEM private key of the JIRA client certificate", commando.String, "none").
+		AddFlag("dry-run", "Report the descriptions and comments that would change without editing them", commando.Bool, false).
+		AddFlag("yes", "Edit the tickets without asking to confirm the instance and ticket counts first, for automation", commando.Bool, false).
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("verbose,v", "Print every attachment and page processed as well as the progress of the run, or every HTTP request too with -vv", commando.Bool, false).
+		AddFlag("debug", "Print every HTTP request sent as well as every attachment and page processed, the same as -vv", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(withStateLock("rewrite", withTransport(withSecrets(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := rewrite(args, flags)
+			if err != nil {
+				output.Errorf("Failed rewriting tickets: %s\n", err)
+				exit(exitCode(err))
+			}
+		}))))))
 
-		target := filepath.Join("stage", header.Name)
-		switch header.Typeflag {
+	commando.
+		Register("snapshot").
+		SetDescription("Creates, lists, or restores snapshots of the workspace state, excluding the staging directory").
+		AddArgument("action", "Snapshot action to run: create, list, or restore", "").
+		AddArgument("file", "Snapshot file to write or restore, defaults to a timestamped file in the snapshots directory", "none").
+		AddFlag("reason", "Reason recorded with the snapshot", commando.String, "none").
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := snapshotCommand(args, flags)
+			if err != nil {
+				output.Errorf("Failed running snapshot %s: %s\n", args["action"].Value, err)
+				exit(exitCode(err))
+			}
+		})))
 
-		case tar.TypeDir:
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, 0755); err != nil {
-					return fmt.Errorf("failed creating directory %s: %s", target, err)
-				}
+	commando.
+		Register("db").
+		SetDescription("Merges, compares, or validates database files").
+		AddArgument("action", "Database action to run: merge, diff, or validate", "").
+		AddArgument("files...", "For merge, the output file followed by the databases to merge; for diff, the earlier and later databases; for validate, the database to check, defaulting to database.json", "").
+		AddFlag("output", "Output format of diff and validate: text or json", commando.String, "text").
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := dbCommand(args, flags)
+			if err != nil {
+				output.Errorf("Failed running db %s: %s\n", args["action"].Value, err)
+				exit(exitCode(err))
 			}
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
+		})))
+
+	commando.
+		Register("clean").
+		SetDescription("Deletes the staging directory, the archive directory and downloaded archives, or the database and its state files once the migration is finished").
+		AddFlag("stage", "Delete the staging directory", commando.Bool, false).
+		AddFlag("archive", "Delete the directory the processed archive is assembled in and the downloaded GitHub archives", commando.Bool, false).
+		AddFlag("db", "Delete the database and the state files kept with it, keeping the audit log and snapshots", commando.Bool, false).
+		AddFlag("all", "Delete the staging directory, the archive directory and downloaded archives, and the database", commando.Bool, false).
+		AddFlag("force", "Delete the staging directory or the database even though attachments are still waiting to upload", commando.Bool, false).
+		AddFlag("yes", "Delete without asking to confirm what is deleted first, for automation", commando.Bool, false).
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		AddFlag("archive-dir", "Directory the processed archive is assembled in", commando.String, "archive").
+		SetAction(withPaths(withStateLock("clean", func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := clean(flags)
 			if err != nil {
-				return fmt.Errorf("failed opening file %s: %s", target, err)
+				output.Errorf("Failed cleaning workspace: %s\n", err)
+				exit(exitCode(err))
 			}
-			if _, err := io.Copy(f, tr); err != nil {
-				return fmt.Errorf("failed to copy file %s: %s", target, err)
+		})))
+
+	commando.
+		Register("gc").
+		SetDescription("Deletes the staged files of attachments already uploaded, and optionally verified, to reclaim disk while the migration is in flight").
+		AddFlag("verified", "Only delete the staged files of attachments verify found intact since they were uploaded", commando.Bool, false).
+		AddFlag("checksums", "Only delete the staged files of attachments whose content verify --checksums matched since they were uploaded", commando.Bool, false).
+		AddFlag("dry-run", "Report the staged files that would be deleted without deleting them", commando.Bool, false).
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withPaths(withStateLock("gc", func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := gc(flags)
+			if err != nil {
+				output.Errorf("Failed pruning staged files: %s\n", err)
+				exit(exitCode(err))
 			}
-			f.Close()
-		}
-	}
+		})))
 
-}
+	commando.
+		Register("delete").
+		SetDescription("Soft-deletes attachments, excluding them from every command while keeping them in the database").
+		AddArgument("paths...", "Staged paths of the attachments to delete", "").
+		AddFlag("reason", "Reason recorded with the decision", commando.String, "none").
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withPaths(withStateLock("delete", func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := softDelete(args, flags)
+			if err != nil {
+				output.Errorf("Failed deleting attachments: %s\n", err)
+				exit(exitCode(err))
+			}
+		})))
 
-func compress(src string, writers ...io.Writer) error {
-	if _, err := os.Stat(src); err != nil {
-		return fmt.Errorf("unable to tar files: %v", err.Error())
-	}
+	commando.
+		Register("restore").
+		SetDescription("Restores soft-deleted attachments").
+		AddArgument("paths...", "Staged paths of the attachments to restore", "").
+		AddFlag("reason", "Reason recorded with the decision", commando.String, "none").
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withPaths(withStateLock("restore", func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := restore(args, flags)
+			if err != nil {
+				output.Errorf("Failed restoring attachments: %s\n", err)
+				exit(exitCode(err))
+			}
+		})))
 
-	mw := io.MultiWriter(writers...)
+	commando.
+		Register("archive").
+		SetDescription("Generates an archive of the exported attachments").
+		AddFlag("only-issues", "Comma separated GitHub issue numbers or ranges to include", commando.String, "all").
+		AddFlag("skip-issues", "Comma separated GitHub issue numbers or ranges to exclude", commando.String, "none").
+		AddFlag("include-ext", "Comma separated file extensions to include, leaving out every other attachment", commando.String, "all").
+		AddFlag("exclude-ext", "Comma separated file extensions to leave out, such as exe,mp4", commando.String, "none").
+		AddFlag("exclude-type", "Comma separated MIME types detected from the file contents to leave out, such as video/* or application/x-executable", commando.String, "none").
+		AddFlag("layout", "Layout of the files in the archive: flat names prefixed with the issue number, or per-ticket directories named after the JIRA ticket", commando.String, "flat").
+		AddFlag("name-template", "Go template naming each attachment file from {{.IssueNumber}}, {{.CommentNumber}}, {{.Name}}, {{.Base}}, {{.Ext}}, {{.Type}}, and {{.Key}}, such as {{.IssueNumber}}_{{.CommentNumber}}_{{.Name}}", commando.String, "none").
+		AddFlag("no-sanitize-names", "Keep attachment file names as they are instead of normalizing them to Unicode NFC, replacing characters Windows or JIRA reject, and truncating them to 240 bytes", commando.Bool, false).
+		AddFlag("compression", "Compression of the archive: gzip, zstd, or none", commando.String, "gzip").
+		AddFlag("split-size", "Largest volume to write, such as 2GB, splitting the archive into numbered volumes listed in an index", commando.String, "none").
+		AddFlag("output", "Path or s3://, gs://, or azblob:// URL to write the archive to, defaults to processed_archive with the compression's extension", commando.String, "none").
+		AddFlag("no-space-check", "Archive without first checking the archive directory and output volumes have room for the attachments", commando.Bool, false).
+		AddFlag("summary-file", "Write a JSON summary of the run's counts, duration, and failures to this file", commando.String, "none").
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("verbose,v", "Print every attachment and page processed as well as the progress of the run, or every HTTP request too with -vv", commando.Bool, false).
+		AddFlag("debug", "Print every HTTP request sent as well as every attachment and page processed, the same as -vv", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		AddFlag("archive-dir", "Directory the processed archive is assembled in before it is compressed", commando.String, "archive").
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		SetAction(withOutput(withPaths(withStateLock("archive", func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			summary := newRunSummary("archive")
+			err := summary.write(flags["summary-file"].Value.(string), archiveCommand(flags, summary))
+			if err != nil {
+				output.Errorf("Failed archiving attachments: %s\n", err)
+				exit(exitCode(err))
+			}
+		}))))
 
-	gzw := gzip.NewWriter(mw)
-	defer gzw.Close()
+	commando.
+		Register("extract").
+		SetDescription("Unpacks a processed attachment archive and verifies its files against the manifest").
+		AddArgument("archive", "Path or https:// or object storage URL of the processed archive to unpack, or the index of a split archive", "").
+		AddFlag("output", "Directory to unpack the archive into, defaults to extracted", commando.String, "none").
+		AddFlag("database", "Unpack into the staging directory and rebuild the database from the manifest so the attachments can be uploaded", commando.Bool, false).
+		AddFlag("force-unlock", "Take over the workspace lock held by another run that is no longer running", commando.Bool, false).
+		AddFlag("quiet,q", "Print errors only", commando.Bool, false).
+		AddFlag("verbose,v", "Print every attachment and page processed as well as the progress of the run, or every HTTP request too with -vv", commando.Bool, false).
+		AddFlag("debug", "Print every HTTP request sent as well as every attachment and page processed, the same as -vv", commando.Bool, false).
+		AddFlag("db-path", "Path of the database, alongside which the other workspace state files are kept", commando.String, "database.json").
+		AddFlag("stage-dir", "Directory the attachments are staged in", commando.String, "stage").
+		SetAction(withOutput(withPaths(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			err := extract(args, flags)
+			if err != nil {
+				output.Errorf("Failed extracting archive: %s\n", err)
+				exit(exitCode(err))
+			}
+		})))
 
-	tw := tar.NewWriter(gzw)
-	defer tw.Close()
+	os.Args = expandVerbosity(commando.DefaultCommandRegistry, os.Args)
+	err := checkUsage(commando.DefaultCommandRegistry, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s.\n", err)
+		os.Exit(exitConfig)
+	}
+	for _, command := range commando.DefaultCommandRegistry.Commands {
+		command.Action = withRedaction(command.Action)
+	}
+	commando.Parse(nil)
+	output.Flush()
+}
 
-	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !fi.Mode().IsRegular() {
-			return nil
-		}
-		header, err := tar.FileInfoHeader(fi, fi.Name())
-		if err != nil {
-			return err
-		}
-		header.Name = strings.TrimPrefix(strings.Replace(file, src, "", -1), string(filepath.Separator))
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
-		f, err := os.Open(file)
+// newJIRAClient returns a client of the JIRA instance at jiraURL authenticated
+// with the bearer token, or, when the secret is oauth, with the OAuth token
+// stored by login, sent through the Atlassian API gateway.
+func newJIRAClient(secret, jiraURL string) (*jira.Client, error) {
+	if unset(secret) {
+		return nil, fmt.Errorf("--jira-secret, --jira-secret-file, or --use-keyring must be specified")
+	}
+	if secret == oauth.Secret {
+		creds, err := oauth.Load()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if _, err := io.Copy(tw, f); err != nil {
-			return err
+		if !unset(jiraURL) && !creds.Matches(jiraURL) {
+			return nil, fmt.Errorf("the stored OAuth token is for %s, not %s, run login for it", creds.SiteURL, jiraURL)
 		}
-		f.Close()
-
-		return nil
-	})
-}
+		return jira.NewClient(creds.Client(&upload.ProgressTransport{Base: &tracing.Transport{Base: jiraTransport, Service: tracing.ServiceJIRA}}), creds.APIURL())
+	}
 
-func processAttachments(db *database) error {
-	entries, err := os.ReadDir("stage")
+	if unset(jiraURL) {
+		return nil, fmt.Errorf("--jira-url must be specified")
+	}
+	base, err := normalizeJIRAURL(jiraURL)
 	if err != nil {
-		return fmt.Errorf("error reading directory: %s", err)
+		return nil, err
 	}
-
-	for _, entry := range entries {
-		if strings.HasPrefix(entry.Name(), "attachments") && strings.HasSuffix(entry.Name(), ".json") {
-			path := filepath.Join("stage", entry.Name())
-			bytes, err := os.ReadFile(path)
-			if err != nil {
-				return fmt.Errorf("error reading file %s: %s", path, err)
-			}
-
-			var attachments []struct {
-				Issue        string `json:"issue"`
-				IssueComment string `json:"issue_comment"`
-				AssetURL     string `json:"asset_url"`
-			}
-			if err := json.Unmarshal(bytes, &attachments); err != nil {
-				return fmt.Errorf("error unmarshalling JSON from %s: %s", path, err)
-			}
-
-			for _, _attachment := range attachments {
-				if _attachment.Issue != "" {
-					issueTokens := strings.Split(_attachment.Issue, "/")
-					issueNumber, err := strconv.ParseInt(issueTokens[len(issueTokens)-1], 10, 64)
-					if err != nil {
-						return fmt.Errorf("error parsing issue number from %s: %s", _attachment.Issue, err)
-					}
-					pathTokens := strings.Split(_attachment.AssetURL, "/")
-					path := strings.Join(pathTokens[3:], "/")
-					entry := &attachment{
-						IssueNumber: int(issueNumber),
-						Type:        "issue",
-						Path:        path,
-						URL:         _attachment.Issue,
-					}
-					db.Attachments = append(db.Attachments, entry)
-
-				} else if _attachment.IssueComment != "" {
-					issueTokens := strings.Split(_attachment.IssueComment, "/")
-					issueNumber, err := strconv.ParseInt(strings.Split(issueTokens[len(issueTokens)-1], "#")[0], 10, 64)
-					if err != nil {
-						return fmt.Errorf("error parsing issue number from %s: %s", _attachment.IssueComment, err)
-					}
-					commentTokens := strings.Split(_attachment.IssueComment, "#")
-					commentNumber, err := strconv.ParseInt(strings.Split(commentTokens[len(commentTokens)-1], "issuecomment-")[1], 10, 64)
-					if err != nil {
-						return fmt.Errorf("error parsing comment number from %s: %s", _attachment.IssueComment, err)
-					}
-					pathTokens := strings.Split(_attachment.AssetURL, "/")
-					path := strings.Join(pathTokens[3:], "/")
-					entry := &attachment{
-						CommentNumber: commentNumber,
-						IssueNumber:   int(issueNumber),
-						Type:          "issue_comment",
-						Path:          path,
-						URL:           _attachment.IssueComment,
-					}
-					db.Attachments = append(db.Attachments, entry)
-				}
-			}
-		}
+	tp := jira.BearerAuthTransport{
+		Token:     secret,
+		Transport: &upload.ProgressTransport{Base: &tracing.Transport{Base: jiraTransport, Service: tracing.ServiceJIRA}},
 	}
 
-	return nil
+	return jira.NewClient(tp.Client(), base)
 }
 
-func processIssues(client *github.Client, org, repo string, db *database) error {
-	opts := &github.IssueListByRepoOptions{
-		State: "all",
-		ListOptions: github.ListOptions{
-			Page:    1,
-			PerPage: 100,
-		},
+// jiraAPISuffixes are the paths operators commonly paste after the JIRA base
+// URL, which would otherwise be repeated in front of every REST path.
+var jiraAPISuffixes = []string{"/rest/api/2", "/rest/api/latest", "/rest/api", "/rest", "/secure/Dashboard.jspa"}
+
+// normalizeJIRAURL returns the base URL of the JIRA instance with a trailing
+// slash, keeping any context path it is served under, such as /jira, so REST
+// paths resolve beneath it. Query strings, fragments, and REST API paths
+// pasted after the base URL are removed.
+func normalizeJIRAURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid JIRA URL %q, must be such as https://jira.example.com or https://example.com/jira", raw)
 	}
-	for {
-		issues, resp, err := client.Issues.ListByRepo(context.Background(), org, repo, opts)
-		if err != nil {
-			if resp.StatusCode == http.StatusNotFound {
-				return fmt.Errorf("repository %s/%s not found", org, repo)
-			}
-			return fmt.Errorf("failed listing issues for %s/%s: %s", org, repo, err)
-		}
-		fmt.Printf("Processing GitHub issues page %d of %d\n", opts.ListOptions.Page, resp.LastPage)
-		for _, _issue := range issues {
-			entry := &issue{
-				URL:    _issue.GetHTMLURL(),
-				Number: _issue.GetNumber(),
-			}
-			db.Issues[_issue.GetTitle()] = entry
-		}
-		if resp.NextPage == 0 {
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.RawPath = ""
+	path := strings.TrimRight(u.Path, "/")
+	for _, suffix := range jiraAPISuffixes {
+		if strings.HasSuffix(strings.ToLower(path), strings.ToLower(suffix)) {
+			path = strings.TrimRight(path[:len(path)-len(suffix)], "/")
 			break
 		}
-		opts.ListOptions.Page = resp.NextPage
-		time.Sleep(1 * time.Second)
 	}
-	return nil
+	u.Path = path + "/"
+	return u.String(), nil
+}
+
+func newGitHubClient(token string) *github.Client {
+	var transport http.RoundTripper = &tracing.Transport{Base: http.DefaultTransport, Service: tracing.ServiceGitHub}
+	if githubCacheDir != "" {
+		transport = client.NewCachingTransport(githubCacheDir, transport)
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	tc := oauth2.NewClient(ctx, ts)
+
+	return github.NewClient(tc)
 }
 
 func IsEmpty(path string) (bool, error) {
@@ -324,287 +758,1171 @@ func IsEmpty(path string) (bool, error) {
 	return false, err
 }
 
-func processTickets(client *jira.Client, key string, db *database) error {
-	opts := &jira.SearchOptions{
-		StartAt:    0,
-		MaxResults: 1000,
+// localArchive downloads an archive given as an HTTPS URL into the download
+// cache, resuming any earlier partial download, and returns its local path.
+// Other paths, including object storage URLs, are returned unchanged.
+func localArchive(path, sum string) (string, error) {
+	if !download.IsURL(path) {
+		return path, nil
 	}
-	for {
-		issues, resp, err := client.Issue.Search(fmt.Sprintf("project=%s", key), opts)
-		if err != nil {
-			// Read body
-			body, readErr := io.ReadAll(resp.Body)
-			if readErr != nil {
-				return fmt.Errorf("failed reading body: %s\nfailed searching for tickets in %s: %s", readErr, key, err)
+	if sum == "none" {
+		sum = ""
+	}
+	fmt.Printf("Downloading %s\n", path)
+	local, err := download.Cached(http.DefaultClient, path, sum)
+	if err != nil {
+		return "", fmt.Errorf("failed downloading archive: %w", err)
+	}
+	return local, nil
+}
+
+// archiveList returns the archives given to --archive, which takes a comma
+// separated list of paths and URLs, as large organization exports are split
+// across several archives. Local paths holding a glob pattern, such as
+// migration_archive-*.tar.gz, are expanded to the archives they match.
+func archiveList(value string) ([]string, error) {
+	var archives []string
+	seen := make(map[string]bool)
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches := []string{pattern}
+		if !download.IsURL(pattern) && !objectstore.IsURL(pattern) && strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid archive pattern %q: %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no archive matches %s", pattern)
+			}
+		}
+		for _, archive := range matches {
+			if !seen[archive] {
+				seen[archive] = true
+				archives = append(archives, archive)
 			}
-			resp.Body.Close()
-			return fmt.Errorf("failed searching for tickets in %s: %s\n\n%s", key, err, string(body))
 		}
-		fmt.Printf("Processing JIRA tickets %d of %d\n", opts.StartAt, resp.Total)
-		for _, _issue := range issues {
-			entry := &ticket{
-				Key:      _issue.Key,
-				Uploaded: false,
+	}
+	if len(archives) == 0 {
+		return nil, fmt.Errorf("--archive must name at least one archive")
+	}
+	return archives, nil
+}
+
+// directorySize returns the total size of the regular files under path, or
+// zero when path does not exist.
+func directorySize(path string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == path {
+				return nil
 			}
-			db.Tickets[_issue.Fields.Summary] = entry
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += uint64(fi.Size())
 		}
-		if resp.StartAt+resp.MaxResults >= resp.Total {
+		return nil
+	})
+	return size, err
+}
+
+// parseSize parses a size in bytes such as 2GB, where the KB, MB, GB, and TB
+// suffixes are multiples of 1024.
+func parseSize(size string) (int64, error) {
+	units := []struct {
+		suffix string
+		bytes  int64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	value := strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.bytes
 			break
 		}
-		opts.StartAt = resp.StartAt + resp.MaxResults
-		time.Sleep(1 * time.Second)
 	}
-	return nil
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, must be a positive number of bytes optionally followed by KB, MB, GB, or TB", size)
+	}
+	return n * multiplier, nil
 }
 
-func collect(flags map[string]commando.FlagValue) error {
-	archive := flags["archive"].Value.(string)
+func collectCommand(flags map[string]commando.FlagValue, summary *runSummary) error {
+	archivePath := flags["archive"].Value.(string)
+	archiveSum := flags["archive-sha256"].Value.(string)
 	skipArchive := flags["skip-archive"].Value.(bool)
+	// commando registers --no-stage as the inverted stage flag.
+	noStage := !flags["stage"].Value.(bool)
 	githubToken := flags["github-token"].Value.(string)
 	org := flags["org"].Value.(string)
 	repo := flags["repo"].Value.(string)
-	jiraURL := flags["jira-url"].Value.(string)
+	githubAPI := flags["github-api"].Value.(string)
 	_ = flags["jira-username"].Value.(string)
-	jiraSecret := flags["jira-secret"].Value.(string)
-	jiraKeys := flags["jira-keys"].Value.(string)
-
-	jira, err := newJIRAClient(jiraSecret, jiraURL)
+	target := flags["target"].Value.(string)
+	onlyIssues := flags["only-issues"].Value.(string)
+	skipIssues := flags["skip-issues"].Value.(string)
+	labels := flags["labels"].Value.(string)
+	state := flags["state"].Value.(string)
+	since := flags["since"].Value.(string)
+	until := flags["until"].Value.(string)
+	editHistory := flags["edit-history"].Value.(string)
+	dedup := flags["dedup"].Value.(string)
+	hashAlgorithm := flags["hash"].Value.(string)
+	fips := flags["fips"].Value.(bool)
+	// commando registers --no-space-check as the inverted space-check flag.
+	spaceCheck := flags["space-check"].Value.(bool)
+
+	phases, err := newCollectPhases(flags)
 	if err != nil {
-		fmt.Printf("Error creating JIRA client: %s", err)
-	}
-
-	gh := newGitHubClient(githubToken)
-
-	if _, err := os.Stat("stage"); os.IsNotExist(err) {
-		err = os.MkdirAll("stage", 0755)
-		if err != nil {
-			return fmt.Errorf("failed creating staging directory: %s", err)
-		}
+		return configError(err)
 	}
-
-	empty, err := IsEmpty("stage")
-	if err != nil {
-		return fmt.Errorf("failed checking if staging directory empty: %s", err)
+	switch {
+	case !skipArchive && archivePath == "none":
+		return configError(fmt.Errorf("--archive must be specified unless --skip-archive is"))
+	case phases.github && (org == "none" || repo == "none"):
+		return configError(fmt.Errorf("--org and --repo must be specified unless --skip-github or --attachments-only is"))
+	case phases.github && githubToken == "none":
+		return configError(fmt.Errorf("--github-token, --github-token-file, or --use-keyring must be specified"))
+	case phases.jira && target == store.TargetJIRA && flags["jira-url"].Value.(string) == "none":
+		return configError(fmt.Errorf("--jira-url must be specified unless --skip-jira or --attachments-only is"))
+	case phases.jira && target == store.TargetJIRA && flags["jira-keys"].Value.(string) == "none" && flags["ticket-keys-file"].Value.(string) == "none" && flags["ticket-key-range"].Value.(string) == "none":
+		return configError(fmt.Errorf("--jira-keys, --ticket-keys-file, or --ticket-key-range must be specified unless --skip-jira or --attachments-only is"))
 	}
 
+	var archives []string
 	if !skipArchive {
-		if empty {
-			fmt.Println("Expanding archive")
-			err := expand(archive)
-			if err != nil {
-				return fmt.Errorf("failed expanding tarball: %s", err)
-			}
-		} else {
-			fmt.Println("Staging directory not empty, skipping archive expansion")
+		archives, err = archiveList(archivePath)
+		if err != nil {
+			return configError(err)
 		}
-	} else {
-		if empty {
-			return fmt.Errorf("staging directory is empty, but --skip-archive was specified")
+		if len(archives) > 1 && archiveSum != "none" {
+			return configError(fmt.Errorf("--archive-sha256 checks a single downloaded archive and cannot be used with several archives"))
+		}
+		if len(archives) > 1 && noStage {
+			return configError(fmt.Errorf("--no-stage cannot be used with several archives, as upload streams the attachments out of a single archive"))
 		}
 	}
 
-	db := &database{
-		Attachments: []*attachment{},
-		Issues:      make(map[string]*issue),
-		Tickets:     make(map[string]*ticket),
+	filter, err := store.ParseIssueFilter(onlyIssues, skipIssues)
+	if err != nil {
+		return configError(err)
 	}
 
-	fmt.Println("Processing GitHub archive")
-	err = processAttachments(db)
+	since, incremental, err := resolveSince(since)
 	if err != nil {
-		return fmt.Errorf("failed processing attachments: %s", err)
+		return configError(err)
+	}
+	if incremental && !phases.github {
+		return configError(fmt.Errorf("--since only applies to the GitHub listing and cannot be used with --skip-github or --attachments-only"))
 	}
 
-	fmt.Println("Processing JIRA tickets")
-	scrubbedKeys := strings.ReplaceAll(jiraKeys, " ", "")
-	keyTokens := strings.Split(scrubbedKeys, ",")
-	searchKey := strings.Join(keyTokens, " OR project=")
-	err = processTickets(jira, searchKey, db)
+	query, err := collect.ParseIssueQuery(labels, state, since, until)
 	if err != nil {
-		return fmt.Errorf("failed processing tickets: %s", err)
+		return configError(err)
 	}
 
-	fmt.Println("Processing GitHub issues")
-	err = processIssues(gh, org, repo, db)
+	matching, err := newMatchConfig(flags)
 	if err != nil {
-		return fmt.Errorf("failed processing issues: %s", err)
+		return configError(err)
 	}
 
-	fmt.Println("Writing database to disk")
-	bytes, err := json.Marshal(db)
+	tickets, err := phases.ticketLister(flags, matching)
 	if err != nil {
-		return fmt.Errorf("failed marshalling database: %s", err)
+		return configError(err)
 	}
-	err = os.WriteFile("database.json", bytes, 0644)
-
-	return nil
-}
 
-func upload(flags map[string]commando.FlagValue) error {
-	jiraURL := flags["jira-url"].Value.(string)
-	_ = flags["jira-username"].Value.(string)
-	jiraSecret := flags["jira-secret"].Value.(string)
+	if editHistory != "include" && editHistory != "exclude" {
+		return configError(fmt.Errorf("invalid edit history mode %q, must be include or exclude", editHistory))
+	}
 
-	jira, err := newJIRAClient(jiraSecret, jiraURL)
+	err = collect.ValidDedupPolicy(dedup)
 	if err != nil {
-		log.Panicf("Error creating JIRA client: %s", err)
+		return configError(err)
 	}
 
-	bytes, err := os.ReadFile("database.json")
+	err = collect.ValidGitHubAPI(githubAPI)
 	if err != nil {
-		return fmt.Errorf("failed reading database: %s", err)
+		return configError(err)
 	}
 
-	db := &database{}
-	err = json.Unmarshal(bytes, db)
+	err = checksum.Valid(hashAlgorithm, fips)
 	if err != nil {
-		return fmt.Errorf("failed unmarshalling database: %s", err)
+		return configError(err)
 	}
 
-	for title, ticket := range db.Tickets {
-		if ticket.Uploaded {
-			continue
+	if _, err := os.Stat(store.StageDir); os.IsNotExist(err) {
+		err = os.MkdirAll(store.StageDir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed creating staging directory: %w", err)
 		}
-		issue := db.Issues[title]
-		if issue != nil {
-			for _, attachment := range db.Attachments {
-				if attachment.IssueNumber == issue.Number {
-					path := filepath.Join("stage", attachment.Path)
-					nameTokens := strings.Split(attachment.Path, "/")
-					name := nameTokens[len(nameTokens)-1]
-					file, err := os.Open(path)
-					if err != nil {
-						return fmt.Errorf("failed opening attachment: %s", err)
-					}
-					fmt.Printf("Uploading attachment %s to %s\n", path, ticket.Key)
-					_, resp, err := jira.Issue.PostAttachment(ticket.Key, file, name)
-					if err != nil {
-						file.Close()
-						body, readErr := io.ReadAll(resp.Body)
-						if readErr != nil {
-							return fmt.Errorf("failed reading error body: %s\nfailed uploading attachment: %s", readErr, err)
-						}
-						resp.Body.Close()
-						return fmt.Errorf("failed uploading attachment: %s\n\n%s", err, string(body))
-					}
-					if resp.StatusCode != 200 {
-						file.Close()
-						return fmt.Errorf("failed uploading attachment: %s", resp.Status)
-					}
-					file.Close()
+	}
 
-					db.Tickets[title].Uploaded = true
+	empty, err := IsEmpty(store.StageDir)
+	if err != nil {
+		return fmt.Errorf("failed checking if staging directory empty: %w", err)
+	}
 
-					bytes, err := json.Marshal(db)
+	// The attachments, issues, and tickets are independent of each other
+	// until they are linked, so they are collected at the same time.
+	var g errgroup.Group
+	gh := &collect.GitHubSource{Client: client.NewGitHub(newGitHubClient(githubToken)), Token: githubToken, Org: org, Repo: repo, API: githubAPI}
+	listed := startListing(&g, tickets, phases.issueSource(gh), query)
+	var db *store.Database
+	var duplicates int
+	var orphans []*store.Attachment
+	g.Go(func() error {
+		var err error
+		if !skipArchive {
+			if empty || incremental {
+				for i, archive := range archives {
+					archives[i], err = localArchive(archive, archiveSum)
 					if err != nil {
-						return fmt.Errorf("failed marshalling database: %s", err)
+						return err
 					}
-					err = os.WriteFile("database.json", bytes, 0644)
-					if err != nil {
-						return fmt.Errorf("failed writing database: %s", err)
+				}
+				var checkSpace func(int64) error
+				if spaceCheck {
+					checkSpace = func(required int64) error {
+						return ensureSpace(store.StageDir, uint64(required), "Staging the referenced attachments")
 					}
 				}
+				if len(archives) > 1 {
+					fmt.Printf("Expanding %d archives\n", len(archives))
+				} else {
+					fmt.Println("Expanding archive")
+				}
+				err = collect.Expand(archives, hashAlgorithm, !noStage, checkSpace)
+				if err != nil {
+					return fmt.Errorf("failed expanding archive: %w", err)
+				}
+			} else {
+				fmt.Println("Staging directory not empty, skipping archive expansion")
+			}
+		} else {
+			if empty {
+				return fmt.Errorf("staging directory is empty, but --skip-archive was specified")
 			}
 		}
-	}
-	fmt.Println("All attachments uploaded")
-
-	return nil
-}
-
-func copy(src, dst string) error {
-	sourceFileStat, err := os.Stat(src)
-	if err != nil {
-		return fmt.Errorf("failed getting file stats: %s", err)
-	}
-
-	if !sourceFileStat.Mode().IsRegular() {
-		return fmt.Errorf("%s is not a regular file", src)
-	}
-
-	source, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("failed opening source file: %s", err)
-	}
-	defer source.Close()
-
-	destination, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("failed creating destination file: %s", err)
-	}
-	defer destination.Close()
-	_, err = io.Copy(destination, source)
-	if err != nil {
-		return fmt.Errorf("failed copying file: %s", err)
-	}
 
-	return nil
-}
+		db = store.New(hashAlgorithm)
+		db.Unstaged = noStage
+		db.Target = target
+		db.Collected = time.Now().UTC()
 
-func archive() error {
-	if _, err := os.Stat("archive"); os.IsNotExist(err) {
-		fmt.Println("Creating archive directory")
-		err := os.Mkdir("archive", 0755)
+		fmt.Println("Processing GitHub archive")
+		err = collect.ProcessAttachments(db)
 		if err != nil {
-			return fmt.Errorf("failed creating archive directory: %s", err)
+			return fmt.Errorf("failed processing attachments: %w", err)
 		}
-	} else {
-		fmt.Println("Archive directory already exists, deleting contents")
-		err := os.RemoveAll("archive")
+		db.Attachments = filter.Apply(db.Attachments)
+
+		if !noStage {
+			fmt.Println("Computing attachment checksums")
+			err = collect.HashAttachments(db.Attachments, db.HashAlgorithm)
+			if err != nil {
+				return fmt.Errorf("failed computing checksums: %w", err)
+			}
+		}
+		err = collect.RecordArchiveChecksums(db.Attachments, db.HashAlgorithm)
 		if err != nil {
-			return fmt.Errorf("failed deleting archive directory: %s", err)
+			return fmt.Errorf("failed recording archive checksums: %w", err)
 		}
-		fmt.Println("Creating new archive directory")
-		err = os.Mkdir("archive", 0755)
-		if err != nil {
-			return fmt.Errorf("failed creating archive directory: %s", err)
+		if noStage {
+			err = collect.AdoptArchiveChecksums(db.Attachments)
+			if err != nil {
+				return fmt.Errorf("failed recording archive checksums: %w", err)
+			}
 		}
-	}
 
-	bytes, err := os.ReadFile("database.json")
+		duplicates = collect.DedupAttachments(db, dedup)
+		if duplicates > 0 {
+			fmt.Printf("Found %d attachments duplicating the content of another attachment\n", duplicates)
+		}
+
+		fmt.Println("Checking edit history")
+		orphans, err = collect.MarkEditOrphans(db)
+		if err != nil {
+			return fmt.Errorf("failed checking edit history: %w", err)
+		}
+		if len(orphans) > 0 {
+			fmt.Printf("%d attachments are only referenced by earlier edits:\n", len(orphans))
+			for _, attachment := range orphans {
+				fmt.Printf("  %s (%s)\n", attachment.Path, attachment.URL)
+			}
+			if editHistory == "exclude" {
+				fmt.Println("Excluding attachments only referenced by earlier edits")
+				var current []*store.Attachment
+				for _, attachment := range db.Attachments {
+					if !attachment.Orphaned {
+						current = append(current, attachment)
+					}
+				}
+				db.Attachments = current
+			}
+		}
+		return nil
+	})
+	err = g.Wait()
 	if err != nil {
-		return fmt.Errorf("failed reading database: %s", err)
+		return err
 	}
 
-	db := &database{}
-	err = json.Unmarshal(bytes, db)
+	err = link(tickets, matching, listed, db, incremental)
+	summary.Counts["attachments"] = int64(len(db.Attachments))
+	summary.Counts["duplicates"] = int64(duplicates)
+	summary.Counts["edit_orphans"] = int64(len(orphans))
+	summary.Counts["issues"] = int64(len(db.Issues))
+	summary.Counts["tickets"] = int64(len(db.Tickets))
+	return err
+}
+
+// resolveSince returns the --since date to collect from, resolving last to
+// when the existing database was collected, and whether the collect is
+// incremental, merging into the existing database rather than replacing it.
+func resolveSince(since string) (string, bool, error) {
+	if since == "none" {
+		return since, false, nil
+	}
+	_, err := os.Stat(store.DatabaseFile)
+	exists := err == nil
+	if since != "last" {
+		return since, exists, nil
+	}
+	if !exists {
+		return "", false, fmt.Errorf("--since last continues from the database of a previous collect, but there is none")
+	}
+	previous, err := store.Load()
 	if err != nil {
-		return fmt.Errorf("failed unmarshalling database: %s", err)
+		return "", false, err
 	}
+	if previous.Collected.IsZero() {
+		return "", false, fmt.Errorf("the database does not record when it was collected, pass --since a date instead of last")
+	}
+	fmt.Printf("Collecting issues updated since the last collect at %s\n", previous.Collected.Format(time.RFC3339))
+	return previous.Collected.Format(time.RFC3339), true, nil
+}
 
-	fmt.Println("Copying files to archive directory")
-	for _, attachment := range db.Attachments {
-		nameTokens := strings.Split(attachment.Path, "/")
-		name := nameTokens[len(nameTokens)-1]
-		if attachment.Type == "issue" {
-			srcPath := filepath.Join("stage", attachment.Path)
-			dstPath := filepath.Join("archive", fmt.Sprintf("%d_%s", attachment.IssueNumber, name))
-			err := copy(srcPath, dstPath)
+// listing holds the issues of the source and the tickets of the target,
+// listed alongside the attachments since neither depends on them.
+type listing struct {
+	source  string
+	issues  []*store.IssueEntry
+	tickets []*store.TicketEntry
+}
+
+// startListing lists the issues of the source and the tickets of the target
+// concurrently in the group. The listing is complete once the group has been
+// waited for without error.
+func startListing(g *errgroup.Group, tickets *ticketLister, issueSource collect.Source, query *collect.IssueQuery) *listing {
+	listed := &listing{source: issueSource.Name()}
+	if !tickets.perIssue {
+		g.Go(func() error {
+			fmt.Printf("Processing %s\n", tickets.name)
+			entries, err := tickets.list()
 			if err != nil {
-				return fmt.Errorf("failed copying issue attachment: %s", err)
+				return fmt.Errorf("failed processing tickets: %w", err)
 			}
+			listed.tickets = entries
+			return nil
+		})
+	}
+	g.Go(func() error {
+		fmt.Printf("Processing %s issues\n", issueSource.Name())
+		issues, err := issueSource.ListIssues(query)
+		if err != nil {
+			return fmt.Errorf("failed processing issues: %w", err)
+		}
+		listed.issues = issues
+		return nil
+	})
+	return listed
+}
+
+// link pairs the listed issues of the source with the tickets of the target
+// using the matching strategy to relate the collected attachments to their
+// destination, then writes the database. An incremental collect is merged
+// into the existing database.
+func link(tickets *ticketLister, matching *match.Config, listed *listing, db *store.Database, incremental bool) error {
+	var err error
+	if tickets.perIssue {
+		fmt.Printf("Giving each %s issue one of the %s\n", listed.source, tickets.name)
+		collect.LinkPages(db, listed.issues)
+	} else {
+		matcher := tickets.matcher
+		if matcher == nil {
+			fmt.Printf("Matching %s issues to %s by %s\n", listed.source, tickets.name, matching.Strategy)
+			matcher = matching.Matcher(listed.tickets)
 		} else {
-			srcPath := filepath.Join("stage", attachment.Path)
-			dstPath := filepath.Join("archive", fmt.Sprintf("%d_%d_%s", attachment.IssueNumber, attachment.CommentNumber, name))
-			err := copy(srcPath, dstPath)
-			if err != nil {
-				return fmt.Errorf("failed copying issue comment attachment: %s", err)
+			fmt.Printf("Linking %s issues to the %s\n", listed.source, tickets.name)
+		}
+		unmatched := collect.Link(db, listed.issues, matcher)
+		if len(unmatched) > 0 {
+			fmt.Printf("%d %s issues matched no ticket, preview them with match preview\n", len(unmatched), listed.source)
+		}
+	}
+
+	if incremental {
+		previous, err := store.Load()
+		if err != nil {
+			return err
+		}
+		changed, err := store.MergeDelta(previous, db)
+		if err != nil {
+			return fmt.Errorf("failed merging into the existing database: %w", err)
+		}
+		fmt.Printf("Merged %d new or changed attachments from %d updated issues into the existing database\n", changed, len(db.Issues))
+		db = previous
+	} else {
+		err = store.CarryDecisions(db)
+		if err != nil {
+			return fmt.Errorf("failed carrying over attachment decisions: %w", err)
+		}
+	}
+
+	path, err := takeSnapshot("none", "before re-collect")
+	if err != nil {
+		return fmt.Errorf("failed snapshotting workspace: %w", err)
+	}
+	if path != "" {
+		fmt.Printf("Saved previous workspace state to %s\n", path)
+	}
+
+	fmt.Println("Writing database to disk")
+	return store.Save(db)
+}
+
+func uploadCommand(flags map[string]commando.FlagValue, summary *runSummary) error {
+	jiraURL := flags["jira-url"].Value.(string)
+	jiraUsername := flags["jira-username"].Value.(string)
+	jiraSecret := flags["jira-secret"].Value.(string)
+	storageHeadroom := flags["storage-headroom"].Value.(int)
+	onlyIssues := flags["only-issues"].Value.(string)
+	skipIssues := flags["skip-issues"].Value.(string)
+	selectTickets := flags["ticket"].Value.(string)
+	selectIssues := flags["issue"].Value.(string)
+	order := flags["order"].Value.(string)
+	planPath := flags["plan"].Value.(string)
+	migrateContent := flags["migrate-content"].Value.(bool)
+	unlockTransition := flags["unlock-transition"].Value.(string)
+	relockTransition := flags["relock-transition"].Value.(string)
+	renderPolicy := flags["render-policy"].Value.(string)
+	retries := flags["retries"].Value.(int)
+	atomic := flags["atomic"].Value.(bool)
+	splitOversize := flags["split-oversize"].Value.(bool)
+	maxImageBytes := flags["max-image-bytes"].Value.(int)
+	bundle := flags["bundle-per-ticket"].Value.(bool)
+	// commando registers --no-stage as the inverted stage flag.
+	noStage := !flags["stage"].Value.(bool)
+	archivePath := flags["archive"].Value.(string)
+	archiveSum := flags["archive-sha256"].Value.(string)
+	onConflict := flags["on-conflict"].Value.(string)
+	provenance := flags["provenance-comment"].Value.(bool)
+	provenanceUpdate := flags["provenance-update"].Value.(bool)
+	remoteLink := flags["remote-link"].Value.(bool)
+	entityProperty := flags["entity-property"].Value.(string)
+	stallTimeout := flags["stall-timeout"].Value.(int)
+	fips := flags["fips"].Value.(bool)
+	commentMarkers := flags["comment-markers"].Value.(string)
+	retryFailed := flags["retry-failed"].Value.(bool)
+	rescreen := flags["rescreen"].Value.(bool)
+	continueOnError := flags["continue-on-error"].Value.(bool)
+	targetName := flags["target"].Value.(string)
+	simulate := flags["simulate"].Value.(bool)
+
+	err := upload.ValidConflictPolicy(onConflict)
+	if err != nil {
+		return configError(err)
+	}
+
+	err = validOrder(order)
+	if err != nil {
+		return configError(err)
+	}
+
+	err = store.ValidTarget(targetName)
+	if err != nil {
+		return configError(err)
+	}
+	onJIRA := targetName == store.TargetJIRA
+	switch {
+	case onJIRA && !simulate && (jiraURL == "none" || jiraUsername == "none"):
+		return configError(fmt.Errorf("--jira-url and --jira-username must be specified for the %s target", targetName))
+	case targetName == store.TargetConfluence && (flags["confluence-url"].Value.(string) == "none" || flags["confluence-space"].Value.(string) == "none" || flags["confluence-token"].Value.(string) == "none"):
+		return configError(fmt.Errorf("--confluence-url, --confluence-space, and --confluence-token must be specified for the %s target", targetName))
+	}
+	if simulate && !onJIRA {
+		return configError(fmt.Errorf("--simulate rehearses uploads to JIRA and cannot be used with the %s target", targetName))
+	}
+	if !onJIRA && (unlockTransition != "none" || relockTransition != "none" || provenance || remoteLink || entityProperty != "none" || commentMarkers != "none" || storageHeadroom != 0) {
+		return configError(fmt.Errorf("--unlock-transition, --relock-transition, --provenance-comment, --remote-link, --entity-property, --comment-markers, and --storage-headroom only apply to JIRA and cannot be used with the %s target", targetName))
+	}
+
+	if simulate {
+		sim, err := startSimulation(flags)
+		if err != nil {
+			return err
+		}
+		defer sim.stop()
+		jiraURL, jiraSecret = sim.jira.URL, simulatedSecret
+	}
+
+	opts := &upload.Options{
+		Retries:    retries,
+		Atomic:     atomic,
+		OnConflict: onConflict,
+
+		ProvenanceUpdate: provenanceUpdate,
+		Started:          time.Now().UTC(),
+
+		Watchdog: &upload.Watchdog{Timeout: time.Duration(stallTimeout) * time.Minute},
+
+		ContinueOnError: continueOnError,
+	}
+
+	filter, err := store.ParseIssueFilter(onlyIssues, skipIssues)
+	if err != nil {
+		return configError(err)
+	}
+
+	lock, err := newWorkflowLock(unlockTransition, relockTransition)
+	if err != nil {
+		return configError(err)
+	}
+
+	correlation, err := newCommentCorrelation(commentMarkers)
+	if err != nil {
+		return configError(err)
+	}
+
+	policy, err := loadRenderPolicy(renderPolicy)
+	if err != nil {
+		return configError(err)
+	}
+
+	typeFilter, err := newTypeFilter(flags)
+	if err != nil {
+		return configError(err)
+	}
+
+	screens, err := newScreening(flags)
+	if err != nil {
+		return configError(err)
+	}
+
+	opts.Names, err = newNamer(flags)
+	if err != nil {
+		return configError(err)
+	}
+
+	users, err := loadUserMap(flags["user-map"].Value.(string))
+	if err != nil {
+		return configError(err)
+	}
+
+	creator, err := newTicketCreator(flags, users)
+	if err != nil {
+		return configError(err)
+	}
+	if creator != nil && !onJIRA {
+		return configError(fmt.Errorf("--create-missing only applies to JIRA and cannot be used with the %s target", targetName))
+	}
+	switch {
+	case migrateContent && !onJIRA:
+		return configError(fmt.Errorf("--migrate-content only applies to JIRA and cannot be used with the %s target", targetName))
+	case atomic && onConflict == upload.ConflictReplace:
+		return configError(fmt.Errorf("--on-conflict replace deletes the attachments it replaces, which a rollback cannot restore, and cannot be used with --atomic"))
+	case migrateContent && atomic:
+		return configError(fmt.Errorf("--migrate-content uploads each comment's attachments in turn and cannot be used with --atomic"))
+	case migrateContent && bundle:
+		return configError(fmt.Errorf("--migrate-content uploads each comment's attachments in turn and cannot be used with --bundle-per-ticket"))
+	}
+	if retryFailed && rescreen {
+		return configError(fmt.Errorf("--retry-failed and --rescreen cannot be used together"))
+	}
+	var approved *uploadPlan
+	if planPath != "none" {
+		if onlyIssues != "all" || skipIssues != "none" || selectTickets != "none" || selectIssues != "none" || order != orderTitle || retryFailed || rescreen || creator != nil {
+			return configError(fmt.Errorf("--plan fixes the uploads and their order, and cannot be used with --only-issues, --skip-issues, --ticket, --issue, --order, --retry-failed, --rescreen, or --create-missing"))
+		}
+		approved, err = loadPlan(planPath)
+		if err != nil {
+			return configError(err)
+		}
+	}
+
+	var jiraClient *jira.Client
+	var pages client.Confluence
+	var target client.Target
+	uploadLimit := int64(client.AzureDevOpsUploadLimit)
+	switch targetName {
+	case store.TargetJIRA:
+		jiraClient, err = newJIRAClient(jiraSecret, jiraURL)
+		if err != nil {
+			return configError(err)
+		}
+		target = client.NewJIRATarget(client.NewJIRA(jiraClient))
+	case store.TargetConfluence:
+		pages = newConfluenceClient(flags)
+		target = pages
+		uploadLimit = client.ConfluenceUploadLimit
+	default:
+		target, err = newAzureDevOpsClient(flags)
+		if err != nil {
+			return configError(err)
+		}
+	}
+
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case db.TargetName() != targetName:
+		return configError(fmt.Errorf("the database was collected for the %s target, upload with --target %s", db.TargetName(), db.TargetName()))
+	case noStage && !db.Unstaged:
+		return configError(fmt.Errorf("the database was collected with staged attachments, upload without --no-stage"))
+	case !noStage && db.Unstaged:
+		return configError(fmt.Errorf("the database was collected with --no-stage, upload with --no-stage and --archive"))
+	case noStage && archivePath == "none":
+		return configError(fmt.Errorf("--archive must be specified with --no-stage"))
+	case noStage && splitOversize:
+		return configError(fmt.Errorf("--split-oversize needs staged attachments and cannot be used with --no-stage"))
+	case noStage && bundle:
+		return configError(fmt.Errorf("--bundle-per-ticket needs staged attachments and cannot be used with --no-stage"))
+	case noStage && order != orderTitle:
+		return configError(fmt.Errorf("--order needs staged attachments and cannot be used with --no-stage, which uploads in archive order"))
+	case bundle && splitOversize:
+		return configError(fmt.Errorf("--bundle-per-ticket and --split-oversize cannot be used together"))
+	case noStage && maxImageBytes > 0:
+		return configError(fmt.Errorf("--max-image-bytes needs staged attachments and cannot be used with --no-stage"))
+	case noStage && len(screens.scanners) > 0:
+		return configError(fmt.Errorf("--clamd and --scan-command need staged attachments and cannot be used with --no-stage"))
+	}
+	if noStage {
+		archivePath, err = localArchive(archivePath, archiveSum)
+		if err != nil {
+			return err
+		}
+		opts.Archive, err = upload.OpenArchive(archivePath)
+		if err != nil {
+			return err
+		}
+		defer opts.Archive.Close()
+	}
+
+	err = selectUploads(db, filter, selectTickets, selectIssues)
+	if err != nil {
+		return configError(err)
+	}
+
+	if !simulate {
+		err = confirmUpload(flags, db, filter, approved, creator)
+		if err != nil {
+			return err
+		}
+	}
+
+	if creator != nil {
+		fmt.Println("Creating tickets for unmatched issues")
+		created, err := creator.createMissing(jiraClient, db, filter)
+		summary.Counts["created_tickets"] = int64(created)
+		if err != nil {
+			return err
+		}
+	}
+
+	opts.HashAlgorithm = db.HashAlgorithm
+	if fips {
+		err = checksum.Valid(db.Algorithm(), fips)
+		if err != nil {
+			return configError(err)
+		}
+	}
+
+	pending := upload.Pending(db, filter)
+	var planned []string
+	switch {
+	case approved != nil:
+		pending, planned, err = approved.apply(db)
+		if err != nil {
+			return configError(err)
+		}
+		fmt.Printf("Executing plan %s of %d uploads to %d tickets\n", planPath, approved.Uploads, len(approved.Tickets))
+	case retryFailed:
+		pending = upload.Failed(db, filter)
+		fmt.Printf("Retrying %d tickets with failed attachments\n", len(pending))
+	case rescreen:
+		if !screens.enabled() {
+			return configError(fmt.Errorf("--rescreen needs --block-executables, --clamd, or --scan-command"))
+		}
+		pending = upload.Flagged(db, filter)
+		for _, attachments := range pending {
+			for _, attachment := range attachments {
+				attachment.Flagged = nil
+			}
+		}
+		fmt.Printf("Rescreening %d tickets with quarantined attachments\n", len(pending))
+	default:
+		quarantined := 0
+		for _, attachments := range upload.Failed(db, filter) {
+			quarantined += len(attachments)
+		}
+		if quarantined > 0 {
+			fmt.Printf("Skipping %d attachments that failed to upload previously, use --retry-failed to reattempt them\n", quarantined)
+		}
+		flagged := 0
+		for _, attachments := range upload.Flagged(db, filter) {
+			flagged += len(attachments)
+		}
+		if flagged > 0 {
+			fmt.Printf("Skipping %d attachments quarantined by screening, use --rescreen to screen them again\n", flagged)
+		}
+	}
+	for _, attachment := range db.Attachments {
+		if attachment.DuplicateOf != "" && !attachment.Deleted && filter.Allows(attachment.IssueNumber) {
+			output.Detailf("Skipping attachment %s on issue %d, its content is uploaded from %s on issue %d\n", attachment.Path, attachment.IssueNumber, attachment.DuplicateOf, attachment.DuplicateOfIssue)
+		}
+	}
+
+	meta := &attachmentMeta{Enabled: true, UploadLimit: uploadLimit}
+	var clock *store.ServerClock
+	if onJIRA {
+		meta, err = getAttachmentMeta(jiraClient)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Checking JIRA server time")
+		clock, err = checkServerClock(jiraClient, db)
+		if err != nil {
+			return fmt.Errorf("failed checking JIRA server time: %w", err)
+		}
+	}
+
+	fmt.Println("Checking attachment file types")
+	excluded, err := filterPendingTypes(pending, typeFilter, db.Unstaged)
+	if err != nil {
+		return fmt.Errorf("failed checking attachment file types: %w", err)
+	}
+	for _, attachment := range excluded {
+		output.Detailf("Skipping attachment %s: %s\n", attachment.Path, attachment.Excluded)
+	}
+
+	var flagged []*store.Attachment
+	if screens.enabled() {
+		fmt.Println("Screening attachments")
+		flagged, err = screens.check(pending, db.Unstaged)
+		if err != nil {
+			return fmt.Errorf("failed screening attachments: %w", err)
+		}
+		for _, attachment := range flagged {
+			output.Detailf("Quarantining attachment %s: %s\n", attachment.Path, attachment.Flagged.Reason)
+		}
+	}
+
+	var downscaled []*store.Attachment
+	if !db.Unstaged {
+		if maxImageBytes > 0 {
+			fmt.Println("Downscaling large images")
+		}
+		downscaled, err = downscaleImages(pending, int64(maxImageBytes), db.Algorithm())
+		if err != nil {
+			return fmt.Errorf("failed downscaling images: %w", err)
+		}
+	}
+
+	fmt.Println("Checking attachment sizes")
+	oversize, err := applySizeLimit(pending, meta.UploadLimit, splitOversize, db.Unstaged)
+	if err != nil {
+		return fmt.Errorf("failed checking attachment sizes: %w", err)
+	}
+	for _, attachment := range oversize {
+		output.Detailf("Skipping attachment %s: %s\n", attachment.Path, attachment.SkipReason)
+	}
+	err = store.Save(db)
+	if err != nil {
+		return err
+	}
+
+	var warnings []string
+	if onJIRA {
+		fmt.Println("Estimating attachment storage")
+		err = estimateQuota(meta, db, pending, int64(storageHeadroom))
+		if err != nil {
+			return fmt.Errorf("failed estimating attachment storage: %w", err)
+		}
+
+		fmt.Println("Checking attachment types")
+		warnings = policy.check(pending)
+		for _, warning := range warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+	}
+
+	titles, err := orderUploads(db, pending, order, db.Unstaged)
+	if err != nil {
+		return fmt.Errorf("failed ordering uploads: %w", err)
+	}
+	if approved != nil {
+		titles = nil
+		for _, title := range planned {
+			if _, ok := pending[title]; ok {
+				titles = append(titles, title)
 			}
 		}
 	}
 
-	file, err := os.Create("processed_archive.tgz")
+	var content *contentMigration
+	if migrateContent {
+		content, err = newContentMigration(db, pending, clock, users)
+		if err != nil {
+			return err
+		}
+	}
+
+	uploads := &ticketUploads{
+		db:             db,
+		target:         target,
+		pages:          pages,
+		jira:           jiraClient,
+		lock:           lock,
+		bundle:         bundle,
+		uploadLimit:    meta.UploadLimit,
+		content:        content,
+		remoteLink:     remoteLink,
+		entityProperty: entityProperty,
+		correlation:    correlation,
+		provenance:     provenance,
+		clock:          clock,
+		runID:          summary.RunID,
+		opts:           opts,
+	}
+	blocked, ticketErrors, err := uploads.run(titles, pending)
+	if err != nil {
+		return err
+	}
+	err = saveRenames(opts.Names)
+	if err != nil {
+		return err
+	}
+	failures := runFailures(db, pending, ticketErrors, opts.Started)
+	summarizeUpload(summary, pending, oversize, blocked, warnings, failures, opts.Started)
+	summary.Counts["excluded"] = int64(len(excluded))
+	summary.Counts["flagged"] = int64(len(flagged))
+	summary.Counts["downscaled"] = int64(len(downscaled))
+	printExcluded(excluded)
+	printFlagged(flagged)
+	if len(oversize) > 0 {
+		fmt.Printf("Skipped %d attachments over the upload limit:\n", len(oversize))
+		for _, attachment := range oversize {
+			fmt.Printf("  %s (%s)\n", attachment.Path, attachment.SkipReason)
+		}
+	}
+	if len(warnings) > 0 {
+		fmt.Printf("%d attachments may not render in JIRA:\n", len(warnings))
+		for _, warning := range warnings {
+			fmt.Printf("  %s\n", warning)
+		}
+	}
+	if len(blocked) > 0 {
+		sort.Strings(blocked)
+		fmt.Printf("Skipped %d tickets blocked by their workflow status:\n", len(blocked))
+		for _, entry := range blocked {
+			fmt.Printf("  %s\n", entry)
+		}
+	}
+	if len(failures) > 0 {
+		fmt.Printf("%d uploads failed:\n", len(failures))
+		printFailures(failures)
+		return partialFailure(fmt.Errorf("%d uploads failed, reattempt them with --retry-failed", len(failures)))
+	}
+	if len(blocked) > 0 {
+		return nil
+	}
+	fmt.Println("All attachments uploaded")
+
+	return nil
+}
+
+func archiveCommand(flags map[string]commando.FlagValue, summary *runSummary) error {
+	onlyIssues := flags["only-issues"].Value.(string)
+	skipIssues := flags["skip-issues"].Value.(string)
+	layout := flags["layout"].Value.(string)
+	compression := flags["compression"].Value.(string)
+	splitSize := flags["split-size"].Value.(string)
+	output := flags["output"].Value.(string)
+	spaceCheck := flags["space-check"].Value.(bool)
+
+	filter, err := store.ParseIssueFilter(onlyIssues, skipIssues)
+	if err != nil {
+		return configError(err)
+	}
+
+	typeFilter, err := newTypeFilter(flags)
+	if err != nil {
+		return configError(err)
+	}
+
+	names, err := newNamer(flags)
+	if err != nil {
+		return configError(err)
+	}
+
+	err = archive.ValidLayout(layout)
+	if err != nil {
+		return configError(err)
+	}
+
+	err = archive.ValidCompression(compression)
+	if err != nil {
+		return configError(err)
+	}
+
+	var volumeSize int64
+	if splitSize != "none" {
+		volumeSize, err = parseSize(splitSize)
+		if err != nil {
+			return configError(err)
+		}
+	}
+	if objectstore.IsURL(output) {
+		if volumeSize > 0 {
+			return configError(fmt.Errorf("--split-size writes local volumes and cannot be used with an object storage --output"))
+		}
+		err = objectstore.Valid(output)
+		if err != nil {
+			return configError(err)
+		}
+	}
+
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if db.Unstaged {
+		return configError(fmt.Errorf("the database was collected with --no-stage, collect again without it to archive the attachments"))
+	}
+
+	pruned := 0
+	for _, attachment := range filter.Apply(db.Attachments) {
+		if attachment.Pruned && !attachment.Deleted {
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		return fmt.Errorf("gc pruned the staged files of %d attachments, collect again to stage them before archiving", pruned)
+	}
+
+	attachments, excluded, err := applyTypeFilter(filter.Apply(db.Attachments), typeFilter, db.Unstaged)
+	if err != nil {
+		return fmt.Errorf("failed checking attachment file types: %w", err)
+	}
+	summary.Counts["excluded"] = int64(len(excluded))
+	printExcluded(excluded)
+
+	name := "processed_archive" + archive.Extension(compression)
+	if output != "none" {
+		name = output
+	}
+	if spaceCheck {
+		err = checkArchiveSpace(attachments, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(archiveDir); os.IsNotExist(err) {
+		fmt.Println("Creating archive directory")
+		err := os.MkdirAll(archiveDir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed creating archive directory: %w", err)
+		}
+	} else {
+		fmt.Println("Archive directory already exists, deleting contents")
+		err := os.RemoveAll(archiveDir)
+		if err != nil {
+			return fmt.Errorf("failed deleting archive directory: %w", err)
+		}
+		fmt.Println("Creating new archive directory")
+		err = os.MkdirAll(archiveDir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed creating archive directory: %w", err)
+		}
+	}
+
+	fmt.Println("Copying files to archive directory")
+	entries, err := archive.Copy(db, attachments, archiveDir, layout, names)
+	summary.Counts["files"] = int64(len(entries))
+	if err != nil {
+		return err
+	}
+	err = saveRenames(names)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Writing archive manifest and checksums")
+	err = archive.WriteManifest(archiveDir, entries)
+	if err != nil {
+		return err
+	}
+
+	if volumeSize > 0 {
+		return archiveVolumes(name, compression, volumeSize, db.Algorithm(), summary)
+	}
+	if objectstore.IsURL(name) {
+		return archiveObject(name, compression, summary)
+	}
+
+	file, err := os.Create(name)
 	if err != nil {
-		return fmt.Errorf("failed opening archive: %s", err)
+		return fmt.Errorf("failed opening archive: %w", err)
 	}
 	defer file.Close()
 
 	fmt.Println("Compressing archive")
-	err = compress("archive", file)
+	_, err = archive.Compress(archiveDir, compression, file)
+	if err != nil {
+		return fmt.Errorf("failed compressing archive: %w", err)
+	}
+	fmt.Printf("Archive compressed: %s\n", name)
+	if info, err := file.Stat(); err == nil {
+		summary.Counts["archive_bytes"] = info.Size()
+	}
+
+	return nil
+}
+
+// checkArchiveSpace fails when the attachments cannot be copied into the
+// archive directory and compressed into the archive at name, measuring the
+// staged files they are copied from. The compressed archive is assumed to be
+// no smaller than the copies, which holds for the already compressed images
+// and videos attachments mostly are. The contents of the archive directory
+// are replaced, so the space they take counts as free.
+func checkArchiveSpace(attachments []*store.Attachment, name string) error {
+	var copies uint64
+	for _, attachment := range attachments {
+		if attachment.Deleted {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(store.StageDir, attachment.Path))
+		if err != nil {
+			return fmt.Errorf("failed reading staged attachment %s: %w", attachment.Path, err)
+		}
+		copies += uint64(info.Size())
+	}
+	existing, err := directorySize(archiveDir)
+	if err != nil {
+		return fmt.Errorf("failed measuring archive directory: %w", err)
+	}
+	required := uint64(0)
+	if copies > existing {
+		required = copies - existing
+	}
+
+	if objectstore.IsURL(name) {
+		return ensureSpace(archiveDir, required, "Copying the attachments into the archive directory")
+	}
+	outputDir := filepath.Dir(name)
+	if sameVolume(existingDir(archiveDir), existingDir(outputDir)) {
+		return ensureSpace(archiveDir, required+copies, "Copying the attachments into the archive directory and compressing them")
+	}
+	err = ensureSpace(archiveDir, required, "Copying the attachments into the archive directory")
+	if err != nil {
+		return err
+	}
+	return ensureSpace(outputDir, copies, "Compressing the archive")
+}
+
+// archiveObject compresses the archive directory straight into the object
+// storage URL, which only holds the archive once it is complete.
+func archiveObject(url, compression string, summary *runSummary) error {
+	object, err := objectstore.Create(context.Background(), url)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Compressing archive into %s\n", url)
+	counter := &countingWriter{}
+	_, err = archive.Compress(archiveDir, compression, object, counter)
+	if err != nil {
+		object.Close()
+		return fmt.Errorf("failed compressing archive: %w", err)
+	}
+	err = object.Close()
+	if err != nil {
+		return fmt.Errorf("failed uploading archive: %w", err)
+	}
+	fmt.Printf("Archive compressed: %s\n", url)
+	summary.Counts["archive_bytes"] = counter.n
+
+	return nil
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// archiveVolumes compresses the archive directory into volumes of at most
+// size bytes named after the archive, and writes an index of them
+// alongside.
+func archiveVolumes(name, compression string, size int64, algorithm string, summary *runSummary) error {
+	volumes, err := archive.NewVolumeWriter(name, size, algorithm)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Compressing archive into volumes of at most %d bytes\n", size)
+	files, err := archive.Compress(archiveDir, compression, volumes)
+	if err != nil {
+		volumes.Close()
+		return fmt.Errorf("failed compressing archive: %w", err)
+	}
+	err = volumes.Close()
 	if err != nil {
-		return fmt.Errorf("failed compressing archive: %s", err)
+		return err
+	}
+
+	index := &archive.Index{
+		Archive:     name,
+		Compression: compression,
+		SplitSize:   size,
+		Algorithm:   algorithm,
+		Volumes:     volumes.Volumes,
+		Files:       files,
+	}
+	for _, volume := range volumes.Volumes {
+		index.Bytes += volume.Bytes
+		fmt.Printf("Archive volume written: %s\n", volume.Name)
+	}
+	indexPath := strings.TrimSuffix(name, archive.Extension(compression)) + "_index.json"
+	err = archive.WriteIndex(indexPath, index)
+	if err != nil {
+		return err
 	}
-	fmt.Println("Archive compressed: processed_archive.tgz")
+	fmt.Printf("Archive compressed into %d volumes indexed by %s\n", len(volumes.Volumes), indexPath)
+	summary.Counts["archive_bytes"] = index.Bytes
+	summary.Counts["volumes"] = int64(len(volumes.Volumes))
 
 	return nil
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// commentCorrelation finds the JIRA comment migrated from a GitHub comment.
+// The template is a regular expression in which {comment} stands for the
+// GitHub comment ID and {url} for the GitHub comment URL.
+type commentCorrelation struct {
+	template string
+}
+
+// newCommentCorrelation returns nil when the template is "none", which
+// leaves comment markers disabled.
+func newCommentCorrelation(template string) (*commentCorrelation, error) {
+	if template == "none" {
+		return nil, nil
+	}
+	if !strings.Contains(template, "{comment}") && !strings.Contains(template, "{url}") {
+		return nil, fmt.Errorf("comment marker pattern %q must contain {comment} or {url}", template)
+	}
+	c := &commentCorrelation{template: template}
+	_, err := c.pattern(&store.Attachment{CommentNumber: 1, URL: "https://github.com"})
+	if err != nil {
+		return nil, fmt.Errorf("invalid comment marker pattern %q: %w", template, err)
+	}
+	return c, nil
+}
+
+func (c *commentCorrelation) pattern(attachment *store.Attachment) (*regexp.Regexp, error) {
+	expr := strings.NewReplacer(
+		"{comment}", strconv.FormatInt(attachment.CommentNumber, 10),
+		"{url}", regexp.QuoteMeta(attachment.URL),
+	).Replace(c.template)
+	return regexp.Compile(expr)
+}
+
+// markComments appends a reference to each uploaded comment-level
+// attachment to the JIRA comment matching its GitHub comment, so readers can
+// tell which files belong to which comment. Each JIRA comment is updated at
+// most once and references already present are not added again.
+func markComments(client *jira.Client, ticket *store.Ticket, attachments []*store.Attachment, correlation *commentCorrelation) error {
+	issue, resp, err := client.Issue.Get(ticket.Key, &jira.GetQueryOptions{Fields: "comment"})
+	if err != nil {
+		return fmt.Errorf("failed retrieving comments on %s: %w", ticket.Key, jiraRefused(resp, err))
+	}
+	var comments []*jira.Comment
+	if issue.Fields != nil && issue.Fields.Comments != nil {
+		comments = issue.Fields.Comments.Comments
+	}
+
+	updated := make(map[string]string)
+	for _, attachment := range attachments {
+		if attachment.CommentNumber == 0 || len(attachment.JIRAIDs) == 0 {
+			continue
+		}
+		pattern, err := correlation.pattern(attachment)
+		if err != nil {
+			return fmt.Errorf("failed building comment marker pattern: %w", err)
+		}
+		var target *jira.Comment
+		for _, comment := range comments {
+			if comment.ID != ticket.ProvenanceComment && pattern.MatchString(comment.Body) {
+				target = comment
+				break
+			}
+		}
+		if target == nil {
+			fmt.Printf("No comment on %s matches GitHub comment %d for attachment %s\n", ticket.Key, attachment.CommentNumber, attachment.Path)
+			continue
+		}
+
+		body, ok := updated[target.ID]
+		if !ok {
+			body = target.Body
+		}
+		markup := attachmentMarkup(attachment)
+		if !strings.Contains(body, markup) {
+			body += "\n\nMigrated attachment: " + markup
+		}
+		updated[target.ID] = body
+	}
+
+	for _, comment := range comments {
+		body, ok := updated[comment.ID]
+		if !ok || body == comment.Body {
+			continue
+		}
+		fmt.Printf("Marking comment %s on %s\n", comment.ID, ticket.Key)
+		_, resp, err := client.Issue.UpdateComment(ticket.Key, &jira.Comment{ID: comment.ID, Body: body})
+		if err != nil {
+			return fmt.Errorf("failed marking comment %s on %s: %w", comment.ID, ticket.Key, jiraRefused(resp, err))
+		}
+		err = audit.Record(&audit.Entry{Action: audit.ActionCommentUpdated, Ticket: ticket.Key, ID: comment.ID, Detail: "attachment markers"})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// sourceMarker tells contributors where the conversation of a GitHub issue
+// moved once its attachments are verified on the ticket, by labelling the
+// issue, commenting on it with the ticket key, and locking it.
+type sourceMarker struct {
+	client  *github.Client
+	label   string
+	comment bool
+	lock    bool
+	jiraURL string
+}
+
+// newSourceMarker reads the --mark-source, --mark-comment, and --lock-source
+// flags of verify, returning nil when GitHub issues are not marked.
+func newSourceMarker(flags map[string]commando.FlagValue, jiraURL string) (*sourceMarker, error) {
+	label := flags["mark-source"].Value.(string)
+	comment := flags["mark-comment"].Value.(bool)
+	lock := flags["lock-source"].Value.(bool)
+	token := flags["github-token"].Value.(string)
+	if label == "none" && !comment && !lock {
+		return nil, nil
+	}
+	if token == "none" {
+		return nil, fmt.Errorf("--github-token must be specified with --mark-source, --mark-comment, or --lock-source")
+	}
+	if label == "none" {
+		label = ""
+	}
+	return &sourceMarker{
+		client:  newGitHubClient(token),
+		label:   label,
+		comment: comment,
+		lock:    lock,
+		jiraURL: strings.TrimSuffix(jiraURL, "/"),
+	}, nil
+}
+
+// markVerified marks the GitHub issue of every ticket whose attachments
+// were all uploaded and verified, skipping issues already marked by an
+// earlier run, and records each issue marked in the database. Attachments
+// excluded by type are not waited for.
+func (m *sourceMarker) markVerified(db *store.Database, failedIssues map[int]bool) (int, error) {
+	incomplete := make(map[int]bool)
+	for _, attachment := range db.Attachments {
+		if attachment.Deleted || attachment.DuplicateOf != "" || attachment.Excluded != "" {
+			continue
+		}
+		if len(attachment.JIRAIDs) == 0 {
+			incomplete[attachment.IssueNumber] = true
+		}
+	}
+
+	marked := 0
+	for _, title := range sortedKeys(db.Tickets) {
+		ticket := db.Tickets[title]
+		issue, ok := db.Issues[title]
+		if !ok || !ticket.Uploaded || issue.Marked != nil || incomplete[issue.Number] || failedIssues[issue.Number] {
+			continue
+		}
+		err := m.mark(issue, ticket.Key)
+		if err != nil {
+			return marked, err
+		}
+		err = audit.Record(&audit.Entry{Action: audit.ActionSourceMarked, Ticket: issue.URL, Detail: m.describe(ticket.Key)})
+		if err != nil {
+			return marked, err
+		}
+		now := time.Now().UTC()
+		issue.Marked = &now
+		marked++
+		err = store.Save(db)
+		if err != nil {
+			return marked, err
+		}
+	}
+	return marked, nil
+}
+
+// mark labels, comments on, and locks the GitHub issue as configured.
+func (m *sourceMarker) mark(issue *store.Issue, key string) error {
+	owner, repo, err := issueRepository(issue.URL)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if m.label != "" {
+		fmt.Printf("Labelling GitHub issue %d %s\n", issue.Number, m.label)
+		_, resp, err := m.client.Issues.AddLabelsToIssue(ctx, owner, repo, issue.Number, []string{m.label})
+		if err != nil {
+			return fmt.Errorf("failed labelling GitHub issue %d: %w", issue.Number, gitHubRefused(resp, err))
+		}
+	}
+	if m.comment {
+		fmt.Printf("Commenting on GitHub issue %d\n", issue.Number)
+		body := fmt.Sprintf("The attachments of this issue were migrated to %s.", key)
+		if !unset(m.jiraURL) {
+			body = fmt.Sprintf("The attachments of this issue were migrated to [%s](%s/browse/%s).", key, m.jiraURL, key)
+		}
+		_, resp, err := m.client.Issues.CreateComment(ctx, owner, repo, issue.Number, &github.IssueComment{Body: &body})
+		if err != nil {
+			return fmt.Errorf("failed commenting on GitHub issue %d: %w", issue.Number, gitHubRefused(resp, err))
+		}
+	}
+	if m.lock {
+		fmt.Printf("Locking GitHub issue %d\n", issue.Number)
+		resp, err := m.client.Issues.Lock(ctx, owner, repo, issue.Number, &github.LockIssueOptions{LockReason: "resolved"})
+		if err != nil {
+			return fmt.Errorf("failed locking GitHub issue %d: %w", issue.Number, gitHubRefused(resp, err))
+		}
+	}
+	return nil
+}
+
+// describe lists what marking an issue does, for the audit log.
+func (m *sourceMarker) describe(key string) string {
+	var actions []string
+	if m.label != "" {
+		actions = append(actions, "labelled "+m.label)
+	}
+	if m.comment {
+		actions = append(actions, "commented "+key)
+	}
+	if m.lock {
+		actions = append(actions, "locked")
+	}
+	return strings.Join(actions, ", ")
+}
+
+// issueRepository returns the owner and repository of a GitHub issue or
+// pull request URL such as https://github.com/org/repo/issues/12.
+func issueRepository(url string) (string, string, error) {
+	tokens := strings.Split(strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://"), "/")
+	if len(tokens) < 5 || (tokens[3] != "issues" && tokens[3] != "pull") {
+		return "", "", fmt.Errorf("not a GitHub issue URL: %s", url)
+	}
+	if _, err := strconv.Atoi(tokens[4]); err != nil {
+		return "", "", fmt.Errorf("not a GitHub issue URL: %s", url)
+	}
+	return tokens[1], tokens[2], nil
+}
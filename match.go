@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/match"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+func matchCommand(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) error {
+	action := args["action"].Value
+	if action != "preview" {
+		return configError(fmt.Errorf("unknown match action %s", action))
+	}
+
+	matching, err := newMatchConfig(flags)
+	if err != nil {
+		return configError(err)
+	}
+
+	issues, err := loadIssues(flags)
+	if err != nil {
+		return err
+	}
+	tickets, err := loadTickets(flags, matching.Field)
+	if err != nil {
+		return err
+	}
+
+	printPreview(match.PreviewMatches(matching.Matcher(tickets), issues, tickets))
+	return nil
+}
+
+// newMatchConfig builds the matching strategy from the match flags shared by
+// collect, fetch, and match.
+func newMatchConfig(flags map[string]commando.FlagValue) (*match.Config, error) {
+	return match.NewConfig(
+		flags["match-strategy"].Value.(string),
+		flags["match-field"].Value.(string),
+		flags["match-mapping-file"].Value.(string),
+		flags["match-pattern"].Value.(string),
+	)
+}
+
+func loadIssues(flags map[string]commando.FlagValue) ([]*store.IssueEntry, error) {
+	issuesFile := flags["issues-file"].Value.(string)
+	githubToken := flags["github-token"].Value.(string)
+	org := flags["org"].Value.(string)
+	repo := flags["repo"].Value.(string)
+
+	var issues []*store.IssueEntry
+	if issuesFile != "none" {
+		bytes, err := os.ReadFile(issuesFile)
+		if err != nil {
+			return nil, configError(fmt.Errorf("failed reading issues file: %w", err))
+		}
+		err = json.Unmarshal(bytes, &issues)
+		if err != nil {
+			return nil, configError(fmt.Errorf("failed unmarshalling issues file: %w", err))
+		}
+		return issues, nil
+	}
+
+	if githubToken == "none" || org == "none" || repo == "none" {
+		return nil, configError(fmt.Errorf("--issues-file or --github-token, --org, and --repo must be specified"))
+	}
+	gh := &collect.GitHubSource{Client: client.NewGitHub(newGitHubClient(githubToken)), Token: githubToken, Org: org, Repo: repo, API: flags["github-api"].Value.(string)}
+	err := collect.ValidGitHubAPI(gh.API)
+	if err != nil {
+		return nil, configError(err)
+	}
+	return gh.ListIssues(collect.AllIssues())
+}
+
+func loadTickets(flags map[string]commando.FlagValue, field string) ([]*store.TicketEntry, error) {
+	ticketsFile := flags["tickets-file"].Value.(string)
+	jiraURL := flags["jira-url"].Value.(string)
+	jiraSecret := flags["jira-secret"].Value.(string)
+	jiraKeys := flags["jira-keys"].Value.(string)
+
+	var tickets []*store.TicketEntry
+	if ticketsFile != "none" {
+		bytes, err := os.ReadFile(ticketsFile)
+		if err != nil {
+			return nil, configError(fmt.Errorf("failed reading tickets file: %w", err))
+		}
+		err = json.Unmarshal(bytes, &tickets)
+		if err != nil {
+			return nil, configError(fmt.Errorf("failed unmarshalling tickets file: %w", err))
+		}
+		return tickets, nil
+	}
+
+	if jiraURL == "none" || jiraSecret == "none" || jiraKeys == "none" {
+		return nil, configError(fmt.Errorf("--tickets-file or --jira-url, --jira-secret, and --jira-keys must be specified"))
+	}
+	jiraClient, err := newJIRAClient(jiraSecret, jiraURL)
+	if err != nil {
+		return nil, configError(fmt.Errorf("failed creating JIRA client: %w", err))
+	}
+	source, err := collect.NewTicketSource(jiraKeys, "none", "none", flags["jira-search-workers"].Value.(int))
+	if err != nil {
+		return nil, configError(err)
+	}
+	return source.List(client.NewJIRA(jiraClient), field)
+}
+
+// printPreview reports the match rate, the unmatched issues, the colliding
+// issue titles, and the ambiguous matches.
+func printPreview(p *match.Preview) {
+	rate := 0.0
+	if p.Issues > 0 {
+		rate = float64(p.Matched) / float64(p.Issues) * 100
+	}
+	fmt.Printf("GitHub issues: %d\n", p.Issues)
+	fmt.Printf("JIRA tickets: %d\n", p.Tickets)
+	fmt.Printf("Matched issues: %d (%.1f%%)\n", p.Matched, rate)
+
+	if len(p.Unmatched) > 0 {
+		fmt.Printf("\nUnmatched issues: %d\n", len(p.Unmatched))
+		for _, issue := range p.Unmatched {
+			fmt.Printf("  #%d %s\n", issue.Number, issue.Title)
+		}
+	}
+
+	if len(p.IssueCollisions) > 0 {
+		fmt.Printf("\nGitHub titles shared by multiple issues: %d\n", len(p.IssueCollisions))
+		for _, title := range sortedKeys(p.IssueCollisions) {
+			var numbers []string
+			for _, issue := range p.IssueCollisions[title] {
+				numbers = append(numbers, fmt.Sprintf("#%d", issue.Number))
+			}
+			fmt.Printf("  %s: %s\n", title, strings.Join(numbers, ", "))
+		}
+	}
+
+	if len(p.Ambiguous) > 0 {
+		fmt.Printf("\nGitHub issues matching multiple tickets, the last is used: %d\n", len(p.Ambiguous))
+		for _, title := range sortedKeys(p.Ambiguous) {
+			var keys []string
+			for _, ticket := range p.Ambiguous[title] {
+				keys = append(keys, ticket.Key)
+			}
+			fmt.Printf("  %s: %s\n", title, strings.Join(keys, ", "))
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/lindluni/attachment-processor/pkg/naming"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// renamesFile logs the attachment files given a new name by sanitization,
+// mapping each back to its original name.
+const renamesFile = "renames.json"
+
+// newNamer builds the namer from the --name-template and --no-sanitize-names
+// flags shared by upload and archive.
+func newNamer(flags map[string]commando.FlagValue) (*naming.Namer, error) {
+	template, err := naming.ParseTemplate(flags["name-template"].Value.(string))
+	if err != nil {
+		return nil, err
+	}
+	// commando registers --no-sanitize-names as the inverted sanitize-names
+	// flag.
+	namer := &naming.Namer{Template: template, Sanitize: flags["sanitize-names"].Value.(bool)}
+	if namer.Sanitize {
+		namer.Renames, err = naming.LoadRenames(store.StatePath(renamesFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return namer, nil
+}
+
+// saveRenames writes the log of renamed files when any were renamed.
+func saveRenames(namer *naming.Namer) error {
+	if len(namer.Renames) == 0 {
+		return nil
+	}
+	return namer.Renames.Save(store.StatePath(renamesFile))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/lindluni/attachment-processor/pkg/cassette"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/secret"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// jiraTransport carries the JIRA requests. It is the default transport
+// unless JIRA requires a client certificate, which is only presented to JIRA.
+var jiraTransport http.RoundTripper = http.DefaultTransport
+
+// githubCacheDir holds the GitHub responses cached for revalidation by later
+// runs, or is empty when GitHub responses are not cached.
+var githubCacheDir string
+
+const githubCache = "github-cache"
+
+// withTransport wraps a command action so every HTTP client it creates, for
+// GitHub, JIRA, and the other APIs, goes through the proxy and trusts the
+// certificate authorities given by the flags, and JIRA requests present the
+// client certificate given by the flags.
+func withTransport(action func(map[string]commando.ArgValue, map[string]commando.FlagValue)) func(map[string]commando.ArgValue, map[string]commando.FlagValue) {
+	return func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+		transport, err := newTransport(flags)
+		if err != nil {
+			output.Errorf("Failed configuring HTTP transport: %s\n", err)
+			exit(exitConfig)
+		}
+		http.DefaultTransport = transport
+		secret.Client = &http.Client{Transport: transport, Timeout: secret.RequestTimeout}
+		jiraTransport, err = newJIRATransport(transport, flags)
+		if err != nil {
+			output.Errorf("Failed configuring HTTP transport: %s\n", err)
+			exit(exitConfig)
+		}
+		if flag, ok := flags["github-cache"]; ok && flag.Value.(bool) {
+			githubCacheDir = store.StatePath(githubCache)
+		}
+		err = useCassette(flags)
+		if err != nil {
+			output.Errorf("Failed configuring HTTP transport: %s\n", err)
+			exit(exitConfig)
+		}
+		if output.Verbosity >= output.LevelDebug {
+			http.DefaultTransport = &debugTransport{base: http.DefaultTransport}
+			jiraTransport = &debugTransport{base: jiraTransport}
+		}
+		action(args, flags)
+	}
+}
+
+// useCassette records every HTTP response of the run to the file given by
+// --record, or answers every request from the responses recorded in the
+// file given by --replay instead of sending it.
+func useCassette(flags map[string]commando.FlagValue) error {
+	record, replay := "none", "none"
+	if flag, ok := flags["record"]; ok {
+		record = flag.Value.(string)
+	}
+	if flag, ok := flags["replay"]; ok {
+		replay = flag.Value.(string)
+	}
+	switch {
+	case record != "none" && replay != "none":
+		return fmt.Errorf("--record and --replay cannot be used together")
+	case record != "none":
+		recorder, err := cassette.NewRecorder(record)
+		if err != nil {
+			return err
+		}
+		http.DefaultTransport = recorder.Wrap(http.DefaultTransport)
+		jiraTransport = recorder.Wrap(jiraTransport)
+	case replay != "none":
+		player, err := cassette.Load(replay)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Replaying the HTTP responses recorded in %s\n", replay)
+		http.DefaultTransport = player
+		jiraTransport = player
+	}
+	return nil
+}
+
+// newTransport returns the default transport configured with --proxy,
+// --ca-cert, and --insecure-skip-verify. Without --proxy, the HTTPS_PROXY,
+// HTTP_PROXY, and NO_PROXY environment variables are honored as before.
+func newTransport(flags map[string]commando.FlagValue) (*http.Transport, error) {
+	proxy := flags["proxy"].Value.(string)
+	caCert := flags["ca-cert"].Value.(string)
+	insecure := flags["insecure-skip-verify"].Value.(bool)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "none" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q, must be such as http://proxy.example.com:3128", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caCert != "none" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caCert)
+		}
+		config.RootCAs = pool
+	}
+	if insecure {
+		fmt.Println("Warning: TLS certificate verification is disabled, connections can be intercepted")
+		config.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
+// newJIRATransport returns the transport with the client certificate and key
+// of --jira-client-cert and --jira-client-key added, for JIRA deployments
+// requiring mutual TLS, or the transport itself without them.
+func newJIRATransport(transport *http.Transport, flags map[string]commando.FlagValue) (http.RoundTripper, error) {
+	certFile := flags["jira-client-cert"].Value.(string)
+	keyFile := flags["jira-client-key"].Value.(string)
+	if certFile == "none" && keyFile == "none" {
+		return transport, nil
+	}
+	if certFile == "none" || keyFile == "none" {
+		return nil, fmt.Errorf("--jira-client-cert and --jira-client-key must be specified together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading JIRA client certificate: %w", err)
+	}
+	mutual := transport.Clone()
+	mutual.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	return mutual, nil
+}
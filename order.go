@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// The orders tickets are uploaded in. Tickets are ordered by title unless
+// --order says otherwise.
+const (
+	orderTitle     = "title"
+	orderIssueAsc  = "issue-asc"
+	orderIssueDesc = "issue-desc"
+	orderSizeAsc   = "size-asc"
+	orderSizeDesc  = "size-desc"
+)
+
+// validOrder returns an error when the upload order is not recognized.
+func validOrder(order string) error {
+	switch order {
+	case orderTitle, orderIssueAsc, orderIssueDesc, orderSizeAsc, orderSizeDesc:
+		return nil
+	}
+	return fmt.Errorf("invalid upload order %q, must be one of %s, %s, %s, %s, or %s", order, orderTitle, orderIssueAsc, orderIssueDesc, orderSizeAsc, orderSizeDesc)
+}
+
+// orderUploads returns the titles of the pending tickets in the order they
+// are uploaded in: by title, by GitHub issue number, or by the total size of
+// their pending attachments, with ties broken by title. Ordering by size also
+// orders the attachments of each ticket by size, so small files can be sent
+// first to validate the pipeline quickly. Attachments left in the archive
+// are uploaded in the order of their archive members instead, each ticket at
+// the position of its first member, so the archive is read through once
+// rather than reopened for every member behind the last one read.
+func orderUploads(db *store.Database, pending map[string][]*store.Attachment, order string, unstaged bool) ([]string, error) {
+	titles := sortedKeys(pending)
+	if unstaged {
+		return archiveOrder(titles, pending), nil
+	}
+	switch order {
+	case orderIssueAsc, orderIssueDesc:
+		sort.SliceStable(titles, func(i, j int) bool {
+			if order == orderIssueDesc {
+				i, j = j, i
+			}
+			return db.Issues[titles[i]].Number < db.Issues[titles[j]].Number
+		})
+	case orderSizeAsc, orderSizeDesc:
+		sizes := make(map[*store.Attachment]int64)
+		totals := make(map[string]int64)
+		for title, attachments := range pending {
+			for _, attachment := range attachments {
+				size, err := attachmentSize(attachment, unstaged)
+				if err != nil {
+					return nil, err
+				}
+				sizes[attachment] = size
+				totals[title] += size
+			}
+			sort.SliceStable(attachments, func(i, j int) bool {
+				if order == orderSizeDesc {
+					i, j = j, i
+				}
+				return sizes[attachments[i]] < sizes[attachments[j]]
+			})
+		}
+		sort.SliceStable(titles, func(i, j int) bool {
+			if order == orderSizeDesc {
+				i, j = j, i
+			}
+			return totals[titles[i]] < totals[titles[j]]
+		})
+	}
+	return titles, nil
+}
+
+// archiveOrder orders the attachments of each ticket by the position of
+// their archive member, and the titles by the position of the first member
+// of their ticket.
+func archiveOrder(titles []string, pending map[string][]*store.Attachment) []string {
+	first := make(map[string]int)
+	for title, attachments := range pending {
+		sort.SliceStable(attachments, func(i, j int) bool {
+			return attachments[i].Position < attachments[j].Position
+		})
+		if len(attachments) > 0 {
+			first[title] = attachments[0].Position
+		}
+	}
+	sort.SliceStable(titles, func(i, j int) bool {
+		return first[titles[i]] < first[titles[j]]
+	})
+	return titles
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// TestOrderUploadsInArchiveOrder checks attachments left in the archive are
+// uploaded in the order of their archive members, whatever their titles, so
+// the archive is read through once.
+func TestOrderUploadsInArchiveOrder(t *testing.T) {
+	db := store.New(checksum.SHA256)
+	db.Unstaged = true
+	pending := map[string][]*store.Attachment{
+		"Crash on save": {{Path: "attachments/1/b.png", Position: 7}, {Path: "attachments/1/a.png", Position: 5}},
+		"Slow search":   {{Path: "attachments/2/log.txt", Position: 2}},
+	}
+
+	titles, err := orderUploads(db, pending, orderTitle, db.Unstaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Slow search", "Crash on save"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("ordered tickets %v, want %v", titles, want)
+	}
+	if first := pending["Crash on save"][0].Path; first != "attachments/1/a.png" {
+		t.Errorf("first attachment of the ticket is %s, want the one earlier in the archive", first)
+	}
+}
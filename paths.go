@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/download"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// auditFile is the append-only log of every externally visible action,
+// kept alongside the database.
+const auditFile = "audit.log"
+
+// archiveDir is the directory the archive command assembles the processed
+// archive in before compressing it.
+var archiveDir = "archive"
+
+// withPaths wraps a command action so it works on the workspace given by
+// --db-path and --stage-dir, and --archive-dir for the commands taking it,
+// rather than the working directory. The state files kept with the database,
+// the downloaded archives, the snapshots, and the lock, follow the database,
+// so several migrations can run side by side from a read-only working
+// directory.
+func withPaths(action func(map[string]commando.ArgValue, map[string]commando.FlagValue)) func(map[string]commando.ArgValue, map[string]commando.FlagValue) {
+	return func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+		store.DatabaseFile = flags["db-path"].Value.(string)
+		store.StageDir = flags["stage-dir"].Value.(string)
+		download.CacheDir = store.StatePath(filepath.Base(download.CacheDir))
+		audit.File = store.StatePath(auditFile)
+		if dir, ok := flags["archive-dir"]; ok {
+			archiveDir = dir.Value.(string)
+		}
+		action(args, flags)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/match"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// collectPhases selects the systems collect reaches. A skipped system's part
+// of the database is carried over from the existing database, so the
+// database can be built up from whichever systems are reachable.
+type collectPhases struct {
+	github bool
+	jira   bool
+	// previous is the existing database, nil when there is none.
+	previous *store.Database
+}
+
+// newCollectPhases reads the --skip-github, --skip-jira, and
+// --attachments-only flags of collect, loading the existing database when a
+// phase is skipped.
+func newCollectPhases(flags map[string]commando.FlagValue) (*collectPhases, error) {
+	phases := &collectPhases{
+		github: !flags["skip-github"].Value.(bool),
+		jira:   !flags["skip-jira"].Value.(bool),
+	}
+	if flags["attachments-only"].Value.(bool) {
+		phases.github, phases.jira = false, false
+	}
+	if phases.github && phases.jira {
+		return phases, nil
+	}
+
+	if _, err := os.Stat(store.DatabaseFile); err == nil {
+		previous, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		phases.previous = previous
+	}
+	if !phases.github && phases.previous == nil {
+		return nil, fmt.Errorf("--skip-github and --attachments-only reuse the issues of the existing database, but there is none")
+	}
+	return phases, nil
+}
+
+// issueSource returns the source of the issues: GitHub, or the issues of the
+// existing database when GitHub is skipped.
+func (p *collectPhases) issueSource(gh *collect.GitHubSource) collect.Source {
+	if p.github {
+		return gh
+	}
+	var issues []*store.IssueEntry
+	for title, issue := range p.previous.Issues {
+		issues = append(issues, &store.IssueEntry{Title: title, URL: issue.URL, Number: issue.Number, Labels: issue.Labels})
+	}
+	return &carriedIssues{issues: issues}
+}
+
+// ticketLister returns the lister of the target's tickets, or when JIRA is
+// skipped a lister keeping the tickets the existing database linked each
+// issue to.
+func (p *collectPhases) ticketLister(flags map[string]commando.FlagValue, matching *match.Config) (*ticketLister, error) {
+	if p.jira {
+		return newTicketLister(flags, matching)
+	}
+	return &ticketLister{
+		name: "tickets of the existing database",
+		list: func() ([]*store.TicketEntry, error) {
+			return nil, nil
+		},
+		matcher: &carriedMatcher{previous: p.previous},
+	}, nil
+}
+
+// carriedIssues is a source listing the issues of the existing database in
+// place of GitHub. Its attachments come from the archive alone.
+type carriedIssues struct {
+	issues []*store.IssueEntry
+}
+
+func (s *carriedIssues) Name() string {
+	return "carried over GitHub"
+}
+
+func (s *carriedIssues) FetchAttachments(*store.IssueFilter, *collect.IssueQuery, *store.Database) error {
+	return fmt.Errorf("the issues of the existing database have no attachments to fetch")
+}
+
+func (s *carriedIssues) ListIssues(*collect.IssueQuery) ([]*store.IssueEntry, error) {
+	return s.issues, nil
+}
+
+// carriedMatcher matches each issue to the ticket the existing database
+// linked it to.
+type carriedMatcher struct {
+	previous *store.Database
+}
+
+func (m *carriedMatcher) Match(issue *store.IssueEntry) []*store.TicketEntry {
+	if m.previous == nil {
+		return nil
+	}
+	ticket, ok := m.previous.Tickets[issue.Title]
+	if !ok {
+		return nil
+	}
+	return []*store.TicketEntry{{Summary: issue.Title, Key: ticket.Key}}
+}
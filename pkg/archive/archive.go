@@ -0,0 +1,253 @@
+// Package archive builds the processed attachment archive handed over
+// alongside the JIRA tickets.
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/naming"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+const (
+	LayoutFlat      = "flat"
+	LayoutPerTicket = "per-ticket"
+)
+
+func ValidLayout(layout string) error {
+	switch layout {
+	case LayoutFlat, LayoutPerTicket:
+		return nil
+	}
+	return fmt.Errorf("invalid layout %q, must be one of flat or per-ticket", layout)
+}
+
+// UnmatchedDir holds the attachments of issues matched to no JIRA ticket in
+// the per-ticket layout.
+const UnmatchedDir = "unmatched"
+
+// Copy copies each staged attachment into dir and returns a manifest entry
+// for each file copied. In the flat layout files are named after their issue
+// and, for comment attachments, their comment. In the per-ticket layout they
+// keep their own name in a directory named after the JIRA ticket, falling
+// back to the flat name when two attachments of a ticket share a name. A
+// name template of names replaces both, and names sanitizes the file names
+// when asked to. Names still taken are told apart by a counter such as
+// "screenshot (1).png". Deleted attachments are left out.
+func Copy(db *store.Database, attachments []*store.Attachment, dir, layout string, names *naming.Namer) ([]*Entry, error) {
+	keys := make(map[int]string)
+	titles := make(map[int]string)
+	issues := make(map[int]*store.Issue)
+	for title, issue := range db.Issues {
+		titles[issue.Number] = title
+		issues[issue.Number] = issue
+		if ticket, ok := db.Tickets[title]; ok {
+			keys[issue.Number] = ticket.Key
+		}
+	}
+
+	var entries []*Entry
+	taken := make(map[string]bool)
+	for _, attachment := range attachments {
+		if attachment.Deleted {
+			continue
+		}
+		own := names.Clean(attachment.Path, attachment.Name())
+		name := fmt.Sprintf("%d_%s", attachment.IssueNumber, own)
+		if attachment.CommentNumber != 0 {
+			name = fmt.Sprintf("%d_%d_%s", attachment.IssueNumber, attachment.CommentNumber, own)
+		}
+		templated := names != nil && names.Template != nil
+		if templated {
+			var err error
+			name, err = names.Name(naming.NewFields(attachment, attachment.Name(), keys[attachment.IssueNumber]), attachment.Path)
+			if err != nil {
+				return entries, err
+			}
+		}
+		if layout == LayoutPerTicket {
+			ticketDir := keys[attachment.IssueNumber]
+			if ticketDir == "" {
+				ticketDir = UnmatchedDir
+			}
+			if !templated && !taken[path.Join(ticketDir, own)] {
+				name = own
+			}
+			name = path.Join(ticketDir, name)
+			err := os.MkdirAll(filepath.Join(dir, ticketDir), 0755)
+			if err != nil {
+				return entries, fmt.Errorf("failed creating ticket directory: %w", err)
+			}
+		}
+		name = naming.Unique(name, taken)
+		srcPath := filepath.Join(store.StageDir, attachment.Path)
+		sum, size, err := copyFile(srcPath, filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return entries, fmt.Errorf("failed copying %s attachment: %w", attachment.Type, err)
+		}
+		entry := &Entry{
+			File:          name,
+			Type:          attachment.Type,
+			URL:           attachment.URL,
+			AssetURL:      attachment.AssetURL,
+			IssueNumber:   attachment.IssueNumber,
+			IssueTitle:    titles[attachment.IssueNumber],
+			CommentNumber: attachment.CommentNumber,
+			JIRAKey:       keys[attachment.IssueNumber],
+			SHA256:        sum,
+			Bytes:         size,
+		}
+		if issue := issues[attachment.IssueNumber]; issue != nil {
+			entry.IssueURL = issue.URL
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+func ValidCompression(compression string) error {
+	switch compression {
+	case CompressionGzip, CompressionZstd, CompressionNone:
+		return nil
+	}
+	return fmt.Errorf("invalid compression %q, must be one of gzip, zstd, or none", compression)
+}
+
+// Extension returns the file extension of a tarball with the compression.
+func Extension(compression string) string {
+	switch compression {
+	case CompressionZstd:
+		return ".tar.zst"
+	case CompressionNone:
+		return ".tar"
+	}
+	return ".tgz"
+}
+
+// File is a file written to the archive.
+type File struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Compress writes the regular files under src as a tarball with the
+// compression to every writer, and returns the files written.
+func Compress(src, compression string, writers ...io.Writer) ([]File, error) {
+	if _, err := os.Stat(src); err != nil {
+		return nil, fmt.Errorf("unable to tar files: %v", err.Error())
+	}
+
+	mw := io.MultiWriter(writers...)
+
+	var cw io.WriteCloser
+	switch compression {
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(mw)
+		if err != nil {
+			return nil, fmt.Errorf("failed creating zstd writer: %w", err)
+		}
+		cw = zw
+	case CompressionNone:
+		cw = nopCloser{mw}
+	default:
+		cw = gzip.NewWriter(mw)
+	}
+
+	var files []File
+	tw := tar.NewWriter(cw)
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(fi, fi.Name())
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, f); err != nil {
+			return err
+		}
+		f.Close()
+		files = append(files, File{Name: header.Name, Bytes: fi.Size()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// copyFile copies src to dst and returns the SHA-256 checksum and size of
+// the bytes copied.
+func copyFile(src, dst string) (string, int64, error) {
+	sourceFileStat, err := os.Stat(src)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed getting file stats: %w", err)
+	}
+
+	if !sourceFileStat.Mode().IsRegular() {
+		return "", 0, fmt.Errorf("%s is not a regular file", src)
+	}
+
+	source, err := os.Open(src)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed opening source file: %w", err)
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed creating destination file: %w", err)
+	}
+	defer destination.Close()
+	content := checksum.NewReader(source, checksum.SHA256)
+	_, err = io.Copy(destination, content)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed copying file: %w", err)
+	}
+
+	return content.Sum(), content.N, nil
+}
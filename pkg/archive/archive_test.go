@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+)
+
+// TestCompressKeepsNamesContainingDirectory archives files whose names
+// contain the name of the archived directory, and checks they are extracted
+// under their own names. The directory is given relative to the working
+// directory, as --archive-dir usually is.
+func TestCompressKeepsNamesContainingDirectory(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	src := "out"
+	files := map[string]string{
+		"12_layout.png":       "layout",
+		"out/13_outline.pdf":  "outline",
+		"PROJ-1/14_shout.txt": "shout",
+	}
+	var entries []*Entry
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum, err := checksum.Sum(bytes.NewReader([]byte(content)), checksum.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, &Entry{File: name, SHA256: sum, Bytes: int64(len(content))})
+	}
+	err = WriteManifest(src, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionNone} {
+		t.Run(compression, func(t *testing.T) {
+			var archived bytes.Buffer
+			written, err := Compress(src, compression, &archived)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make(map[string]bool)
+			for _, file := range written {
+				names[file.Name] = true
+			}
+			for name := range files {
+				if !names[name] {
+					t.Errorf("archive is missing %s, has %v", name, names)
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), "archive.tar")
+			err = os.WriteFile(path, archived.Bytes(), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			extracted, mismatches, err := Extract(path, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(extracted) != len(files) {
+				t.Errorf("extracted manifest has %d entries, want %d", len(extracted), len(files))
+			}
+			for _, mismatch := range mismatches {
+				t.Errorf("%s: %s", mismatch.File, mismatch.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/export"
+	"github.com/lindluni/attachment-processor/pkg/objectstore"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// Mismatch is a file listed in the manifest whose extracted content does not
+// match it.
+type Mismatch struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// Extract unpacks the processed archive at path into dir, then checks every
+// file listed in its manifest has the recorded size and SHA-256 checksum. An
+// archive whose manifest lists a file outside dir is refused.
+// The compression is detected from the archive's content, and the archive
+// may be streamed from an object storage URL. A path ending in
+// _index.json is read as the index of a split archive, whose volumes are
+// checked against the index and unpacked in order.
+func Extract(path, dir string) ([]*Entry, []*Mismatch, error) {
+	var r io.Reader
+	if strings.HasSuffix(path, "_index.json") {
+		volumes, err := openVolumes(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			for _, volume := range volumes {
+				volume.Close()
+			}
+		}()
+		readers := make([]io.Reader, len(volumes))
+		for i, volume := range volumes {
+			readers[i] = volume
+		}
+		r = io.MultiReader(readers...)
+	} else if objectstore.IsURL(path) {
+		object, err := objectstore.Open(context.Background(), path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer object.Close()
+		r = object
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed opening archive: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	tr, closer, err := decompress(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closer.Close()
+
+	err = unpack(tr, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bytes, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading archive manifest: %w", err)
+	}
+	var entries []*Entry
+	err = json.Unmarshal(bytes, &entries)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed unmarshalling archive manifest: %w", err)
+	}
+	// The files listed are opened here and staged for upload from the
+	// rebuilt database, so the manifest is held to the same names as the
+	// archive members.
+	for _, entry := range entries {
+		name, err := export.CleanName(entry.File)
+		if err == nil && name == "." {
+			err = fmt.Errorf("archive manifest lists a file with no name")
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid archive manifest: %w", err)
+		}
+		entry.File = name
+	}
+
+	var mismatches []*Mismatch
+	for _, entry := range entries {
+		mismatch, err := check(dir, entry)
+		if err != nil {
+			return entries, mismatches, err
+		}
+		if mismatch != nil {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return entries, mismatches, nil
+}
+
+// openVolumes checks the size and checksum of every volume listed in the
+// index against the files beside it, and opens them in order.
+func openVolumes(indexPath string) ([]*os.File, error) {
+	bytes, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading archive index: %w", err)
+	}
+	index := &Index{}
+	err = json.Unmarshal(bytes, index)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling archive index: %w", err)
+	}
+
+	var files []*os.File
+	for _, volume := range index.Volumes {
+		file, err := os.Open(filepath.Join(filepath.Dir(indexPath), filepath.Base(volume.Name)))
+		if err != nil {
+			closeAll(files)
+			return nil, fmt.Errorf("failed opening archive volume: %w", err)
+		}
+		files = append(files, file)
+		content := checksum.NewReader(file, index.Algorithm)
+		_, err = io.Copy(io.Discard, content)
+		if err != nil {
+			closeAll(files)
+			return nil, fmt.Errorf("failed reading archive volume %s: %w", volume.Name, err)
+		}
+		if content.N != volume.Bytes || content.Sum() != volume.Checksum {
+			closeAll(files)
+			return nil, fmt.Errorf("archive volume %s does not match the index, it may be corrupt or incomplete", volume.Name)
+		}
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			closeAll(files)
+			return nil, fmt.Errorf("failed rewinding archive volume %s: %w", volume.Name, err)
+		}
+	}
+	return files, nil
+}
+
+func closeAll(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// decompress returns a tar reader of r, detecting gzip and zstd compression
+// from its magic bytes and reading anything else as a plain tarball.
+func decompress(r io.Reader) (*tar.Reader, io.Closer, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("failed reading archive: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed reading gzip archive: %w", err)
+		}
+		return tar.NewReader(gzr), gzr, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed reading zstd archive: %w", err)
+		}
+		return tar.NewReader(zr), closerFunc(zr.Close), nil
+	}
+	return tar.NewReader(br), closerFunc(func() {}), nil
+}
+
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
+
+// unpack writes the regular files of the tarball under dir, refusing any
+// whose name would place it outside dir.
+func unpack(tr *tar.Reader, dir string) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed reading archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := export.CleanName(header.Name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return fmt.Errorf("failed creating directory: %w", err)
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("failed creating file: %w", err)
+		}
+		_, err = io.Copy(file, tr)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed extracting %s: %w", name, err)
+		}
+	}
+}
+
+// check compares the extracted file of the entry with its recorded size and
+// checksum.
+func check(dir string, entry *Entry) (*Mismatch, error) {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(entry.File)))
+	if os.IsNotExist(err) {
+		return &Mismatch{File: entry.File, Reason: "missing from the archive"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed opening %s: %w", entry.File, err)
+	}
+	defer file.Close()
+
+	content := checksum.NewReader(file, checksum.SHA256)
+	_, err = io.Copy(io.Discard, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading %s: %w", entry.File, err)
+	}
+	if content.N != entry.Bytes {
+		return &Mismatch{File: entry.File, Reason: fmt.Sprintf("size is %d bytes, manifest records %d", content.N, entry.Bytes)}, nil
+	}
+	if content.Sum() != entry.SHA256 {
+		return &Mismatch{File: entry.File, Reason: fmt.Sprintf("checksum is %s, manifest records %s", content.Sum(), entry.SHA256)}, nil
+	}
+	return nil, nil
+}
+
+// Database rebuilds a database from the manifest entries so the extracted
+// files can be uploaded from the staging directory. Every file is a staged
+// attachment at its path in the archive, and issues and tickets are restored
+// from the GitHub issue and JIRA ticket recorded with each file. Files with
+// no JIRA ticket are left out of the tickets so they are not uploaded.
+func Database(entries []*Entry) *store.Database {
+	db := store.New(checksum.SHA256)
+	for _, entry := range entries {
+		db.Attachments = append(db.Attachments, &store.Attachment{
+			Type:          entry.Type,
+			URL:           entry.URL,
+			AssetURL:      entry.AssetURL,
+			IssueNumber:   entry.IssueNumber,
+			CommentNumber: entry.CommentNumber,
+			Path:          entry.File,
+			Checksum:      entry.SHA256,
+			Size:          entry.Bytes,
+		})
+
+		title := entry.IssueTitle
+		if title == "" {
+			title = fmt.Sprintf("#%d", entry.IssueNumber)
+		}
+		if _, ok := db.Issues[title]; !ok {
+			db.Issues[title] = &store.Issue{URL: entry.IssueURL, Number: entry.IssueNumber}
+		}
+		if entry.JIRAKey != "" {
+			db.Tickets[title] = &store.Ticket{Key: entry.JIRAKey}
+		}
+	}
+	return db
+}
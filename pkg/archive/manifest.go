@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ManifestFile traces every file in the archive back to its source.
+	ManifestFile = "manifest.json"
+	// SumsFile lists the SHA-256 checksum of every file in the archive in
+	// the format read by sha256sum -c.
+	SumsFile = "SHA256SUMS"
+)
+
+// Entry describes a file in the archive, the GitHub issue or comment it was
+// attached to, and the JIRA ticket it was migrated to. The checksum is always
+// SHA-256, whatever algorithm the database uses, so recipients can check it
+// with standard tools.
+type Entry struct {
+	File          string `json:"file"`
+	Type          string `json:"type"`
+	URL           string `json:"url"`
+	AssetURL      string `json:"asset_url,omitempty"`
+	IssueNumber   int    `json:"issue_number"`
+	IssueTitle    string `json:"issue_title,omitempty"`
+	IssueURL      string `json:"issue_url,omitempty"`
+	CommentNumber int64  `json:"comment_number,omitempty"`
+	JIRAKey       string `json:"jira_key,omitempty"`
+	SHA256        string `json:"sha256"`
+	Bytes         int64  `json:"bytes"`
+}
+
+// WriteManifest writes the manifest and checksums of the entries into dir so
+// they are archived alongside the files.
+func WriteManifest(dir string, entries []*Entry) error {
+	if entries == nil {
+		entries = []*Entry{}
+	}
+	bytes, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshalling archive manifest: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, ManifestFile), bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing archive manifest: %w", err)
+	}
+
+	var sums strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&sums, "%s  %s\n", entry.SHA256, entry.File)
+	}
+	err = os.WriteFile(filepath.Join(dir, SumsFile), []byte(sums.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing archive checksums: %w", err)
+	}
+	return nil
+}
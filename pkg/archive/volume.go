@@ -0,0 +1,137 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+)
+
+// Volume is one part of an archive split into bounded volumes.
+type Volume struct {
+	Name     string `json:"name"`
+	Bytes    int64  `json:"bytes"`
+	Checksum string `json:"checksum"`
+}
+
+// Index lists the volumes of a split archive. Concatenating the volumes in
+// order reproduces the archive.
+type Index struct {
+	Archive     string    `json:"archive"`
+	Compression string    `json:"compression"`
+	SplitSize   int64     `json:"split_size"`
+	Algorithm   string    `json:"algorithm"`
+	Bytes       int64     `json:"bytes"`
+	Volumes     []*Volume `json:"volumes"`
+	Files       []File    `json:"files"`
+}
+
+// WriteIndex writes the index as JSON to path.
+func WriteIndex(path string, index *Index) error {
+	bytes, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshalling archive index: %w", err)
+	}
+	err = os.WriteFile(path, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing archive index: %w", err)
+	}
+	return nil
+}
+
+// VolumeWriter cuts the bytes written to it into volumes of at most size
+// bytes named after the archive with a .001, .002, ... suffix, hashing each
+// volume as it is written.
+type VolumeWriter struct {
+	Volumes []*Volume
+
+	name      string
+	size      int64
+	algorithm string
+	file      *os.File
+	hash      hash.Hash
+}
+
+// NewVolumeWriter removes the volumes left by an earlier run of the archive
+// and returns a writer for new ones.
+func NewVolumeWriter(name string, size int64, algorithm string) (*VolumeWriter, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid split size %d, must be positive", size)
+	}
+	stale, err := filepath.Glob(name + ".[0-9][0-9][0-9]")
+	if err != nil {
+		return nil, fmt.Errorf("failed listing archive volumes: %w", err)
+	}
+	for _, path := range stale {
+		err = os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed removing archive volume: %w", err)
+		}
+	}
+	return &VolumeWriter{name: name, size: size, algorithm: algorithm}, nil
+}
+
+func (w *VolumeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.file == nil || w.Volumes[len(w.Volumes)-1].Bytes == w.size {
+			err := w.next()
+			if err != nil {
+				return written, err
+			}
+		}
+		volume := w.Volumes[len(w.Volumes)-1]
+		chunk := p
+		if remaining := w.size - volume.Bytes; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.file.Write(chunk)
+		w.hash.Write(chunk[:n])
+		volume.Bytes += int64(n)
+		written += n
+		if err != nil {
+			return written, fmt.Errorf("failed writing archive volume %s: %w", volume.Name, err)
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// next finishes the current volume and starts the next.
+func (w *VolumeWriter) next() error {
+	err := w.finish()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s.%03d", w.name, len(w.Volumes)+1)
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed creating archive volume: %w", err)
+	}
+	w.file = file
+	w.hash = checksum.New(w.algorithm)
+	w.Volumes = append(w.Volumes, &Volume{Name: name})
+	return nil
+}
+
+// finish closes the current volume and records its checksum.
+func (w *VolumeWriter) finish() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("failed closing archive volume: %w", err)
+	}
+	w.Volumes[len(w.Volumes)-1].Checksum = fmt.Sprintf("%x", w.hash.Sum(nil))
+	return nil
+}
+
+// Close finishes the last volume.
+func (w *VolumeWriter) Close() error {
+	return w.finish()
+}
@@ -0,0 +1,101 @@
+// Package audit appends every externally visible action of the migrator to
+// an append-only log of JSON lines, for change-management records of the
+// changes made to production trackers.
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/output"
+)
+
+// The actions recorded in the log.
+const (
+	ActionExtracted          = "file_extracted"
+	ActionUploaded           = "attachment_uploaded"
+	ActionDeleted            = "attachment_deleted"
+	ActionCommented          = "comment_posted"
+	ActionCommentUpdated     = "comment_updated"
+	ActionDescriptionUpdated = "description_updated"
+	ActionTicketCreated      = "ticket_created"
+	ActionPageCreated        = "page_created"
+	ActionLinked             = "remote_link_set"
+	ActionPropertySet        = "entity_property_set"
+	ActionTransitioned       = "ticket_transitioned"
+	ActionSourceMarked       = "github_issue_marked"
+)
+
+// File is the path of the log, kept alongside the database. Nothing is
+// logged when it is empty.
+var File string
+
+// Command is the command of the run, recorded with each action.
+var Command string
+
+// RunID identifies the run, recorded with each action and in the run
+// summary so the two can be tied together.
+var RunID = newRunID()
+
+var mu sync.Mutex
+
+// Entry is a line of the log. Ticket is the ticket key, page ID, or GitHub
+// issue URL acted on, Path the staged path of the file involved, and ID the
+// ID of the attachment or comment, where they apply.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	RunID   string    `json:"run_id"`
+	Command string    `json:"command,omitempty"`
+	Action  string    `json:"action"`
+	Ticket  string    `json:"ticket,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	ID      string    `json:"id,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// Record appends the entry to the log, stamped with the time, run ID, and
+// command, with any secret in its detail redacted. The log is opened in append mode for each entry, so it is never
+// truncated and survives interrupted runs whole up to their last action.
+func Record(entry *Entry) error {
+	if File == "" {
+		return nil
+	}
+	entry.Time = time.Now().UTC()
+	entry.RunID = RunID
+	entry.Command = Command
+	entry.Detail = output.Redact(entry.Detail)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed marshalling audit entry: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	file, err := os.OpenFile(File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed opening audit log: %w", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing audit log: %w", err)
+	}
+	return nil
+}
+
+func newRunID() string {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(id)
+}
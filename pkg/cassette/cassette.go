@@ -0,0 +1,198 @@
+// Package cassette records the HTTP responses of a run to a file and replays
+// them in a later run, so a collect or upload can be reproduced without the
+// GitHub and JIRA instances it ran against. Only the method and URL of each
+// request are recorded, without the credentials in its headers, and requests
+// are matched on them, each recorded response being replayed once in the
+// order it was recorded.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Version is the layout of the cassettes written by Recorder.
+const Version = 1
+
+// Cassette is the recorded interactions of a run.
+type Cassette struct {
+	Version      int            `json:"version"`
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response it received. The body is
+// base64 encoded in the file.
+type Interaction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// unrecordedHeaders are the response headers left out of the cassette, as
+// they can carry session credentials.
+var unrecordedHeaders = []string{"Set-Cookie", "Www-Authenticate"}
+
+// Recorder writes every response received through the transports it wraps
+// to the cassette file, rewriting the file after each one so an interrupted
+// run keeps what it recorded.
+type Recorder struct {
+	path     string
+	mu       sync.Mutex
+	cassette *Cassette
+}
+
+// NewRecorder returns a recorder writing to path, replacing any cassette
+// there.
+func NewRecorder(path string) (*Recorder, error) {
+	r := &Recorder{path: path, cassette: &Cassette{Version: Version, Interactions: []*Interaction{}}}
+	err := r.save()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Wrap returns a transport sending requests through base and recording
+// their responses.
+func (r *Recorder) Wrap(base http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{recorder: r, base: base}
+}
+
+type recordingTransport struct {
+	recorder *Recorder
+	base     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading response to record: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	for _, name := range unrecordedHeaders {
+		header.Del(name)
+	}
+	err = t.recorder.add(&Interaction{
+		Method: req.Method,
+		URL:    requestURL(req.URL),
+		Status: resp.StatusCode,
+		Header: header,
+		Body:   body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *Recorder) add(interaction *Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	return r.save()
+}
+
+// save writes the cassette. The recorder mutex must be held.
+func (r *Recorder) save() error {
+	bytes, err := json.Marshal(r.cassette)
+	if err != nil {
+		return fmt.Errorf("failed marshalling cassette: %w", err)
+	}
+	tmp := r.path + ".tmp"
+	err = os.MkdirAll(filepath.Dir(r.path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed creating cassette directory: %w", err)
+	}
+	err = os.WriteFile(tmp, bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing cassette: %w", err)
+	}
+	err = os.Rename(tmp, r.path)
+	if err != nil {
+		return fmt.Errorf("failed writing cassette: %w", err)
+	}
+	return nil
+}
+
+// Player answers requests with the responses of a cassette instead of
+// sending them. A request without a recorded response left fails, naming
+// the request, as the run has diverged from the recording.
+type Player struct {
+	mu        sync.Mutex
+	responses map[string][]*Interaction
+}
+
+// Load reads the cassette at path for replaying.
+func Load(path string) (*Player, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading cassette: %w", err)
+	}
+	cassette := &Cassette{}
+	err = json.Unmarshal(bytes, cassette)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing cassette: %w", err)
+	}
+	if cassette.Version != Version {
+		return nil, fmt.Errorf("cassette %s has version %d, this version of the migrator replays version %d cassettes", path, cassette.Version, Version)
+	}
+	p := &Player{responses: make(map[string][]*Interaction)}
+	for _, interaction := range cassette.Interactions {
+		key := interaction.Method + " " + interaction.URL
+		p.responses[key] = append(p.responses[key], interaction)
+	}
+	return p, nil
+}
+
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	key := req.Method + " " + requestURL(req.URL)
+	p.mu.Lock()
+	queue := p.responses[key]
+	if len(queue) == 0 {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("the cassette has no response left for %s", key)
+	}
+	interaction := queue[0]
+	p.responses[key] = queue[1:]
+	p.mu.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(interaction.Body)),
+		ContentLength: int64(len(interaction.Body)),
+		Request:       req,
+	}, nil
+}
+
+// requestURL returns the URL requests are matched on, without any
+// credentials in it.
+func requestURL(u *url.URL) string {
+	stripped := *u
+	stripped.User = nil
+	return stripped.String()
+}
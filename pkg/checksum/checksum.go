@@ -0,0 +1,80 @@
+// Package checksum computes the checksums recorded for attachments as they
+// move from the GitHub archive to JIRA.
+package checksum
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/zeebo/blake3"
+)
+
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	BLAKE3 = "blake3"
+)
+
+// Valid checks the algorithm is supported and, in FIPS mode, that it is
+// approved by FIPS 180-4. BLAKE3 is faster but not approved.
+func Valid(algorithm string, fips bool) error {
+	switch algorithm {
+	case SHA256, SHA512:
+		return nil
+	case BLAKE3:
+		if fips {
+			return fmt.Errorf("hash algorithm %s is not FIPS approved, use sha256 or sha512", algorithm)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid hash algorithm %q, must be one of sha256, sha512, or blake3", algorithm)
+}
+
+// New returns a hash for the algorithm. Databases written before the
+// algorithm was recorded use SHA-256.
+func New(algorithm string) hash.Hash {
+	switch algorithm {
+	case SHA512:
+		return sha512.New()
+	case BLAKE3:
+		return blake3.New()
+	}
+	return sha256.New()
+}
+
+// Reader hashes and counts the bytes read through it.
+type Reader struct {
+	r io.Reader
+	h hash.Hash
+	// N is the number of bytes read so far.
+	N int64
+}
+
+func NewReader(r io.Reader, algorithm string) *Reader {
+	return &Reader{r: r, h: New(algorithm)}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	r.N += int64(n)
+	return n, err
+}
+
+// Sum returns the hex encoded checksum of the bytes read so far.
+func (r *Reader) Sum() string {
+	return hex.EncodeToString(r.h.Sum(nil))
+}
+
+// Sum reads r to the end and returns its hex encoded checksum.
+func Sum(r io.Reader, algorithm string) (string, error) {
+	h := New(algorithm)
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
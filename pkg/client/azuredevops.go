@@ -0,0 +1,258 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AzureDevOpsUploadLimit is the largest file Azure DevOps accepts in a single
+// attachment upload.
+const AzureDevOpsUploadLimit = 60 << 20
+
+// AzureDevOps uploads attachments to the work items of an Azure DevOps
+// Boards project, in place of JIRA tickets, and lists the work items issues
+// are matched to.
+type AzureDevOps interface {
+	Target
+	// ListWorkItems lists every work item in the project.
+	ListWorkItems(ctx context.Context) ([]*WorkItem, error)
+}
+
+// WorkItem is the part of an Azure DevOps work item issues are matched on.
+type WorkItem struct {
+	ID    int
+	Title string
+}
+
+type azureDevOps struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewAzureDevOps returns a client of the project at orgURL, such as
+// https://dev.azure.com/my-org, authenticated with a personal access token
+// with the Work Items read and write scope. Requests are sent through the
+// HTTP client.
+func NewAzureDevOps(httpClient *http.Client, orgURL, project, token string) AzureDevOps {
+	return &azureDevOps{
+		baseURL: strings.TrimSuffix(orgURL, "/") + "/" + url.PathEscape(project) + "/_apis/wit",
+		token:   token,
+		client:  httpClient,
+	}
+}
+
+// workItemRelation is a link from a work item, which for attached files
+// points at the attachment.
+type workItemRelation struct {
+	Rel        string                 `json:"rel"`
+	URL        string                 `json:"url"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+type workItem struct {
+	ID        int                    `json:"id"`
+	Rev       int                    `json:"rev"`
+	Fields    map[string]interface{} `json:"fields"`
+	Relations []*workItemRelation    `json:"relations"`
+}
+
+// attachedFile returns the relation as an attachment, or nil if it does not
+// link an attached file.
+func (r *workItemRelation) attachedFile() *TargetAttachment {
+	if r.Rel != "AttachedFile" {
+		return nil
+	}
+	attachment := &TargetAttachment{ID: r.URL}
+	if name, ok := r.Attributes["name"].(string); ok {
+		attachment.Name = name
+	}
+	if size, ok := r.Attributes["resourceSize"].(float64); ok {
+		attachment.Size = int64(size)
+	}
+	return attachment
+}
+
+// do sends the request to the API path and decodes the JSON response into v
+// unless it is nil.
+func (a *azureDevOps) do(ctx context.Context, method, path, contentType string, body io.Reader, v interface{}) error {
+	endpoint := a.baseURL + path
+	if strings.Contains(path, "?") {
+		endpoint += "&api-version=7.0"
+	} else {
+		endpoint += "?api-version=7.0"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("", a.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(resp.Body)
+		return Refused(resp, fmt.Errorf("%s %s: %s\n\n%s", method, path, resp.Status, string(message)))
+	}
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+func (a *azureDevOps) getWorkItem(ctx context.Context, key string) (*workItem, error) {
+	item := &workItem{}
+	err := a.do(ctx, http.MethodGet, "/workitems/"+url.PathEscape(key)+"?$expand=relations", "", nil, item)
+	return item, err
+}
+
+// patchWorkItem applies the JSON patch operations to the work item and
+// returns it as updated.
+func (a *azureDevOps) patchWorkItem(ctx context.Context, key string, operations []map[string]interface{}) (*workItem, error) {
+	body, err := json.Marshal(operations)
+	if err != nil {
+		return nil, err
+	}
+	item := &workItem{}
+	err = a.do(ctx, http.MethodPatch, "/workitems/"+url.PathEscape(key)+"?$expand=relations", "application/json-patch+json", bytes.NewReader(body), item)
+	return item, err
+}
+
+func (a *azureDevOps) ListAttachments(ctx context.Context, key string) ([]*TargetAttachment, error) {
+	item, err := a.getWorkItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var attachments []*TargetAttachment
+	for _, relation := range item.Relations {
+		if attachment := relation.attachedFile(); attachment != nil {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments, nil
+}
+
+// PostAttachment uploads the file to the project's attachment store, then
+// links it to the work item with an AttachedFile relation. The attachment is
+// identified by its URL.
+func (a *azureDevOps) PostAttachment(ctx context.Context, key string, r io.Reader, name string) (*TargetAttachment, error) {
+	uploaded := struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}{}
+	err := a.do(ctx, http.MethodPost, "/attachments?fileName="+url.QueryEscape(name), "application/octet-stream", r, &uploaded)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := a.patchWorkItem(ctx, key, []map[string]interface{}{{
+		"op":   "add",
+		"path": "/relations/-",
+		"value": map[string]interface{}{
+			"rel": "AttachedFile",
+			"url": uploaded.URL,
+		},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("uploaded %s but failed attaching it to work item %s: %w", name, key, err)
+	}
+	for _, relation := range item.Relations {
+		if relation.URL == uploaded.URL {
+			if attachment := relation.attachedFile(); attachment != nil {
+				if attachment.Name == "" {
+					attachment.Name = name
+				}
+				return attachment, nil
+			}
+		}
+	}
+	return &TargetAttachment{ID: uploaded.URL, Name: name}, nil
+}
+
+// DeleteAttachment removes the relation linking the attachment to the work
+// item, guarded by the work item revision so a concurrent edit cannot shift
+// the relation removed.
+func (a *azureDevOps) DeleteAttachment(ctx context.Context, key, id string) error {
+	item, err := a.getWorkItem(ctx, key)
+	if err != nil {
+		return err
+	}
+	for i, relation := range item.Relations {
+		if relation.Rel != "AttachedFile" || relation.URL != id {
+			continue
+		}
+		_, err = a.patchWorkItem(ctx, key, []map[string]interface{}{
+			{"op": "test", "path": "/rev", "value": item.Rev},
+			{"op": "remove", "path": "/relations/" + strconv.Itoa(i)},
+		})
+		return err
+	}
+	return fmt.Errorf("attachment %s is not attached to work item %s", id, key)
+}
+
+func (a *azureDevOps) ListWorkItems(ctx context.Context) ([]*WorkItem, error) {
+	query := map[string]string{
+		"query": "SELECT [System.Id] FROM WorkItems WHERE [System.TeamProject] = @project ORDER BY [System.Id]",
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	result := struct {
+		WorkItems []struct {
+			ID int `json:"id"`
+		} `json:"workItems"`
+	}{}
+	err = a.do(ctx, http.MethodPost, "/wiql", "application/json", bytes.NewReader(body), &result)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*WorkItem
+	for start := 0; start < len(result.WorkItems); start += 200 {
+		end := start + 200
+		if end > len(result.WorkItems) {
+			end = len(result.WorkItems)
+		}
+		batch := map[string]interface{}{
+			"fields": []string{"System.Id", "System.Title"},
+		}
+		var ids []int
+		for _, item := range result.WorkItems[start:end] {
+			ids = append(ids, item.ID)
+		}
+		batch["ids"] = ids
+		body, err := json.Marshal(batch)
+		if err != nil {
+			return nil, err
+		}
+		page := struct {
+			Value []*workItem `json:"value"`
+		}{}
+		err = a.do(ctx, http.MethodPost, "/workitemsbatch", "application/json", bytes.NewReader(body), &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Value {
+			title, _ := item.Fields["System.Title"].(string)
+			items = append(items, &WorkItem{ID: item.ID, Title: title})
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bitbucket lists the issues of a Bitbucket repository and downloads the
+// files attached to them, for migrations from the Bitbucket issue tracker.
+type Bitbucket interface {
+	// ListIssues lists a page of the repository's issues matching the
+	// Bitbucket query language filter, returning the next page or zero after
+	// the last.
+	ListIssues(ctx context.Context, workspace, repo, filter string, page int) ([]*BitbucketIssue, int, error)
+	// ListAttachments lists a page of the files attached to an issue.
+	ListAttachments(ctx context.Context, workspace, repo string, id int, page int) ([]*BitbucketAttachment, int, error)
+	// DownloadAttachment returns the content of a file attached to an
+	// issue.
+	DownloadAttachment(ctx context.Context, workspace, repo string, id int, name string) (io.ReadCloser, error)
+}
+
+// BitbucketIssue is the part of a Bitbucket issue the collector reads.
+type BitbucketIssue struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	State     string    `json:"state"`
+	UpdatedOn time.Time `json:"updated_on"`
+	Links     struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+// BitbucketAttachment is a file attached to a Bitbucket issue.
+type BitbucketAttachment struct {
+	Name string `json:"name"`
+}
+
+type bitbucket struct {
+	baseURL  string
+	username string
+	secret   string
+	client   *http.Client
+}
+
+// NewBitbucket returns a client of the Bitbucket 2.0 REST API at baseURL,
+// such as https://api.bitbucket.org/2.0. With a username the secret is an
+// app password, and without one it is an access token.
+func NewBitbucket(baseURL, username, secret string) Bitbucket {
+	return &bitbucket{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		secret:   secret,
+		client:   http.DefaultClient,
+	}
+}
+
+// get requests the API path and returns the response, which the caller must
+// close.
+func (b *bitbucket) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+	endpoint := b.baseURL + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.secret)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+b.secret)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, Refused(resp, fmt.Errorf("GET %s: %s", path, resp.Status))
+	}
+	return resp, nil
+}
+
+// list decodes a page of a paginated collection into values and returns the
+// next page, or zero after the last.
+func (b *bitbucket) list(ctx context.Context, path string, params url.Values, page int, values interface{}) (int, error) {
+	params.Set("page", strconv.Itoa(page))
+	params.Set("pagelen", "50")
+	resp, err := b.get(ctx, path, params)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body := struct {
+		Values interface{} `json:"values"`
+		Next   string      `json:"next"`
+	}{Values: values}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return 0, fmt.Errorf("failed decoding %s: %w", path, err)
+	}
+	if body.Next == "" {
+		return 0, nil
+	}
+	return page + 1, nil
+}
+
+func (b *bitbucket) ListIssues(ctx context.Context, workspace, repo, filter string, page int) ([]*BitbucketIssue, int, error) {
+	params := url.Values{}
+	params.Set("sort", "id")
+	if filter != "" {
+		params.Set("q", filter)
+	}
+	var issues []*BitbucketIssue
+	next, err := b.list(ctx, fmt.Sprintf("/repositories/%s/%s/issues", url.PathEscape(workspace), url.PathEscape(repo)), params, page, &issues)
+	return issues, next, err
+}
+
+func (b *bitbucket) ListAttachments(ctx context.Context, workspace, repo string, id int, page int) ([]*BitbucketAttachment, int, error) {
+	var attachments []*BitbucketAttachment
+	next, err := b.list(ctx, fmt.Sprintf("/repositories/%s/%s/issues/%d/attachments", url.PathEscape(workspace), url.PathEscape(repo), id), url.Values{}, page, &attachments)
+	return attachments, next, err
+}
+
+func (b *bitbucket) DownloadAttachment(ctx context.Context, workspace, repo string, id int, name string) (io.ReadCloser, error) {
+	resp, err := b.get(ctx, fmt.Sprintf("/repositories/%s/%s/issues/%d/attachments/%s", url.PathEscape(workspace), url.PathEscape(repo), id, url.PathEscape(name)), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+)
+
+// cacheHeader marks responses served from the cache after the server
+// confirmed they had not changed.
+const cacheHeader = "X-Attachment-Processor-Cache"
+
+// FromCache reports whether a response was served from the cache of a
+// CachingTransport, having cost the server no more than a 304.
+func FromCache(resp *http.Response) bool {
+	return resp != nil && resp.Header.Get(cacheHeader) != ""
+}
+
+type cachingTransport struct {
+	dir  string
+	base http.RoundTripper
+}
+
+// NewCachingTransport returns a transport keeping the GET responses carrying
+// an ETag or Last-Modified header in dir and revalidating them with
+// If-None-Match and If-Modified-Since, so a response that has not changed is
+// answered with a 304 and read from disk. GitHub does not count such
+// requests against the rate limit. Responses are kept per credential, so one
+// token never sees what was fetched with another.
+func NewCachingTransport(dir string, base http.RoundTripper) http.RoundTripper {
+	return &cachingTransport{dir: dir, base: base}
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+	path := t.path(req)
+	cached := t.load(path, req)
+	if cached != nil {
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.Header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		for name, values := range resp.Header {
+			if name != "Content-Length" {
+				cached.Header[name] = values
+			}
+		}
+		cached.Header.Set(cacheHeader, "hit")
+		cached.Request = req
+		return cached, nil
+	}
+	if cached != nil {
+		cached.Body.Close()
+	}
+	if resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "") {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		t.store(path, resp, body)
+	}
+	return resp, nil
+}
+
+// path returns the cache file of the request, keyed by its URL and the
+// credential it is sent with.
+func (t *cachingTransport) path(req *http.Request) string {
+	hash := sha256.New()
+	io.WriteString(hash, req.URL.String())
+	hash.Write([]byte{0})
+	io.WriteString(hash, req.Header.Get("Authorization"))
+	return filepath.Join(t.dir, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// load returns the cached response at path, or nil when there is none or it
+// cannot be read, in which case the request is simply made uncached.
+func (t *cachingTransport) load(path string, req *http.Request) *http.Response {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), req)
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+// store writes the response with its body to path. Failing to cache is not
+// an error.
+func (t *cachingTransport) store(path string, resp *http.Response, body []byte) {
+	saved := *resp
+	saved.Body = io.NopCloser(bytes.NewReader(body))
+	saved.ContentLength = int64(len(body))
+	saved.TransferEncoding = nil
+	raw, err := httputil.DumpResponse(&saved, true)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(t.dir, 0o700)
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, raw, 0o600)
+	if err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
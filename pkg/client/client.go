@@ -0,0 +1,112 @@
+// Package client defines the parts of the GitHub and JIRA APIs the collector
+// and uploader depend on, so callers can supply their own implementations,
+// and adapts the go-github and go-jira clients to them.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/v47/github"
+)
+
+// GitHub lists the issues and comments attachments are collected from.
+type GitHub interface {
+	ListIssues(ctx context.Context, org, repo string, opts *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error)
+	// ListIssueComments lists the comments on an issue, or on every issue
+	// in the repository when number is zero.
+	ListIssueComments(ctx context.Context, org, repo string, number int, opts *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error)
+	// Query runs a GraphQL query and decodes its data into result.
+	Query(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error
+}
+
+// JIRA finds the tickets attachments are uploaded to and manages their
+// attachments.
+type JIRA interface {
+	SearchIssues(jql string, opts *jira.SearchOptions) ([]jira.Issue, *jira.Response, error)
+	GetIssue(key string, opts *jira.GetQueryOptions) (*jira.Issue, *jira.Response, error)
+	PostAttachment(ctx context.Context, key string, r io.Reader, name string) (*[]jira.Attachment, *jira.Response, error)
+	DeleteAttachment(id string) (*jira.Response, error)
+}
+
+type gitHub struct {
+	client *github.Client
+}
+
+// NewGitHub adapts a go-github client to the GitHub interface.
+func NewGitHub(client *github.Client) GitHub {
+	return &gitHub{client: client}
+}
+
+func (g *gitHub) ListIssues(ctx context.Context, org, repo string, opts *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error) {
+	issues, resp, err := g.client.Issues.ListByRepo(ctx, org, repo, opts)
+	return issues, resp, GitHubRefused(resp, err)
+}
+
+func (g *gitHub) ListIssueComments(ctx context.Context, org, repo string, number int, opts *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error) {
+	comments, resp, err := g.client.Issues.ListComments(ctx, org, repo, number, opts)
+	return comments, resp, GitHubRefused(resp, err)
+}
+
+func (g *gitHub) Query(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error {
+	body := map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	}
+	req, err := g.client.NewRequest(http.MethodPost, "graphql", body)
+	if err != nil {
+		return err
+	}
+	response := struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}{}
+	resp, err := g.client.Do(ctx, req, &response)
+	if err != nil {
+		return GitHubRefused(resp, err)
+	}
+	if len(response.Errors) > 0 {
+		var messages []string
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("graphql query failed: %s", strings.Join(messages, "; "))
+	}
+	return json.Unmarshal(response.Data, result)
+}
+
+type jiraClient struct {
+	client *jira.Client
+}
+
+// NewJIRA adapts a go-jira client to the JIRA interface.
+func NewJIRA(client *jira.Client) JIRA {
+	return &jiraClient{client: client}
+}
+
+func (j *jiraClient) SearchIssues(jql string, opts *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	issues, resp, err := j.client.Issue.Search(jql, opts)
+	return issues, resp, JIRARefused(resp, err)
+}
+
+func (j *jiraClient) GetIssue(key string, opts *jira.GetQueryOptions) (*jira.Issue, *jira.Response, error) {
+	issue, resp, err := j.client.Issue.Get(key, opts)
+	return issue, resp, JIRARefused(resp, err)
+}
+
+func (j *jiraClient) PostAttachment(ctx context.Context, key string, r io.Reader, name string) (*[]jira.Attachment, *jira.Response, error) {
+	attachments, resp, err := j.client.Issue.PostAttachmentWithContext(ctx, key, r, name)
+	return attachments, resp, JIRARefused(resp, err)
+}
+
+func (j *jiraClient) DeleteAttachment(id string) (*jira.Response, error) {
+	resp, err := j.client.Issue.DeleteAttachment(id)
+	return resp, JIRARefused(resp, err)
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ConfluenceUploadLimit is the largest file Confluence accepts as an
+// attachment under its default settings.
+const ConfluenceUploadLimit = 100 << 20
+
+// Confluence uploads attachments to pages of a Confluence space, in place of
+// JIRA tickets, creating one page per issue. Pages are addressed by their
+// content ID.
+type Confluence interface {
+	Target
+	// FindPage returns the ID of the page in the space with the title, or an
+	// empty ID if there is none.
+	FindPage(ctx context.Context, title string) (string, error)
+	// CreatePage creates a page in the space, under the parent page when one
+	// is configured, with a body in the storage format, and returns its ID.
+	CreatePage(ctx context.Context, title, body string) (string, error)
+}
+
+type confluence struct {
+	baseURL  string
+	space    string
+	parent   string
+	username string
+	token    string
+	client   *http.Client
+}
+
+// NewConfluence returns a client of the space at baseURL, such as
+// https://my-org.atlassian.net/wiki, authenticated with a username and API
+// token, or with a personal access token when the username is empty. Pages
+// are created under the parent page unless it is empty. Requests are sent
+// through the HTTP client.
+func NewConfluence(httpClient *http.Client, baseURL, space, parent, username, token string) Confluence {
+	return &confluence{
+		baseURL:  strings.TrimSuffix(baseURL, "/") + "/rest/api",
+		space:    space,
+		parent:   parent,
+		username: username,
+		token:    token,
+		client:   httpClient,
+	}
+}
+
+// confluenceContent is the part of a page or attachment the client reads.
+type confluenceContent struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	Extensions struct {
+		FileSize int64 `json:"fileSize"`
+	} `json:"extensions"`
+}
+
+type confluenceResults struct {
+	Results []*confluenceContent `json:"results"`
+	Links   struct {
+		Next string `json:"next"`
+	} `json:"_links"`
+}
+
+func (c *confluenceContent) attachment() *TargetAttachment {
+	return &TargetAttachment{ID: c.ID, Name: c.Title, Size: c.Extensions.FileSize}
+}
+
+// do sends the request to the API path and decodes the JSON response into v
+// unless it is nil.
+func (c *confluence) do(ctx context.Context, method, path, contentType string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if c.username == "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else {
+		req.SetBasicAuth(c.username, c.token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	// Attachment uploads are refused without the header, which opts out of
+	// the XSRF check.
+	req.Header.Set("X-Atlassian-Token", "nocheck")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(resp.Body)
+		return Refused(resp, fmt.Errorf("%s %s: %s\n\n%s", method, path, resp.Status, string(message)))
+	}
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *confluence) FindPage(ctx context.Context, title string) (string, error) {
+	query := url.Values{}
+	query.Set("spaceKey", c.space)
+	query.Set("title", title)
+	query.Set("type", "page")
+	results := &confluenceResults{}
+	err := c.do(ctx, http.MethodGet, "/content?"+query.Encode(), "", nil, results)
+	if err != nil {
+		return "", err
+	}
+	if len(results.Results) == 0 {
+		return "", nil
+	}
+	return results.Results[0].ID, nil
+}
+
+func (c *confluence) CreatePage(ctx context.Context, title, body string) (string, error) {
+	page := map[string]interface{}{
+		"type":  "page",
+		"title": title,
+		"space": map[string]string{"key": c.space},
+		"body": map[string]interface{}{
+			"storage": map[string]string{
+				"value":          body,
+				"representation": "storage",
+			},
+		},
+	}
+	if c.parent != "" {
+		page["ancestors"] = []map[string]string{{"id": c.parent}}
+	}
+	encoded, err := json.Marshal(page)
+	if err != nil {
+		return "", err
+	}
+	created := &confluenceContent{}
+	err = c.do(ctx, http.MethodPost, "/content", "application/json", bytes.NewReader(encoded), created)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (c *confluence) ListAttachments(ctx context.Context, key string) ([]*TargetAttachment, error) {
+	var attachments []*TargetAttachment
+	for start := 0; ; {
+		results := &confluenceResults{}
+		err := c.do(ctx, http.MethodGet, fmt.Sprintf("/content/%s/child/attachment?start=%d&limit=100", url.PathEscape(key), start), "", nil, results)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results.Results {
+			attachments = append(attachments, result.attachment())
+		}
+		if results.Links.Next == "" || len(results.Results) == 0 {
+			return attachments, nil
+		}
+		start += len(results.Results)
+	}
+}
+
+// PostAttachment streams the file to the page as a multipart upload, so it is
+// never held in memory.
+func (c *confluence) PostAttachment(ctx context.Context, key string, r io.Reader, name string) (*TargetAttachment, error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	results := &confluenceResults{}
+	err := c.do(ctx, http.MethodPost, "/content/"+url.PathEscape(key)+"/child/attachment", form.FormDataContentType(), body, results)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(results.Results) == 0 {
+		return nil, fmt.Errorf("Confluence returned no attachment")
+	}
+	return results.Results[0].attachment(), nil
+}
+
+// DeleteAttachment moves the attachment to the space's trash.
+func (c *confluence) DeleteAttachment(ctx context.Context, key, id string) error {
+	return c.do(ctx, http.MethodDelete, "/content/"+url.PathEscape(id), "", nil, nil)
+}
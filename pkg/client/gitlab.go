@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitLab lists the issues and notes of a GitLab project and downloads the
+// files uploaded to it, for migrations from GitLab rather than GitHub.
+type GitLab interface {
+	// ListIssues lists a page of the project's issues filtered by the query
+	// parameters, returning the next page or zero after the last.
+	ListIssues(ctx context.Context, project string, params url.Values, page int) ([]*GitLabIssue, int, error)
+	// ListNotes lists a page of the comments on an issue.
+	ListNotes(ctx context.Context, project string, iid int, page int) ([]*GitLabNote, int, error)
+	// DownloadUpload returns the content of a file uploaded to the project,
+	// referenced in markdown as /uploads/<secret>/<filename>.
+	DownloadUpload(ctx context.Context, project, secret, filename string) (io.ReadCloser, error)
+}
+
+// GitLabIssue is the part of a GitLab issue the collector reads.
+type GitLabIssue struct {
+	IID         int       `json:"iid"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	WebURL      string    `json:"web_url"`
+	Labels      []string  `json:"labels"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GitLabNote is the part of a GitLab issue comment the collector reads.
+// System notes record events such as label changes rather than comments.
+type GitLabNote struct {
+	ID     int64  `json:"id"`
+	Body   string `json:"body"`
+	System bool   `json:"system"`
+}
+
+type gitLab struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewGitLab returns a client of the GitLab REST API at baseURL, such as
+// https://gitlab.com, authenticated with a personal access token.
+func NewGitLab(baseURL, token string) GitLab {
+	return &gitLab{
+		baseURL: strings.TrimSuffix(baseURL, "/") + "/api/v4",
+		token:   token,
+		client:  http.DefaultClient,
+	}
+}
+
+// get requests the API path and returns the response, which the caller must
+// close, and the page following it.
+func (g *gitLab) get(ctx context.Context, path string, params url.Values) (*http.Response, int, error) {
+	endpoint := g.baseURL + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("PRIVATE-TOKEN", g.token)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, Refused(resp, fmt.Errorf("GET %s: %s", path, resp.Status))
+	}
+	next, _ := strconv.Atoi(resp.Header.Get("X-Next-Page"))
+	return resp, next, nil
+}
+
+func (g *gitLab) getJSON(ctx context.Context, path string, params url.Values, v interface{}) (int, error) {
+	resp, next, err := g.get(ctx, path, params)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return 0, fmt.Errorf("failed decoding %s: %w", path, err)
+	}
+	return next, nil
+}
+
+func (g *gitLab) ListIssues(ctx context.Context, project string, params url.Values, page int) ([]*GitLabIssue, int, error) {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", "100")
+	var issues []*GitLabIssue
+	next, err := g.getJSON(ctx, "/projects/"+url.PathEscape(project)+"/issues", query, &issues)
+	return issues, next, err
+}
+
+func (g *gitLab) ListNotes(ctx context.Context, project string, iid int, page int) ([]*GitLabNote, int, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", "100")
+	var notes []*GitLabNote
+	next, err := g.getJSON(ctx, fmt.Sprintf("/projects/%s/issues/%d/notes", url.PathEscape(project), iid), query, &notes)
+	return notes, next, err
+}
+
+func (g *gitLab) DownloadUpload(ctx context.Context, project, secret, filename string) (io.ReadCloser, error) {
+	resp, _, err := g.get(ctx, fmt.Sprintf("/projects/%s/uploads/%s/%s", url.PathEscape(project), url.PathEscape(secret), url.PathEscape(filename)), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
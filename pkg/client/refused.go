@@ -0,0 +1,72 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/v47/github"
+)
+
+// RefusedError is the error of a request a service refused, either by
+// rejecting the credentials or their permissions, or by rate limiting the
+// client. Scripts driving a migration tell the two apart by the exit code.
+type RefusedError struct {
+	// RateLimited is set when the service rate limited the request rather
+	// than refusing its credentials.
+	RateLimited bool
+	err         error
+}
+
+func (e *RefusedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *RefusedError) Unwrap() error {
+	return e.err
+}
+
+// Refused marks the error of a request as refused when its response refused
+// the credentials or rate limited the request, and otherwise returns it
+// unchanged. GitHub answers an exhausted rate limit with 403 Forbidden, told
+// apart from refused permissions by its headers.
+func Refused(resp *http.Response, err error) error {
+	if err == nil || resp == nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return &RefusedError{RateLimited: true, err: err}
+	case http.StatusForbidden:
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != "" {
+			return &RefusedError{RateLimited: true, err: err}
+		}
+		return &RefusedError{err: err}
+	case http.StatusUnauthorized:
+		return &RefusedError{err: err}
+	}
+	return err
+}
+
+// JIRARefused marks the error of a go-jira request as Refused does.
+func JIRARefused(resp *jira.Response, err error) error {
+	if resp == nil {
+		return err
+	}
+	return Refused(resp.Response, err)
+}
+
+// GitHubRefused marks the error of a go-github request as Refused does,
+// trusting go-github to recognize the rate limits it reports, secondary rate
+// limits among them.
+func GitHubRefused(resp *github.Response, err error) error {
+	var limited *github.RateLimitError
+	var abused *github.AbuseRateLimitError
+	if errors.As(err, &limited) || errors.As(err, &abused) {
+		return &RefusedError{RateLimited: true, err: err}
+	}
+	if resp == nil {
+		return err
+	}
+	return Refused(resp.Response, err)
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// Target is the tracker attachments are uploaded to. Tickets are addressed
+// by the key recorded in the database, which is the ticket key for JIRA and
+// the work item ID for Azure DevOps.
+type Target interface {
+	// ListAttachments lists the files already attached to the ticket.
+	ListAttachments(ctx context.Context, key string) ([]*TargetAttachment, error)
+	// PostAttachment attaches the file to the ticket.
+	PostAttachment(ctx context.Context, key string, r io.Reader, name string) (*TargetAttachment, error)
+	// DeleteAttachment removes an attachment from the ticket.
+	DeleteAttachment(ctx context.Context, key, id string) error
+}
+
+// TargetAttachment is a file attached to a ticket of the target.
+type TargetAttachment struct {
+	ID   string
+	Name string
+	Size int64
+}
+
+type jiraTarget struct {
+	client JIRA
+}
+
+// NewJIRATarget uploads to JIRA tickets through the client.
+func NewJIRATarget(client JIRA) Target {
+	return &jiraTarget{client: client}
+}
+
+func (t *jiraTarget) ListAttachments(ctx context.Context, key string) ([]*TargetAttachment, error) {
+	issue, _, err := t.client.GetIssue(key, &jira.GetQueryOptions{Fields: "attachment"})
+	if err != nil {
+		return nil, err
+	}
+	var attachments []*TargetAttachment
+	if issue.Fields == nil {
+		return attachments, nil
+	}
+	for _, attachment := range issue.Fields.Attachments {
+		attachments = append(attachments, &TargetAttachment{ID: attachment.ID, Name: attachment.Filename, Size: int64(attachment.Size)})
+	}
+	return attachments, nil
+}
+
+func (t *jiraTarget) PostAttachment(ctx context.Context, key string, r io.Reader, name string) (*TargetAttachment, error) {
+	attachments, resp, err := t.client.PostAttachment(ctx, key, r, name)
+	if err != nil {
+		if resp == nil {
+			return nil, err
+		}
+		body, readErr := io.ReadAll(resp.Body)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed reading error body: %s\n%w", readErr, err)
+		}
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w\n\n%s", err, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	if attachments == nil || len(*attachments) == 0 {
+		return nil, fmt.Errorf("JIRA returned no attachment")
+	}
+	return &TargetAttachment{ID: (*attachments)[0].ID, Name: (*attachments)[0].Filename, Size: int64((*attachments)[0].Size)}, nil
+}
+
+func (t *jiraTarget) DeleteAttachment(ctx context.Context, key, id string) error {
+	_, err := t.client.DeleteAttachment(id)
+	return err
+}
@@ -0,0 +1,311 @@
+package collect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/export"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ArchiveChecksumsFile holds the checksum of every archive member, written
+// alongside the database when the archive is expanded so later runs can
+// compare against it.
+const ArchiveChecksumsFile = "archive_checksums.json"
+
+// ArchiveChecksums are the checksums, sizes, and positions of the archive
+// members keyed by their path in the archive.
+type ArchiveChecksums struct {
+	Algorithm string            `json:"algorithm"`
+	Members   map[string]string `json:"members"`
+	Sizes     map[string]int64  `json:"sizes,omitempty"`
+	Positions map[string]int    `json:"positions,omitempty"`
+}
+
+// Expand extracts what the migration needs from the GitHub repository
+// archives, each a gzipped tarball, plain tarball, or zip archive, into the
+// staging directory, recording the checksum and size of every member as it
+// is written. Large organization exports are split across several archives,
+// which are expanded into the same staging tree; a member found in more than
+// one of them is staged once if the copies are identical and fails the
+// expansion if they differ. Archives are mostly repository data the
+// migration never reads, so the archive is streamed twice: first for the
+// JSON metadata at its root, then for only the attachment files the metadata
+// references. Unless stage is set the attachment files are only hashed and
+// left in the archive, to be streamed out of it again on upload. Before the
+// attachment files are staged, checkSpace, unless nil, is given the bytes
+// they will add to the staging directory, from the sizes recorded in the
+// archive's index during the first pass, so a volume too small to hold them
+// fails up front rather than halfway through.
+func Expand(paths []string, algorithm string, stage bool, checkSpace func(required int64) error) error {
+	e := &expansion{
+		sums: &ArchiveChecksums{
+			Algorithm: algorithm,
+			Members:   make(map[string]string),
+			Sizes:     make(map[string]int64),
+			Positions: make(map[string]int),
+		},
+		origins: make(map[string]string),
+	}
+
+	index := make(map[string]int64)
+	for _, path := range paths {
+		err := extract(path, e, func(name string, size int64) bool {
+			index[name] = size
+			return isMetadata(name)
+		}, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	schema, err := detectSchema()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reading attachments metadata as archive schema %s\n", schema.name)
+	referenced := make(map[string]bool)
+	err = eachAttachmentRecord(schema, func(record attachmentRecord) error {
+		referenced[assetPath(record.AssetURL)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if stage && checkSpace != nil {
+		err = checkSpace(stagedGrowth(referenced, index))
+		if err != nil {
+			return err
+		}
+	}
+	if stage {
+		fmt.Printf("Extracting %d referenced attachments\n", len(referenced))
+	} else {
+		fmt.Printf("Hashing %d referenced attachments\n", len(referenced))
+	}
+	for _, path := range paths {
+		err = extract(path, e, func(name string, size int64) bool {
+			return referenced[name]
+		}, stage)
+		if err != nil {
+			return err
+		}
+	}
+	if e.duplicates > 0 {
+		fmt.Printf("Skipped %d members found identical in more than one archive\n", e.duplicates)
+	}
+
+	return saveArchiveChecksums(e.sums)
+}
+
+// expansion tracks the members extracted across the archives of an Expand.
+type expansion struct {
+	sums *ArchiveChecksums
+	// origins maps each member extracted to the archive it was extracted
+	// from.
+	origins    map[string]string
+	duplicates int
+}
+
+// checkDuplicate hashes a member already extracted from another archive and
+// fails unless it is identical to the copy extracted before.
+func (e *expansion) checkDuplicate(name, path string, member io.Reader) error {
+	hashed := checksum.NewReader(member, e.sums.Algorithm)
+	if _, err := io.Copy(io.Discard, hashed); err != nil {
+		return fmt.Errorf("failed reading member %s: %w", name, err)
+	}
+	if hashed.Sum() != e.sums.Members[name] || hashed.N != e.sums.Sizes[name] {
+		return fmt.Errorf("member %s of %s differs from the copy in %s; the archives are not parts of the same export", name, path, e.origins[name])
+	}
+	e.duplicates++
+	return nil
+}
+
+// stagedGrowth returns the bytes staging the referenced members adds to the
+// staging directory, less the size of any copies already staged by an earlier
+// run that staging overwrites.
+func stagedGrowth(referenced map[string]bool, index map[string]int64) int64 {
+	var growth int64
+	for name := range referenced {
+		size := index[name]
+		if info, err := os.Stat(filepath.Join(store.StageDir, filepath.FromSlash(name))); err == nil {
+			size -= info.Size()
+		}
+		if size > 0 {
+			growth += size
+		}
+	}
+	return growth
+}
+
+// isMetadata reports whether the archive member is a JSON metadata file at
+// the root of the archive.
+func isMetadata(name string) bool {
+	return !strings.Contains(name, "/") && strings.HasSuffix(name, ".json")
+}
+
+// extract streams the archive, recording the checksums and sizes of the
+// regular files whose cleaned member path and indexed size are wanted and,
+// when stage is set, writing them to the staging directory. A member already
+// extracted from another archive of the expansion is only hashed, and
+// compared with the copy extracted before.
+func extract(path string, e *expansion, wanted func(name string, size int64) bool, stage bool) error {
+	r, err := export.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := header.Name
+		if !wanted(name, header.Size) {
+			continue
+		}
+		if origin, ok := e.origins[name]; ok && origin != path {
+			err = e.checkDuplicate(name, path, r)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		e.origins[name] = path
+
+		member := checksum.NewReader(r, e.sums.Algorithm)
+		_, span := tracing.Start(context.Background(), "archive.member", attribute.String("path", name), attribute.Bool("staged", stage))
+		err = copyMember(name, member, header.Mode, stage)
+		span.SetAttributes(attribute.Int64("bytes", member.N))
+		tracing.End(span, err)
+		if err != nil {
+			return err
+		}
+		e.sums.Members[name] = member.Sum()
+		e.sums.Sizes[name] = member.N
+		e.sums.Positions[name] = len(e.origins)
+		if !stage {
+			continue
+		}
+		err = audit.Record(&audit.Entry{Action: audit.ActionExtracted, Path: name, Detail: member.Sum()})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyMember writes the archive member to the staging directory when stage
+// is set, and otherwise only reads it through so it is hashed. A member whose
+// name would place it outside the staging directory is refused.
+func copyMember(name string, member io.Reader, mode os.FileMode, stage bool) error {
+	if _, err := export.CleanName(name); err != nil {
+		return err
+	}
+	if !stage {
+		if _, err := io.Copy(io.Discard, member); err != nil {
+			return fmt.Errorf("failed reading member %s: %w", name, err)
+		}
+		return nil
+	}
+
+	target := filepath.Join(store.StageDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed creating directory %s: %w", filepath.Dir(target), err)
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed opening file %s: %w", target, err)
+	}
+	_, err = io.Copy(f, member)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy file %s: %w", target, err)
+	}
+	return nil
+}
+
+func saveArchiveChecksums(sums *ArchiveChecksums) error {
+	bytes, err := json.MarshalIndent(sums, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshalling archive checksums: %w", err)
+	}
+	err = os.WriteFile(store.StatePath(ArchiveChecksumsFile), bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing archive checksums: %w", err)
+	}
+	return nil
+}
+
+// RecordArchiveChecksums copies the archive member checksums, sizes, and
+// positions onto the attachments. Staging directories populated without
+// expanding an archive have no checksums file, and checksums computed with
+// another algorithm cannot be compared, so both are left without archive
+// checksums.
+func RecordArchiveChecksums(attachments []*store.Attachment, algorithm string) error {
+	bytes, err := os.ReadFile(store.StatePath(ArchiveChecksumsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading archive checksums: %w", err)
+	}
+	sums := &ArchiveChecksums{}
+	err = json.Unmarshal(bytes, sums)
+	if err != nil {
+		return fmt.Errorf("failed unmarshalling archive checksums: %w", err)
+	}
+	if sums.Algorithm != algorithm {
+		fmt.Printf("Archive checksums were computed with %s rather than %s, re-expand the archive to record them\n", sums.Algorithm, algorithm)
+		return nil
+	}
+	for _, attachment := range attachments {
+		name := filepath.ToSlash(filepath.Clean(attachment.Path))
+		attachment.ArchiveChecksum = sums.Members[name]
+		attachment.Size = sums.Sizes[name]
+		attachment.Position = sums.Positions[name]
+	}
+	return nil
+}
+
+// AdoptArchiveChecksums uses the archive member checksums as the attachment
+// checksums when the attachments are left in the archive, since the bytes
+// uploaded are the member's own.
+func AdoptArchiveChecksums(attachments []*store.Attachment) error {
+	for _, attachment := range attachments {
+		if attachment.ArchiveChecksum == "" {
+			return fmt.Errorf("no archive checksum recorded for %s, expand the archive again", attachment.Path)
+		}
+		attachment.Checksum = attachment.ArchiveChecksum
+	}
+	return nil
+}
+
+// HashAttachments records the checksum of every staged attachment.
+func HashAttachments(attachments []*store.Attachment, algorithm string) error {
+	for _, attachment := range attachments {
+		file, err := os.Open(filepath.Join(store.StageDir, attachment.Path))
+		if err != nil {
+			return fmt.Errorf("failed opening attachment: %w", err)
+		}
+		sum, err := checksum.Sum(file, algorithm)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed hashing %s: %w", attachment.Path, err)
+		}
+		attachment.Checksum = sum
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package collect
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// TestCopyMemberRefusesNamesOutsideStage stages members whose names escape
+// the staging directory and checks nothing is written outside it.
+func TestCopyMemberRefusesNamesOutsideStage(t *testing.T) {
+	useWorkspace(t, nil)
+
+	for _, name := range []string{"../escape.txt", "attachments/../../escape.txt", "/escape.txt"} {
+		err := copyMember(name, strings.NewReader("escaped"), 0644, true)
+		if err == nil {
+			t.Errorf("member %s was staged, want it refused", name)
+		}
+	}
+	escaped := filepath.Join(filepath.Dir(store.StageDir), "escape.txt")
+	if _, err := os.Stat(escaped); err == nil {
+		t.Errorf("%s was written outside the staging directory", escaped)
+	}
+}
@@ -0,0 +1,222 @@
+// Package collect builds the migration database from a GitHub repository
+// archive or the GitHub, GitLab, or Bitbucket API, and the JIRA tickets the
+// attachments belong to.
+package collect
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// attachmentRecord is an entry of the attachments metadata in the archive, in
+// the shape of the current version of the archive layout.
+type attachmentRecord struct {
+	Issue                    string `json:"issue"`
+	IssueComment             string `json:"issue_comment"`
+	PullRequest              string `json:"pull_request"`
+	PullRequestReviewComment string `json:"pull_request_review_comment"`
+	URL                      string `json:"url"`
+	AssetURL                 string `json:"asset_url"`
+}
+
+// eachAttachmentRecord calls fn with every entry of the attachments
+// metadata files staged from the archive in turn, parsed by the schema. The
+// files are decoded as a stream, since enterprise exports produce files of
+// hundreds of megabytes.
+func eachAttachmentRecord(schema *archiveSchema, fn func(attachmentRecord) error) error {
+	paths, err := attachmentFiles()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = decodeArray(path, func(raw json.RawMessage) error {
+			record, err := schema.parse(raw)
+			if err != nil {
+				return fmt.Errorf("error parsing attachment in %s as archive schema %s: %w", path, schema.name, err)
+			}
+			return fn(record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachmentFiles returns the paths of the attachments metadata files staged
+// from the archive.
+func attachmentFiles() ([]string, error) {
+	entries, err := os.ReadDir(store.StageDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "attachments") && strings.HasSuffix(entry.Name(), ".json") {
+			paths = append(paths, filepath.Join(store.StageDir, entry.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// decodeArray calls fn with each element of the JSON array in the file,
+// decoding one element at a time so memory use does not grow with the size
+// of the file.
+func decodeArray[T any](path string, fn func(T) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("error unmarshalling JSON from %s: %w", path, err)
+	}
+	if token == nil {
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("error unmarshalling JSON from %s: expected an array", path)
+	}
+	for decoder.More() {
+		var element T
+		err = decoder.Decode(&element)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling JSON from %s: %w", path, err)
+		}
+		err = fn(element)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	if err != nil {
+		return fmt.Errorf("error unmarshalling JSON from %s: %w", path, err)
+	}
+	return nil
+}
+
+// assetPath returns the path within the archive of the asset URL, such as
+// attachments/1/2/file.png for tarball://root/attachments/1/2/file.png. Asset
+// URLs already relative to the root of the archive are returned as they are.
+func assetPath(assetURL string) string {
+	if !strings.Contains(assetURL, "://") {
+		return strings.TrimPrefix(assetURL, "/")
+	}
+	pathTokens := strings.Split(assetURL, "/")
+	return strings.Join(pathTokens[3:], "/")
+}
+
+// ProcessAttachments adds an attachment to the database for every asset
+// listed in the attachments metadata of the expanded archive, whichever
+// supported version of the archive layout it was written with.
+func ProcessAttachments(db *store.Database) error {
+	schema, err := detectSchema()
+	if err != nil {
+		return err
+	}
+	return eachAttachmentRecord(schema, func(_attachment attachmentRecord) error {
+		if _attachment.Issue != "" {
+			issueTokens := strings.Split(_attachment.Issue, "/")
+			issueNumber, err := strconv.ParseInt(issueTokens[len(issueTokens)-1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("error parsing issue number from %s: %w", _attachment.Issue, err)
+			}
+			path := assetPath(_attachment.AssetURL)
+			entry := &store.Attachment{
+				AssetURL:    _attachment.URL,
+				IssueNumber: int(issueNumber),
+				Type:        "issue",
+				Path:        path,
+				URL:         _attachment.Issue,
+			}
+			db.Attachments = append(db.Attachments, entry)
+
+		} else if _attachment.IssueComment != "" {
+			issueTokens := strings.Split(_attachment.IssueComment, "/")
+			issueNumber, err := strconv.ParseInt(strings.Split(issueTokens[len(issueTokens)-1], "#")[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("error parsing issue number from %s: %w", _attachment.IssueComment, err)
+			}
+			commentTokens := strings.Split(_attachment.IssueComment, "#")
+			commentNumber, err := strconv.ParseInt(strings.Split(commentTokens[len(commentTokens)-1], "issuecomment-")[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("error parsing comment number from %s: %w", _attachment.IssueComment, err)
+			}
+			path := assetPath(_attachment.AssetURL)
+			entry := &store.Attachment{
+				AssetURL:      _attachment.URL,
+				CommentNumber: commentNumber,
+				IssueNumber:   int(issueNumber),
+				Type:          "issue_comment",
+				Path:          path,
+				URL:           _attachment.IssueComment,
+			}
+			db.Attachments = append(db.Attachments, entry)
+
+		} else if _attachment.PullRequest != "" {
+			pullNumber, _, err := parsePullRequestURL(_attachment.PullRequest)
+			if err != nil {
+				return fmt.Errorf("error parsing pull request number from %s: %w", _attachment.PullRequest, err)
+			}
+			path := assetPath(_attachment.AssetURL)
+			entry := &store.Attachment{
+				AssetURL:    _attachment.URL,
+				IssueNumber: pullNumber,
+				Type:        "pull_request",
+				Path:        path,
+				URL:         _attachment.PullRequest,
+			}
+			db.Attachments = append(db.Attachments, entry)
+
+		} else if _attachment.PullRequestReviewComment != "" {
+			pullNumber, commentNumber, err := parsePullRequestURL(_attachment.PullRequestReviewComment)
+			if err != nil {
+				return fmt.Errorf("error parsing review comment from %s: %w", _attachment.PullRequestReviewComment, err)
+			}
+			path := assetPath(_attachment.AssetURL)
+			entry := &store.Attachment{
+				AssetURL:      _attachment.URL,
+				CommentNumber: commentNumber,
+				IssueNumber:   pullNumber,
+				Type:          "pull_request_review_comment",
+				Path:          path,
+				URL:           _attachment.PullRequestReviewComment,
+			}
+			db.Attachments = append(db.Attachments, entry)
+		}
+		return nil
+	})
+}
+
+// parsePullRequestURL extracts the pull request number and, for review
+// comments, the comment ID from URLs such as
+// https://github.com/org/repo/pull/12 or https://github.com/org/repo/pull/12/files#r345.
+func parsePullRequestURL(pullURL string) (int, int64, error) {
+	tokens := strings.SplitN(pullURL, "/pull/", 2)
+	if len(tokens) != 2 {
+		return 0, 0, fmt.Errorf("not a pull request URL")
+	}
+	rest, fragment, _ := strings.Cut(tokens[1], "#")
+	pullNumber, err := strconv.ParseInt(strings.Split(rest, "/")[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fragment == "" {
+		return int(pullNumber), 0, nil
+	}
+	commentNumber, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimPrefix(fragment, "discussion_"), "r"), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(pullNumber), commentNumber, nil
+}
@@ -0,0 +1,165 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// bitbucketOpenStates are the Bitbucket issue states counted as open. The
+// rest, such as resolved and wontfix, are counted as closed.
+var bitbucketOpenStates = []string{"new", "open", "on hold"}
+
+// BitbucketSource collects the files attached to the issues of a Bitbucket
+// repository.
+type BitbucketSource struct {
+	Client    client.Bitbucket
+	Workspace string
+	Repo      string
+}
+
+func (s *BitbucketSource) Name() string {
+	return "Bitbucket"
+}
+
+// bitbucketFilter returns the Bitbucket query language filter for the query.
+// Bitbucket issues have no labels, so a label filter is refused.
+func (q *IssueQuery) bitbucketFilter() (string, error) {
+	if len(q.labels) > 0 {
+		return "", fmt.Errorf("bitbucket issues have no labels to filter on")
+	}
+	var clauses []string
+	if q.state != "all" {
+		var states []string
+		for _, state := range bitbucketOpenStates {
+			operator := "="
+			if q.state == "closed" {
+				operator = "!="
+			}
+			states = append(states, fmt.Sprintf("state %s %s", operator, strconv.Quote(state)))
+		}
+		joiner := " OR "
+		if q.state == "closed" {
+			joiner = " AND "
+		}
+		clauses = append(clauses, "("+strings.Join(states, joiner)+")")
+	}
+	if !q.since.IsZero() {
+		clauses = append(clauses, "updated_on >= "+q.since.Format(time.RFC3339))
+	}
+	if !q.until.IsZero() {
+		clauses = append(clauses, "updated_on <= "+q.until.Format(time.RFC3339))
+	}
+	return strings.Join(clauses, " AND "), nil
+}
+
+// issues calls fn with every issue of the repository matching the query.
+func (s *BitbucketSource) issues(query *IssueQuery, fn func(issue *client.BitbucketIssue) error) error {
+	filter, err := query.bitbucketFilter()
+	if err != nil {
+		return err
+	}
+	page := 1
+	for page != 0 {
+		issues, next, err := s.Client.ListIssues(context.Background(), s.Workspace, s.Repo, filter, page)
+		if err != nil {
+			return fmt.Errorf("failed listing issues for %s/%s: %w", s.Workspace, s.Repo, err)
+		}
+		output.Detailf("Processing Bitbucket issues page %d\n", page)
+		for _, issue := range issues {
+			err = fn(issue)
+			if err != nil {
+				return err
+			}
+		}
+		page = next
+	}
+	return nil
+}
+
+func (s *BitbucketSource) ListIssues(query *IssueQuery) ([]*store.IssueEntry, error) {
+	var entries []*store.IssueEntry
+	err := s.issues(query, func(issue *client.BitbucketIssue) error {
+		entries = append(entries, &store.IssueEntry{
+			Title:  issue.Title,
+			URL:    issue.Links.HTML.Href,
+			Number: issue.ID,
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// FetchAttachments downloads the files attached to each issue. Bitbucket
+// attaches files to the issue itself rather than its comments, so every
+// attachment is an issue attachment.
+func (s *BitbucketSource) FetchAttachments(filter *store.IssueFilter, query *IssueQuery, db *store.Database) error {
+	return s.issues(query, func(issue *client.BitbucketIssue) error {
+		if !filter.Allows(issue.ID) {
+			return nil
+		}
+		page := 1
+		for page != 0 {
+			attachments, next, err := s.Client.ListAttachments(context.Background(), s.Workspace, s.Repo, issue.ID, page)
+			if err != nil {
+				return fmt.Errorf("failed listing attachments of %s: %w", issue.Links.HTML.Href, err)
+			}
+			for _, attachment := range attachments {
+				path, err := s.download(issue.ID, attachment.Name)
+				if err != nil {
+					return fmt.Errorf("failed downloading %s from %s: %w", attachment.Name, issue.Links.HTML.Href, err)
+				}
+				db.Attachments = append(db.Attachments, &store.Attachment{
+					IssueNumber: issue.ID,
+					Type:        "issue",
+					Path:        path,
+					URL:         issue.Links.HTML.Href,
+				})
+			}
+			page = next
+		}
+		return nil
+	})
+}
+
+// download saves the attachment into the staging directory under its issue
+// and returns the staged path. Attachments that were already downloaded are
+// not fetched again.
+func (s *BitbucketSource) download(id int, name string) (string, error) {
+	path := fmt.Sprintf("attachments/bitbucket/%d/%s", id, filepath.Base(name))
+	target := filepath.Join(store.StageDir, filepath.FromSlash(path))
+	if _, err := os.Stat(target); err == nil {
+		return path, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed creating directory %s: %w", filepath.Dir(target), err)
+	}
+	content, err := s.Client.DownloadAttachment(context.Background(), s.Workspace, s.Repo, id, name)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	f, err := os.Create(target)
+	if err != nil {
+		return "", fmt.Errorf("failed creating file %s: %w", target, err)
+	}
+	_, err = io.Copy(f, content)
+	f.Close()
+	if err != nil {
+		os.Remove(target)
+		return "", fmt.Errorf("failed writing file %s: %w", target, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,202 @@
+package collect
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/fake"
+	"github.com/lindluni/attachment-processor/pkg/match"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// assetTransport answers requests for GitHub attachment URLs with the
+// content of the asset, standing in for GitHub's asset storage.
+type assetTransport map[string]string
+
+func (t assetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	content, ok := t[req.URL.String()]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(content)), Request: req}, nil
+}
+
+// useWorkspace points the database and staging directory at a temporary
+// directory for the test, and serves the assets in place of GitHub.
+func useWorkspace(t *testing.T, assets assetTransport) {
+	dir := t.TempDir()
+	databaseFile, stageDir, transport := store.DatabaseFile, store.StageDir, http.DefaultTransport
+	store.DatabaseFile = filepath.Join(dir, "database.json")
+	store.StageDir = filepath.Join(dir, "stage")
+	http.DefaultTransport = assets
+	t.Cleanup(func() {
+		store.DatabaseFile, store.StageDir, http.DefaultTransport = databaseFile, stageDir, transport
+	})
+}
+
+// TestFetchAndLink collects the attachments of a repository from a fake
+// GitHub, matches its issues to the tickets of a fake JIRA, and checks the
+// database written and the files staged.
+func TestFetchAndLink(t *testing.T) {
+	const (
+		screenshot = "https://user-images.githubusercontent.com/1/crash.png"
+		log        = "https://github.com/acme/widgets/files/7/save.log"
+	)
+	useWorkspace(t, assetTransport{
+		screenshot: "png bytes",
+		log:        "log lines",
+	})
+
+	github := fake.NewGitHub()
+	defer github.Close()
+	github.AddIssue("acme", "widgets", "Crash on save", "It crashes: ![crash]("+screenshot+")")
+	github.AddIssue("acme", "widgets", "Untracked request", "No ticket for this one: "+screenshot)
+	github.AddComment("acme", "widgets", 1, "Here is the log "+log)
+
+	jira := fake.NewJIRA()
+	defer jira.Close()
+	jira.AddTicket("PROJ-1", "Crash on save")
+	jira.AddTicket("PROJ-2", "Unrelated ticket")
+
+	filter, err := store.ParseIssueFilter("all", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := store.New(checksum.SHA256)
+	gh := client.NewGitHub(github.Client())
+	err = FetchAttachments(gh, "token", "acme", "widgets", filter, AllIssues(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = HashAttachments(db.Attachments, db.HashAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues, err := ListIssues(gh, "acme", "widgets", AllIssues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tickets, err := ListTickets(client.NewJIRA(jira.Client()), ProjectQuery("PROJ"), "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmatched := Link(db, issues, (&match.Config{Strategy: match.StrategyTitleExact}).Matcher(tickets))
+	err = store.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unmatched) != 1 || unmatched[0].Title != "Untracked request" {
+		t.Errorf("unmatched issues are %v, want Untracked request", unmatched)
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket := saved.Tickets["Crash on save"]; ticket == nil || ticket.Key != "PROJ-1" || ticket.Uploaded {
+		t.Errorf("ticket of Crash on save is %+v, want PROJ-1 not uploaded", ticket)
+	}
+	if issue := saved.Issues["Crash on save"]; issue == nil || issue.Number != 1 {
+		t.Errorf("issue Crash on save is %+v, want number 1", issue)
+	}
+
+	want := []struct {
+		issue   int
+		typ     string
+		path    string
+		content string
+	}{
+		{1, "issue", "attachments/user-images.githubusercontent.com/1/crash.png", "png bytes"},
+		{2, "issue", "attachments/user-images.githubusercontent.com/1/crash.png", "png bytes"},
+		{1, "issue_comment", "attachments/github.com/acme/widgets/files/7/save.log", "log lines"},
+	}
+	if len(saved.Attachments) != len(want) {
+		t.Fatalf("database has %d attachments, want %d", len(saved.Attachments), len(want))
+	}
+	for i, w := range want {
+		attachment := saved.Attachments[i]
+		if attachment.IssueNumber != w.issue || attachment.Type != w.typ || attachment.Path != w.path {
+			t.Errorf("attachment %d is issue %d %s %s, want issue %d %s %s", i, attachment.IssueNumber, attachment.Type, attachment.Path, w.issue, w.typ, w.path)
+		}
+		sum, err := checksum.Sum(strings.NewReader(w.content), checksum.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attachment.Checksum != sum {
+			t.Errorf("attachment %s has checksum %s, want %s", attachment.Path, attachment.Checksum, sum)
+		}
+		staged, err := os.ReadFile(filepath.Join(store.StageDir, filepath.FromSlash(attachment.Path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(staged) != w.content {
+			t.Errorf("staged %s holds %q, want %q", attachment.Path, staged, w.content)
+		}
+	}
+}
+
+// TestFetchAppliesQueryToComments fetches the attachments of the issues
+// carrying a label, and checks the assets of comments on other issues are
+// left out along with the issues.
+func TestFetchAppliesQueryToComments(t *testing.T) {
+	const (
+		labelled = "https://github.com/acme/widgets/files/7/crash.log"
+		other    = "https://github.com/acme/widgets/files/8/other.log"
+	)
+	useWorkspace(t, assetTransport{
+		labelled: "crash lines",
+		other:    "other lines",
+	})
+
+	github := fake.NewGitHub()
+	defer github.Close()
+	github.AddIssue("acme", "widgets", "Crash on save", "Crashes").Labels = []string{"bug"}
+	github.AddIssue("acme", "widgets", "Dark mode", "Please")
+	github.AddComment("acme", "widgets", 1, "Log: "+labelled)
+	github.AddComment("acme", "widgets", 2, "Log: "+other)
+
+	filter, err := store.ParseIssueFilter("all", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := ParseIssueQuery("bug", "all", "none", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := store.New(checksum.SHA256)
+	err = FetchAttachments(client.NewGitHub(github.Client()), "token", "acme", "widgets", filter, query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Attachments) != 1 || db.Attachments[0].IssueNumber != 1 {
+		t.Fatalf("fetched attachments %v, want the comment asset of issue 1 only", db.Attachments)
+	}
+}
+
+// TestDownloadRefusesPathsOutsideAttachments downloads assets whose URL
+// paths climb out of the attachments directory, and checks they are refused.
+func TestDownloadRefusesPathsOutsideAttachments(t *testing.T) {
+	escapes := []string{
+		"https://github.com/acme/widgets/files/7/../../../../../../escape.txt",
+		"https://github.com/acme/widgets/files/7/%2e%2e/%2e%2e/%2e%2e/%2e%2e/%2e%2e/%2e%2e/escape.txt",
+	}
+	assets := assetTransport{}
+	for _, escape := range escapes {
+		assets[escape] = "escaped"
+	}
+	useWorkspace(t, assets)
+
+	for _, escape := range escapes {
+		staged, err := download("token", escape)
+		if err == nil {
+			t.Errorf("asset %s was staged at %s, want it refused", escape, staged)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package collect
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// Post is the description or a comment of an issue or pull request, as
+// archived.
+type Post struct {
+	// URL is the URL of the post, which the attachments referenced from it
+	// record as theirs.
+	URL     string
+	Author  string
+	Created time.Time
+	Body    string
+	// Comment is set for comments and cleared for the description.
+	Comment bool
+}
+
+// postRecord is an entry of the issue, comment, and pull request metadata in
+// the archive. Comments name the issue or pull request they belong to.
+type postRecord struct {
+	URL         string    `json:"url"`
+	User        string    `json:"user"`
+	Body        string    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+	Issue       string    `json:"issue"`
+	PullRequest string    `json:"pull_request"`
+}
+
+// LoadPosts returns the archived posts of the issues and pull requests with
+// the given numbers, keyed by number and ordered from the description
+// through the comments by when they were written. Databases built without an
+// archive have no posts.
+func LoadPosts(numbers map[int]bool) (map[int][]*Post, error) {
+	entries, err := os.ReadDir(store.StageDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+
+	posts := make(map[int][]*Post)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") || !hasBodyPrefix(entry.Name()) {
+			continue
+		}
+		comments := strings.Contains(entry.Name(), "comments_")
+		err = decodeArray(filepath.Join(store.StageDir, entry.Name()), func(record postRecord) error {
+			parent := record.URL
+			if comments {
+				parent = record.Issue
+				if parent == "" {
+					parent = record.PullRequest
+				}
+			}
+			number, ok := postNumber(parent)
+			if !ok || !numbers[number] {
+				return nil
+			}
+			posts[number] = append(posts[number], &Post{
+				URL:     record.URL,
+				Author:  record.User[strings.LastIndex(record.User, "/")+1:],
+				Created: record.CreatedAt,
+				Body:    record.Body,
+				Comment: comments,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, list := range posts {
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].Comment != list[j].Comment {
+				return !list[i].Comment
+			}
+			return list[i].Created.Before(list[j].Created)
+		})
+	}
+	return posts, nil
+}
+
+// postNumber returns the issue or pull request number of a URL such as
+// https://github.com/org/repo/issues/12 or https://github.com/org/repo/pull/12.
+func postNumber(url string) (int, bool) {
+	for _, kind := range []string{"/issues/", "/pull/"} {
+		_, rest, found := strings.Cut(url, kind)
+		if !found {
+			continue
+		}
+		rest, _, _ = strings.Cut(rest, "#")
+		rest, _, _ = strings.Cut(rest, "/")
+		number, err := strconv.Atoi(rest)
+		return number, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,45 @@
+package collect
+
+import (
+	"fmt"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+func ValidDedupPolicy(policy string) error {
+	switch policy {
+	case store.DedupAll, store.DedupOnce, store.DedupSkipExisting:
+		return nil
+	}
+	return fmt.Errorf("invalid dedup policy %q, must be one of all, once, or skip-existing", policy)
+}
+
+// DedupAttachments records the dedup policy in the database and, for the once
+// policy, marks every attachment whose content matches an earlier attachment
+// as a duplicate of it so only the first copy is uploaded. Checksums must
+// already be computed.
+func DedupAttachments(db *store.Database, policy string) int {
+	db.DedupPolicy = policy
+	if policy != store.DedupOnce {
+		return 0
+	}
+
+	duplicates := 0
+	canonical := make(map[string]*store.Attachment)
+	for _, attachment := range db.Attachments {
+		attachment.DuplicateOf = ""
+		attachment.DuplicateOfIssue = 0
+		if attachment.Checksum == "" {
+			continue
+		}
+		first, ok := canonical[attachment.Checksum]
+		if !ok {
+			canonical[attachment.Checksum] = attachment
+			continue
+		}
+		attachment.DuplicateOf = first.Path
+		attachment.DuplicateOfIssue = first.IssueNumber
+		duplicates++
+	}
+	return duplicates
+}
@@ -0,0 +1,189 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// assetPattern matches the attachment URLs GitHub generates when files are
+// dropped into an issue or comment body.
+var assetPattern = regexp.MustCompile(`https://(user-images\.githubusercontent\.com/|github\.com/[^/\s]+/[^/\s]+/files/\d+/)[^\s"'()<>\[\]]+`)
+
+// FetchAttachments downloads the assets referenced in the bodies of the
+// issues and issue comments passing the filter and query, for repositories
+// without an archive, and adds an attachment to the database for each.
+// Comments are listed for the whole repository, so only those on an issue
+// passing the filter and query are kept.
+func FetchAttachments(client client.GitHub, token, org, repo string, filter *store.IssueFilter, query *IssueQuery, db *store.Database) error {
+	matched := make(map[int]bool)
+	opts := query.options()
+	for {
+		issues, resp, err := client.ListIssues(context.Background(), org, repo, opts)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				return fmt.Errorf("repository %s/%s not found", org, repo)
+			}
+			return fmt.Errorf("failed listing issues for %s/%s: %w", org, repo, err)
+		}
+		output.Detailf("Scanning GitHub issues page %d of %d\n", opts.ListOptions.Page, resp.LastPage)
+		for _, _issue := range issues {
+			if !filter.Allows(_issue.GetNumber()) || !query.matches(_issue) {
+				continue
+			}
+			matched[_issue.GetNumber()] = true
+			for _, assetURL := range findAssets(_issue.GetBody()) {
+				path, err := download(token, assetURL)
+				if err != nil {
+					return fmt.Errorf("failed downloading %s from %s: %w", assetURL, _issue.GetHTMLURL(), err)
+				}
+				entry := &store.Attachment{
+					IssueNumber: _issue.GetNumber(),
+					Type:        "issue",
+					Path:        path,
+					URL:         _issue.GetHTMLURL(),
+				}
+				db.Attachments = append(db.Attachments, entry)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.ListOptions.Page = resp.NextPage
+		if !fromCache(resp) {
+			time.Sleep(1 * time.Second)
+		}
+	}
+
+	commentOpts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{
+			Page:    1,
+			PerPage: 100,
+		},
+	}
+	for {
+		comments, resp, err := client.ListIssueComments(context.Background(), org, repo, 0, commentOpts)
+		if err != nil {
+			return fmt.Errorf("failed listing issue comments for %s/%s: %w", org, repo, err)
+		}
+		output.Detailf("Scanning GitHub issue comments page %d of %d\n", commentOpts.ListOptions.Page, resp.LastPage)
+		for _, comment := range comments {
+			assets := findAssets(comment.GetBody())
+			if len(assets) == 0 {
+				continue
+			}
+			issueTokens := strings.Split(comment.GetIssueURL(), "/")
+			issueNumber, err := strconv.ParseInt(issueTokens[len(issueTokens)-1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("error parsing issue number from %s: %w", comment.GetIssueURL(), err)
+			}
+			if !matched[int(issueNumber)] {
+				continue
+			}
+			for _, assetURL := range assets {
+				path, err := download(token, assetURL)
+				if err != nil {
+					return fmt.Errorf("failed downloading %s from %s: %w", assetURL, comment.GetHTMLURL(), err)
+				}
+				entry := &store.Attachment{
+					CommentNumber: comment.GetID(),
+					IssueNumber:   int(issueNumber),
+					Type:          "issue_comment",
+					Path:          path,
+					URL:           comment.GetHTMLURL(),
+				}
+				db.Attachments = append(db.Attachments, entry)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		commentOpts.ListOptions.Page = resp.NextPage
+		if !fromCache(resp) {
+			time.Sleep(1 * time.Second)
+		}
+	}
+
+	return nil
+}
+
+// findAssets returns the unique attachment URLs referenced in body.
+func findAssets(body string) []string {
+	var assets []string
+	seen := make(map[string]bool)
+	for _, match := range assetPattern.FindAllString(body, -1) {
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		assets = append(assets, match)
+	}
+	return assets
+}
+
+// download saves the asset into the staging directory, mirroring its host and
+// path, and returns the staged path in the same form as archive attachments.
+// Assets that were already downloaded are not fetched again, and assets whose
+// path would place them outside the attachments directory are refused.
+func download(token, assetURL string) (string, error) {
+	u, err := url.Parse(assetURL)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing URL: %w", err)
+	}
+	staged := path.Clean("attachments/" + u.Host + u.Path)
+	if !strings.HasPrefix(staged, "attachments/") {
+		return "", fmt.Errorf("asset path %s lies outside the attachments directory", u.Path)
+	}
+	target := filepath.Join(store.StageDir, filepath.FromSlash(staged))
+	if _, err := os.Stat(target); err == nil {
+		return staged, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed creating directory %s: %w", filepath.Dir(target), err)
+	}
+
+	// The token is set on the request rather than the transport so it is not
+	// forwarded when GitHub redirects to the storage backend.
+	req, err := http.NewRequest(http.MethodGet, assetURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "token "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed requesting asset: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", client.Refused(resp, fmt.Errorf("unexpected response: %s", resp.Status))
+	}
+
+	f, err := os.Create(target)
+	if err != nil {
+		return "", fmt.Errorf("failed creating file %s: %w", target, err)
+	}
+	_, err = io.Copy(f, resp.Body)
+	f.Close()
+	if err != nil {
+		os.Remove(target)
+		return "", fmt.Errorf("failed writing file %s: %w", target, err)
+	}
+
+	return staged, nil
+}
@@ -0,0 +1,196 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// uploadPattern matches the markdown links GitLab generates when files are
+// dropped into an issue or comment, capturing the upload secret and file
+// name.
+var uploadPattern = regexp.MustCompile(`/uploads/([0-9a-f]{32})/([^\s"'()<>\[\]]+)`)
+
+// GitLabSource collects the files uploaded to the issues of a GitLab
+// project, given by its full path such as group/subgroup/project.
+type GitLabSource struct {
+	Client  client.GitLab
+	Project string
+}
+
+func (s *GitLabSource) Name() string {
+	return "GitLab"
+}
+
+// params returns the GitLab issue list parameters for the query. GitLab
+// filters on every field server side.
+func (q *IssueQuery) params() url.Values {
+	params := url.Values{}
+	params.Set("scope", "all")
+	params.Set("order_by", "created_at")
+	params.Set("sort", "asc")
+	switch q.state {
+	case "open":
+		params.Set("state", "opened")
+	case "closed":
+		params.Set("state", "closed")
+	}
+	if len(q.labels) > 0 {
+		params.Set("labels", strings.Join(q.labels, ","))
+	}
+	if !q.since.IsZero() {
+		params.Set("updated_after", q.since.Format(time.RFC3339))
+	}
+	if !q.until.IsZero() {
+		params.Set("updated_before", q.until.Format(time.RFC3339))
+	}
+	return params
+}
+
+// issues calls fn with every issue of the project matching the query.
+func (s *GitLabSource) issues(query *IssueQuery, fn func(issue *client.GitLabIssue) error) error {
+	page := 1
+	for page != 0 {
+		issues, next, err := s.Client.ListIssues(context.Background(), s.Project, query.params(), page)
+		if err != nil {
+			return fmt.Errorf("failed listing issues for %s: %w", s.Project, err)
+		}
+		output.Detailf("Processing GitLab issues page %d\n", page)
+		for _, issue := range issues {
+			err = fn(issue)
+			if err != nil {
+				return err
+			}
+		}
+		page = next
+	}
+	return nil
+}
+
+func (s *GitLabSource) ListIssues(query *IssueQuery) ([]*store.IssueEntry, error) {
+	var entries []*store.IssueEntry
+	err := s.issues(query, func(issue *client.GitLabIssue) error {
+		entries = append(entries, &store.IssueEntry{
+			Title:  issue.Title,
+			URL:    issue.WebURL,
+			Number: issue.IID,
+			Labels: issue.Labels,
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// FetchAttachments downloads the files referenced in the description and
+// comments of each issue. System notes are skipped as they never carry
+// uploads.
+func (s *GitLabSource) FetchAttachments(filter *store.IssueFilter, query *IssueQuery, db *store.Database) error {
+	return s.issues(query, func(issue *client.GitLabIssue) error {
+		if !filter.Allows(issue.IID) {
+			return nil
+		}
+		for _, upload := range findUploads(issue.Description) {
+			path, err := s.download(upload[0], upload[1])
+			if err != nil {
+				return fmt.Errorf("failed downloading %s from %s: %w", upload[1], issue.WebURL, err)
+			}
+			db.Attachments = append(db.Attachments, &store.Attachment{
+				IssueNumber: issue.IID,
+				Type:        "issue",
+				Path:        path,
+				URL:         issue.WebURL,
+			})
+		}
+
+		page := 1
+		for page != 0 {
+			notes, next, err := s.Client.ListNotes(context.Background(), s.Project, issue.IID, page)
+			if err != nil {
+				return fmt.Errorf("failed listing comments on %s: %w", issue.WebURL, err)
+			}
+			for _, note := range notes {
+				if note.System {
+					continue
+				}
+				noteURL := fmt.Sprintf("%s#note_%d", issue.WebURL, note.ID)
+				for _, upload := range findUploads(note.Body) {
+					path, err := s.download(upload[0], upload[1])
+					if err != nil {
+						return fmt.Errorf("failed downloading %s from %s: %w", upload[1], noteURL, err)
+					}
+					db.Attachments = append(db.Attachments, &store.Attachment{
+						CommentNumber: note.ID,
+						IssueNumber:   issue.IID,
+						Type:          "issue_comment",
+						Path:          path,
+						URL:           noteURL,
+					})
+				}
+			}
+			page = next
+		}
+		return nil
+	})
+}
+
+// findUploads returns the secret and file name of each unique upload
+// referenced in body.
+func findUploads(body string) [][2]string {
+	var uploads [][2]string
+	seen := make(map[string]bool)
+	for _, match := range uploadPattern.FindAllStringSubmatch(body, -1) {
+		if seen[match[0]] {
+			continue
+		}
+		seen[match[0]] = true
+		name, err := url.PathUnescape(match[2])
+		if err != nil {
+			name = match[2]
+		}
+		uploads = append(uploads, [2]string{match[1], name})
+	}
+	return uploads
+}
+
+// download saves the upload into the staging directory under its secret and
+// returns the staged path. Uploads that were already downloaded are not
+// fetched again.
+func (s *GitLabSource) download(secret, filename string) (string, error) {
+	path := "attachments/gitlab/" + secret + "/" + filepath.Base(filename)
+	target := filepath.Join(store.StageDir, filepath.FromSlash(path))
+	if _, err := os.Stat(target); err == nil {
+		return path, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed creating directory %s: %w", filepath.Dir(target), err)
+	}
+	content, err := s.Client.DownloadUpload(context.Background(), s.Project, secret, filename)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	f, err := os.Create(target)
+	if err != nil {
+		return "", fmt.Errorf("failed creating file %s: %w", target, err)
+	}
+	_, err = io.Copy(f, content)
+	f.Close()
+	if err != nil {
+		os.Remove(target)
+		return "", fmt.Errorf("failed writing file %s: %w", target, err)
+	}
+	return path, nil
+}
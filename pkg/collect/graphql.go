@@ -0,0 +1,195 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	GitHubAPIGraphQL = "graphql"
+	GitHubAPIREST    = "rest"
+)
+
+// ValidGitHubAPI returns an error for an API issues cannot be listed through.
+func ValidGitHubAPI(api string) error {
+	switch api {
+	case GitHubAPIGraphQL, GitHubAPIREST:
+		return nil
+	}
+	return fmt.Errorf("invalid GitHub API %q, must be graphql or rest", api)
+}
+
+const issuesQuery = `query($org: String!, $repo: String!, $cursor: String, $filter: IssueFilters) {
+  repository(owner: $org, name: $repo) {
+    issues(first: 100, after: $cursor, filterBy: $filter) {
+      totalCount
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        number
+        title
+        url
+        updatedAt
+        labels(first: 100) { nodes { name } }
+      }
+    }
+  }
+}`
+
+// Pull requests cannot be filtered by date, so they are listed most recently
+// updated first and the listing stops at the first one older than since.
+const pullRequestsQuery = `query($org: String!, $repo: String!, $cursor: String, $states: [PullRequestState!], $labels: [String!]) {
+  repository(owner: $org, name: $repo) {
+    pullRequests(first: 100, after: $cursor, states: $states, labels: $labels, orderBy: {field: UPDATED_AT, direction: DESC}) {
+      totalCount
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        number
+        title
+        url
+        updatedAt
+        labels(first: 100) { nodes { name } }
+      }
+    }
+  }
+}`
+
+type graphQLIssue struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Labels    struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+}
+
+type graphQLConnection struct {
+	TotalCount int `json:"totalCount"`
+	PageInfo   struct {
+		HasNextPage bool   `json:"hasNextPage"`
+		EndCursor   string `json:"endCursor"`
+	} `json:"pageInfo"`
+	Nodes []*graphQLIssue `json:"nodes"`
+}
+
+// ListIssuesGraphQL lists the issues and pull requests matching the query
+// through the GraphQL API, which returns just the fields the database needs
+// a hundred at a time without the pause between pages the REST listing
+// makes, so large repositories are listed many times faster.
+func ListIssuesGraphQL(client client.GitHub, org, repo string, query *IssueQuery) ([]*store.IssueEntry, error) {
+	filter := map[string]interface{}{}
+	var prStates []string
+	switch query.state {
+	case "open":
+		filter["states"] = []string{"OPEN"}
+		prStates = []string{"OPEN"}
+	case "closed":
+		filter["states"] = []string{"CLOSED"}
+		prStates = []string{"CLOSED", "MERGED"}
+	}
+	if len(query.labels) > 0 {
+		filter["labels"] = query.labels
+	}
+	if !query.since.IsZero() {
+		filter["since"] = query.since.Format(time.RFC3339)
+	}
+	variables := map[string]interface{}{
+		"org":    org,
+		"repo":   repo,
+		"filter": filter,
+	}
+	issues, err := listGraphQL(client, org, repo, "issues", issuesQuery, variables, query)
+	if err != nil {
+		return nil, err
+	}
+
+	variables = map[string]interface{}{
+		"org":  org,
+		"repo": repo,
+	}
+	if prStates != nil {
+		variables["states"] = prStates
+	}
+	if len(query.labels) > 0 {
+		variables["labels"] = query.labels
+	}
+	pulls, err := listGraphQL(client, org, repo, "pullRequests", pullRequestsQuery, variables, query)
+	if err != nil {
+		return nil, err
+	}
+	return append(issues, pulls...), nil
+}
+
+// listGraphQL pages through the named issue connection of the repository,
+// keeping the entries the query matches.
+func listGraphQL(client client.GitHub, org, repo, connection, graphQL string, variables map[string]interface{}, query *IssueQuery) ([]*store.IssueEntry, error) {
+	kind := "issues"
+	if connection == "pullRequests" {
+		kind = "pull requests"
+	}
+	var entries []*store.IssueEntry
+	listed := 0
+	for {
+		var result struct {
+			Repository map[string]*graphQLConnection `json:"repository"`
+		}
+		ctx, span := tracing.Start(context.Background(), "github.graphql.page", attribute.String("connection", connection), attribute.Int("listed", listed))
+		err := client.Query(ctx, graphQL, variables, &result)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed listing %s for %s/%s: %w", kind, org, repo, err)
+		}
+		if result.Repository == nil {
+			return nil, fmt.Errorf("repository %s/%s not found", org, repo)
+		}
+		page := result.Repository[connection]
+		listed += len(page.Nodes)
+		output.Detailf("Processing GitHub %s %d of %d\n", kind, listed, page.TotalCount)
+		for _, node := range page.Nodes {
+			if connection == "pullRequests" && !query.since.IsZero() && node.UpdatedAt.Before(query.since) {
+				return entries, nil
+			}
+			if !query.updatedInRange(node.UpdatedAt) || !query.hasLabels(node) {
+				continue
+			}
+			entry := &store.IssueEntry{
+				Title:  node.Title,
+				URL:    node.URL,
+				Number: node.Number,
+			}
+			for _, label := range node.Labels.Nodes {
+				entry.Labels = append(entry.Labels, label.Name)
+			}
+			entries = append(entries, entry)
+		}
+		if !page.PageInfo.HasNextPage {
+			return entries, nil
+		}
+		variables["cursor"] = page.PageInfo.EndCursor
+	}
+}
+
+// hasLabels reports whether the issue has every label of the query, as the
+// REST API requires, since GraphQL matches issues with any of them.
+func (q *IssueQuery) hasLabels(issue *graphQLIssue) bool {
+	names := make(map[string]bool)
+	for _, label := range issue.Labels.Nodes {
+		names[strings.ToLower(label.Name)] = true
+	}
+	for _, label := range q.labels {
+		if !names[strings.ToLower(label)] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package collect
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// bodyPrefixes are the archive metadata files holding the current bodies of
+// the records attachments are referenced from.
+var bodyPrefixes = []string{
+	"issues_",
+	"issue_comments_",
+	"pull_requests_",
+	"pull_request_review_comments_",
+}
+
+// MarkEditOrphans flags attachments whose asset URL no longer appears in the
+// current body of the issue, comment, or pull request they belong to. The
+// archive keeps every asset ever uploaded, so these are files that were only
+// referenced by an earlier edit. Attachments whose parent body is not in the
+// archive are left unflagged.
+func MarkEditOrphans(db *store.Database) ([]*store.Attachment, error) {
+	bodies, err := loadBodies()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []*store.Attachment
+	for _, attachment := range db.Attachments {
+		body, ok := bodies[attachment.URL]
+		if !ok || attachment.AssetURL == "" {
+			continue
+		}
+		attachment.Orphaned = !strings.Contains(body, attachment.AssetURL)
+		if attachment.Orphaned {
+			orphans = append(orphans, attachment)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Path < orphans[j].Path
+	})
+
+	return orphans, nil
+}
+
+// bodyRecord is an entry of the issue, comment, and pull request metadata in
+// the archive.
+type bodyRecord struct {
+	URL  string `json:"url"`
+	Body string `json:"body"`
+}
+
+// loadBodies returns the current body of every archived record keyed by its
+// URL.
+func loadBodies() (map[string]string, error) {
+	entries, err := os.ReadDir(store.StageDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+
+	bodies := make(map[string]string)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") || !hasBodyPrefix(entry.Name()) {
+			continue
+		}
+		err = decodeArray(filepath.Join(store.StageDir, entry.Name()), func(record bodyRecord) error {
+			bodies[record.URL] = record.Body
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bodies, nil
+}
+
+func hasBodyPrefix(name string) bool {
+	for _, prefix := range bodyPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
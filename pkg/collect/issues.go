@@ -0,0 +1,143 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// IssueQuery holds the filters applied when listing GitHub issues.
+type IssueQuery struct {
+	labels []string
+	state  string
+	since  time.Time
+	until  time.Time
+}
+
+// ParseIssueQuery builds an issue query from the collect flags. Labels of
+// "all" and dates of "none" leave the corresponding filter unset.
+func ParseIssueQuery(labels, state, since, until string) (*IssueQuery, error) {
+	query := &IssueQuery{
+		state: state,
+	}
+	switch state {
+	case "all", "open", "closed":
+	default:
+		return nil, fmt.Errorf("invalid issue state %q, must be one of all, open, or closed", state)
+	}
+	if labels != "all" {
+		for _, label := range strings.Split(labels, ",") {
+			label = strings.TrimSpace(label)
+			if label != "" {
+				query.labels = append(query.labels, label)
+			}
+		}
+	}
+	var err error
+	if since != "none" {
+		query.since, err = parseDate(since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since date %q: %w", since, err)
+		}
+	}
+	if until != "none" {
+		query.until, err = parseDate(until)
+		if err != nil {
+			return nil, fmt.Errorf("invalid until date %q: %w", until, err)
+		}
+	}
+	return query, nil
+}
+
+// AllIssues is a query matching every issue in the repository.
+func AllIssues() *IssueQuery {
+	return &IssueQuery{state: "all"}
+}
+
+// parseDate accepts either an RFC 3339 timestamp or a plain YYYY-MM-DD date.
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// options returns the list options for the first page of matching issues.
+func (q *IssueQuery) options() *github.IssueListByRepoOptions {
+	return &github.IssueListByRepoOptions{
+		State:  q.state,
+		Labels: q.labels,
+		Since:  q.since,
+		ListOptions: github.ListOptions{
+			Page:    1,
+			PerPage: 100,
+		},
+	}
+}
+
+// matches applies the filters the GitHub API cannot evaluate server side.
+func (q *IssueQuery) matches(i *github.Issue) bool {
+	return q.updatedInRange(i.GetUpdatedAt())
+}
+
+// updatedInRange reports whether an issue updated at updated is no later
+// than the until date.
+func (q *IssueQuery) updatedInRange(updated time.Time) bool {
+	return q.until.IsZero() || !updated.After(q.until)
+}
+
+// ListIssues lists the issues and pull requests matching the query through
+// the REST API.
+func ListIssues(client client.GitHub, org, repo string, query *IssueQuery) ([]*store.IssueEntry, error) {
+	var entries []*store.IssueEntry
+	opts := query.options()
+	for {
+		ctx, span := tracing.Start(context.Background(), "github.issues.page", attribute.Int("page", opts.ListOptions.Page))
+		issues, resp, err := client.ListIssues(ctx, org, repo, opts)
+		tracing.End(span, err)
+		if err != nil {
+			if resp.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("repository %s/%s not found", org, repo)
+			}
+			return nil, fmt.Errorf("failed listing issues for %s/%s: %w", org, repo, err)
+		}
+		output.Detailf("Processing GitHub issues page %d of %d\n", opts.ListOptions.Page, resp.LastPage)
+		for _, _issue := range issues {
+			if !query.matches(_issue) {
+				continue
+			}
+			entry := &store.IssueEntry{
+				Title:  _issue.GetTitle(),
+				URL:    _issue.GetHTMLURL(),
+				Number: _issue.GetNumber(),
+			}
+			for _, label := range _issue.Labels {
+				entry.Labels = append(entry.Labels, label.GetName())
+			}
+			entries = append(entries, entry)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.ListOptions.Page = resp.NextPage
+		if !fromCache(resp) {
+			time.Sleep(1 * time.Second)
+		}
+	}
+	return entries, nil
+}
+
+// fromCache reports whether a page was served from the GitHub response cache,
+// so there is no need to pause before requesting the next one.
+func fromCache(resp *github.Response) bool {
+	return client.FromCache(resp.Response)
+}
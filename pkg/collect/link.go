@@ -0,0 +1,44 @@
+package collect
+
+import (
+	"github.com/lindluni/attachment-processor/pkg/match"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// Link adds the issues to the database keyed by title, along with the ticket
+// the matcher pairs each issue with, and returns the issues matching no
+// ticket. An ambiguous match uses the last ticket listed.
+func Link(db *store.Database, issues []*store.IssueEntry, matcher match.Matcher) []*store.IssueEntry {
+	var unmatched []*store.IssueEntry
+	for _, _issue := range issues {
+		db.Issues[_issue.Title] = &store.Issue{
+			URL:    _issue.URL,
+			Number: _issue.Number,
+			Labels: _issue.Labels,
+		}
+		tickets := matcher.Match(_issue)
+		if len(tickets) == 0 {
+			unmatched = append(unmatched, _issue)
+			continue
+		}
+		db.Tickets[_issue.Title] = &store.Ticket{
+			Key:      tickets[len(tickets)-1].Key,
+			Uploaded: false,
+		}
+	}
+	return unmatched
+}
+
+// LinkPages adds the issues to the database keyed by title, each with a
+// ticket of its own for the Confluence target. The tickets have no key until
+// upload creates the page for the issue.
+func LinkPages(db *store.Database, issues []*store.IssueEntry) {
+	for _, _issue := range issues {
+		db.Issues[_issue.Title] = &store.Issue{
+			URL:    _issue.URL,
+			Number: _issue.Number,
+			Labels: _issue.Labels,
+		}
+		db.Tickets[_issue.Title] = &store.Ticket{}
+	}
+}
@@ -0,0 +1,237 @@
+package collect
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// schemaFile is the archive member recording the version of the archive
+// layout, such as {"version": "1.0.1"}. Archives exported before it was
+// introduced have none.
+const schemaFile = "schema.json"
+
+// archiveSchema parses the attachments metadata written by one version of
+// the migration archive layout into the current record shape. A version is
+// recognized by the fields of its records: all of required, and at least one
+// of subjects, which name the issue, comment, or pull request the attachment
+// belongs to.
+type archiveSchema struct {
+	name     string
+	major    string
+	required []string
+	subjects []string
+	parse    func(raw json.RawMessage) (attachmentRecord, error)
+}
+
+// archiveSchemas are the supported versions of the archive layout, newest
+// first.
+var archiveSchemas = []*archiveSchema{
+	{
+		name:     "1.x",
+		major:    "1",
+		required: []string{"url", "asset_url"},
+		subjects: []string{"issue", "issue_comment", "pull_request", "pull_request_review_comment"},
+		parse: func(raw json.RawMessage) (attachmentRecord, error) {
+			var record attachmentRecord
+			err := json.Unmarshal(raw, &record)
+			return record, err
+		},
+	},
+	{
+		// GitHub Enterprise Server 2.x exports name the record an attachment
+		// belongs to by its type and URL.
+		name:     "0.x",
+		major:    "0",
+		required: []string{"url", "asset_url", "attachable_type"},
+		subjects: []string{"attachable_url"},
+		parse:    parseLegacyAttachment,
+	},
+}
+
+// ignoredFields are the fields of the attachments metadata no version is
+// recognized by, as the migration does not read them.
+var ignoredFields = map[string]bool{
+	"type":               true,
+	"user":               true,
+	"created_at":         true,
+	"asset_name":         true,
+	"asset_content_type": true,
+	"repository":         true,
+}
+
+// legacyAttachmentRecord is an entry of the attachments metadata of 0.x
+// archives.
+type legacyAttachmentRecord struct {
+	URL            string `json:"url"`
+	AssetURL       string `json:"asset_url"`
+	AttachableType string `json:"attachable_type"`
+	AttachableURL  string `json:"attachable_url"`
+}
+
+func parseLegacyAttachment(raw json.RawMessage) (attachmentRecord, error) {
+	var legacy legacyAttachmentRecord
+	err := json.Unmarshal(raw, &legacy)
+	if err != nil {
+		return attachmentRecord{}, err
+	}
+	record := attachmentRecord{URL: legacy.URL, AssetURL: legacy.AssetURL}
+	switch legacy.AttachableType {
+	case "Issue":
+		record.Issue = legacy.AttachableURL
+	case "IssueComment":
+		record.IssueComment = legacy.AttachableURL
+	case "PullRequest":
+		record.PullRequest = legacy.AttachableURL
+	case "PullRequestReviewComment":
+		record.PullRequestReviewComment = legacy.AttachableURL
+	default:
+		return attachmentRecord{}, fmt.Errorf("unsupported attachable_type %q of %s", legacy.AttachableType, legacy.URL)
+	}
+	return record, nil
+}
+
+// recognizes reports whether a record with the fields was written by the
+// version.
+func (s *archiveSchema) recognizes(fields map[string]bool) bool {
+	for _, field := range s.required {
+		if !fields[field] {
+			return false
+		}
+	}
+	for _, field := range s.subjects {
+		if fields[field] {
+			return true
+		}
+	}
+	return false
+}
+
+// detectSchema returns the version of the archive layout the staged
+// attachments metadata was written with, from the fields of its first record
+// and, when several versions recognize them, the version in schema.json.
+// Archives without attachments are read as the current version.
+func detectSchema() (*archiveSchema, error) {
+	version, err := readSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	path, fields, err := firstAttachmentFields()
+	if err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return archiveSchemas[0], nil
+	}
+
+	var matched []*archiveSchema
+	for _, schema := range archiveSchemas {
+		if schema.recognizes(fields) {
+			matched = append(matched, schema)
+		}
+	}
+	for _, schema := range matched {
+		if strings.SplitN(version, ".", 2)[0] == schema.major {
+			return schema, nil
+		}
+	}
+	if len(matched) > 0 {
+		return matched[0], nil
+	}
+	return nil, unrecognizedSchema(path, version, fields)
+}
+
+// unrecognizedSchema describes attachments metadata no supported version
+// recognizes, listing the fields none of them know of or, when there are
+// none, every field of its records.
+func unrecognizedSchema(path, version string, fields map[string]bool) error {
+	known := make(map[string]bool)
+	var supported []string
+	for _, schema := range archiveSchemas {
+		for _, field := range append(append([]string{}, schema.required...), schema.subjects...) {
+			known[field] = true
+		}
+		subjects := schema.subjects[0]
+		if len(schema.subjects) > 1 {
+			subjects = "one of " + strings.Join(schema.subjects, ", ")
+		}
+		supported = append(supported, fmt.Sprintf("%s with %s and %s", schema.name, strings.Join(schema.required, ", "), subjects))
+	}
+	var unrecognized []string
+	for field := range fields {
+		if !known[field] && !ignoredFields[field] {
+			unrecognized = append(unrecognized, field)
+		}
+	}
+	sort.Strings(unrecognized)
+
+	described := "the archive"
+	if version != "" {
+		described = fmt.Sprintf("the archive, schema version %s,", version)
+	}
+	detail := "unrecognized fields: " + strings.Join(unrecognized, ", ")
+	if len(unrecognized) == 0 {
+		var present []string
+		for field := range fields {
+			present = append(present, field)
+		}
+		sort.Strings(present)
+		detail = "fields present: " + strings.Join(present, ", ")
+	}
+	return fmt.Errorf("the attachments metadata %s of %s matches no supported archive schema; %s; supported schemas are %s", filepath.Base(path), described, detail, strings.Join(supported, "; "))
+}
+
+// readSchemaVersion returns the version recorded in the staged schema.json,
+// or an empty version when the archive has none.
+func readSchemaVersion() (string, error) {
+	bytes, err := os.ReadFile(filepath.Join(store.StageDir, schemaFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading file %s: %w", schemaFile, err)
+	}
+	var schema struct {
+		Version string `json:"version"`
+	}
+	err = json.Unmarshal(bytes, &schema)
+	if err != nil {
+		return "", fmt.Errorf("error unmarshalling JSON from %s: %w", schemaFile, err)
+	}
+	return schema.Version, nil
+}
+
+// errFound stops decodeArray once the record sought is found.
+var errFound = fmt.Errorf("found")
+
+// firstAttachmentFields returns the fields of the first record of the staged
+// attachments metadata and the file holding it, or nil fields when there are
+// no records.
+func firstAttachmentFields() (string, map[string]bool, error) {
+	paths, err := attachmentFiles()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, path := range paths {
+		var fields map[string]bool
+		err = decodeArray(path, func(record map[string]json.RawMessage) error {
+			fields = make(map[string]bool)
+			for field := range record {
+				fields[field] = true
+			}
+			return errFound
+		})
+		if err == errFound {
+			return path, fields, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return "", nil, nil
+}
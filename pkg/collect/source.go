@@ -0,0 +1,54 @@
+package collect
+
+import (
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+const (
+	SourceGitHub    = "github"
+	SourceGitLab    = "gitlab"
+	SourceBitbucket = "bitbucket"
+)
+
+// Source is an issue tracker attachments are migrated from. Every source
+// fills the same database, so the attachments are matched and uploaded the
+// same way whatever they were collected from.
+type Source interface {
+	// Name is the name of the issue tracker shown in progress messages.
+	Name() string
+	// FetchAttachments downloads the attachments of the issues passing the
+	// filter and query into the staging directory and adds them to the
+	// database.
+	FetchAttachments(filter *store.IssueFilter, query *IssueQuery, db *store.Database) error
+	// ListIssues lists the issues matching the query.
+	ListIssues(query *IssueQuery) ([]*store.IssueEntry, error)
+}
+
+// GitHubSource collects the attachments of a GitHub repository.
+type GitHubSource struct {
+	Client client.GitHub
+	// Token authenticates the attachment downloads, which are not made
+	// through the client.
+	Token string
+	Org   string
+	Repo  string
+	// API is the API issues are listed through, GitHubAPIGraphQL or
+	// GitHubAPIREST.
+	API string
+}
+
+func (s *GitHubSource) Name() string {
+	return "GitHub"
+}
+
+func (s *GitHubSource) FetchAttachments(filter *store.IssueFilter, query *IssueQuery, db *store.Database) error {
+	return FetchAttachments(s.Client, s.Token, s.Org, s.Repo, filter, query, db)
+}
+
+func (s *GitHubSource) ListIssues(query *IssueQuery) ([]*store.IssueEntry, error) {
+	if s.API == GitHubAPIREST {
+		return ListIssues(s.Client, s.Org, s.Repo, query)
+	}
+	return ListIssuesGraphQL(s.Client, s.Org, s.Repo, query)
+}
@@ -0,0 +1,352 @@
+package collect
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// TicketSource selects how JIRA tickets are discovered. Tickets are found
+// with a JQL search of the projects unless a keys file or key range is
+// given, for instances that deny search to automation accounts.
+type TicketSource struct {
+	projects string
+	keys     []string
+	workers  int
+}
+
+// NewTicketSource builds the ticket source from the collect flags. A keys
+// file of "none" and a key range of "none" leave the JQL search in place,
+// run by up to workers searches at a time.
+func NewTicketSource(jiraKeys, keysFile, keyRange string, workers int) (*TicketSource, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid number of JIRA search workers %d, must be at least 1", workers)
+	}
+	source := &TicketSource{projects: jiraKeys, workers: workers}
+	if keysFile != "none" && keyRange != "none" {
+		return nil, fmt.Errorf("--ticket-keys-file and --ticket-key-range cannot be used together")
+	}
+	var err error
+	if keysFile != "none" {
+		source.keys, err = readTicketKeys(keysFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if keyRange != "none" {
+		source.keys, err = parseTicketKeyRange(keyRange)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return source, nil
+}
+
+// readTicketKeys reads one ticket key per line, ignoring blank lines and
+// lines starting with #.
+func readTicketKeys(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening ticket keys file: %w", err)
+	}
+	defer file.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading ticket keys file: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("ticket keys file %s lists no keys", path)
+	}
+	return keys, nil
+}
+
+// parseTicketKeyRange expands a range such as "PROJ-1..PROJ-5000" into every
+// key between the two, inclusive.
+func parseTicketKeyRange(keyRange string) ([]string, error) {
+	fromKey, toKey, ok := strings.Cut(strings.ReplaceAll(keyRange, " ", ""), "..")
+	if !ok {
+		return nil, fmt.Errorf("invalid ticket key range %q, must look like PROJ-1..PROJ-5000", keyRange)
+	}
+	project, from, err := splitTicketKey(fromKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticket key range %q: %w", keyRange, err)
+	}
+	toProject, to, err := splitTicketKey(toKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticket key range %q: %w", keyRange, err)
+	}
+	if project != toProject {
+		return nil, fmt.Errorf("invalid ticket key range %q: both keys must be in the same project", keyRange)
+	}
+	if to < from {
+		return nil, fmt.Errorf("invalid ticket key range %q: range is reversed", keyRange)
+	}
+
+	keys := make([]string, 0, to-from+1)
+	for number := from; number <= to; number++ {
+		keys = append(keys, fmt.Sprintf("%s-%d", project, number))
+	}
+	return keys, nil
+}
+
+func splitTicketKey(key string) (string, int, error) {
+	index := strings.LastIndex(key, "-")
+	if index <= 0 {
+		return "", 0, fmt.Errorf("%s is not a ticket key", key)
+	}
+	number, err := strconv.Atoi(key[index+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("%s is not a ticket key", key)
+	}
+	return key[:index], number, nil
+}
+
+// List returns the tickets of the source, reading the custom field alongside
+// the summary unless it is empty. Keys that do not exist or are not visible
+// are skipped, since key ranges routinely include deleted or moved tickets.
+func (s *TicketSource) List(client client.JIRA, field string) ([]*store.TicketEntry, error) {
+	if len(s.keys) == 0 {
+		return ListTickets(client, ProjectQuery(s.projects), field, s.workers)
+	}
+
+	fields := "summary"
+	if field != "" {
+		fields += "," + field
+	}
+	var entries []*store.TicketEntry
+	missing := 0
+	for i, key := range s.keys {
+		if i%100 == 0 {
+			output.Detailf("Processing JIRA tickets %d of %d\n", i, len(s.keys))
+		}
+		issue, resp, err := client.GetIssue(key, &jira.GetQueryOptions{Fields: fields})
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				missing++
+				continue
+			}
+			return nil, fmt.Errorf("failed retrieving ticket %s: %w", key, err)
+		}
+		entries = append(entries, &store.TicketEntry{
+			Key:     issue.Key,
+			Summary: issue.Fields.Summary,
+			Field:   fieldValue(issue.Fields, field),
+		})
+	}
+	if missing > 0 {
+		fmt.Printf("Skipped %d ticket keys that do not exist or are not visible\n", missing)
+	}
+	return entries, nil
+}
+
+// ListTickets returns the tickets of the projects named by a ProjectQuery
+// clause, reading only the summary and, unless it is empty, the custom field
+// rather than the full tickets. Projects with more than ticketPageSize
+// tickets are split into ranges of creation dates searched by up to workers searches
+// at a time.
+func ListTickets(client client.JIRA, key, field string, workers int) ([]*store.TicketEntry, error) {
+	fields := []string{"summary"}
+	if field != "" {
+		fields = append(fields, field)
+	}
+	ranges, total, err := createdRanges(client, key, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &ticketProgress{total: total}
+	results := make([][]*store.TicketEntry, len(ranges))
+	var g errgroup.Group
+	g.SetLimit(workers)
+	for i, clause := range ranges {
+		i, jql := i, fmt.Sprintf("(%s)%s ORDER BY key", key, clause)
+		g.Go(func() error {
+			entries, err := searchTickets(client, jql, fields, field, progress)
+			if err != nil {
+				return fmt.Errorf("failed searching for tickets in %s: %w", key, err)
+			}
+			results[i] = entries
+			return nil
+		})
+	}
+	err = g.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*store.TicketEntry
+	for _, result := range results {
+		entries = append(entries, result...)
+	}
+	return entries, nil
+}
+
+// ticketPageSize is the number of tickets requested per search page, which
+// JIRA may lower.
+const ticketPageSize = 1000
+
+// createdRanges returns the JQL clauses splitting the tickets of the
+// projects into up to workers ranges of creation dates, and the number of
+// tickets. The first range has no lower bound and the last no upper bound,
+// so tickets created while the ranges are searched are still found. Up to
+// ticketPageSize tickets are searched as one range.
+func createdRanges(client client.JIRA, key string, workers int) ([]string, int, error) {
+	first, total, err := createdBound(client, key, "ASC")
+	if err != nil {
+		return nil, 0, err
+	}
+	if workers <= 1 || total <= ticketPageSize {
+		return []string{""}, total, nil
+	}
+	last, _, err := createdBound(client, key, "DESC")
+	if err != nil {
+		return nil, 0, err
+	}
+	step := last.Sub(first) / time.Duration(workers)
+	if step < time.Minute {
+		return []string{""}, total, nil
+	}
+
+	// JQL compares dates to the minute.
+	const jqlDate = "2006/01/02 15:04"
+	var ranges []string
+	lower := ""
+	for i := 1; i < workers; i++ {
+		bound := first.Add(step * time.Duration(i)).Format(jqlDate)
+		ranges = append(ranges, fmt.Sprintf("%s AND created < \"%s\"", lower, bound))
+		lower = fmt.Sprintf(" AND created >= \"%s\"", bound)
+	}
+	ranges = append(ranges, lower)
+	return ranges, total, nil
+}
+
+// createdBound returns the creation date of the first ticket of the projects
+// in the order, ASC for the oldest or DESC for the newest, and the number of
+// tickets.
+func createdBound(client client.JIRA, key, order string) (time.Time, int, error) {
+	opts := &jira.SearchOptions{MaxResults: 1, Fields: []string{"created"}}
+	issues, resp, err := client.SearchIssues(fmt.Sprintf("(%s) ORDER BY created %s", key, order), opts)
+	if err != nil {
+		return time.Time{}, 0, searchError(key, resp, err)
+	}
+	if len(issues) == 0 || issues[0].Fields == nil {
+		return time.Time{}, resp.Total, nil
+	}
+	return time.Time(issues[0].Fields.Created), resp.Total, nil
+}
+
+// ticketProgress reports the tickets listed across concurrent searches.
+type ticketProgress struct {
+	mu     sync.Mutex
+	listed int
+	total  int
+}
+
+func (p *ticketProgress) add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listed += n
+	output.Detailf("Processing JIRA tickets %d of %d\n", p.listed, p.total)
+}
+
+// searchTickets pages through the tickets found by the JQL search.
+func searchTickets(client client.JIRA, jql string, fields []string, field string, progress *ticketProgress) ([]*store.TicketEntry, error) {
+	var entries []*store.TicketEntry
+	opts := &jira.SearchOptions{
+		StartAt:    0,
+		MaxResults: ticketPageSize,
+		Fields:     fields,
+	}
+	for {
+		_, span := tracing.Start(context.Background(), "jira.search.page", attribute.String("jql", jql), attribute.Int("start_at", opts.StartAt))
+		issues, resp, err := client.SearchIssues(jql, opts)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, responseError(resp, err)
+		}
+		for _, _issue := range issues {
+			entry := &store.TicketEntry{
+				Key:     _issue.Key,
+				Summary: _issue.Fields.Summary,
+				Field:   fieldValue(_issue.Fields, field),
+			}
+			entries = append(entries, entry)
+		}
+		progress.add(len(issues))
+		if len(issues) == 0 || resp.StartAt+resp.MaxResults >= resp.Total {
+			break
+		}
+		opts.StartAt = resp.StartAt + resp.MaxResults
+		time.Sleep(1 * time.Second)
+	}
+	return entries, nil
+}
+
+func searchError(key string, resp *jira.Response, err error) error {
+	return fmt.Errorf("failed searching for tickets in %s: %w", key, responseError(resp, err))
+}
+
+// responseError adds the body of a failed JIRA response, which explains
+// errors such as invalid JQL, to the error.
+func responseError(resp *jira.Response, err error) error {
+	if resp == nil || resp.Body == nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		return fmt.Errorf("%w (failed reading body: %s)", err, readErr)
+	}
+	return fmt.Errorf("%w\n\n%s", err, string(body))
+}
+
+// ProjectQuery turns a comma separated list of JIRA project keys into the
+// project clause of a JQL search, such as project=A OR project=B.
+func ProjectQuery(jiraKeys string) string {
+	scrubbedKeys := strings.ReplaceAll(jiraKeys, " ", "")
+	keyTokens := strings.Split(scrubbedKeys, ",")
+	return "project=" + strings.Join(keyTokens, " OR project=")
+}
+
+// fieldValue returns the custom field as text. Number fields are formatted
+// without a fraction and option fields yield their selected value.
+func fieldValue(fields *jira.IssueFields, field string) string {
+	if field == "" || fields == nil {
+		return ""
+	}
+	switch value := fields.Unknowns[field].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case map[string]interface{}:
+		if option, ok := value["value"].(string); ok {
+			return option
+		}
+	}
+	return ""
+}
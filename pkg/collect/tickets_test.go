@@ -0,0 +1,60 @@
+package collect
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/fake"
+)
+
+// TestProjectQuery checks every project key of the list gets its own
+// project clause.
+func TestProjectQuery(t *testing.T) {
+	for keys, want := range map[string]string{
+		"PROJ":         "project=PROJ",
+		"PROJ, OPS":    "project=PROJ OR project=OPS",
+		"PROJ,OPS,WEB": "project=PROJ OR project=OPS OR project=WEB",
+	} {
+		if got := ProjectQuery(keys); got != want {
+			t.Errorf("ProjectQuery(%q) is %q, want %q", keys, got, want)
+		}
+	}
+}
+
+// TestListTicketsAcrossRanges lists the tickets of two projects large enough
+// to be split into ranges of creation dates searched in parallel, and checks
+// every ticket is listed once.
+func TestListTicketsAcrossRanges(t *testing.T) {
+	jira := fake.NewJIRA()
+	defer jira.Close()
+	want := make(map[string]string)
+	for i := 1; i <= ticketPageSize/2+1; i++ {
+		for _, project := range []string{"PROJ", "OPS"} {
+			key := fmt.Sprintf("%s-%d", project, i)
+			want[key] = "Ticket " + key
+			jira.AddTicket(key, want[key])
+		}
+	}
+	jira.AddTicket("OTHER-1", "Not listed")
+
+	for _, workers := range []int{1, 4} {
+		entries, err := ListTickets(client.NewJIRA(jira.Client()), ProjectQuery("PROJ,OPS"), "", workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed := make(map[string]bool)
+		for _, entry := range entries {
+			if listed[entry.Key] {
+				t.Errorf("%d workers listed %s twice", workers, entry.Key)
+			}
+			listed[entry.Key] = true
+			if want[entry.Key] != entry.Summary {
+				t.Errorf("%d workers listed %s with summary %q, want %q", workers, entry.Key, entry.Summary, want[entry.Key])
+			}
+		}
+		if len(listed) != len(want) {
+			t.Errorf("%d workers listed %d tickets, want %d", workers, len(listed), len(want))
+		}
+	}
+}
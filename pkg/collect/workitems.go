@@ -0,0 +1,28 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// ListWorkItems returns the work items of the Azure DevOps project as
+// tickets keyed by work item ID, with their title as the summary, so issues
+// are matched to them as they would be to JIRA tickets.
+func ListWorkItems(client client.AzureDevOps) ([]*store.TicketEntry, error) {
+	items, err := client.ListWorkItems(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed listing work items: %w", err)
+	}
+	var entries []*store.TicketEntry
+	for _, item := range items {
+		entries = append(entries, &store.TicketEntry{
+			Key:     strconv.Itoa(item.ID),
+			Summary: item.Title,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,203 @@
+// Package download fetches migration archives published at HTTPS URLs into a
+// local cache, resuming interrupted downloads where they stopped.
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/client"
+)
+
+// CacheDir is where downloaded archives are kept, in the working directory
+// unless another directory is configured.
+var CacheDir = "downloads"
+
+// IsURL reports whether path is an HTTPS URL rather than a local path.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "https://")
+}
+
+// state is kept beside a partial download so it is only resumed against the
+// same version of the file.
+type state struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Complete     bool   `json:"complete"`
+}
+
+// Cached downloads the file at rawURL into the cache and returns its local
+// path. A complete download already in the cache is reused, and a partial one
+// is resumed with a range request when the server supports it and the file
+// has not changed. When sum is not empty, it is the SHA-256 checksum the
+// download must match, and a cached file that does not match is downloaded
+// again.
+func Cached(client *http.Client, rawURL, sum string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive URL %s: %w", rawURL, err)
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "archive"
+	}
+	err = os.MkdirAll(CacheDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed creating download directory: %w", err)
+	}
+	target := filepath.Join(CacheDir, name)
+	statePath := target + ".state.json"
+
+	current := loadState(statePath)
+	if current == nil || current.URL != rawURL {
+		current = &state{URL: rawURL}
+		os.Remove(target)
+	}
+	if current.Complete {
+		err = validate(target, sum)
+		if err == nil {
+			fmt.Printf("Using cached download %s\n", target)
+			return target, nil
+		}
+		fmt.Printf("Downloading %s again: %s\n", rawURL, err)
+		current = &state{URL: rawURL}
+		os.Remove(target)
+	}
+
+	err = fetch(client, target, current, statePath)
+	if err != nil {
+		return "", err
+	}
+	err = validate(target, sum)
+	if err != nil {
+		os.Remove(target)
+		os.Remove(statePath)
+		return "", err
+	}
+	return target, nil
+}
+
+// fetch downloads the rest of the file into target, appending to what is
+// already there when the server honors the range request.
+func fetch(httpClient *http.Client, target string, current *state, statePath string) error {
+	var offset int64
+	if info, err := os.Stat(target); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, current.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		switch {
+		case current.ETag != "":
+			req.Header.Set("If-Range", current.ETag)
+		case current.LastModified != "":
+			req.Header.Set("If-Range", current.LastModified)
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed downloading %s: %w", current.URL, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		fmt.Printf("Resuming download of %s at byte %d\n", current.URL, offset)
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		if offset > 0 {
+			fmt.Printf("Server did not resume %s, downloading from the start\n", current.URL)
+		}
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file already holds every byte.
+		current.Complete = true
+		return saveState(statePath, current)
+	default:
+		return client.Refused(resp, fmt.Errorf("failed downloading %s: %s", current.URL, resp.Status))
+	}
+	current.ETag = resp.Header.Get("ETag")
+	current.LastModified = resp.Header.Get("Last-Modified")
+	err = saveState(statePath, current)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed opening download %s: %w", target, err)
+	}
+	n, err := io.Copy(file, resp.Body)
+	closeErr := file.Close()
+	if err != nil {
+		return fmt.Errorf("download of %s interrupted after %d bytes, run again to resume: %w", current.URL, offset+n, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed writing download %s: %w", target, closeErr)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("download of %s ended after %d of %d bytes, run again to resume", current.URL, n, resp.ContentLength)
+	}
+
+	current.Complete = true
+	return saveState(statePath, current)
+}
+
+// validate checks the file matches the SHA-256 checksum, when one is given.
+func validate(target, sum string) error {
+	if sum == "" {
+		return nil
+	}
+	file, err := os.Open(target)
+	if err != nil {
+		return fmt.Errorf("failed opening download %s: %w", target, err)
+	}
+	defer file.Close()
+	actual, err := checksum.Sum(file, checksum.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed hashing download %s: %w", target, err)
+	}
+	if !strings.EqualFold(actual, sum) {
+		return fmt.Errorf("download %s has checksum %s, expected %s", target, actual, sum)
+	}
+	return nil
+}
+
+func loadState(path string) *state {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	s := &state{}
+	if json.Unmarshal(bytes, s) != nil {
+		return nil
+	}
+	return s
+}
+
+func saveState(path string, s *state) error {
+	bytes, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed marshalling download state: %w", err)
+	}
+	err = os.WriteFile(path, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing download state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package downscale shrinks PNG and JPEG images to fit under a size limit by
+// re-encoding them and, when that is not enough, reducing their dimensions.
+package downscale
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+)
+
+// jpegQualities are tried in turn on a JPEG before its dimensions are
+// reduced.
+var jpegQualities = []int{85, 70}
+
+// scaleStep is the factor each dimension is reduced by at each attempt.
+const scaleStep = 0.75
+
+// minDimension is the smallest width or height an image is reduced to
+// before giving up, below which a screenshot is no longer legible.
+const minDimension = 64
+
+// IsSupported reports whether images of the MIME type can be downscaled.
+func IsSupported(contentType string) bool {
+	return contentType == "image/png" || contentType == "image/jpeg"
+}
+
+// Fit writes to dst a copy of the PNG or JPEG image at src no larger than
+// maxBytes, in the same format, and returns its size and dimensions. JPEGs
+// are first re-encoded at a lower quality; then both formats are scaled down
+// by a quarter at a time until they fit.
+func Fit(src, dst string, maxBytes int64) (*Result, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding image: %w", err)
+	}
+	if format != "png" && format != "jpeg" {
+		return nil, fmt.Errorf("cannot downscale %s images", format)
+	}
+
+	original := img.Bounds()
+	quality := jpegQualities[len(jpegQualities)-1]
+	var encoded []byte
+	for _, q := range jpegQualities {
+		if format != "jpeg" {
+			break
+		}
+		encoded, err = encode(img, format, q)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(encoded)) <= maxBytes {
+			return write(dst, encoded, original, img.Bounds())
+		}
+	}
+	for scale := 1.0; ; scale *= scaleStep {
+		width := int(float64(original.Dx()) * scale)
+		height := int(float64(original.Dy()) * scale)
+		if width < minDimension || height < minDimension {
+			return nil, fmt.Errorf("cannot fit a %dx%d image into %d bytes", original.Dx(), original.Dy(), maxBytes)
+		}
+		scaled := img
+		if scale < 1 {
+			scaled = resize(img, width, height)
+		}
+		encoded, err = encode(scaled, format, quality)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(encoded)) <= maxBytes {
+			return write(dst, encoded, original, scaled.Bounds())
+		}
+	}
+}
+
+// Result describes the image written by Fit.
+type Result struct {
+	Bytes  int64
+	Width  int
+	Height int
+	// Scaled is set when the dimensions were reduced, not just the encoding.
+	Scaled bool
+}
+
+func encode(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	} else {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func write(dst string, encoded []byte, original, bounds image.Rectangle) (*Result, error) {
+	err := os.WriteFile(dst, encoded, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed writing image: %w", err)
+	}
+	return &Result{
+		Bytes:  int64(len(encoded)),
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Scaled: bounds.Dx() != original.Dx() || bounds.Dy() != original.Dy(),
+	}, nil
+}
+
+// resize scales the image to width by height, averaging the source pixels
+// covered by each destination pixel so text in screenshots stays legible.
+func resize(img image.Image, width, height int) image.Image {
+	src := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := src.Min.Y + (y+1)*src.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := src.Min.X + (x+1)*src.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,222 @@
+// Package export reads GitHub migration exports in the formats they arrive
+// in: gzipped tarballs, plain tarballs, and ZIP archives, held locally or in
+// object storage.
+package export
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/objectstore"
+)
+
+const (
+	FormatTarGzip = "tar.gz"
+	FormatTar     = "tar"
+	FormatZip     = "zip"
+)
+
+// Detect returns the format of the export at path from its magic bytes,
+// regardless of its file extension.
+func Detect(path string) (string, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 262)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("error reading archive %s: %w", path, err)
+	}
+	return detect(path, header[:n])
+}
+
+func detect(path string, header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatTarGzip, nil
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")):
+		return FormatTar, nil
+	}
+	return "", fmt.Errorf("archive %s is not a gzipped tarball, tarball, or zip archive", path)
+}
+
+// openFile opens the export at path, which may be an object storage URL.
+func openFile(path string) (io.ReadCloser, error) {
+	if objectstore.IsURL(path) {
+		return objectstore.Open(context.Background(), path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Member is a regular file in an export.
+type Member struct {
+	// Name is the cleaned, slash separated path of the file in the export.
+	Name string
+	Mode os.FileMode
+	// Size is the uncompressed size of the file recorded in the export's
+	// index, known before the file is read.
+	Size int64
+}
+
+// Reader streams the regular files of an export in the order they are
+// stored. Reads return the content of the member last returned by Next.
+type Reader struct {
+	Format string
+
+	path    string
+	file    io.ReadCloser
+	gzr     *gzip.Reader
+	tr      *tar.Reader
+	zr      *zip.ReadCloser
+	next    int
+	current io.ReadCloser
+}
+
+// Open opens the export at path, detecting its format. Exports in object
+// storage are streamed rather than downloaded, which ZIP archives do not
+// allow since their index is at the end.
+func Open(path string) (*Reader, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(file, 512)
+	header, err := br.Peek(262)
+	if err != nil && err != io.EOF {
+		file.Close()
+		return nil, fmt.Errorf("error reading archive %s: %w", path, err)
+	}
+	format, err := detect(path, header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r := &Reader{Format: format, path: path}
+
+	if format == FormatZip {
+		file.Close()
+		if objectstore.IsURL(path) {
+			return nil, fmt.Errorf("zip archive %s cannot be streamed from object storage, download it first", path)
+		}
+		r.zr, err = zip.OpenReader(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive %s: %w", path, err)
+		}
+		return r, nil
+	}
+
+	r.file = file
+	if format == FormatTar {
+		r.tr = tar.NewReader(br)
+		return r, nil
+	}
+	r.gzr, err = gzip.NewReader(br)
+	if err != nil {
+		r.file.Close()
+		return nil, fmt.Errorf("error reading archive %s: %w", path, err)
+	}
+	r.tr = tar.NewReader(r.gzr)
+	return r, nil
+}
+
+// Next advances to the next regular file, returning io.EOF after the last.
+func (r *Reader) Next() (*Member, error) {
+	if r.zr != nil {
+		return r.nextZip()
+	}
+	for {
+		header, err := r.tr.Next()
+		switch {
+		case err == io.EOF:
+			return nil, io.EOF
+		case err != nil:
+			return nil, fmt.Errorf("error reading archive %s: %w", r.path, err)
+		case header == nil || header.Typeflag != tar.TypeReg:
+			continue
+		}
+		name, err := CleanName(header.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive %s: %w", r.path, err)
+		}
+		return &Member{Name: name, Mode: os.FileMode(header.Mode), Size: header.Size}, nil
+	}
+}
+
+func (r *Reader) nextZip() (*Member, error) {
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+	for r.next < len(r.zr.File) {
+		file := r.zr.File[r.next]
+		r.next++
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		name, err := CleanName(file.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive %s: %w", r.path, err)
+		}
+		content, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error reading member %s of archive %s: %w", file.Name, r.path, err)
+		}
+		r.current = content
+		return &Member{Name: name, Mode: file.Mode(), Size: int64(file.UncompressedSize64)}, nil
+	}
+	return nil, io.EOF
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.zr != nil {
+		if r.current == nil {
+			return 0, io.EOF
+		}
+		return r.current.Read(p)
+	}
+	return r.tr.Read(p)
+}
+
+// Close closes the export.
+func (r *Reader) Close() error {
+	if r.zr != nil {
+		if r.current != nil {
+			r.current.Close()
+		}
+		return r.zr.Close()
+	}
+	if r.gzr != nil {
+		r.gzr.Close()
+	}
+	return r.file.Close()
+}
+
+// CleanName returns the member name in the form paths are compared in,
+// refusing names that would place the member outside the directory the
+// archive is unpacked into.
+func CleanName(name string) (string, error) {
+	cleaned := path.Clean(filepath.ToSlash(name))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("archive member %s lies outside the archive", name)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestNextRefusesMembersOutsideArchive reads tarballs and ZIP archives
+// holding a member whose name escapes the directory they are unpacked into,
+// and checks reading stops at it.
+func TestNextRefusesMembersOutsideArchive(t *testing.T) {
+	for _, name := range []string{"../escape.txt", "attachments/../../escape.txt", "/etc/escape.txt"} {
+		for _, format := range []string{FormatTar, FormatZip} {
+			path := filepath.Join(t.TempDir(), "export."+format)
+			writeExport(t, path, format, []string{"attachments/ok.txt", name})
+
+			r, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			member, err := r.Next()
+			if err != nil || member.Name != "attachments/ok.txt" {
+				t.Errorf("%s: first member is %v, %v, want attachments/ok.txt", format, member, err)
+			}
+			_, err = r.Next()
+			if err == nil || !strings.Contains(err.Error(), "lies outside the archive") {
+				t.Errorf("%s: member %s read with error %v, want it refused", format, name, err)
+			}
+			r.Close()
+		}
+	}
+}
+
+// writeExport writes an export in the format holding a small file under each
+// of the names.
+func writeExport(t *testing.T, path, format string, names []string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var add func(name string) (io.Writer, error)
+	var close func() error
+	if format == FormatZip {
+		zw := zip.NewWriter(file)
+		add = func(name string) (io.Writer, error) {
+			return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		}
+		close = zw.Close
+	} else {
+		tw := tar.NewWriter(file)
+		add = func(name string) (io.Writer, error) {
+			return tw, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 2, Typeflag: tar.TypeReg})
+		}
+		close = tw.Close
+	}
+	for _, name := range names {
+		w, err := add(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte("ok"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
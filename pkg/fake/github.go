@@ -0,0 +1,224 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+)
+
+// GitHub is a fake GitHub REST API holding the issues and comments of
+// repositories. GraphQL is not implemented, collect against it with
+// --github-api rest.
+type GitHub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	issues   map[string][]*Issue
+	comments map[string][]*IssueComment
+	nextID   int64
+}
+
+// Issue is an issue of a repository of the fake GitHub.
+type Issue struct {
+	Number int
+	Title  string
+	Body   string
+	Labels []string
+	State  string
+	Author string
+}
+
+// IssueComment is a comment on an issue of the fake GitHub.
+type IssueComment struct {
+	ID     int64
+	Issue  int
+	Body   string
+	Author string
+}
+
+// NewGitHub starts a fake GitHub with no repositories. Close it when done.
+func NewGitHub() *GitHub {
+	g := &GitHub{
+		issues:   make(map[string][]*Issue),
+		comments: make(map[string][]*IssueComment),
+	}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	return g
+}
+
+// Client returns a go-github client of the fake.
+func (g *GitHub) Client() *github.Client {
+	client := github.NewClient(g.Server.Client())
+	client.BaseURL, _ = url.Parse(g.URL + "/")
+	return client
+}
+
+// AddIssue adds an open issue to the repository org/repo, numbered after
+// its existing issues, and returns it for further setup.
+func (g *GitHub) AddIssue(org, repo, title, body string) *Issue {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	name := org + "/" + repo
+	issue := &Issue{Number: len(g.issues[name]) + 1, Title: title, Body: body, State: "open", Author: "octocat"}
+	g.issues[name] = append(g.issues[name], issue)
+	return issue
+}
+
+// AddComment adds a comment to the issue of the repository org/repo.
+func (g *GitHub) AddComment(org, repo string, number int, body string) *IssueComment {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nextID++
+	comment := &IssueComment{ID: g.nextID, Issue: number, Body: body, Author: "octocat"}
+	name := org + "/" + repo
+	g.comments[name] = append(g.comments[name], comment)
+	return comment
+}
+
+var (
+	issuesPath        = regexp.MustCompile(`^/repos/([^/]+/[^/]+)/issues$`)
+	allCommentsPath   = regexp.MustCompile(`^/repos/([^/]+/[^/]+)/issues/comments$`)
+	issueCommentsPath = regexp.MustCompile(`^/repos/([^/]+/[^/]+)/issues/([0-9]+)/comments$`)
+)
+
+func (g *GitHub) serve(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case issuesPath.MatchString(path):
+		name := issuesPath.FindStringSubmatch(path)[1]
+		issues, ok := g.issues[name]
+		if !ok {
+			writeGitHubError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		var encoded []interface{}
+		for _, issue := range issues {
+			if listsIssue(r.URL.Query(), issue) {
+				encoded = append(encoded, g.encodeIssue(name, issue))
+			}
+		}
+		g.writePage(w, r, encoded)
+	case allCommentsPath.MatchString(path):
+		name := allCommentsPath.FindStringSubmatch(path)[1]
+		g.writeComments(w, r, name, 0)
+	case issueCommentsPath.MatchString(path):
+		match := issueCommentsPath.FindStringSubmatch(path)
+		number, _ := strconv.Atoi(match[2])
+		g.writeComments(w, r, match[1], number)
+	default:
+		writeGitHubError(w, http.StatusNotFound, fmt.Sprintf("the fake GitHub does not implement %s %s", r.Method, path))
+	}
+}
+
+// listsIssue reports whether the issue passes the state and labels filters of
+// the listing query, which GitHub defaults to the open issues with any
+// labels.
+func listsIssue(query url.Values, issue *Issue) bool {
+	state := query.Get("state")
+	if state == "" {
+		state = "open"
+	}
+	if state != "all" && issue.State != state {
+		return false
+	}
+	labels := make(map[string]bool)
+	for _, label := range issue.Labels {
+		labels[strings.ToLower(label)] = true
+	}
+	for _, label := range strings.Split(query.Get("labels"), ",") {
+		if label != "" && !labels[strings.ToLower(label)] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeComments lists the comments of the issue, or of every issue when
+// number is zero, oldest first.
+func (g *GitHub) writeComments(w http.ResponseWriter, r *http.Request, name string, number int) {
+	comments := append([]*IssueComment(nil), g.comments[name]...)
+	sort.SliceStable(comments, func(a, b int) bool {
+		return comments[a].ID < comments[b].ID
+	})
+	var encoded []interface{}
+	for _, comment := range comments {
+		if number != 0 && comment.Issue != number {
+			continue
+		}
+		encoded = append(encoded, map[string]interface{}{
+			"id":         comment.ID,
+			"body":       comment.Body,
+			"html_url":   fmt.Sprintf("https://github.com/%s/issues/%d#issuecomment-%d", name, comment.Issue, comment.ID),
+			"issue_url":  fmt.Sprintf("%s/repos/%s/issues/%d", g.URL, name, comment.Issue),
+			"user":       map[string]interface{}{"login": comment.Author},
+			"created_at": time.Unix(comment.ID, 0).UTC(),
+		})
+	}
+	g.writePage(w, r, encoded)
+}
+
+func (g *GitHub) encodeIssue(name string, issue *Issue) map[string]interface{} {
+	var labels []interface{}
+	for _, label := range issue.Labels {
+		labels = append(labels, map[string]interface{}{"name": label})
+	}
+	return map[string]interface{}{
+		"number":   issue.Number,
+		"title":    issue.Title,
+		"body":     issue.Body,
+		"state":    issue.State,
+		"labels":   labels,
+		"html_url": fmt.Sprintf("https://github.com/%s/issues/%d", name, issue.Number),
+		"user":     map[string]interface{}{"login": issue.Author},
+	}
+}
+
+// writePage writes the page of the items requested by the page and per_page
+// parameters, linking the next and last pages as GitHub does.
+func (g *GitHub) writePage(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	query := r.URL.Query()
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(query.Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = 30
+	}
+	last := (len(items) + perPage - 1) / perPage
+	if last == 0 {
+		last = 1
+	}
+	if page < last {
+		link := func(page int) string {
+			q := r.URL.Query()
+			q.Set("page", strconv.Itoa(page))
+			return fmt.Sprintf("<%s%s?%s>", g.URL, r.URL.Path, q.Encode())
+		}
+		w.Header().Set("Link", fmt.Sprintf(`%s; rel="next", %s; rel="last"`, link(page+1), link(last)))
+	}
+	start, end := (page-1)*perPage, page*perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+	writeJSON(w, http.StatusOK, append([]interface{}{}, items[start:end]...))
+}
+
+func writeGitHubError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]interface{}{"message": message})
+}
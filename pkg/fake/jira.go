@@ -0,0 +1,561 @@
+// Package fake serves in-process fakes of the GitHub and JIRA APIs the
+// collector and uploader use, on httptest servers, so the pipelines can be
+// exercised end to end without live instances. The fakes keep their state
+// in memory and implement only the endpoints and fields the migrator reads.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// DefaultUploadLimit is the attachment size limit of a fake JIRA, the
+// default of JIRA Server.
+const DefaultUploadLimit = 10 * 1024 * 1024
+
+// JIRA is a fake JIRA instance holding tickets and their attachments.
+type JIRA struct {
+	*httptest.Server
+
+	// UploadLimit is the largest attachment accepted, larger ones are
+	// rejected with 413 Request Entity Too Large as JIRA does.
+	UploadLimit int64
+	// FailureRate is the fraction of attachment uploads, from 0 to 1, failed
+	// at random with 503 Service Unavailable as a transient outage would.
+	FailureRate float64
+
+	mu          sync.Mutex
+	tickets     map[string]*Ticket
+	attachments map[string]*Attachment
+	nextID      int
+	random      *rand.Rand
+	stats       Stats
+}
+
+// Stats counts the attachment uploads the fake JIRA received.
+type Stats struct {
+	Uploaded int
+	Bytes    int64
+	Rejected int
+	Failed   int
+}
+
+// Ticket is a ticket of the fake JIRA.
+type Ticket struct {
+	Key         string
+	Summary     string
+	Description string
+	Status      string
+	Labels      []string
+	Created     time.Time
+	Attachments []*Attachment
+	Comments    []*Comment
+	RemoteLinks []string
+	Properties  map[string]json.RawMessage
+}
+
+// Attachment is a file attached to a ticket of the fake JIRA.
+type Attachment struct {
+	ID       string
+	Ticket   string
+	Filename string
+	Content  []byte
+	Created  time.Time
+}
+
+// Comment is a comment on a ticket of the fake JIRA.
+type Comment struct {
+	ID   string
+	Body string
+}
+
+// NewJIRA starts a fake JIRA with no tickets. Close it when done.
+func NewJIRA() *JIRA {
+	j := &JIRA{
+		UploadLimit: DefaultUploadLimit,
+		tickets:     make(map[string]*Ticket),
+		attachments: make(map[string]*Attachment),
+		nextID:      10000,
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	j.Server = httptest.NewServer(http.HandlerFunc(j.serve))
+	return j
+}
+
+// Client returns a go-jira client of the fake.
+func (j *JIRA) Client() *jira.Client {
+	client, _ := jira.NewClient(j.Server.Client(), j.URL)
+	return client
+}
+
+// AddTicket creates a ticket with the summary, returning it for further
+// setup.
+func (j *JIRA) AddTicket(key, summary string) *Ticket {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.addTicket(key, summary)
+}
+
+// addTicket creates a ticket. The mutex must be held.
+func (j *JIRA) addTicket(key, summary string) *Ticket {
+	ticket := &Ticket{
+		Key:        key,
+		Summary:    summary,
+		Status:     "Open",
+		Created:    time.Now().UTC().Add(time.Duration(len(j.tickets)) * time.Minute),
+		Properties: make(map[string]json.RawMessage),
+	}
+	j.tickets[key] = ticket
+	return ticket
+}
+
+// Stats returns the counts of the attachment uploads received so far.
+func (j *JIRA) Stats() Stats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stats
+}
+
+// Ticket returns the ticket with the key, or nil.
+func (j *JIRA) Ticket(key string) *Ticket {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.tickets[key]
+}
+
+// Attachments returns the attachments of the ticket in the order they were
+// added.
+func (j *JIRA) Attachments(key string) []*Attachment {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ticket, ok := j.tickets[key]
+	if !ok {
+		return nil
+	}
+	return append([]*Attachment(nil), ticket.Attachments...)
+}
+
+func (j *JIRA) id() string {
+	j.nextID++
+	return strconv.Itoa(j.nextID)
+}
+
+var (
+	ticketPath         = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)$`)
+	ticketChildPath    = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)/(attachments|comment|remotelink|transitions)$`)
+	commentPath        = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)/comment/([^/]+)$`)
+	propertyPath       = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)/properties/([^/]+)$`)
+	attachmentPath     = regexp.MustCompile(`^/rest/api/2/attachment/([0-9]+)$`)
+	attachmentFilePath = regexp.MustCompile(`^/secure/attachment/([0-9]+)/`)
+	projectPath        = regexp.MustCompile(`^/rest/api/2/project/([^/]+)$`)
+)
+
+// searchJQL matches the JQL the fake answers: project clauses joined with
+// OR, optionally in parentheses, followed by bounds on the creation date and
+// an order. projectClause and createdClause pick its parts apart.
+var (
+	searchJQL     = regexp.MustCompile(`^(?:\((project=[A-Z][A-Z0-9_]*(?: OR project=[A-Z][A-Z0-9_]*)*)\)|(project=[A-Z][A-Z0-9_]*(?: OR project=[A-Z][A-Z0-9_]*)*))((?: AND created (?:>=|<) "[^"]*")*)(?: ORDER BY (key|created ASC|created DESC))?$`)
+	projectClause = regexp.MustCompile(`project=([A-Z][A-Z0-9_]*)`)
+	createdClause = regexp.MustCompile(`created (>=|<) "([^"]*)"`)
+)
+
+// jqlDate is the layout of the dates in JQL, compared to the minute.
+const jqlDate = "2006/01/02 15:04"
+
+func (j *JIRA) serve(w http.ResponseWriter, r *http.Request) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case path == "/rest/api/2/serverInfo":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"baseUrl":    j.URL,
+			"version":    "9.4.0",
+			"serverTime": time.Now().UTC().Format("2006-01-02T15:04:05.000-0700"),
+		})
+	case path == "/rest/api/2/myself":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": "migrator", "displayName": "Migrator"})
+	case path == "/rest/api/2/attachment/meta":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "uploadLimit": j.UploadLimit})
+	case path == "/rest/api/2/mypermissions":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"permissions": map[string]interface{}{"CREATE_ATTACHMENTS": map[string]interface{}{"havePermission": true}},
+		})
+	case path == "/rest/api/2/search":
+		j.search(w, r)
+	case path == "/rest/api/2/issue" && r.Method == http.MethodPost:
+		j.create(w, r)
+	case projectPath.MatchString(path):
+		key := projectPath.FindStringSubmatch(path)[1]
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "name": key})
+	case ticketPath.MatchString(path):
+		j.ticket(w, r, ticketPath.FindStringSubmatch(path)[1])
+	case ticketChildPath.MatchString(path):
+		match := ticketChildPath.FindStringSubmatch(path)
+		j.ticketChild(w, r, match[1], match[2])
+	case commentPath.MatchString(path):
+		match := commentPath.FindStringSubmatch(path)
+		j.updateComment(w, r, match[1], match[2])
+	case propertyPath.MatchString(path):
+		match := propertyPath.FindStringSubmatch(path)
+		j.property(w, r, match[1], match[2])
+	case attachmentPath.MatchString(path):
+		j.attachment(w, r, attachmentPath.FindStringSubmatch(path)[1])
+	case attachmentFilePath.MatchString(path):
+		attachment, ok := j.attachments[attachmentFilePath.FindStringSubmatch(path)[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "attachment not found")
+			return
+		}
+		w.Write(attachment.Content)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("the fake JIRA does not implement %s %s", r.Method, path))
+	}
+}
+
+// search returns the tickets of the projects named in the JQL created within
+// its bounds, ordered by creation date when the JQL orders by created and by
+// key otherwise. JQL of any other form is rejected as JIRA rejects malformed
+// queries.
+func (j *JIRA) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	jql := query.Get("jql")
+	match := searchJQL.FindStringSubmatch(jql)
+	if match == nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error in the JQL Query: the fake JIRA cannot parse %q", jql))
+		return
+	}
+	projects := make(map[string]bool)
+	for _, clause := range projectClause.FindAllStringSubmatch(match[1]+match[2], -1) {
+		projects[clause[1]] = true
+	}
+	var after, before []time.Time
+	for _, clause := range createdClause.FindAllStringSubmatch(match[3], -1) {
+		bound, err := time.Parse(jqlDate, clause[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Date value '%s' for field 'created' is invalid.", clause[2]))
+			return
+		}
+		if clause[1] == ">=" {
+			after = append(after, bound)
+		} else {
+			before = append(before, bound)
+		}
+	}
+
+	var found []*Ticket
+	for _, ticket := range j.tickets {
+		if projects[projectOf(ticket.Key)] && createdWithin(ticket.Created, after, before) {
+			found = append(found, ticket)
+		}
+	}
+	sort.Slice(found, func(a, b int) bool {
+		switch match[4] {
+		case "created DESC":
+			return found[a].Created.After(found[b].Created)
+		case "created ASC":
+			return found[a].Created.Before(found[b].Created)
+		}
+		return found[a].Key < found[b].Key
+	})
+
+	startAt, _ := strconv.Atoi(query.Get("startAt"))
+	maxResults, err := strconv.Atoi(query.Get("maxResults"))
+	if err != nil || maxResults <= 0 {
+		maxResults = 50
+	}
+	issues := []interface{}{}
+	for i := startAt; i < len(found) && i < startAt+maxResults; i++ {
+		issues = append(issues, j.encode(found[i]))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"startAt":    startAt,
+		"maxResults": maxResults,
+		"total":      len(found),
+		"issues":     issues,
+	})
+}
+
+func (j *JIRA) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Fields struct {
+			Project struct {
+				Key string `json:"key"`
+			} `json:"project"`
+			Summary     string   `json:"summary"`
+			Description string   `json:"description"`
+			Labels      []string `json:"labels"`
+		} `json:"fields"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Fields.Project.Key == "" {
+		writeError(w, http.StatusBadRequest, "a ticket needs a project and summary")
+		return
+	}
+	number := 1
+	for key := range j.tickets {
+		if projectOf(key) == req.Fields.Project.Key {
+			number++
+		}
+	}
+	key := fmt.Sprintf("%s-%d", req.Fields.Project.Key, number)
+	ticket := j.addTicket(key, req.Fields.Summary)
+	ticket.Description = req.Fields.Description
+	ticket.Labels = req.Fields.Labels
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": j.id(), "key": key, "self": j.URL + "/rest/api/2/issue/" + key})
+}
+
+func (j *JIRA) ticket(w http.ResponseWriter, r *http.Request, key string) {
+	ticket, ok := j.tickets[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, j.encode(ticket))
+	case http.MethodPut:
+		var req struct {
+			Fields struct {
+				Description *string `json:"description"`
+			} `json:"fields"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Fields.Description != nil {
+			ticket.Description = *req.Fields.Description
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (j *JIRA) ticketChild(w http.ResponseWriter, r *http.Request, key, child string) {
+	ticket, ok := j.tickets[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	switch {
+	case child == "attachments" && r.Method == http.MethodPost:
+		j.upload(w, r, ticket)
+	case child == "comment" && r.Method == http.MethodPost:
+		var comment Comment
+		json.NewDecoder(r.Body).Decode(&comment)
+		comment.ID = j.id()
+		ticket.Comments = append(ticket.Comments, &comment)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": comment.ID, "body": comment.Body})
+	case child == "remotelink" && r.Method == http.MethodPost:
+		var link struct {
+			Object struct {
+				URL string `json:"url"`
+			} `json:"object"`
+		}
+		json.NewDecoder(r.Body).Decode(&link)
+		ticket.RemoteLinks = append(ticket.RemoteLinks, link.Object.URL)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": len(ticket.RemoteLinks)})
+	case child == "transitions" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"transitions": []interface{}{}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// upload attaches the files of the multipart request to the ticket, unless
+// the upload is picked to fail by FailureRate.
+func (j *JIRA) upload(w http.ResponseWriter, r *http.Request, ticket *Ticket) {
+	if j.random.Float64() < j.FailureRate {
+		j.stats.Failed++
+		writeError(w, http.StatusServiceUnavailable, "simulated transient failure")
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid multipart request: %s", err))
+		return
+	}
+	var added []map[string]interface{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid multipart request: %s", err))
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(part, j.UploadLimit+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed reading upload: %s", err))
+			return
+		}
+		if int64(len(content)) > j.UploadLimit {
+			j.stats.Rejected++
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the file exceeds the maximum attachment size of %d bytes", j.UploadLimit))
+			return
+		}
+		attachment := &Attachment{ID: j.id(), Ticket: ticket.Key, Filename: part.FileName(), Content: content, Created: time.Now().UTC()}
+		ticket.Attachments = append(ticket.Attachments, attachment)
+		j.attachments[attachment.ID] = attachment
+		j.stats.Uploaded++
+		j.stats.Bytes += int64(len(content))
+		added = append(added, j.encodeAttachment(attachment))
+	}
+	writeJSON(w, http.StatusOK, added)
+}
+
+func (j *JIRA) updateComment(w http.ResponseWriter, r *http.Request, key, id string) {
+	ticket, ok := j.tickets[key]
+	if !ok || r.Method != http.MethodPut {
+		writeError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+	for _, comment := range ticket.Comments {
+		if comment.ID == id {
+			var update Comment
+			json.NewDecoder(r.Body).Decode(&update)
+			comment.Body = update.Body
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": comment.ID, "body": comment.Body})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "comment not found")
+}
+
+func (j *JIRA) property(w http.ResponseWriter, r *http.Request, key, name string) {
+	ticket, ok := j.tickets[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		value, _ := io.ReadAll(r.Body)
+		ticket.Properties[name] = value
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		value, ok := ticket.Properties[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "property not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": name, "value": value})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (j *JIRA) attachment(w http.ResponseWriter, r *http.Request, id string) {
+	attachment, ok := j.attachments[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, j.encodeAttachment(attachment))
+	case http.MethodDelete:
+		delete(j.attachments, id)
+		ticket := j.tickets[attachment.Ticket]
+		for i, a := range ticket.Attachments {
+			if a.ID == id {
+				ticket.Attachments = append(ticket.Attachments[:i], ticket.Attachments[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// encode returns the REST representation of the ticket with every field the
+// migrator reads.
+func (j *JIRA) encode(ticket *Ticket) map[string]interface{} {
+	attachments := []interface{}{}
+	for _, attachment := range ticket.Attachments {
+		attachments = append(attachments, j.encodeAttachment(attachment))
+	}
+	comments := []interface{}{}
+	for _, comment := range ticket.Comments {
+		comments = append(comments, map[string]interface{}{"id": comment.ID, "body": comment.Body})
+	}
+	return map[string]interface{}{
+		"id":   strings.TrimPrefix(ticket.Key, projectOf(ticket.Key)+"-"),
+		"key":  ticket.Key,
+		"self": j.URL + "/rest/api/2/issue/" + ticket.Key,
+		"fields": map[string]interface{}{
+			"summary":     ticket.Summary,
+			"description": ticket.Description,
+			"labels":      ticket.Labels,
+			"created":     ticket.Created.Format("2006-01-02T15:04:05.000-0700"),
+			"status":      map[string]interface{}{"name": ticket.Status},
+			"attachment":  attachments,
+			"comment":     map[string]interface{}{"comments": comments, "total": len(comments), "maxResults": len(comments)},
+		},
+	}
+}
+
+func (j *JIRA) encodeAttachment(attachment *Attachment) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       attachment.ID,
+		"self":     j.URL + "/rest/api/2/attachment/" + attachment.ID,
+		"filename": attachment.Filename,
+		"size":     len(attachment.Content),
+		"created":  attachment.Created.Format("2006-01-02T15:04:05.000-0700"),
+		"content":  fmt.Sprintf("%s/secure/attachment/%s/%s", j.URL, attachment.ID, attachment.Filename),
+	}
+}
+
+// projectOf returns the project of a ticket key such as PROJ-12.
+// createdWithin reports whether a ticket created at created, to the minute,
+// is no earlier than any of the after bounds and earlier than every before
+// bound.
+func createdWithin(created time.Time, after, before []time.Time) bool {
+	created = created.Truncate(time.Minute)
+	for _, bound := range after {
+		if created.Before(bound) {
+			return false
+		}
+	}
+	for _, bound := range before {
+		if !created.Before(bound) {
+			return false
+		}
+	}
+	return true
+}
+
+func projectOf(key string) string {
+	if i := strings.LastIndex(key, "-"); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError responds with the error format of the JIRA REST API.
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]interface{}{"errorMessages": []string{message}, "errors": map[string]string{}})
+}
@@ -0,0 +1,183 @@
+// Package filetype identifies the type of attachment files from their
+// contents and filters them by extension and MIME type.
+package filetype
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLength is the number of leading bytes examined to detect a type.
+const sniffLength = 512
+
+// signature is a leading byte sequence identifying a file type the standard
+// library does not detect.
+type signature struct {
+	prefix      []byte
+	contentType string
+}
+
+// executableSignatures identify programs and scripts, which the standard
+// library reports as application/octet-stream or text/plain.
+var executableSignatures = []signature{
+	{[]byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xce}, "application/x-mach-binary"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xcf}, "application/x-mach-binary"},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+	{[]byte("#!"), "text/x-shellscript"},
+}
+
+// Detect returns the MIME type of the data, which is the leading bytes of a
+// file, falling back to the type of the file name's extension when the
+// contents are not recognized.
+func Detect(data []byte, name string) string {
+	if len(data) > sniffLength {
+		data = data[:sniffLength]
+	}
+	for _, sig := range executableSignatures {
+		if bytes.HasPrefix(data, sig.prefix) {
+			return sig.contentType
+		}
+	}
+	contentType := http.DetectContentType(data)
+	if contentType == "application/octet-stream" || strings.HasPrefix(contentType, "text/plain") {
+		if byName := ByName(name); byName != "" {
+			return byName
+		}
+	}
+	return contentType
+}
+
+// Sniff returns the MIME type of the file at path, detected from its
+// contents.
+func Sniff(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return Detect(data[:n], path), nil
+}
+
+// ByName returns the MIME type registered for the extension of the file
+// name without its parameters, or an empty string when it has none.
+func ByName(name string) string {
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// Filter selects attachments by file extension and MIME type.
+type Filter struct {
+	include      []string
+	exclude      []string
+	excludeTypes []string
+}
+
+// ParseFilter builds a filter from comma separated lists of the extensions
+// to include and exclude and the MIME types to exclude. Extensions may be
+// given with or without the leading dot and MIME types may end in /* to
+// match a whole class such as video/*. An include list of "all" and lists of
+// "none" leave the corresponding filter unset.
+func ParseFilter(include, exclude, excludeTypes string) (*Filter, error) {
+	filter := &Filter{}
+	if include != "all" {
+		filter.include = parseExtensions(include)
+		if len(filter.include) == 0 {
+			return nil, fmt.Errorf("invalid extensions to include %q", include)
+		}
+	}
+	if exclude != "none" {
+		filter.exclude = parseExtensions(exclude)
+	}
+	if excludeTypes != "none" {
+		for _, contentType := range strings.Split(excludeTypes, ",") {
+			contentType = strings.ToLower(strings.TrimSpace(contentType))
+			if contentType == "" {
+				continue
+			}
+			if !strings.Contains(contentType, "/") {
+				return nil, fmt.Errorf("invalid MIME type %q, must be such as video/mp4 or video/*", contentType)
+			}
+			filter.excludeTypes = append(filter.excludeTypes, contentType)
+		}
+	}
+	return filter, nil
+}
+
+func parseExtensions(list string) []string {
+	var extensions []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions
+}
+
+// IsEmpty reports whether the filter lets every file through, so there is
+// no need to detect their types.
+func (f *Filter) IsEmpty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0 && len(f.excludeTypes) == 0
+}
+
+// Reason returns why the filter excludes a file of the name and MIME type,
+// or an empty string when it is included.
+func (f *Filter) Reason(name, contentType string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if len(f.include) > 0 && !contains(f.include, ext) {
+		if ext == "" {
+			return "has no extension and only listed extensions are included"
+		}
+		return fmt.Sprintf("%s files are not included", ext)
+	}
+	if contains(f.exclude, ext) {
+		return fmt.Sprintf("%s files are excluded", ext)
+	}
+	for _, excluded := range f.excludeTypes {
+		if MatchType(excluded, contentType) {
+			return fmt.Sprintf("%s content is excluded", contentType)
+		}
+	}
+	return ""
+}
+
+// MatchType reports whether the MIME type matches the pattern, which is a
+// MIME type or a class such as video/*.
+func MatchType(pattern, contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+	}
+	return contentType == pattern
+}
+
+func contains(list []string, value string) bool {
+	for _, candidate := range list {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+// Package match pairs GitHub issues with the JIRA tickets their attachments
+// are uploaded to.
+package match
+
+import (
+	"sort"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// Preview summarises how GitHub issues pair with JIRA tickets.
+type Preview struct {
+	Issues          int
+	Tickets         int
+	Matched         int
+	Unmatched       []*store.IssueEntry
+	IssueCollisions map[string][]*store.IssueEntry
+	// Ambiguous holds the tickets matched by each issue that matches more
+	// than one, keyed by the issue title.
+	Ambiguous map[string][]*store.TicketEntry
+}
+
+// PreviewMatches pairs issues with tickets the same way collect does and
+// records the issue titles that collide, since only one issue per title can
+// be kept in the database, and the issues matching more than one ticket.
+func PreviewMatches(matcher Matcher, issues []*store.IssueEntry, tickets []*store.TicketEntry) *Preview {
+	preview := &Preview{
+		Issues:          len(issues),
+		Tickets:         len(tickets),
+		IssueCollisions: make(map[string][]*store.IssueEntry),
+		Ambiguous:       make(map[string][]*store.TicketEntry),
+	}
+
+	issuesByTitle := make(map[string][]*store.IssueEntry)
+	for _, issue := range issues {
+		issuesByTitle[issue.Title] = append(issuesByTitle[issue.Title], issue)
+		matched := matcher.Match(issue)
+		switch {
+		case len(matched) == 0:
+			preview.Unmatched = append(preview.Unmatched, issue)
+			continue
+		case len(matched) > 1:
+			preview.Ambiguous[issue.Title] = matched
+		}
+		preview.Matched++
+	}
+	for title, entries := range issuesByTitle {
+		if len(entries) > 1 {
+			preview.IssueCollisions[title] = entries
+		}
+	}
+
+	sort.Slice(preview.Unmatched, func(i, j int) bool {
+		return preview.Unmatched[i].Number < preview.Unmatched[j].Number
+	})
+
+	return preview
+}
@@ -0,0 +1,208 @@
+package match
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+const (
+	StrategyTitleExact      = "title-exact"
+	StrategyTitleNormalized = "title-normalized"
+	StrategyCustomField     = "custom-field"
+	StrategyMappingFile     = "mapping-file"
+	StrategyRegexExtract    = "regex-extract"
+)
+
+// Matcher finds the JIRA tickets a GitHub issue's attachments belong to.
+// More than one ticket means the match is ambiguous, in which case the last
+// ticket listed is used.
+type Matcher interface {
+	Match(issue *store.IssueEntry) []*store.TicketEntry
+}
+
+// Config selects the matching strategy and holds the options it needs.
+type Config struct {
+	Strategy string
+	// Field is the JIRA custom field holding the GitHub issue URL or number,
+	// read alongside each ticket's summary for the custom-field strategy.
+	Field string
+
+	mapping map[int]string
+	pattern *regexp.Regexp
+}
+
+// NewConfig validates the strategy and its options. A field, mapping file,
+// or pattern of "none" leaves that option unset, and each is only accepted
+// by the strategy that uses it.
+func NewConfig(strategy, field, mappingFile, pattern string) (*Config, error) {
+	config := &Config{Strategy: strategy}
+	switch strategy {
+	case StrategyTitleExact, StrategyTitleNormalized:
+	case StrategyCustomField:
+		if field == "none" {
+			return nil, fmt.Errorf("--match-field must be specified for the %s strategy", strategy)
+		}
+		config.Field = field
+	case StrategyMappingFile:
+		if mappingFile == "none" {
+			return nil, fmt.Errorf("--match-mapping-file must be specified for the %s strategy", strategy)
+		}
+		mapping, err := readMapping(mappingFile)
+		if err != nil {
+			return nil, err
+		}
+		config.mapping = mapping
+	case StrategyRegexExtract:
+		if pattern == "none" {
+			return nil, fmt.Errorf("--match-pattern must be specified for the %s strategy", strategy)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match pattern %q: %w", pattern, err)
+		}
+		if re.NumSubexp() != 1 {
+			return nil, fmt.Errorf("invalid match pattern %q: it must have exactly one capture group for the issue number", pattern)
+		}
+		config.pattern = re
+	default:
+		return nil, fmt.Errorf("invalid match strategy %q, must be one of title-exact, title-normalized, custom-field, mapping-file, or regex-extract", strategy)
+	}
+	if field != "none" && strategy != StrategyCustomField {
+		return nil, fmt.Errorf("--match-field is only used by the %s strategy", StrategyCustomField)
+	}
+	if mappingFile != "none" && strategy != StrategyMappingFile {
+		return nil, fmt.Errorf("--match-mapping-file is only used by the %s strategy", StrategyMappingFile)
+	}
+	if pattern != "none" && strategy != StrategyRegexExtract {
+		return nil, fmt.Errorf("--match-pattern is only used by the %s strategy", StrategyRegexExtract)
+	}
+	return config, nil
+}
+
+// Matcher indexes the tickets for the configured strategy.
+func (c *Config) Matcher(tickets []*store.TicketEntry) Matcher {
+	switch c.Strategy {
+	case StrategyTitleNormalized:
+		return newKeyMatcher(tickets, func(issue *store.IssueEntry) []string {
+			return []string{normalizeTitle(issue.Title)}
+		}, func(ticket *store.TicketEntry) string {
+			return normalizeTitle(ticket.Summary)
+		})
+	case StrategyCustomField:
+		return newKeyMatcher(tickets, func(issue *store.IssueEntry) []string {
+			number := strconv.Itoa(issue.Number)
+			return []string{issue.URL, number, "#" + number}
+		}, func(ticket *store.TicketEntry) string {
+			return strings.TrimSpace(ticket.Field)
+		})
+	case StrategyMappingFile:
+		return newKeyMatcher(tickets, func(issue *store.IssueEntry) []string {
+			return []string{c.mapping[issue.Number]}
+		}, func(ticket *store.TicketEntry) string {
+			return ticket.Key
+		})
+	case StrategyRegexExtract:
+		return newKeyMatcher(tickets, func(issue *store.IssueEntry) []string {
+			return []string{strconv.Itoa(issue.Number)}
+		}, func(ticket *store.TicketEntry) string {
+			match := c.pattern.FindStringSubmatch(ticket.Summary)
+			if match == nil {
+				return ""
+			}
+			number, err := strconv.Atoi(match[1])
+			if err != nil {
+				return ""
+			}
+			return strconv.Itoa(number)
+		})
+	}
+	return newKeyMatcher(tickets, func(issue *store.IssueEntry) []string {
+		return []string{issue.Title}
+	}, func(ticket *store.TicketEntry) string {
+		return ticket.Summary
+	})
+}
+
+// keyMatcher pairs issues with the tickets sharing one of their keys. Every
+// strategy differs only in the keys it derives from each side.
+type keyMatcher struct {
+	tickets  map[string][]*store.TicketEntry
+	issueKey func(issue *store.IssueEntry) []string
+}
+
+func newKeyMatcher(tickets []*store.TicketEntry, issueKey func(issue *store.IssueEntry) []string, ticketKey func(ticket *store.TicketEntry) string) *keyMatcher {
+	m := &keyMatcher{
+		tickets:  make(map[string][]*store.TicketEntry),
+		issueKey: issueKey,
+	}
+	for _, ticket := range tickets {
+		key := ticketKey(ticket)
+		if key == "" {
+			continue
+		}
+		m.tickets[key] = append(m.tickets[key], ticket)
+	}
+	return m
+}
+
+func (m *keyMatcher) Match(issue *store.IssueEntry) []*store.TicketEntry {
+	for _, key := range m.issueKey(issue) {
+		if key == "" {
+			continue
+		}
+		if tickets, ok := m.tickets[key]; ok {
+			return tickets
+		}
+	}
+	return nil
+}
+
+// normalizeTitle lower cases the title and collapses every run of
+// punctuation and whitespace to a single space, so titles that differ only
+// in case, spacing, or punctuation added during the JIRA import still match.
+func normalizeTitle(title string) string {
+	fields := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+// readMapping reads a mapping file of one GitHub issue number and JIRA
+// ticket key per line, separated by a comma, ignoring blank lines and lines
+// starting with #.
+func readMapping(path string) (map[int]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening mapping file: %w", err)
+	}
+	defer file.Close()
+
+	mapping := make(map[int]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		issue, key, ok := strings.Cut(text, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping on line %d of %s, must look like 12,PROJ-34", line, path)
+		}
+		number, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(issue), "#"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid issue number on line %d of %s: %w", line, path, err)
+		}
+		mapping[number] = strings.TrimSpace(key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading mapping file: %w", err)
+	}
+	return mapping, nil
+}
@@ -0,0 +1,102 @@
+// Package naming names the attachment files posted to tickets and written to
+// the archive from a template, and keeps the names on a ticket unique.
+package naming
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// Fields are the values a name template can use.
+type Fields struct {
+	IssueNumber   int
+	CommentNumber int64
+	// Name is the file name, Base the name without its extension, and Ext
+	// the extension with its leading dot.
+	Name string
+	Base string
+	Ext  string
+	// Type is the kind of attachment, such as image or file, and Key the
+	// ticket it belongs to.
+	Type string
+	Key  string
+}
+
+// NewFields returns the fields of a file of the attachment named name on the
+// ticket key.
+func NewFields(attachment *store.Attachment, name, key string) *Fields {
+	ext := filepath.Ext(name)
+	return &Fields{
+		IssueNumber:   attachment.IssueNumber,
+		CommentNumber: attachment.CommentNumber,
+		Name:          name,
+		Base:          strings.TrimSuffix(name, ext),
+		Ext:           ext,
+		Type:          attachment.Type,
+		Key:           key,
+	}
+}
+
+// Template names files from a Go template of their Fields, such as
+// {{.IssueNumber}}_{{.CommentNumber}}_{{.Name}}.
+type Template struct {
+	tmpl *template.Template
+}
+
+// ParseTemplate parses the name template, returning nil for "none" so files
+// keep their own names.
+func ParseTemplate(text string) (*Template, error) {
+	if text == "none" {
+		return nil, nil
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+	err = tmpl.Execute(io.Discard, &Fields{IssueNumber: 1, Name: "file.txt", Base: "file", Ext: ".txt"})
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Name returns the name of the file with the fields, which is the file's own
+// name when the template is nil.
+func (t *Template) Name(fields *Fields) (string, error) {
+	if t == nil {
+		return fields.Name, nil
+	}
+	var buf bytes.Buffer
+	err := t.tmpl.Execute(&buf, fields)
+	if err != nil {
+		return "", fmt.Errorf("failed naming %s: %w", fields.Name, err)
+	}
+	name := strings.TrimSpace(buf.String())
+	switch {
+	case name == "" || name == "." || name == "..":
+		return "", fmt.Errorf("the name template gives %s the invalid name %q", fields.Name, name)
+	case strings.ContainsAny(name, `/\`):
+		return "", fmt.Errorf("the name template gives %s the name %q, which must not contain a path separator", fields.Name, name)
+	}
+	return name, nil
+}
+
+// Unique returns the name, or when it is already taken the name with the
+// first free counter before its extension, such as "screenshot (1).png", and
+// marks the name returned as taken.
+func Unique(name string, taken map[string]bool) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	unique := name
+	for i := 1; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	taken[unique] = true
+	return unique
+}
@@ -0,0 +1,176 @@
+package naming
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength is the longest name in bytes Sanitize leaves, under the 255
+// bytes JIRA and most file systems allow so a counter can still be added to
+// tell apart names that collide.
+const MaxLength = 240
+
+// maxExtLength is the longest extension kept whole when a name is
+// truncated. Anything longer is not really an extension.
+const maxExtLength = 16
+
+// illegal are the characters Windows does not allow in file names.
+const illegal = `<>:"/\|?*`
+
+// reserved are the device names Windows does not allow as a file name, with
+// or without an extension.
+var reserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Sanitize returns the name in Unicode normalization form C with the
+// characters Windows or JIRA reject, such as : and ? and control characters,
+// replaced by underscores, without trailing dots and spaces, and truncated to
+// MaxLength bytes keeping its extension. Windows device names such as CON get
+// an underscore appended.
+func Sanitize(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFC.String(name) {
+		if r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(illegal, r) {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	name = strings.TrimSpace(strings.TrimRight(b.String(), ". "))
+
+	stem, rest, _ := strings.Cut(name, ".")
+	if reserved[strings.ToUpper(stem)] {
+		name = stem + "_"
+		if rest != "" {
+			name += "." + rest
+		}
+	}
+
+	if len(name) > MaxLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxExtLength {
+			ext = ""
+		}
+		base := strings.TrimSuffix(name, ext)
+		limit := MaxLength - len(ext)
+		for limit > 0 && !utf8.RuneStart(base[limit]) {
+			limit--
+		}
+		name = strings.TrimRight(base[:limit], ". ") + ext
+	}
+	if name == "" {
+		return "attachment"
+	}
+	return name
+}
+
+// Rename records a file sanitization gave a new name.
+type Rename struct {
+	// Path is the staged path of the attachment and Original the name it
+	// would have had.
+	Path     string `json:"path"`
+	Original string `json:"original"`
+	Name     string `json:"name"`
+}
+
+// Renames logs the files renamed by sanitization, keyed by their path and
+// original name.
+type Renames map[string]*Rename
+
+// LoadRenames reads the log of renamed files at path, which is empty when
+// the file does not exist.
+func LoadRenames(path string) (Renames, error) {
+	renames := make(Renames)
+	bytes, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return renames, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading renamed files: %w", err)
+	}
+	var entries []*Rename
+	err = json.Unmarshal(bytes, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling renamed files: %w", err)
+	}
+	for _, entry := range entries {
+		renames.record(entry)
+	}
+	return renames, nil
+}
+
+func (r Renames) record(rename *Rename) {
+	r[rename.Path+"\x00"+rename.Original] = rename
+}
+
+// Save writes the log of renamed files to path, ordered by path.
+func (r Renames) Save(path string) error {
+	entries := make([]*Rename, 0, len(r))
+	for _, rename := range r {
+		entries = append(entries, rename)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Path != entries[j].Path {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Original < entries[j].Original
+	})
+	bytes, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshalling renamed files: %w", err)
+	}
+	err = os.WriteFile(path, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing renamed files: %w", err)
+	}
+	return nil
+}
+
+// Namer names the files of attachments from an optional template and, when
+// Sanitize is set, sanitizes the names, logging the files renamed in
+// Renames. A nil Namer leaves names alone.
+type Namer struct {
+	Template *Template
+	Sanitize bool
+	Renames  Renames
+}
+
+// Name returns the name of the file of the attachment at path with the
+// fields.
+func (n *Namer) Name(fields *Fields, path string) (string, error) {
+	if n == nil {
+		return fields.Name, nil
+	}
+	name, err := n.Template.Name(fields)
+	if err != nil {
+		return "", err
+	}
+	return n.Clean(path, name), nil
+}
+
+// Clean returns the name sanitized when sanitizing, logging the file at path
+// when its name changes.
+func (n *Namer) Clean(path, name string) string {
+	if n == nil || !n.Sanitize {
+		return name
+	}
+	clean := Sanitize(name)
+	if clean != name {
+		output.Detailf("Renaming %s from %q to %q\n", path, name, clean)
+		if n.Renames != nil {
+			n.Renames.record(&Rename{Path: path, Original: name, Name: clean})
+		}
+	}
+	return clean
+}
@@ -0,0 +1,266 @@
+// Package oauth authorizes the migrator against Jira Cloud with the OAuth 2.0
+// authorization code flow, known to Atlassian as 3LO, for sites where basic
+// authentication and API tokens are disabled by policy.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenFile is where the credentials are kept in the working directory. It
+// holds the client secret and refresh token, so it is only readable by its
+// owner.
+const TokenFile = "jira-oauth.json"
+
+// Secret is passed as the JIRA secret to authenticate with the stored
+// credentials rather than a token or password.
+const Secret = "oauth"
+
+// Endpoint is the Atlassian authorization server.
+var Endpoint = oauth2.Endpoint{
+	AuthURL:  "https://auth.atlassian.com/authorize",
+	TokenURL: "https://auth.atlassian.com/oauth/token",
+}
+
+// Scopes are the Jira permissions requested. offline_access is what grants
+// the refresh token.
+var Scopes = []string{"read:jira-work", "write:jira-work", "read:jira-user", "offline_access"}
+
+const resourcesURL = "https://api.atlassian.com/oauth/token/accessible-resources"
+
+// Credentials are the OAuth app and tokens authorizing access to one Jira
+// Cloud site.
+type Credentials struct {
+	ClientID     string        `json:"client_id"`
+	ClientSecret string        `json:"client_secret"`
+	RedirectURL  string        `json:"redirect_url"`
+	SiteURL      string        `json:"site_url"`
+	CloudID      string        `json:"cloud_id"`
+	Token        *oauth2.Token `json:"token"`
+}
+
+func (c *Credentials) config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Endpoint:     Endpoint,
+		RedirectURL:  c.RedirectURL,
+		Scopes:       Scopes,
+	}
+}
+
+// APIURL is the base URL Jira REST requests are sent to with an OAuth token,
+// which goes through the Atlassian API gateway rather than the site.
+func (c *Credentials) APIURL() string {
+	return "https://api.atlassian.com/ex/jira/" + c.CloudID + "/"
+}
+
+// Matches reports whether the credentials are for the site URL.
+func (c *Credentials) Matches(siteURL string) bool {
+	return sameSite(c.SiteURL, siteURL)
+}
+
+func sameSite(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "/"), strings.TrimSuffix(b, "/"))
+}
+
+// Login runs the authorization code flow for the app. It calls open with the
+// URL the user authorizes the app at, waits for the browser to be redirected
+// back to a listener on the port, exchanges the code for tokens, and looks up
+// the cloud ID of the site. The app's callback URL must be
+// http://localhost:<port>/callback.
+func Login(ctx context.Context, clientID, clientSecret, siteURL string, port int, open func(url string) error) (*Credentials, error) {
+	creds := &Credentials{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  fmt.Sprintf("http://localhost:%d/callback", port),
+		SiteURL:      strings.TrimSuffix(siteURL, "/"),
+	}
+	config := creds.config()
+
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed generating state: %w", err)
+	}
+	state := hex.EncodeToString(nonce)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed listening for the callback: %w", err)
+	}
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		switch {
+		case query.Get("state") != state:
+			http.Error(w, "Authorization state does not match, try again.", http.StatusBadRequest)
+			return
+		case query.Get("error") != "":
+			fmt.Fprintln(w, "Authorization was refused, you can close this window.")
+			failures <- fmt.Errorf("authorization refused: %s %s", query.Get("error"), query.Get("error_description"))
+			return
+		}
+		fmt.Fprintln(w, "Authorized, you can close this window.")
+		codes <- query.Get("code")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authURL := config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("audience", "api.atlassian.com"),
+		oauth2.SetAuthURLParam("prompt", "consent"),
+	)
+	err = open(authURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var code string
+	select {
+	case code = <-codes:
+	case err = <-failures:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	creds.Token, err = config.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed exchanging authorization code: %w", err)
+	}
+
+	creds.CloudID, creds.SiteURL, err = findSite(ctx, config.Client(ctx, creds.Token), creds.SiteURL)
+	if err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// findSite returns the cloud ID and URL of the site the token grants access
+// to, which must be siteURL unless it is empty and the token grants a single
+// site.
+func findSite(ctx context.Context, client *http.Client, siteURL string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourcesURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed listing accessible sites: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed listing accessible sites: %s", resp.Status)
+	}
+	var sites []struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&sites)
+	if err != nil {
+		return "", "", fmt.Errorf("failed decoding accessible sites: %w", err)
+	}
+
+	if len(sites) == 0 {
+		return "", "", fmt.Errorf("the app was not authorized for any Jira site")
+	}
+	var urls []string
+	for _, site := range sites {
+		if (siteURL == "" && len(sites) == 1) || sameSite(site.URL, siteURL) {
+			return site.ID, site.URL, nil
+		}
+		urls = append(urls, site.URL)
+	}
+	if siteURL == "" {
+		return "", "", fmt.Errorf("the app was authorized for %d sites, choose one of %s with --jira-url", len(sites), strings.Join(urls, ", "))
+	}
+	return "", "", fmt.Errorf("the app was not authorized for %s, only %s", siteURL, strings.Join(urls, ", "))
+}
+
+// Load reads the credentials stored by Save.
+func Load() (*Credentials, error) {
+	bytes, err := os.ReadFile(TokenFile)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no OAuth credentials in %s, run login first", TokenFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading OAuth credentials: %w", err)
+	}
+	creds := &Credentials{}
+	err = json.Unmarshal(bytes, creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling OAuth credentials: %w", err)
+	}
+	if creds.Token == nil || creds.CloudID == "" {
+		return nil, fmt.Errorf("incomplete OAuth credentials in %s, run login again", TokenFile)
+	}
+	return creds, nil
+}
+
+// Save writes the credentials readable only by their owner.
+func Save(creds *Credentials) error {
+	bytes, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshalling OAuth credentials: %w", err)
+	}
+	err = os.WriteFile(TokenFile, bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing OAuth credentials: %w", err)
+	}
+	return nil
+}
+
+// Client returns an HTTP client that authorizes requests sent through base
+// with the access token, refreshing it when it expires. Atlassian rotates the
+// refresh token on every refresh, so each new token is saved as soon as it is
+// issued.
+func (c *Credentials) Client(base http.RoundTripper) *http.Client {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: http.DefaultTransport})
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: &savingSource{creds: c, source: c.config().TokenSource(ctx, c.Token)},
+			Base:   base,
+		},
+	}
+}
+
+// savingSource saves the credentials whenever its source issues a new token.
+type savingSource struct {
+	mu     sync.Mutex
+	creds  *Credentials
+	source oauth2.TokenSource
+}
+
+func (s *savingSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed refreshing OAuth token, run login again: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.creds.Token.AccessToken {
+		s.creds.Token = token
+		err = Save(s.creds)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
@@ -0,0 +1,116 @@
+// Package objectstore streams archives to and from S3, Google Cloud Storage,
+// and Azure Blob Storage, addressed by s3://, gs://, and azblob:// URLs.
+// Credentials are taken from each provider's usual environment variables and
+// configuration files.
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+var schemes = []string{"s3://", "gs://", "azblob://"}
+
+// IsURL reports whether path is an object storage URL rather than a local
+// path.
+func IsURL(path string) bool {
+	for _, scheme := range schemes {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse splits the object URL into the URL of its bucket and the key of the
+// object in it. Query parameters, such as an S3 region, configure the
+// bucket.
+func parse(rawURL string) (string, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid object storage URL %s: %w", rawURL, err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("invalid object storage URL %s, must name a bucket and an object such as s3://bucket/migration.tgz", rawURL)
+	}
+	bucketURL := u.Scheme + "://" + u.Host
+	if u.RawQuery != "" {
+		bucketURL += "?" + u.RawQuery
+	}
+	return bucketURL, key, nil
+}
+
+// Valid checks the object URL names a bucket and an object.
+func Valid(rawURL string) error {
+	_, _, err := parse(rawURL)
+	return err
+}
+
+// openBucket opens the bucket of the object URL and returns the key of the
+// object in it.
+func openBucket(ctx context.Context, rawURL string) (*blob.Bucket, string, error) {
+	bucketURL, key, err := parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed opening bucket %s: %w", bucketURL, err)
+	}
+	return bucket, key, nil
+}
+
+// object closes the bucket along with the reader or writer of its object.
+type object struct {
+	io.Reader
+	io.Writer
+	closer io.Closer
+	bucket *blob.Bucket
+}
+
+func (o *object) Close() error {
+	err := o.closer.Close()
+	bucketErr := o.bucket.Close()
+	if err != nil {
+		return err
+	}
+	return bucketErr
+}
+
+// Open returns a reader streaming the object at the URL.
+func Open(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	bucket, key, err := openBucket(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	r, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		bucket.Close()
+		return nil, fmt.Errorf("failed opening %s: %w", rawURL, err)
+	}
+	return &object{Reader: r, closer: r, bucket: bucket}, nil
+}
+
+// Create returns a writer streaming to the object at the URL. The object is
+// only committed when the writer is closed without error.
+func Create(ctx context.Context, rawURL string) (io.WriteCloser, error) {
+	bucket, key, err := openBucket(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	w, err := bucket.NewWriter(ctx, key, nil)
+	if err != nil {
+		bucket.Close()
+		return nil, fmt.Errorf("failed creating %s: %w", rawURL, err)
+	}
+	return &object{Writer: w, closer: w, bucket: bucket}, nil
+}
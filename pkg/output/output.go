@@ -0,0 +1,67 @@
+// Package output prints the progress of the migrator at the level chosen
+// with -q, -v, and -vv, with secrets redacted. Progress of the run as a whole
+// is printed with fmt.Printf as before and silenced by Quiet, while the
+// per-attachment and per-page progress printed with Detailf and the HTTP
+// requests printed with Debugf only show at the higher levels.
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Level is how much of the progress of a run is printed.
+type Level int
+
+const (
+	// LevelQuiet prints errors only.
+	LevelQuiet Level = iota
+	// LevelNormal prints the progress of the run as a whole and a summary
+	// of what it did.
+	LevelNormal
+	// LevelVerbose prints every attachment and page processed as well.
+	LevelVerbose
+	// LevelDebug prints every HTTP request sent as well.
+	LevelDebug
+)
+
+// Verbosity is the level of the run.
+var Verbosity = LevelNormal
+
+// Console is where errors and confirmation prompts are printed, which is
+// standard output even when Quiet silences it.
+var Console io.Writer = os.Stdout
+
+// Quiet sets the run to LevelQuiet, discarding everything printed to
+// standard output except through Console and Errorf.
+func Quiet() error {
+	discard, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed opening %s: %w", os.DevNull, err)
+	}
+	Verbosity = LevelQuiet
+	Console = os.Stdout
+	os.Stdout = discard
+	return nil
+}
+
+// Errorf prints an error of the run, at every level.
+func Errorf(format string, a ...interface{}) {
+	fmt.Fprintf(Console, format, a...)
+}
+
+// Detailf prints the progress of a single attachment or page, at
+// LevelVerbose and above.
+func Detailf(format string, a ...interface{}) {
+	if Verbosity >= LevelVerbose {
+		fmt.Printf(format, a...)
+	}
+}
+
+// Debugf prints an HTTP request, at LevelDebug.
+func Debugf(format string, a ...interface{}) {
+	if Verbosity >= LevelDebug {
+		fmt.Printf(format, a...)
+	}
+}
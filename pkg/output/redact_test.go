@@ -0,0 +1,51 @@
+package output
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// redactWrites writes each of the writes to a redirect in turn, so each is
+// read from the pipe on its own, and returns what the redirect printed.
+func redactWrites(t *testing.T, writes ...string) string {
+	r, w := io.Pipe()
+	d := &redirect{lineStart: true}
+	var out strings.Builder
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.copy(&out, r)
+	}()
+	for _, s := range writes {
+		_, err := io.WriteString(w, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	<-done
+	return out.String()
+}
+
+// TestRedactAcrossReads checks secrets and headers split across two reads
+// of the pipe are masked.
+func TestRedactAcrossReads(t *testing.T) {
+	AddSecret("s3cr3t-t0ken-value")
+
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"secret", []string{"uploading with s3cr3t-t0k", "en-value to JIRA\n"}, "uploading with *** to JIRA\n"},
+		{"authorization header", []string{"GET /rest/api/2/myself\nAuthorization: Bearer eyJhbGci", "OiJIUzI1NiJ9\nAccept: */*\n"}, "GET /rest/api/2/myself\nAuthorization: Bearer ***\nAccept: */*\n"},
+		{"unterminated line", []string{"token is s3cr3t-", "t0ken-value"}, "token is ***"},
+	}
+	for _, test := range tests {
+		got := redactWrites(t, test.writes...)
+		if got != test.want {
+			t.Errorf("%s: printed %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package screen checks attachment files for malware before they are
+// uploaded, through a ClamAV daemon or an external scanning command.
+package screen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Scanner checks a file for malware.
+type Scanner interface {
+	// Name identifies the scanner in the reasons recorded for flagged
+	// files.
+	Name() string
+	// Scan returns what the scanner found in the file at path, or an empty
+	// string when the file is clean. An error means the file could not be
+	// scanned, not that it is infected.
+	Scan(path string) (string, error)
+}
+
+// executableTypes are the MIME types of programs and scripts, as detected by
+// the filetype package or registered for their extensions.
+var executableTypes = []string{
+	"application/vnd.microsoft.portable-executable",
+	"application/x-msdownload",
+	"application/x-msdos-program",
+	"application/x-dosexec",
+	"application/x-executable",
+	"application/x-mach-binary",
+	"application/x-sharedlib",
+	"application/x-sh",
+	"application/x-msi",
+	"text/x-shellscript",
+}
+
+// IsExecutable reports whether the MIME type is that of a program or script.
+func IsExecutable(contentType string) bool {
+	for _, executable := range executableTypes {
+		if strings.EqualFold(contentType, executable) {
+			return true
+		}
+	}
+	return false
+}
+
+// clamdChunkSize is the largest chunk streamed to clamd at a time, below its
+// default StreamMaxLength.
+const clamdChunkSize = 64 * 1024
+
+// Clamd scans files by streaming them to a ClamAV daemon with the INSTREAM
+// command.
+type Clamd struct {
+	// Network is unix or tcp and Address the socket path or host:port.
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamd returns a scanner of the clamd listening at address, a unix
+// socket path or a tcp://host:port URL.
+func NewClamd(address string) *Clamd {
+	if strings.HasPrefix(address, "tcp://") {
+		return &Clamd{Network: "tcp", Address: strings.TrimPrefix(address, "tcp://"), Timeout: 5 * time.Minute}
+	}
+	return &Clamd{Network: "unix", Address: address, Timeout: 5 * time.Minute}
+}
+
+func (c *Clamd) Name() string {
+	return "clamd"
+}
+
+func (c *Clamd) Scan(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	conn, err := net.DialTimeout(c.Network, c.Address, 30*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed connecting to clamd at %s: %w", c.Address, err)
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(c.Timeout))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return "", fmt.Errorf("failed sending to clamd: %w", err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			_, werr := conn.Write(append(size, buf[:n]...))
+			if werr != nil {
+				return "", fmt.Errorf("failed sending to clamd: %s", werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+	if err != nil {
+		return "", fmt.Errorf("failed sending to clamd: %w", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("failed reading the clamd reply: %w", err)
+	}
+	result := strings.TrimSpace(strings.TrimPrefix(string(bytes.TrimRight(reply, "\x00")), "stream:"))
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd could not scan the file: %s", result)
+}
+
+// Command scans files by running an external command, such as clamscan, with
+// the file's path. The command exits 0 for a clean file and 1 for an
+// infected one, as clamscan and most scanners do; any other exit status is
+// a failure to scan.
+type Command struct {
+	args []string
+}
+
+// ParseCommand splits the command line on spaces. The path of the file to
+// scan replaces {file} or, when the command has no {file}, is appended.
+func ParseCommand(command string) (*Command, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the scan command is empty")
+	}
+	return &Command{args: args}, nil
+}
+
+func (c *Command) Name() string {
+	return filepath.Base(c.args[0])
+}
+
+func (c *Command) Scan(path string) (string, error) {
+	args := make([]string, 0, len(c.args)+1)
+	substituted := false
+	for _, arg := range c.args[1:] {
+		if strings.Contains(arg, "{file}") {
+			arg = strings.ReplaceAll(arg, "{file}", path)
+			substituted = true
+		}
+		args = append(args, arg)
+	}
+	if !substituted {
+		args = append(args, path)
+	}
+
+	output, err := exec.Command(c.args[0], args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		finding := strings.TrimPrefix(verdict(output), path+": ")
+		if finding == "" {
+			finding = "flagged by " + c.Name()
+		}
+		return finding, nil
+	}
+	return "", fmt.Errorf("%s failed: %w: %s", c.Name(), err, verdict(output))
+}
+
+// verdict returns the line of the output naming what was found, as clamscan
+// prints it, or else the last line, where most scanners print their verdict.
+func verdict(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	for _, line := range lines {
+		if strings.HasSuffix(strings.TrimSpace(line), " FOUND") {
+			return strings.TrimSpace(line)
+		}
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,172 @@
+// Package secret resolves references to secrets held by an external secret
+// manager, such as vault://secret/data/migrator#jira, into their values, so
+// raw tokens never need to be exported to the migrator's environment.
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// RequestTimeout bounds each request to a secret manager.
+const RequestTimeout = 30 * time.Second
+
+// Client sends the requests of the providers. It is replaced with a client
+// going through the proxy and certificate authorities of the run before
+// references are resolved.
+var Client = &http.Client{Timeout: RequestTimeout}
+
+// Provider returns the secret at path, picking the key out of a secret
+// holding several values unless key is empty.
+type Provider func(ctx context.Context, path, key string) (string, error)
+
+// Providers are the secret managers references can point at, by URL scheme.
+// Other providers can be added before references are resolved.
+var Providers = map[string]Provider{
+	"vault": Vault,
+	"awssm": AWSSecretsManager,
+}
+
+// IsReference reports whether value is a reference to a secret of one of the
+// providers rather than the secret itself.
+func IsReference(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	if !ok {
+		return false
+	}
+	_, ok = Providers[scheme]
+	return ok
+}
+
+// Resolve returns the secret a reference of the form scheme://path#key points
+// at.
+func Resolve(ctx context.Context, reference string) (string, error) {
+	scheme, rest, _ := strings.Cut(reference, "://")
+	provider, ok := Providers[scheme]
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider %q", scheme)
+	}
+	path, key, _ := strings.Cut(rest, "#")
+	if path == "" {
+		return "", fmt.Errorf("secret reference %s has no path", reference)
+	}
+	value, err := provider(ctx, path, key)
+	if err != nil {
+		return "", fmt.Errorf("failed reading %s://%s: %w", scheme, path, err)
+	}
+	return value, nil
+}
+
+// pick returns the value of key from a secret holding several values. With no
+// key, the secret must hold exactly one.
+func pick(values map[string]interface{}, key string) (string, error) {
+	if key == "" {
+		if len(values) != 1 {
+			keys := make([]string, 0, len(values))
+			for k := range values {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return "", fmt.Errorf("the secret holds %d values, choose one of %s with #key", len(values), strings.Join(keys, ", "))
+		}
+		for k := range values {
+			key = k
+		}
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("the secret has no key %q", key)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("the value of key %q is not a string", key)
+	}
+	return s, nil
+}
+
+// Vault reads a secret from HashiCorp Vault at VAULT_ADDR, authenticated with
+// VAULT_TOKEN and scoped to VAULT_NAMESPACE when set. The path is the API
+// path of the secret, which includes data/ for the KV version 2 engine, such
+// as secret/data/migrator.
+func Vault(ctx context.Context, path, key string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	endpoint, err := url.JoinPath(addr, "v1", path)
+	if err != nil {
+		return "", fmt.Errorf("invalid VAULT_ADDR %s: %w", addr, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("failed decoding secret: %w", err)
+	}
+	values := body.Data
+	// KV version 2 nests the values under data alongside the metadata.
+	if nested, ok := values["data"].(map[string]interface{}); ok && values["metadata"] != nil {
+		values = nested
+	}
+	return pick(values, key)
+}
+
+// AWSSecretsManager reads a secret from AWS Secrets Manager by name or ARN,
+// with the credentials and region of the AWS environment, such as
+// AWS_PROFILE and AWS_REGION. A secret stored as a JSON object has one of its
+// keys picked out; any other secret is returned whole.
+func AWSSecretsManager(ctx context.Context, name, key string) (string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithHTTPClient(Client))
+	if err != nil {
+		return "", fmt.Errorf("failed loading AWS configuration: %w", err)
+	}
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("the secret is binary rather than a string")
+	}
+	value := *out.SecretString
+
+	var values map[string]interface{}
+	if json.Unmarshal([]byte(value), &values) != nil {
+		if key != "" {
+			return "", fmt.Errorf("the secret is not a JSON object, so key %q cannot be picked out of it", key)
+		}
+		return value, nil
+	}
+	return pick(values, key)
+}
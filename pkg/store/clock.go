@@ -0,0 +1,39 @@
+package store
+
+import (
+	"time"
+)
+
+// MaxClockSkew is the difference between the local and JIRA server clocks
+// beyond which timestamps are reported as skewed.
+const MaxClockSkew = time.Minute
+
+// ServerClock records the JIRA server's time zone and how far its clock is
+// ahead of the local clock, so timestamps shown to JIRA users match the
+// server rather than the machine running the migration.
+type ServerClock struct {
+	Zone    string        `json:"zone"`
+	Offset  int           `json:"offset"`
+	Skew    time.Duration `json:"skew"`
+	Checked time.Time     `json:"checked"`
+}
+
+// Skewed reports whether the clocks differ by more than MaxClockSkew.
+func (c *ServerClock) Skewed() bool {
+	return c.Skew > MaxClockSkew || c.Skew < -MaxClockSkew
+}
+
+// Location returns the server's time zone. JIRA reports a fixed offset
+// rather than a zone name, so the zone is named after the offset.
+func (c *ServerClock) Location() *time.Location {
+	return time.FixedZone(c.Zone, c.Offset)
+}
+
+// ServerTime converts a local timestamp to the server's clock and zone.
+// A nil clock leaves the timestamp in UTC.
+func (c *ServerClock) ServerTime(t time.Time) time.Time {
+	if c == nil {
+		return t.UTC()
+	}
+	return t.Add(c.Skew).In(c.Location())
+}
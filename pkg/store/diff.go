@@ -0,0 +1,134 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Diff is what changed between two databases, such as before and after a
+// re-collect.
+type Diff struct {
+	Added       []*AttachmentRef `json:"added"`
+	Removed     []*AttachmentRef `json:"removed"`
+	Changed     []*AttachmentRef `json:"changed"`
+	Matches     []*MatchChange   `json:"matches"`
+	Regressions []*Regression    `json:"regressions"`
+}
+
+// AttachmentRef identifies an attachment in a diff.
+type AttachmentRef struct {
+	Path  string `json:"path"`
+	Issue int    `json:"issue"`
+}
+
+// MatchChange is an issue matched to a different ticket. An empty key means
+// the issue matched no ticket.
+type MatchChange struct {
+	Title string `json:"title"`
+	Issue int    `json:"issue"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Regression is upload progress recorded in the earlier database that the
+// later one has lost, meaning work will be repeated or was undone.
+type Regression struct {
+	Subject string `json:"subject"`
+	Reason  string `json:"reason"`
+}
+
+// IsEmpty reports whether the databases are the same as far as the
+// migration is concerned.
+func (d *Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && len(d.Matches) == 0 && len(d.Regressions) == 0
+}
+
+// Compare returns the attachments added, removed, and changed in content
+// from before to after, the issues matched to different tickets, and the
+// upload status that regressed.
+func Compare(before, after *Database) *Diff {
+	d := &Diff{}
+
+	oldAttachments := make(map[attachmentID]*Attachment)
+	for _, attachment := range before.Attachments {
+		oldAttachments[attachmentID{attachment.Path, attachment.IssueNumber}] = attachment
+	}
+	seen := make(map[attachmentID]bool)
+	for _, attachment := range after.Attachments {
+		id := attachmentID{attachment.Path, attachment.IssueNumber}
+		seen[id] = true
+		ref := &AttachmentRef{Path: attachment.Path, Issue: attachment.IssueNumber}
+		prior, ok := oldAttachments[id]
+		if !ok {
+			d.Added = append(d.Added, ref)
+			continue
+		}
+		if prior.Checksum != attachment.Checksum {
+			d.Changed = append(d.Changed, ref)
+		}
+		subject := fmt.Sprintf("attachment %s on issue #%d", attachment.Path, attachment.IssueNumber)
+		if len(prior.JIRAIDs) > 0 && len(attachment.JIRAIDs) == 0 {
+			d.Regressions = append(d.Regressions, &Regression{Subject: subject, Reason: "is no longer recorded as uploaded and will be uploaded again"})
+		}
+		if prior.Failure == nil && attachment.Failure != nil {
+			d.Regressions = append(d.Regressions, &Regression{Subject: subject, Reason: fmt.Sprintf("is quarantined after failing: %s", attachment.Failure.Error)})
+		}
+	}
+	for id, attachment := range oldAttachments {
+		if !seen[id] {
+			d.Removed = append(d.Removed, &AttachmentRef{Path: attachment.Path, Issue: attachment.IssueNumber})
+		}
+	}
+
+	titles := make(map[string]bool)
+	for title := range before.Issues {
+		titles[title] = true
+	}
+	for title := range after.Issues {
+		titles[title] = true
+	}
+	for title := range titles {
+		oldTicket, newTicket := before.Tickets[title], after.Tickets[title]
+		var oldKey, newKey string
+		if oldTicket != nil {
+			oldKey = oldTicket.Key
+		}
+		if newTicket != nil {
+			newKey = newTicket.Key
+		}
+		issue := after.Issues[title]
+		if issue == nil {
+			issue = before.Issues[title]
+		}
+		if oldKey != newKey {
+			d.Matches = append(d.Matches, &MatchChange{Title: title, Issue: issue.Number, Old: oldKey, New: newKey})
+			continue
+		}
+		if oldTicket != nil && newTicket != nil && oldTicket.Uploaded && !newTicket.Uploaded {
+			d.Regressions = append(d.Regressions, &Regression{
+				Subject: fmt.Sprintf("ticket %s of issue #%d", newKey, issue.Number),
+				Reason:  "is no longer marked uploaded and will be uploaded again",
+			})
+		}
+	}
+
+	sortRefs(d.Added)
+	sortRefs(d.Removed)
+	sortRefs(d.Changed)
+	sort.Slice(d.Matches, func(i, j int) bool {
+		return d.Matches[i].Issue < d.Matches[j].Issue
+	})
+	sort.SliceStable(d.Regressions, func(i, j int) bool {
+		return d.Regressions[i].Subject < d.Regressions[j].Subject
+	})
+	return d
+}
+
+func sortRefs(refs []*AttachmentRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Issue != refs[j].Issue {
+			return refs[i].Issue < refs[j].Issue
+		}
+		return refs[i].Path < refs[j].Path
+	})
+}
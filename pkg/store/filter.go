@@ -0,0 +1,109 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IssueFilter restricts processing to a subset of GitHub issue numbers.
+type IssueFilter struct {
+	only []issueRange
+	skip []issueRange
+	// selected, when not nil, further restricts the filter to its issues.
+	selected map[int]bool
+}
+
+type issueRange struct {
+	from int
+	to   int
+}
+
+// ParseIssueFilter builds a filter from comma separated issue numbers and
+// ranges such as "12,45,102-140". An only list of "all" and a skip list of
+// "none" leave the filter open.
+func ParseIssueFilter(only, skip string) (*IssueFilter, error) {
+	filter := &IssueFilter{}
+	if only != "all" {
+		ranges, err := parseIssueRanges(only)
+		if err != nil {
+			return nil, fmt.Errorf("invalid issue list %q: %w", only, err)
+		}
+		filter.only = ranges
+	}
+	if skip != "none" {
+		ranges, err := parseIssueRanges(skip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid issue list %q: %w", skip, err)
+		}
+		filter.skip = ranges
+	}
+	return filter, nil
+}
+
+func parseIssueRanges(list string) ([]issueRange, error) {
+	var ranges []issueRange
+	for _, token := range strings.Split(strings.ReplaceAll(list, " ", ""), ",") {
+		if token == "" {
+			continue
+		}
+		fromToken, toToken, isRange := strings.Cut(token, "-")
+		from, err := strconv.Atoi(fromToken)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			to, err = strconv.Atoi(toToken)
+			if err != nil {
+				return nil, err
+			}
+			if to < from {
+				return nil, fmt.Errorf("range %s is reversed", token)
+			}
+		}
+		ranges = append(ranges, issueRange{from: from, to: to})
+	}
+	return ranges, nil
+}
+
+// Select restricts the filter to the issue numbers, on top of its only and
+// skip lists.
+func (f *IssueFilter) Select(numbers []int) {
+	f.selected = make(map[int]bool)
+	for _, number := range numbers {
+		f.selected[number] = true
+	}
+}
+
+// Allows reports whether the issue number passes the filter.
+func (f *IssueFilter) Allows(number int) bool {
+	if f.selected != nil && !f.selected[number] {
+		return false
+	}
+	for _, r := range f.skip {
+		if number >= r.from && number <= r.to {
+			return false
+		}
+	}
+	if len(f.only) == 0 {
+		return true
+	}
+	for _, r := range f.only {
+		if number >= r.from && number <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the attachments whose issue numbers pass the filter.
+func (f *IssueFilter) Apply(attachments []*Attachment) []*Attachment {
+	filtered := []*Attachment{}
+	for _, attachment := range attachments {
+		if f.Allows(attachment.IssueNumber) {
+			filtered = append(filtered, attachment)
+		}
+	}
+	return filtered
+}
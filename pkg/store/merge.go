@@ -0,0 +1,194 @@
+package store
+
+import (
+	"fmt"
+)
+
+// attachmentID identifies an attachment across collections of the same
+// repository.
+type attachmentID struct {
+	path  string
+	issue int
+}
+
+// MergeDelta merges the database collected by an incremental run, holding
+// only the issues updated since the previous run, into the previous
+// database. Attachments already in the previous database keep their upload
+// state and decisions unless their content changed, and the tickets of
+// issues receiving new or changed attachments are marked for upload again.
+// The merged database records the collection time of the delta. It returns
+// the number of attachments added or changed.
+func MergeDelta(previous, delta *Database) (int, error) {
+	switch {
+	case previous.TargetName() != delta.TargetName():
+		return 0, fmt.Errorf("the existing database was collected for the %s target, not %s", previous.TargetName(), delta.TargetName())
+	case previous.Algorithm() != delta.Algorithm():
+		return 0, fmt.Errorf("the existing database uses %s checksums, not %s", previous.Algorithm(), delta.Algorithm())
+	case previous.Unstaged != delta.Unstaged:
+		return 0, fmt.Errorf("the existing database and the incremental collect must both use --no-stage or neither")
+	}
+
+	existing := make(map[attachmentID]int)
+	for i, attachment := range previous.Attachments {
+		existing[attachmentID{attachment.Path, attachment.IssueNumber}] = i
+	}
+	changedIssues := make(map[int]bool)
+	changed := 0
+	for _, attachment := range delta.Attachments {
+		i, ok := existing[attachmentID{attachment.Path, attachment.IssueNumber}]
+		if ok {
+			prior := previous.Attachments[i]
+			if prior.Checksum == attachment.Checksum {
+				continue
+			}
+			attachment.Deleted = prior.Deleted
+			attachment.History = prior.History
+			previous.Attachments[i] = attachment
+		} else {
+			previous.Attachments = append(previous.Attachments, attachment)
+		}
+		changedIssues[attachment.IssueNumber] = true
+		changed++
+	}
+
+	for title, issue := range delta.Issues {
+		previous.Issues[title] = issue
+		ticket, ok := delta.Tickets[title]
+		if !ok {
+			continue
+		}
+		prior, ok := previous.Tickets[title]
+		if ok && (prior.Key == ticket.Key || ticket.Key == "") {
+			ticket = prior
+		}
+		if changedIssues[issue.Number] {
+			ticket.Uploaded = false
+		}
+		previous.Tickets[title] = ticket
+	}
+
+	previous.Collected = delta.Collected
+	return changed, nil
+}
+
+// Conflict is a key two merged databases disagree on.
+type Conflict struct {
+	Key    string
+	Reason string
+}
+
+// Merge unions the attachments, issues, and tickets of databases collected
+// separately, such as by operators collecting different repositories or
+// shards of the same repository's issues. Records present in several
+// databases are combined, keeping whichever upload status shows more
+// progress. Records that disagree, such as an issue number claimed by two
+// different issues or a title matched to two different tickets, are returned
+// as conflicts along with a nil database.
+func Merge(dbs ...*Database) (*Database, []*Conflict, error) {
+	if len(dbs) == 0 {
+		return nil, nil, fmt.Errorf("no databases to merge")
+	}
+	first := dbs[0]
+	for _, db := range dbs[1:] {
+		switch {
+		case db.TargetName() != first.TargetName():
+			return nil, nil, fmt.Errorf("databases collected for the %s and %s targets cannot be merged", first.TargetName(), db.TargetName())
+		case db.Algorithm() != first.Algorithm():
+			return nil, nil, fmt.Errorf("databases using %s and %s checksums cannot be merged", first.Algorithm(), db.Algorithm())
+		case db.Unstaged != first.Unstaged:
+			return nil, nil, fmt.Errorf("databases collected with and without --no-stage cannot be merged")
+		}
+	}
+
+	merged := New(first.HashAlgorithm)
+	merged.Target = first.Target
+	merged.Unstaged = first.Unstaged
+	var conflicts []*Conflict
+	conflict := func(key, format string, a ...interface{}) {
+		conflicts = append(conflicts, &Conflict{Key: key, Reason: fmt.Sprintf(format, a...)})
+	}
+
+	numbers := make(map[int]string)
+	attachments := make(map[attachmentID]int)
+	for _, db := range dbs {
+		if merged.DedupPolicy == "" {
+			merged.DedupPolicy = db.DedupPolicy
+		}
+		if merged.ServerClock == nil {
+			merged.ServerClock = db.ServerClock
+		}
+		if db.Collected.After(merged.Collected) {
+			merged.Collected = db.Collected
+		}
+
+		for title, issue := range db.Issues {
+			if url, ok := numbers[issue.Number]; ok && url != issue.URL {
+				conflict(fmt.Sprintf("issue #%d", issue.Number), "claimed by %s and %s", url, issue.URL)
+				continue
+			}
+			numbers[issue.Number] = issue.URL
+			if prior, ok := merged.Issues[title]; ok && prior.Number != issue.Number {
+				conflict(fmt.Sprintf("issue %q", title), "titles issues #%d and #%d", prior.Number, issue.Number)
+				continue
+			}
+			merged.Issues[title] = issue
+		}
+
+		for title, ticket := range db.Tickets {
+			prior, ok := merged.Tickets[title]
+			if !ok {
+				copied := *ticket
+				merged.Tickets[title] = &copied
+				continue
+			}
+			if prior.Key != ticket.Key && prior.Key != "" && ticket.Key != "" {
+				conflict(fmt.Sprintf("ticket of %q", title), "matched to %s and %s", prior.Key, ticket.Key)
+				continue
+			}
+			if prior.Key == "" {
+				prior.Key = ticket.Key
+			}
+			prior.Uploaded = prior.Uploaded || ticket.Uploaded
+			if prior.ProvenanceComment == "" {
+				prior.ProvenanceComment = ticket.ProvenanceComment
+			}
+		}
+
+		for _, attachment := range db.Attachments {
+			id := attachmentID{attachment.Path, attachment.IssueNumber}
+			i, ok := attachments[id]
+			if !ok {
+				attachments[id] = len(merged.Attachments)
+				merged.Attachments = append(merged.Attachments, attachment)
+				continue
+			}
+			prior := merged.Attachments[i]
+			if prior.Checksum != attachment.Checksum {
+				conflict(fmt.Sprintf("attachment %s on issue #%d", attachment.Path, attachment.IssueNumber), "has checksums %s and %s", prior.Checksum, attachment.Checksum)
+				continue
+			}
+			merged.Attachments[i] = progressed(prior, attachment)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, conflicts, nil
+	}
+	return merged, nil, nil
+}
+
+// progressed returns whichever copy of an attachment records more upload
+// progress, with the decision history of the copy that has more of it.
+func progressed(a, b *Attachment) *Attachment {
+	winner, other := a, b
+	if len(b.Sent) > len(a.Sent) || len(b.JIRAIDs) > len(a.JIRAIDs) || (a.Failure != nil && b.Failure == nil) {
+		winner, other = b, a
+	}
+	if len(other.History) > len(winner.History) {
+		copied := *winner
+		copied.Deleted = other.Deleted
+		copied.History = other.History
+		winner = &copied
+	}
+	return winner
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+)
+
+// SchemaVersion is the version of the database layout written by this
+// release. It is incremented whenever the layout changes in a way older
+// databases need upgrading for, with an upgrade added to upgrades.
+const SchemaVersion = 2
+
+// upgrades bring a database from the version at their index to the next.
+// They are passed the database as read from disk for the fields that were
+// renamed or dropped since.
+var upgrades = []func(db *Database, raw []byte) error{
+	upgradeUnversioned,
+	upgradeChecksumNames,
+}
+
+// upgrade brings a database written by an earlier release up to the current
+// schema in place, and refuses databases written by a later release, whose
+// fields this release would silently drop when saving.
+func upgrade(db *Database, raw []byte) error {
+	if db.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("the database has schema version %d but this release only supports up to version %d, upgrade the migrator", db.SchemaVersion, SchemaVersion)
+	}
+	if db.SchemaVersion < 0 {
+		return fmt.Errorf("invalid schema version %d", db.SchemaVersion)
+	}
+	for db.SchemaVersion < SchemaVersion {
+		err := upgrades[db.SchemaVersion](db, raw)
+		if err != nil {
+			return fmt.Errorf("failed upgrading from schema version %d: %w", db.SchemaVersion, err)
+		}
+		db.SchemaVersion++
+	}
+	return nil
+}
+
+// upgradeUnversioned upgrades databases written before versioning, which may
+// predate the per-attachment checksums. Checksums are computed for the staged
+// attachments lacking one so dedup and verification work for them, and the
+// collections omitted by the oldest releases are created.
+func upgradeUnversioned(db *Database, raw []byte) error {
+	if db.Attachments == nil {
+		db.Attachments = []*Attachment{}
+	}
+	if db.Issues == nil {
+		db.Issues = make(map[string]*Issue)
+	}
+	if db.Tickets == nil {
+		db.Tickets = make(map[string]*Ticket)
+	}
+	err := upgradeChecksumNames(db, raw)
+	if err != nil {
+		return err
+	}
+	if db.Unstaged {
+		return nil
+	}
+	for _, attachment := range db.Attachments {
+		if attachment.Checksum != "" || len(attachment.Parts) > 0 {
+			continue
+		}
+		file, err := os.Open(filepath.Join(StageDir, attachment.Path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		attachment.Checksum, err = checksum.Sum(file, db.Algorithm())
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed hashing %s: %w", attachment.Path, err)
+		}
+	}
+	return nil
+}
+
+// legacyChecksums holds the checksum fields of a database written before
+// schema version 2, which named them after SHA-256 whichever algorithm
+// computed them.
+type legacyChecksums struct {
+	Attachments []struct {
+		SHA256           string `json:"sha256"`
+		ArchiveSHA256    string `json:"archive_sha256"`
+		DownscaledSHA256 string `json:"downscaled_sha256"`
+		Sent             []struct {
+			SHA256 string `json:"sha256"`
+		} `json:"sent"`
+	} `json:"attachments"`
+}
+
+// upgradeChecksumNames upgrades databases from version 1, copying the
+// checksums recorded under their SHA-256 names into the renamed fields.
+func upgradeChecksumNames(db *Database, raw []byte) error {
+	var legacy legacyChecksums
+	err := json.Unmarshal(raw, &legacy)
+	if err != nil {
+		return err
+	}
+	if len(legacy.Attachments) != len(db.Attachments) {
+		return fmt.Errorf("found %d attachments with legacy checksums but %d attachments", len(legacy.Attachments), len(db.Attachments))
+	}
+	for i, attachment := range db.Attachments {
+		old := legacy.Attachments[i]
+		if attachment.Checksum == "" {
+			attachment.Checksum = old.SHA256
+		}
+		if attachment.ArchiveChecksum == "" {
+			attachment.ArchiveChecksum = old.ArchiveSHA256
+		}
+		if attachment.DownscaledChecksum == "" {
+			attachment.DownscaledChecksum = old.DownscaledSHA256
+		}
+		for j, sent := range attachment.Sent {
+			if sent.Checksum == "" && j < len(old.Sent) {
+				sent.Checksum = old.Sent[j].SHA256
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+// TestLoadUpgradesChecksumNames loads a database written at schema version 1,
+// which recorded checksums under their SHA-256 names whichever algorithm
+// computed them, and checks they are read into the renamed fields.
+func TestLoadUpgradesChecksumNames(t *testing.T) {
+	useWorkspace(t)
+
+	legacy := `{
+	"schema_version": 1,
+	"hash_algorithm": "blake3",
+	"attachments": [{
+		"type": "issue",
+		"issue_number": 1,
+		"path": "attachments/1/crash.png",
+		"sha256": "aaa",
+		"archive_sha256": "bbb",
+		"downscaled": "downscaled/attachments/1/crash.png",
+		"downscaled_sha256": "ccc",
+		"jira_ids": ["10"],
+		"sent": [{"name": "crash.png", "sha256": "ccc", "bytes": 3, "jira_id": "10", "jira_size": 3}]
+	}],
+	"issues": {},
+	"tickets": {}
+}`
+	err := os.WriteFile(DatabaseFile, []byte(legacy), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := LoadFile(DatabaseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.SchemaVersion != SchemaVersion {
+		t.Errorf("database upgraded to schema version %d, want %d", db.SchemaVersion, SchemaVersion)
+	}
+	attachment := db.Attachments[0]
+	if attachment.Checksum != "aaa" || attachment.ArchiveChecksum != "bbb" || attachment.DownscaledChecksum != "ccc" {
+		t.Errorf("attachment checksums are %q, %q and %q, want aaa, bbb and ccc", attachment.Checksum, attachment.ArchiveChecksum, attachment.DownscaledChecksum)
+	}
+	if len(attachment.Sent) != 1 || attachment.Sent[0].Checksum != "ccc" {
+		t.Errorf("sent files are %v, want one with checksum ccc", attachment.Sent)
+	}
+	if attachment.UploadChecksum() != "ccc" {
+		t.Errorf("upload checksum is %q, want the downscaled copy's ccc", attachment.UploadChecksum())
+	}
+}
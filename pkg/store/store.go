@@ -0,0 +1,437 @@
+// Package store holds the migration database relating the collected
+// attachments to their GitHub issues and destination JIRA tickets.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+)
+
+// DatabaseFile is where the database is written, in the working directory
+// unless another path is configured. The other workspace state files are
+// kept alongside it.
+var DatabaseFile = "database.json"
+
+// StageDir is the staging directory the attachments are expanded into, in the
+// working directory unless another directory is configured. Attachment paths
+// are relative to it.
+var StageDir = "stage"
+
+// StatePath returns the path of a workspace state file kept in the directory
+// of the database.
+func StatePath(name string) string {
+	return filepath.Join(filepath.Dir(DatabaseFile), name)
+}
+
+const (
+	TargetJIRA        = "jira"
+	TargetAzureDevOps = "azure-devops"
+	TargetConfluence  = "confluence"
+)
+
+func ValidTarget(target string) error {
+	switch target {
+	case TargetJIRA, TargetAzureDevOps, TargetConfluence:
+		return nil
+	}
+	return fmt.Errorf("invalid target %q, must be jira, azure-devops, or confluence", target)
+}
+
+const (
+	DedupAll          = "all"
+	DedupOnce         = "once"
+	DedupSkipExisting = "skip-existing"
+)
+
+type Database struct {
+	// SchemaVersion is the version of the layout the database was written
+	// with. Databases written before versioning was introduced have none.
+	SchemaVersion int                `json:"schema_version"`
+	Attachments   []*Attachment      `json:"attachments"`
+	Issues        map[string]*Issue  `json:"issues"`
+	Tickets       map[string]*Ticket `json:"tickets"`
+	DedupPolicy   string             `json:"dedup_policy,omitempty"`
+	ServerClock   *ServerClock       `json:"server_clock,omitempty"`
+	// HashAlgorithm is the algorithm of every checksum in the database. An
+	// empty algorithm means SHA-256.
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+	// Unstaged means the attachments were left in the archive rather than
+	// expanded into the staging directory, so they are uploaded by
+	// streaming them out of the archive.
+	Unstaged bool `json:"unstaged,omitempty"`
+	// Target is the tracker the tickets belong to. An empty target means
+	// JIRA.
+	Target string `json:"target,omitempty"`
+	// Collected is when the collect or fetch that last wrote the database
+	// started, which an incremental collect continues from.
+	Collected time.Time `json:"collected"`
+}
+
+// New returns an empty database whose checksums use the algorithm.
+func New(hashAlgorithm string) *Database {
+	return &Database{
+		SchemaVersion: SchemaVersion,
+		Attachments:   []*Attachment{},
+		Issues:        make(map[string]*Issue),
+		Tickets:       make(map[string]*Ticket),
+		HashAlgorithm: hashAlgorithm,
+	}
+}
+
+type Attachment struct {
+	Type          string   `json:"type"`
+	URL           string   `json:"url"`
+	IssueNumber   int      `json:"issue_number"`
+	CommentNumber int64    `json:"comment_number"`
+	Path          string   `json:"path"`
+	SkipReason    string   `json:"skip_reason,omitempty"`
+	Parts         []string `json:"parts,omitempty"`
+	AssetURL      string   `json:"asset_url,omitempty"`
+	Orphaned      bool     `json:"orphaned,omitempty"`
+	// Checksum holds the checksum computed with the database's hash
+	// algorithm, which is SHA-256 unless another was selected.
+	Checksum string      `json:"checksum,omitempty"`
+	JIRAIDs  []string    `json:"jira_ids,omitempty"`
+	Deleted  bool        `json:"deleted,omitempty"`
+	History  []*Decision `json:"history,omitempty"`
+	// ArchiveChecksum is the checksum of the archive member the attachment was
+	// expanded from and Sent records each file as it was posted to JIRA.
+	ArchiveChecksum string      `json:"archive_checksum,omitempty"`
+	Sent            []*SentFile `json:"sent,omitempty"`
+	// Size is the size of the archive member the attachment was expanded
+	// from, and Position where the member comes in the archive, counting the
+	// attachment files extracted from it.
+	Size     int64 `json:"size,omitempty"`
+	Position int   `json:"position,omitempty"`
+	// ContentType is the MIME type detected from the staged file, or from its
+	// name when it was not staged, and Excluded is why the file type filter
+	// of the last upload left the attachment out.
+	ContentType string `json:"content_type,omitempty"`
+	Excluded    string `json:"excluded,omitempty"`
+	// Downscaled is the staged path of the smaller copy of an image over the
+	// --max-image-bytes threshold that is uploaded in its place, and
+	// DownscaledChecksum the checksum of the copy. The archive keeps the
+	// original.
+	Downscaled         string `json:"downscaled,omitempty"`
+	DownscaledChecksum string `json:"downscaled_checksum,omitempty"`
+	// Bundled is the name of the attachment's entry in the zip of all the
+	// ticket's attachments it was uploaded in with --bundle-per-ticket.
+	Bundled string `json:"bundled,omitempty"`
+	// DuplicateOf is the path of the attachment with identical content that
+	// is uploaded in place of this one under the once dedup policy.
+	DuplicateOf      string `json:"duplicate_of,omitempty"`
+	DuplicateOfIssue int    `json:"duplicate_of_issue,omitempty"`
+	// Stalls counts the uploads of the attachment cancelled by the stall
+	// watchdog.
+	Stalls  int            `json:"stalls,omitempty"`
+	Failure *UploadFailure `json:"failure,omitempty"`
+	// Flagged records the screening that found the attachment to be an
+	// executable or malware. Flagged attachments are quarantined: uploads
+	// leave them alone until they are screened again with --rescreen.
+	Flagged *ScreenFinding `json:"flagged,omitempty"`
+	// Verified records the last verify run that found the uploaded
+	// attachment intact, and Pruned means gc deleted its staged files once
+	// it was uploaded.
+	Verified *Verification `json:"verified,omitempty"`
+	Pruned   bool          `json:"pruned,omitempty"`
+}
+
+// StagedFile is a file posted to JIRA on behalf of an attachment.
+type StagedFile struct {
+	Path string
+	Name string
+}
+
+// StagedFiles returns the files to upload for the attachment, which are the
+// split volumes when the attachment was too large to post directly, or the
+// downscaled copy of an image under the attachment's own name.
+func (a *Attachment) StagedFiles() []StagedFile {
+	if len(a.Parts) == 0 && a.Downscaled != "" {
+		return []StagedFile{{Path: filepath.Join(StageDir, a.Downscaled), Name: a.Name()}}
+	}
+	if len(a.Parts) == 0 {
+		return []StagedFile{{Path: filepath.Join(StageDir, a.Path), Name: a.Name()}}
+	}
+	var files []StagedFile
+	for _, part := range a.Parts {
+		nameTokens := strings.Split(part, "/")
+		files = append(files, StagedFile{Path: filepath.Join(StageDir, part), Name: nameTokens[len(nameTokens)-1]})
+	}
+	return files
+}
+
+// UploadChecksum returns the checksum of the content uploaded for the
+// attachment, which is that of its downscaled copy when it has one.
+func (a *Attachment) UploadChecksum() string {
+	if a.Downscaled != "" {
+		return a.DownscaledChecksum
+	}
+	return a.Checksum
+}
+
+// Name returns the file name of the staged attachment.
+func (a *Attachment) Name() string {
+	nameTokens := strings.Split(a.Path, "/")
+	return nameTokens[len(nameTokens)-1]
+}
+
+// UploadedSince reports whether any file of the attachment was posted at or
+// after the given time.
+func (a *Attachment) UploadedSince(t time.Time) bool {
+	for _, sent := range a.Sent {
+		if !sent.Time.Before(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifiedSinceUpload reports whether verify found the attachment intact
+// after every file of it was posted, with its content compared by checksum
+// when checksums is set.
+func (a *Attachment) VerifiedSinceUpload(checksums bool) bool {
+	if a.Verified == nil || (checksums && !a.Verified.Checksums) {
+		return false
+	}
+	for _, sent := range a.Sent {
+		if sent.Time.After(a.Verified.Time) {
+			return false
+		}
+	}
+	return true
+}
+
+// SentFile records a file as it was posted to JIRA: the checksum and size of
+// the bytes sent and the size JIRA reported for the attachment it created.
+type SentFile struct {
+	Name     string    `json:"name"`
+	Checksum string    `json:"checksum"`
+	Bytes    int64     `json:"bytes"`
+	JIRAID   string    `json:"jira_id"`
+	JIRASize int64     `json:"jira_size"`
+	Time     time.Time `json:"time"`
+	// RunID identifies the run that posted the file.
+	RunID string `json:"run_id,omitempty"`
+}
+
+// UploadFailure records why an attachment last failed to upload. Failed
+// attachments are quarantined: later uploads leave them alone until they are
+// reattempted with --retry-failed.
+type UploadFailure struct {
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts"`
+	RunID    string    `json:"run_id,omitempty"`
+}
+
+// Verification records a verify run that found an uploaded attachment
+// intact, and whether it compared the content JIRA holds by checksum.
+type Verification struct {
+	Time      time.Time `json:"time"`
+	Checksums bool      `json:"checksums,omitempty"`
+	RunID     string    `json:"run_id,omitempty"`
+}
+
+// ScreenFinding records why screening flagged an attachment.
+type ScreenFinding struct {
+	Scanner string    `json:"scanner"`
+	Reason  string    `json:"reason"`
+	Time    time.Time `json:"time"`
+}
+
+// Decision records an operator's decision about an attachment so the
+// database keeps a history of why files were excluded or brought back.
+type Decision struct {
+	Action string    `json:"action"`
+	Reason string    `json:"reason,omitempty"`
+	User   string    `json:"user,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type Issue struct {
+	URL    string   `json:"url"`
+	Number int      `json:"number"`
+	Labels []string `json:"labels,omitempty"`
+	// Marked is when verify marked the GitHub issue as migrated.
+	Marked *time.Time `json:"marked,omitempty"`
+}
+
+type Ticket struct {
+	Key      string `json:"key"`
+	Uploaded bool   `json:"uploaded"`
+	// ProvenanceComment is the ID of the comment listing where the ticket's
+	// attachments came from, updated in place on later uploads.
+	ProvenanceComment string `json:"provenance_comment,omitempty"`
+	// Posts maps the URL of each GitHub description and comment copied onto
+	// the ticket by --migrate-content to the ID of its comment.
+	Posts map[string]string `json:"posts,omitempty"`
+}
+
+// IssueEntry and TicketEntry are the listing forms of GitHub issues and JIRA
+// tickets, before they are keyed by title in the database.
+type IssueEntry struct {
+	Title  string   `json:"title"`
+	URL    string   `json:"url"`
+	Number int      `json:"number"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+type TicketEntry struct {
+	Summary string `json:"summary"`
+	Key     string `json:"key"`
+	// Field is the value of the custom field matched on by the
+	// custom-field strategy, when one is configured.
+	Field string `json:"field,omitempty"`
+}
+
+// TargetName returns the tracker the tickets of the database belong to.
+func (db *Database) TargetName() string {
+	if db.Target == "" {
+		return TargetJIRA
+	}
+	return db.Target
+}
+
+// Algorithm returns the checksum algorithm of the database.
+func (db *Database) Algorithm() string {
+	if db.HashAlgorithm == "" {
+		return checksum.SHA256
+	}
+	return db.HashAlgorithm
+}
+
+func Load() (*Database, error) {
+	return LoadFile(DatabaseFile)
+}
+
+// LoadFile reads the database at path, such as one collected by another
+// operator.
+func LoadFile(path string) (*Database, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading database: %w", err)
+	}
+
+	db := &Database{}
+	err = json.Unmarshal(bytes, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling database: %w", err)
+	}
+	err = upgrade(db, bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed upgrading database: %w", err)
+	}
+
+	return db, nil
+}
+
+func Save(db *Database) error {
+	return SaveFile(db, DatabaseFile)
+}
+
+// SaveFile writes the database to path.
+func SaveFile(db *Database, path string) error {
+	db.SchemaVersion = SchemaVersion
+	bytes, err := json.Marshal(db)
+	if err != nil {
+		return fmt.Errorf("failed marshalling database: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed creating database directory: %w", err)
+	}
+	// The database is replaced in one rename so readers such as serve never
+	// see it half written.
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing database: %w", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("failed writing database: %w", err)
+	}
+
+	return nil
+}
+
+// CarryDecisions copies the deletions and decision history from the database
+// on disk, if any, onto the freshly collected attachments so re-collecting
+// does not discard earlier operator decisions. The upload state of an
+// attachment is carried over too while its content is unchanged, so
+// re-collecting does not post it again, and a ticket stays uploaded while
+// none of its issue's attachments are new or changed. Provenance comment and
+// migrated post IDs are carried over with the tickets for the same reason, as
+// are the IDs of the Confluence pages created for the issues by earlier
+// uploads.
+func CarryDecisions(db *Database) error {
+	if _, err := os.Stat(DatabaseFile); os.IsNotExist(err) {
+		return nil
+	}
+	previous, err := Load()
+	if err != nil {
+		return err
+	}
+
+	type key struct {
+		path  string
+		issue int
+	}
+	priors := make(map[key]*Attachment)
+	for _, attachment := range previous.Attachments {
+		priors[key{attachment.Path, attachment.IssueNumber}] = attachment
+	}
+	changedIssues := make(map[int]bool)
+	for _, attachment := range db.Attachments {
+		prior, ok := priors[key{attachment.Path, attachment.IssueNumber}]
+		if ok {
+			attachment.Deleted = prior.Deleted
+			attachment.History = prior.History
+		}
+		if !ok || prior.Checksum != attachment.Checksum {
+			if !attachment.Deleted {
+				changedIssues[attachment.IssueNumber] = true
+			}
+			continue
+		}
+		attachment.JIRAIDs = prior.JIRAIDs
+		attachment.Sent = prior.Sent
+		attachment.Bundled = prior.Bundled
+		attachment.Stalls = prior.Stalls
+		attachment.Failure = prior.Failure
+		attachment.Flagged = prior.Flagged
+		attachment.Verified = prior.Verified
+		// Files gc pruned are staged again by collecting, unless the
+		// collect left them unstaged.
+		if prior.Pruned {
+			_, err := os.Stat(filepath.Join(StageDir, attachment.Path))
+			attachment.Pruned = os.IsNotExist(err)
+		}
+	}
+	pages := db.TargetName() == TargetConfluence && previous.TargetName() == TargetConfluence
+	for title, ticket := range db.Tickets {
+		prior, ok := previous.Tickets[title]
+		if !ok {
+			continue
+		}
+		if pages && ticket.Key == "" {
+			ticket.Key = prior.Key
+		}
+		if prior.Key != ticket.Key {
+			continue
+		}
+		ticket.ProvenanceComment = prior.ProvenanceComment
+		ticket.Posts = prior.Posts
+		if issue, ok := db.Issues[title]; ok && !changedIssues[issue.Number] {
+			ticket.Uploaded = prior.Uploaded
+		}
+	}
+
+	return nil
+}
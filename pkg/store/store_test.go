@@ -0,0 +1,106 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useWorkspace points the database and staging directory at a temporary
+// directory for the test.
+func useWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	databaseFile, stageDir := DatabaseFile, StageDir
+	DatabaseFile = filepath.Join(dir, "database.json")
+	StageDir = filepath.Join(dir, "stage")
+	t.Cleanup(func() {
+		DatabaseFile, StageDir = databaseFile, stageDir
+	})
+}
+
+// TestCarryDecisionsKeepsUploadState re-collects a database whose tickets
+// were uploaded, and checks the upload state survives for unchanged content
+// while tickets with changed attachments are uploaded again.
+func TestCarryDecisionsKeepsUploadState(t *testing.T) {
+	useWorkspace(t)
+
+	previous := New("sha256")
+	previous.Issues["Crash on save"] = &Issue{Number: 1}
+	previous.Issues["Slow start"] = &Issue{Number: 2}
+	previous.Tickets["Crash on save"] = &Ticket{Key: "PROJ-1", Uploaded: true, ProvenanceComment: "100", Posts: map[string]string{"https://github.com/acme/widgets/issues/1": "101"}}
+	previous.Tickets["Slow start"] = &Ticket{Key: "PROJ-2", Uploaded: true}
+	previous.Attachments = []*Attachment{
+		{Type: "issue", IssueNumber: 1, Path: "attachments/1/crash.png", Checksum: "aaa", JIRAIDs: []string{"10"}, Sent: []*SentFile{{Name: "crash.png", JIRAID: "10"}}, Verified: &Verification{Checksums: true}, Pruned: true},
+		{Type: "issue", IssueNumber: 1, Path: "attachments/2/save.log", Checksum: "bbb", Deleted: true, History: []*Decision{{}}},
+		{Type: "issue", IssueNumber: 2, Path: "attachments/3/trace.txt", Checksum: "ccc", JIRAIDs: []string{"11"}, Sent: []*SentFile{{Name: "trace.txt", JIRAID: "11"}}},
+	}
+	err := Save(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := New("sha256")
+	db.Issues["Crash on save"] = &Issue{Number: 1}
+	db.Issues["Slow start"] = &Issue{Number: 2}
+	db.Tickets["Crash on save"] = &Ticket{Key: "PROJ-1"}
+	db.Tickets["Slow start"] = &Ticket{Key: "PROJ-2"}
+	db.Attachments = []*Attachment{
+		{Type: "issue", IssueNumber: 1, Path: "attachments/1/crash.png", Checksum: "aaa"},
+		{Type: "issue", IssueNumber: 1, Path: "attachments/2/save.log", Checksum: "bbb"},
+		{Type: "issue", IssueNumber: 2, Path: "attachments/3/trace.txt", Checksum: "changed"},
+	}
+	err = CarryDecisions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crash := db.Attachments[0]
+	if len(crash.JIRAIDs) != 1 || len(crash.Sent) != 1 || crash.Verified == nil || !crash.Pruned {
+		t.Errorf("unchanged attachment records IDs %v, %d sent files, verification %v, and pruned %t, want its upload state kept", crash.JIRAIDs, len(crash.Sent), crash.Verified, crash.Pruned)
+	}
+	if !db.Attachments[1].Deleted || len(db.Attachments[1].History) != 1 {
+		t.Error("deleted attachment lost its decision")
+	}
+	if trace := db.Attachments[2]; len(trace.JIRAIDs) > 0 || len(trace.Sent) > 0 {
+		t.Errorf("changed attachment records IDs %v and %d sent files, want none", trace.JIRAIDs, len(trace.Sent))
+	}
+	if ticket := db.Tickets["Crash on save"]; !ticket.Uploaded || ticket.ProvenanceComment != "100" || ticket.Posts["https://github.com/acme/widgets/issues/1"] != "101" {
+		t.Errorf("ticket with unchanged attachments is %+v, want it uploaded with its comments", ticket)
+	}
+	if db.Tickets["Slow start"].Uploaded {
+		t.Error("ticket with a changed attachment is marked uploaded")
+	}
+}
+
+// TestCarryDecisionsClearsRestagedPrunes re-collects a database whose staged
+// file was pruned, and checks the attachment is no longer pruned once the
+// file is staged again.
+func TestCarryDecisionsClearsRestagedPrunes(t *testing.T) {
+	useWorkspace(t)
+
+	previous := New("sha256")
+	previous.Attachments = []*Attachment{{Type: "issue", IssueNumber: 1, Path: "attachments/1/crash.png", Checksum: "aaa", JIRAIDs: []string{"10"}, Pruned: true}}
+	err := Save(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged := filepath.Join(StageDir, "attachments", "1", "crash.png")
+	err = os.MkdirAll(filepath.Dir(staged), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(staged, []byte("png bytes"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := New("sha256")
+	db.Attachments = []*Attachment{{Type: "issue", IssueNumber: 1, Path: "attachments/1/crash.png", Checksum: "aaa"}}
+	err = CarryDecisions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment := db.Attachments[0]; attachment.Pruned || len(attachment.JIRAIDs) != 1 {
+		t.Errorf("restaged attachment records pruned %t and IDs %v, want it unpruned and uploaded", attachment.Pruned, attachment.JIRAIDs)
+	}
+}
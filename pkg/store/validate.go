@@ -0,0 +1,118 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem is a structural problem found in a database. Errors leave the
+// database unusable or its upload status untrustworthy, while warnings are
+// usually expected, such as issues no ticket was found for.
+type Problem struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Subject  string `json:"subject"`
+	Detail   string `json:"detail"`
+}
+
+// Validate checks the database for structural problems: staged files that
+// are missing, duplicate attachments, malformed URLs, issues without a
+// matched ticket, and upload status that contradicts itself. Problems are
+// returned sorted by check and subject.
+func Validate(db *Database) []*Problem {
+	var problems []*Problem
+	add := func(severity, check, subject, format string, a ...interface{}) {
+		problems = append(problems, &Problem{Severity: severity, Check: check, Subject: subject, Detail: fmt.Sprintf(format, a...)})
+	}
+
+	ticketsByIssue := make(map[int]*Ticket)
+	for title, issue := range db.Issues {
+		subject := fmt.Sprintf("issue #%d %q", issue.Number, title)
+		if !validURL(issue.URL) {
+			add(SeverityError, "url", subject, "has malformed URL %q", issue.URL)
+		}
+		ticket, ok := db.Tickets[title]
+		switch {
+		case !ok:
+			add(SeverityWarning, "unmatched", subject, "has no matched ticket")
+		case ticket.Key == "" && db.TargetName() != TargetConfluence:
+			add(SeverityError, "unmatched", subject, "is matched to a ticket without a key")
+		default:
+			ticketsByIssue[issue.Number] = ticket
+		}
+	}
+	for title := range db.Tickets {
+		if _, ok := db.Issues[title]; !ok {
+			add(SeverityWarning, "unmatched", fmt.Sprintf("ticket of %q", title), "has no issue of that title")
+		}
+	}
+
+	all := make(map[attachmentID]bool)
+	for _, attachment := range db.Attachments {
+		all[attachmentID{attachment.Path, attachment.IssueNumber}] = true
+	}
+	seen := make(map[attachmentID]bool)
+	paths := make(map[string]int)
+	for _, attachment := range db.Attachments {
+		subject := fmt.Sprintf("attachment %s on issue #%d", attachment.Path, attachment.IssueNumber)
+		id := attachmentID{attachment.Path, attachment.IssueNumber}
+		if seen[id] {
+			add(SeverityError, "duplicate", subject, "is recorded more than once")
+		}
+		seen[id] = true
+		if issue, ok := paths[attachment.Path]; ok && issue != attachment.IssueNumber {
+			add(SeverityWarning, "duplicate", subject, "shares its staged path with an attachment on issue #%d", issue)
+		}
+		paths[attachment.Path] = attachment.IssueNumber
+
+		if !validURL(attachment.URL) {
+			add(SeverityError, "url", subject, "has malformed source URL %q", attachment.URL)
+		}
+		if attachment.AssetURL != "" && !validURL(attachment.AssetURL) {
+			add(SeverityError, "url", subject, "has malformed asset URL %q", attachment.AssetURL)
+		}
+
+		if !db.Unstaged && !attachment.Deleted {
+			for _, file := range attachment.StagedFiles() {
+				if _, err := os.Stat(file.Path); err != nil {
+					add(SeverityError, "staged", subject, "is missing staged file %s", file.Path)
+				}
+			}
+		}
+
+		if len(attachment.JIRAIDs) > 0 {
+			if attachment.Failure != nil {
+				add(SeverityError, "status", subject, "is recorded as uploaded and as failed")
+			}
+			if len(attachment.Sent) > 0 && len(attachment.Sent) != len(attachment.JIRAIDs) {
+				add(SeverityError, "status", subject, "records %d files sent but %d uploaded attachment IDs", len(attachment.Sent), len(attachment.JIRAIDs))
+			}
+			if ticketsByIssue[attachment.IssueNumber] == nil {
+				add(SeverityError, "status", subject, "is recorded as uploaded but its issue has no matched ticket")
+			}
+		}
+		if attachment.DuplicateOf != "" && !all[attachmentID{attachment.DuplicateOf, attachment.DuplicateOfIssue}] {
+			add(SeverityError, "status", subject, "is a duplicate of %s on issue #%d, which is not in the database", attachment.DuplicateOf, attachment.DuplicateOfIssue)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Check != problems[j].Check {
+			return problems[i].Check < problems[j].Check
+		}
+		return problems[i].Subject < problems[j].Subject
+	})
+	return problems
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
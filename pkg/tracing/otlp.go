@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+)
+
+// tracesPath is where OTLP/HTTP collectors receive spans.
+const tracesPath = "/v1/traces"
+
+// exportTimeout bounds each export request.
+const exportTimeout = 30 * time.Second
+
+// newExporter returns an OTLP/HTTP exporter for the collector at endpoint,
+// to which the traces path is added when it is given without a path, as
+// with OTEL_EXPORTER_OTLP_ENDPOINT.
+func newExporter(endpoint string) (*otlptrace.Exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, must be such as http://localhost:4318", endpoint)
+	}
+	path := u.Path
+	if strings.Trim(path, "/") == "" {
+		path = tracesPath
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(path),
+		otlptracehttp.WithTimeout(exportTimeout),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating OTLP exporter: %w", err)
+	}
+	return exporter, nil
+}
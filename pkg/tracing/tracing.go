@@ -0,0 +1,124 @@
+// Package tracing records OpenTelemetry spans around the GitHub and JIRA
+// requests, archive extraction, and attachment uploads of a run, so the time
+// of a slow migration can be attributed to GitHub, JIRA, or the disk. Spans
+// are exported over OTLP/HTTP once Setup is called, and cost nothing
+// otherwise.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The services requests are sent to, recorded as the peer.service of their
+// spans.
+const (
+	ServiceGitHub = "github"
+	ServiceJIRA   = "jira"
+)
+
+// serviceName names the migrator in the exported resource.
+const serviceName = "attachment-processor"
+
+const instrumentationName = "github.com/lindluni/attachment-processor"
+
+// shutdownTimeout bounds how long exporting the last spans may delay exit.
+const shutdownTimeout = 10 * time.Second
+
+// Setup exports the spans of the run to the OTLP/HTTP collector at endpoint,
+// such as http://localhost:4318, labelled with the command and run ID. The
+// returned function exports the spans still buffered and must be called
+// before the process exits.
+func Setup(endpoint, command, runID string) (func() error, error) {
+	exporter, err := newExporter(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(sdktrace.NewBatchSpanProcessor(exporter)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceNameKey.String(serviceName),
+			attribute.String("migration.command", command),
+			attribute.String("migration.run_id", runID),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			return fmt.Errorf("failed exporting traces: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// Start starts a span of the named operation as a child of any span in ctx,
+// returning a context carrying it for the operations it covers.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End ends the span, marking it failed when err is set.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Transport records a client span for each request sent to the service,
+// under any span carried by the request context.
+type Transport struct {
+	Base    http.RoundTripper
+	Service string
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(instrumentationName).Start(req.Context(), fmt.Sprintf("%s %s", t.Service, req.Method),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.PeerServiceKey.String(t.Service),
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPURLKey.String(spanURL(req.URL)),
+			semconv.NetPeerNameKey.String(req.URL.Hostname()),
+		),
+	)
+	if req.ContentLength > 0 {
+		span.SetAttributes(semconv.HTTPRequestContentLengthKey.Int64(req.ContentLength))
+	}
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	span.End()
+	return resp, nil
+}
+
+// spanURL returns the URL of a request without its credentials or query,
+// which can carry tokens.
+func spanURL(u *url.URL) string {
+	stripped := *u
+	stripped.User = nil
+	stripped.RawQuery = ""
+	stripped.Fragment = ""
+	return stripped.String()
+}
@@ -0,0 +1,71 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/lindluni/attachment-processor/pkg/export"
+)
+
+// Archive streams attachments out of the GitHub repository archive in place
+// of the staging directory. The archive is read forward only, so asking for
+// a member that has already been passed reopens it from the start.
+type Archive struct {
+	path string
+	r    *export.Reader
+}
+
+// OpenArchive opens the archive at path for streaming attachments.
+func OpenArchive(path string) (*Archive, error) {
+	a := &Archive{path: path}
+	err := a.rewind()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Close closes the archive.
+func (a *Archive) Close() error {
+	return a.r.Close()
+}
+
+// rewind reopens the archive at its first member.
+func (a *Archive) rewind() error {
+	if a.r != nil {
+		a.r.Close()
+	}
+	r, err := export.Open(a.path)
+	if err != nil {
+		return err
+	}
+	a.r = r
+	return nil
+}
+
+// open positions the archive at the member and returns a reader of its
+// content, valid until the next call. The rest of the archive is searched
+// first, then the archive is reopened and searched from the start.
+func (a *Archive) open(member string) (io.Reader, error) {
+	member = filepath.ToSlash(filepath.Clean(member))
+	for pass := 0; pass < 2; pass++ {
+		for {
+			header, err := a.r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if header.Name == member {
+				return a.r, nil
+			}
+		}
+		err := a.rewind()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("member %s not found in archive %s", member, a.path)
+}
@@ -0,0 +1,171 @@
+package upload
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// BundleDir holds the per-ticket bundles within the staging directory.
+const BundleDir = "bundles"
+
+// BundleName returns the file name of the bundle of the GitHub issue.
+func BundleName(issue int) string {
+	return fmt.Sprintf("issue-%d.zip", issue)
+}
+
+// Bundle zips the attachments into a single file named after the GitHub
+// issue and posts it to the ticket in their place, for instances capping the
+// attachments per ticket. Files keep the names they would be posted under.
+// Every attachment records the bundle's upload and the name of its entry in
+// the bundle, and a failure is recorded on all of them, so the bundle is
+// rebuilt and posted whole when the ticket is retried.
+func Bundle(target client.Target, db *store.Database, title string, attachments []*store.Attachment, limit int64, opts *Options) error {
+	ticket := db.Tickets[title]
+	issue := db.Issues[title]
+	onConflict := opts.OnConflict
+	if db.DedupPolicy == store.DedupSkipExisting {
+		onConflict = ConflictSkip
+	}
+
+	named, err := opts.ticketFiles(db, title)
+	if err != nil {
+		return err
+	}
+	bundle := store.StagedFile{
+		Path: filepath.Join(store.StageDir, BundleDir, BundleName(issue.Number)),
+		Name: BundleName(issue.Number),
+	}
+	fmt.Printf("Bundling %d attachments for %s into %s\n", len(attachments), ticket.Key, bundle.Name)
+	_, span := tracing.Start(context.Background(), "upload.bundle", attribute.String("ticket", ticket.Key), attribute.Int("attachments", len(attachments)))
+	size, err := writeBundle(bundle.Path, attachments, named)
+	span.SetAttributes(attribute.Int64("bytes", size))
+	tracing.End(span, err)
+	if err != nil {
+		return fmt.Errorf("failed bundling attachments for %s: %w", ticket.Key, err)
+	}
+	if limit > 0 && size > limit {
+		return bundleFailure(db, attachments, fmt.Errorf("bundle %s of %d bytes exceeds the upload limit of %d bytes", bundle.Name, size, limit))
+	}
+
+	existing, err := existingAttachments(target, ticket.Key)
+	if err != nil {
+		return err
+	}
+	files := []store.StagedFile{bundle}
+	conflicts, err := existing.conflicts(files, func(store.StagedFile) (int64, error) {
+		return size, nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		switch onConflict {
+		case ConflictSkip:
+			fmt.Printf("Skipping bundle %s, already attached to %s\n", bundle.Name, ticket.Key)
+			for _, attachment := range attachments {
+				attachment.JIRAIDs = conflicts
+			}
+			ticket.Uploaded = Complete(db, title)
+			return store.Save(db)
+		case ConflictRename:
+			files = existing.rename(files)
+			fmt.Printf("Bundle %s is already attached to %s, uploading as %s\n", bundle.Name, ticket.Key, files[0].Name)
+		case ConflictReplace:
+			fmt.Printf("Replacing bundle %s on %s\n", bundle.Name, ticket.Key)
+			err = rollbackAttachments(target, ticket.Key, conflicts)
+			if err != nil {
+				return fmt.Errorf("failed replacing bundle %s: %w", bundle.Name, err)
+			}
+		}
+	}
+
+	sent, err := uploadAttachment(context.Background(), target, ticket.Key, attachments[0], files, opts)
+	if err != nil {
+		return bundleFailure(db, attachments, err)
+	}
+	for _, attachment := range attachments {
+		attachment.JIRAIDs = []string{sent[0].JIRAID}
+		attachment.Sent = sent
+		attachment.Failure = nil
+	}
+	ticket.Uploaded = Complete(db, title)
+	return store.Save(db)
+}
+
+// bundleFailure records the error on every attachment of a bundle.
+func bundleFailure(db *store.Database, attachments []*store.Attachment, err error) error {
+	for _, attachment := range attachments {
+		RecordFailure(attachment, err)
+	}
+	saveErr := store.Save(db)
+	if saveErr != nil {
+		return fmt.Errorf("%w\n%s", err, saveErr)
+	}
+	return err
+}
+
+// writeBundle zips the staged files of the attachments into path under the
+// names given, recording on each attachment the name of its entry, and
+// returns the size of the zip.
+func writeBundle(path string, attachments []*store.Attachment, named map[*store.Attachment][]store.StagedFile) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	for _, attachment := range attachments {
+		files := named[attachment]
+		if files == nil {
+			files = attachment.StagedFiles()
+		}
+		for _, staged := range files {
+			err = addToBundle(zw, staged)
+			if err != nil {
+				return 0, err
+			}
+		}
+		attachment.Bundled = files[0].Name
+	}
+	err = zw.Close()
+	if err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func addToBundle(zw *zip.Writer, staged store.StagedFile) error {
+	source, err := os.Open(staged.Path)
+	if err != nil {
+		return fmt.Errorf("failed opening attachment: %w", err)
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return fmt.Errorf("failed getting file stats: %w", err)
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: staged.Name, Method: zip.Deflate, Modified: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, source)
+	return err
+}
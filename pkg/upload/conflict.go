@@ -0,0 +1,83 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/naming"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+const (
+	ConflictSkip    = "skip"
+	ConflictRename  = "rename"
+	ConflictReplace = "replace"
+)
+
+func ValidConflictPolicy(policy string) error {
+	switch policy {
+	case ConflictSkip, ConflictRename, ConflictReplace:
+		return nil
+	}
+	return fmt.Errorf("invalid conflict policy %q, must be one of skip, rename, or replace", policy)
+}
+
+// attachmentKey identifies an attachment on a ticket by name and size.
+type attachmentKey struct {
+	name string
+	size int64
+}
+
+// ticketAttachments indexes the attachments already on a ticket.
+type ticketAttachments struct {
+	ids   map[attachmentKey]string
+	names map[string]bool
+}
+
+// existingAttachments returns the attachments already on the ticket so that
+// re-running an upload does not post the same file twice.
+func existingAttachments(target client.Target, key string) (*ticketAttachments, error) {
+	attachments, err := target.ListAttachments(context.Background(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing attachments on %s: %w", key, err)
+	}
+	existing := &ticketAttachments{
+		ids:   make(map[attachmentKey]string),
+		names: make(map[string]bool),
+	}
+	for _, attachment := range attachments {
+		existing.ids[attachmentKey{name: attachment.Name, size: attachment.Size}] = attachment.ID
+		existing.names[attachment.Name] = true
+	}
+	return existing, nil
+}
+
+// conflicts returns the IDs of the ticket attachments matching the staged
+// files by name and size. Nil is returned unless every file is matched, so a
+// partially posted split attachment is uploaded again.
+func (t *ticketAttachments) conflicts(files []store.StagedFile, size func(file store.StagedFile) (int64, error)) ([]string, error) {
+	var ids []string
+	for _, file := range files {
+		n, err := size(file)
+		if err != nil {
+			return nil, err
+		}
+		id, ok := t.ids[attachmentKey{name: file.Name, size: n}]
+		if !ok {
+			return nil, nil
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// rename gives each staged file a name not yet used on the ticket by adding a
+// counter before the extension, such as "screenshot (1).png".
+func (t *ticketAttachments) rename(files []store.StagedFile) []store.StagedFile {
+	renamed := make([]store.StagedFile, 0, len(files))
+	for _, file := range files {
+		renamed = append(renamed, store.StagedFile{Path: file.Path, Name: naming.Unique(file.Name, t.names)})
+	}
+	return renamed
+}
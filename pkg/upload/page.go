@@ -0,0 +1,51 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"html"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// Page makes sure the issue has a Confluence page to upload its attachments
+// to, recording the page ID as the ticket key. A page already in the space
+// with the issue's title is reused, so a page created by an interrupted run
+// is not created again.
+func Page(pages client.Confluence, db *store.Database, title string) error {
+	ticket := db.Tickets[title]
+	if ticket.Key != "" {
+		return nil
+	}
+
+	ctx := context.Background()
+	id, err := pages.FindPage(ctx, title)
+	if err != nil {
+		return fmt.Errorf("failed finding page %q: %w", title, err)
+	}
+	if id == "" {
+		fmt.Printf("Creating page %q\n", title)
+		id, err = pages.CreatePage(ctx, title, pageBody(db.Issues[title]))
+		if err != nil {
+			return fmt.Errorf("failed creating page %q: %w", title, err)
+		}
+		err = audit.Record(&audit.Entry{Action: audit.ActionPageCreated, Ticket: id, Detail: title})
+		if err != nil {
+			return err
+		}
+	}
+	ticket.Key = id
+	return store.Save(db)
+}
+
+// pageBody links the page back to the issue its attachments came from, in
+// the Confluence storage format.
+func pageBody(issue *store.Issue) string {
+	if issue == nil || issue.URL == "" {
+		return "<p>Attachments migrated from an issue.</p>"
+	}
+	url := html.EscapeString(issue.URL)
+	return fmt.Sprintf(`<p>Attachments migrated from issue <a href="%s">#%d</a>.</p>`, url, issue.Number)
+}
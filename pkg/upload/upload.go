@@ -0,0 +1,439 @@
+// Package upload posts the collected attachments to their destination
+// tickets.
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/naming"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxStallRetries is how many times a stalled transfer is restarted before
+// it counts as a failed attempt.
+const maxStallRetries = 3
+
+// Pending returns the attachments awaiting upload keyed by the title
+// of the ticket they belong to. Quarantined attachments, whether they failed
+// to upload or were flagged by screening, are left out, as are those a
+// partly uploaded ticket already holds.
+func Pending(db *store.Database, filter *store.IssueFilter) map[string][]*store.Attachment {
+	pending := make(map[string][]*store.Attachment)
+	for title, ticket := range db.Tickets {
+		if ticket.Uploaded {
+			continue
+		}
+		issue := db.Issues[title]
+		if issue == nil || !filter.Allows(issue.Number) {
+			continue
+		}
+		for _, attachment := range db.Attachments {
+			if attachment.IssueNumber == issue.Number && !attachment.Deleted && attachment.DuplicateOf == "" && attachment.Failure == nil && attachment.Flagged == nil && len(attachment.JIRAIDs) == 0 {
+				pending[title] = append(pending[title], attachment)
+			}
+		}
+	}
+	return pending
+}
+
+// Complete reports whether every attachment of the ticket's issue is on the
+// ticket, so the ticket can be marked uploaded. Attachments deleted, posted
+// as a duplicate of another, or left out by the file type filter or the
+// upload limit are not expected on it, while those quarantined by an earlier
+// failure or by screening keep the ticket pending.
+func Complete(db *store.Database, title string) bool {
+	issue := db.Issues[title]
+	if issue == nil {
+		return false
+	}
+	for _, attachment := range db.Attachments {
+		if attachment.IssueNumber != issue.Number || attachment.Deleted || attachment.DuplicateOf != "" || attachment.Excluded != "" || attachment.SkipReason != "" {
+			continue
+		}
+		if len(attachment.JIRAIDs) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Options control how the attachments of each ticket are posted.
+type Options struct {
+	Retries    int
+	Atomic     bool
+	OnConflict string
+
+	// ProvenanceUpdate keeps a single provenance comment per ticket updated
+	// in place rather than adding one per run, and Started marks the start
+	// of the run.
+	ProvenanceUpdate bool
+	Started          time.Time
+
+	Watchdog *Watchdog
+
+	// HashAlgorithm is the database's checksum algorithm, used to hash the
+	// bytes sent.
+	HashAlgorithm string
+
+	// ContinueOnError records failures and moves on to the next attachment,
+	// or the next ticket in atomic mode, instead of ending the run.
+	ContinueOnError bool
+
+	// Archive streams the attachments out of the GitHub repository archive
+	// when they were not staged, and is nil otherwise.
+	Archive *Archive
+
+	// Names names and sanitizes the files posted, which keep their own names
+	// when it is nil.
+	Names *naming.Namer
+}
+
+// files returns the files to post for the attachment. Unstaged attachments
+// are posted straight from their archive member, whose size was recorded
+// during collection.
+func (o *Options) files(attachment *store.Attachment) []store.StagedFile {
+	if o.Archive == nil {
+		return attachment.StagedFiles()
+	}
+	return []store.StagedFile{{Path: attachment.Path, Name: attachment.Name()}}
+}
+
+// ticketFiles returns the files to post for each attachment of the ticket,
+// named by the name template. Files given the same name are told apart by a
+// counter such as "screenshot (1).png". Every attachment of the ticket is
+// named in the order of their paths, not just those being posted, so a file
+// keeps its name when the ticket is resumed or retried.
+func (o *Options) ticketFiles(db *store.Database, title string) (map[*store.Attachment][]store.StagedFile, error) {
+	issue := db.Issues[title]
+	key := db.Tickets[title].Key
+	var attachments []*store.Attachment
+	for _, attachment := range db.Attachments {
+		if issue != nil && attachment.IssueNumber == issue.Number && !attachment.Deleted && attachment.DuplicateOf == "" {
+			attachments = append(attachments, attachment)
+		}
+	}
+	sort.SliceStable(attachments, func(i, j int) bool {
+		return attachments[i].Path < attachments[j].Path
+	})
+
+	files := make(map[*store.Attachment][]store.StagedFile)
+	taken := make(map[string]bool)
+	for _, attachment := range attachments {
+		for _, file := range o.files(attachment) {
+			name, err := o.Names.Name(naming.NewFields(attachment, file.Name, key), attachment.Path)
+			if err != nil {
+				return nil, err
+			}
+			file.Name = naming.Unique(name, taken)
+			files[attachment] = append(files[attachment], file)
+		}
+	}
+	return files, nil
+}
+
+// size returns the size of a file to post for the attachment.
+func (o *Options) size(attachment *store.Attachment, file store.StagedFile) (int64, error) {
+	if o.Archive != nil {
+		return attachment.Size, nil
+	}
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return 0, fmt.Errorf("failed getting file stats: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// open returns the content of a file to post, closed by the returned
+// function.
+func (o *Options) open(file store.StagedFile) (io.Reader, func(), error) {
+	if o.Archive != nil {
+		content, err := o.Archive.open(file.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed opening attachment: %w", err)
+		}
+		return content, func() {}, nil
+	}
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed opening attachment: %w", err)
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// Ticket posts the attachments to the ticket of the target, and marks the
+// ticket uploaded once every attachment of its issue is posted or skipped as
+// already attached. In atomic mode a failure removes the attachments already
+// posted in this run and quarantines every attachment of the ticket but those
+// skipped as already attached, so the whole ticket is retried together.
+func Ticket(target client.Target, db *store.Database, title string, attachments []*store.Attachment, opts *Options) (err error) {
+	ticket := db.Tickets[title]
+	ctx, span := tracing.Start(context.Background(), "upload.ticket", attribute.String("ticket", ticket.Key), attribute.Int("attachments", len(attachments)))
+	defer func() { tracing.End(span, err) }()
+
+	existing, err := existingAttachments(target, ticket.Key)
+	if err != nil {
+		return err
+	}
+	onConflict := opts.OnConflict
+	if db.DedupPolicy == store.DedupSkipExisting {
+		onConflict = ConflictSkip
+	}
+
+	named, err := opts.ticketFiles(db, title)
+	if err != nil {
+		return err
+	}
+
+	var posted []string
+	failed := 0
+	skipped := make(map[*store.Attachment]bool)
+	for _, attachment := range attachments {
+		files := named[attachment]
+		if files == nil {
+			files = opts.files(attachment)
+		}
+		conflicts, err := existing.conflicts(files, func(file store.StagedFile) (int64, error) {
+			return opts.size(attachment, file)
+		})
+		if err != nil {
+			return err
+		}
+		if len(conflicts) > 0 {
+			switch onConflict {
+			case ConflictSkip:
+				output.Detailf("Skipping attachment %s, already attached to %s\n", attachment.Path, ticket.Key)
+				attachment.JIRAIDs = conflicts
+				skipped[attachment] = true
+				continue
+			case ConflictRename:
+				files = existing.rename(files)
+				output.Detailf("Attachment %s is already attached to %s, uploading as %s\n", attachment.Path, ticket.Key, files[0].Name)
+			case ConflictReplace:
+				output.Detailf("Replacing attachment %s on %s\n", attachment.Path, ticket.Key)
+				err = rollbackAttachments(target, ticket.Key, conflicts)
+				if err != nil {
+					return fmt.Errorf("failed replacing attachment %s: %w", attachment.Path, err)
+				}
+			}
+		}
+
+		sent, err := uploadAttachment(ctx, target, ticket.Key, attachment, files, opts)
+		var ids []string
+		for _, file := range sent {
+			ids = append(ids, file.JIRAID)
+		}
+		posted = append(posted, ids...)
+		if err != nil {
+			RecordFailure(attachment, err)
+			if opts.Atomic {
+				if len(posted) > 0 {
+					fmt.Printf("Rolling back %d attachments from %s\n", len(posted), ticket.Key)
+					rollbackErr := rollbackAttachments(target, ticket.Key, posted)
+					if rollbackErr != nil {
+						return fmt.Errorf("%w\nfailed rolling back %s: %s", err, ticket.Key, rollbackErr)
+					}
+				}
+				// Attachments skipped as already attached record the IDs of
+				// attachments the run did not post, which stay.
+				for _, other := range attachments {
+					if skipped[other] {
+						continue
+					}
+					other.JIRAIDs = nil
+					other.Sent = nil
+					if other != attachment {
+						RecordFailure(other, fmt.Errorf("rolled back with %s, which failed: %w", attachment.Path, err))
+					}
+				}
+			}
+			saveErr := store.Save(db)
+			if saveErr != nil {
+				return fmt.Errorf("%w\n%s", err, saveErr)
+			}
+			if opts.ContinueOnError && !opts.Atomic {
+				fmt.Printf("Continuing after failure on %s: %s\n", attachment.Path, err)
+				failed++
+				continue
+			}
+			return err
+		}
+		attachment.JIRAIDs = ids
+		attachment.Sent = sent
+		attachment.Failure = nil
+		attachment.Bundled = ""
+
+		if !opts.Atomic {
+			err = store.Save(db)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d attachments failed to upload to %s", failed, ticket.Key)
+	}
+	ticket.Uploaded = Complete(db, title)
+	return store.Save(db)
+}
+
+// uploadAttachment posts the staged files of an attachment, retrying failed
+// attempts with a linear backoff, and returns a record of each file posted.
+// Transfers cancelled by the stall watchdog are retried up to
+// maxStallRetries times without using up the retries, and are counted on the
+// attachment. On failure the files already posted are returned with the
+// error.
+func uploadAttachment(ctx context.Context, target client.Target, key string, attachment *store.Attachment, files []store.StagedFile, opts *Options) (posted []*store.SentFile, err error) {
+	ctx, span := tracing.Start(ctx, "upload.attachment", attribute.String("ticket", key), attribute.String("path", attachment.Path), attribute.Int("files", len(files)))
+	defer func() {
+		span.SetAttributes(attribute.Int("stalls", attachment.Stalls))
+		tracing.End(span, err)
+	}()
+
+	for _, file := range files {
+		var sent *store.SentFile
+		attempt, stalls := 0, 0
+		for {
+			var stalled bool
+			stalled, err = opts.Watchdog.Run(ctx, func(ctx context.Context) error {
+				var postErr error
+				sent, postErr = postAttachment(ctx, target, key, file, opts)
+				return postErr
+			})
+			if err == nil {
+				break
+			}
+			if stalled {
+				attachment.Stalls++
+				stalls++
+				if stalls <= maxStallRetries {
+					fmt.Printf("Restarting stalled attachment %s (stall %d of %d): %s\n", file.Path, stalls, maxStallRetries, err)
+					continue
+				}
+			}
+			if attempt >= opts.Retries {
+				break
+			}
+			attempt++
+			fmt.Printf("Retrying attachment %s (attempt %d of %d): %s\n", file.Path, attempt, opts.Retries, err)
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if err != nil {
+			return posted, err
+		}
+		posted = append(posted, sent)
+	}
+	return posted, nil
+}
+
+// postAttachment uploads a single file and records the checksum and size of
+// the bytes sent alongside the size the target reports for the new
+// attachment.
+func postAttachment(ctx context.Context, target client.Target, key string, file store.StagedFile, opts *Options) (*store.SentFile, error) {
+	r, done, err := opts.open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	output.Detailf("Uploading attachment %s to %s\n", file.Path, key)
+	ctx, span := tracing.Start(ctx, "upload.file", attribute.String("path", file.Path), attribute.String("name", file.Name))
+	content := checksum.NewReader(r, opts.HashAlgorithm)
+	attachment, err := target.PostAttachment(ctx, key, content, file.Name)
+	span.SetAttributes(attribute.Int64("bytes", content.N))
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed uploading attachment: %w", err)
+	}
+	err = audit.Record(&audit.Entry{Action: audit.ActionUploaded, Ticket: key, Path: file.Path, ID: attachment.ID, Detail: file.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.SentFile{
+		Name:     file.Name,
+		Checksum: content.Sum(),
+		Bytes:    content.N,
+		JIRAID:   attachment.ID,
+		JIRASize: attachment.Size,
+		Time:     time.Now().UTC(),
+		RunID:    audit.RunID,
+	}, nil
+}
+
+// rollbackAttachments deletes attachments posted during a failed atomic
+// upload.
+func rollbackAttachments(target client.Target, key string, ids []string) error {
+	for _, id := range ids {
+		err := target.DeleteAttachment(context.Background(), key, id)
+		if err != nil {
+			return fmt.Errorf("failed deleting attachment %s: %w", id, err)
+		}
+		err = audit.Record(&audit.Entry{Action: audit.ActionDeleted, Ticket: key, ID: id})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordFailure quarantines the attachment with the error from its latest
+// upload attempt, redacted as it is shown by status and serve.
+func RecordFailure(attachment *store.Attachment, err error) {
+	attempts := 1
+	if attachment.Failure != nil {
+		attempts = attachment.Failure.Attempts + 1
+	}
+	attachment.Failure = &store.UploadFailure{
+		Error:    output.Redact(err.Error()),
+		Time:     time.Now().UTC(),
+		Attempts: attempts,
+		RunID:    audit.RunID,
+	}
+}
+
+// Failed returns the quarantined attachments keyed by the title of the ticket
+// they belong to, regardless of whether the ticket is marked uploaded.
+func Failed(db *store.Database, filter *store.IssueFilter) map[string][]*store.Attachment {
+	failed := make(map[string][]*store.Attachment)
+	for title, issue := range db.Issues {
+		if _, ok := db.Tickets[title]; !ok || !filter.Allows(issue.Number) {
+			continue
+		}
+		for _, attachment := range db.Attachments {
+			if attachment.IssueNumber == issue.Number && attachment.Failure != nil && attachment.Flagged == nil && !attachment.Deleted {
+				failed[title] = append(failed[title], attachment)
+			}
+		}
+	}
+	return failed
+}
+
+// Flagged returns the attachments quarantined by screening keyed by the title
+// of the ticket they belong to, regardless of whether the ticket is marked
+// uploaded.
+func Flagged(db *store.Database, filter *store.IssueFilter) map[string][]*store.Attachment {
+	flagged := make(map[string][]*store.Attachment)
+	for title, issue := range db.Issues {
+		if _, ok := db.Tickets[title]; !ok || !filter.Allows(issue.Number) {
+			continue
+		}
+		for _, attachment := range db.Attachments {
+			if attachment.IssueNumber == issue.Number && attachment.Flagged != nil && !attachment.Deleted {
+				flagged[title] = append(flagged[title], attachment)
+			}
+		}
+	}
+	return flagged
+}
@@ -0,0 +1,360 @@
+package upload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/fake"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// newWorkspace stages the files of a database with an issue per ticket in a
+// temporary directory, pointing the database and staging directory at it
+// for the test.
+func newWorkspace(t *testing.T, tickets map[string]string, files map[int]map[string]string) *store.Database {
+	dir := t.TempDir()
+	databaseFile, stageDir := store.DatabaseFile, store.StageDir
+	store.DatabaseFile = filepath.Join(dir, "database.json")
+	store.StageDir = filepath.Join(dir, "stage")
+	t.Cleanup(func() {
+		store.DatabaseFile, store.StageDir = databaseFile, stageDir
+	})
+
+	db := store.New(checksum.SHA256)
+	number := 0
+	for title, key := range tickets {
+		number++
+		db.Issues[title] = &store.Issue{Number: number}
+		db.Tickets[title] = &store.Ticket{Key: key}
+	}
+	for number, staged := range files {
+		var paths []string
+		for path := range staged {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			content := staged[path]
+			err := os.MkdirAll(filepath.Dir(filepath.Join(store.StageDir, path)), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(store.StageDir, path), []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Attachments = append(db.Attachments, &store.Attachment{Type: "issue", IssueNumber: number, Path: path})
+		}
+	}
+	err := store.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// uploadPending uploads every pending ticket of the database to the fake
+// JIRA, returning the first error.
+func uploadPending(t *testing.T, jira *fake.JIRA, db *store.Database, opts *Options) error {
+	filter, err := store.ParseIssueFilter("all", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := client.NewJIRATarget(client.NewJIRA(jira.Client()))
+	for title, attachments := range Pending(db, filter) {
+		err = Ticket(target, db, title, attachments, opts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestTicketUploadsToJIRA uploads the staged attachments of a ticket to a
+// fake JIRA and checks the files it received and the database saved.
+func TestTicketUploadsToJIRA(t *testing.T) {
+	jira := fake.NewJIRA()
+	defer jira.Close()
+	jira.AddTicket("PROJ-1", "Crash on save")
+
+	db := newWorkspace(t, map[string]string{"Crash on save": "PROJ-1"}, map[int]map[string]string{
+		1: {
+			"attachments/1/crash.png": "png bytes",
+			"attachments/2/save.log":  "log lines",
+		},
+	})
+	err := uploadPending(t, jira, db, &Options{OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(map[string]*fake.Attachment)
+	for _, attachment := range jira.Attachments("PROJ-1") {
+		received[attachment.Filename] = attachment
+	}
+	if len(received) != 2 || string(received["crash.png"].Content) != "png bytes" || string(received["save.log"].Content) != "log lines" {
+		t.Fatalf("PROJ-1 received %v, want crash.png and save.log", received)
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Tickets["Crash on save"].Uploaded {
+		t.Error("ticket is not marked uploaded")
+	}
+	for _, attachment := range saved.Attachments {
+		uploaded := received[attachment.Name()]
+		if len(attachment.Sent) != 1 || len(attachment.JIRAIDs) != 1 || attachment.JIRAIDs[0] != uploaded.ID {
+			t.Errorf("attachment %s records IDs %v and %d sent files, want %s", attachment.Path, attachment.JIRAIDs, len(attachment.Sent), uploaded.ID)
+			continue
+		}
+		sum, err := checksum.Sum(openStaged(t, attachment.Path), checksum.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent := attachment.Sent[0]; sent.Checksum != sum || sent.JIRASize != int64(len(uploaded.Content)) {
+			t.Errorf("attachment %s recorded %+v, want checksum %s and size %d", attachment.Path, sent, sum, len(uploaded.Content))
+		}
+	}
+
+	// A second run finds nothing pending and leaves the ticket alone.
+	err = uploadPending(t, jira, saved, &Options{OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := jira.Stats(); stats.Uploaded != 2 {
+		t.Errorf("fake JIRA received %d uploads, want 2", stats.Uploaded)
+	}
+}
+
+// TestTicketSkipsConflicts uploads to a ticket already holding one of the
+// files and checks the skipped file is recorded under the existing
+// attachment.
+func TestTicketSkipsConflicts(t *testing.T) {
+	jira := fake.NewJIRA()
+	defer jira.Close()
+	jira.AddTicket("PROJ-1", "Crash on save")
+
+	db := newWorkspace(t, map[string]string{"Crash on save": "PROJ-1"}, map[int]map[string]string{
+		1: {"attachments/1/crash.png": "png bytes"},
+	})
+	err := uploadPending(t, jira, db, &Options{OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := jira.Attachments("PROJ-1")[0]
+
+	db.Tickets["Crash on save"].Uploaded = false
+	db.Attachments[0].JIRAIDs = nil
+	err = uploadPending(t, jira, db, &Options{OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received := jira.Attachments("PROJ-1"); len(received) != 1 {
+		t.Errorf("PROJ-1 holds %d attachments, want 1", len(received))
+	}
+	if ids := db.Attachments[0].JIRAIDs; len(ids) != 1 || ids[0] != existing.ID {
+		t.Errorf("skipped attachment records IDs %v, want %s", ids, existing.ID)
+	}
+	if !db.Tickets["Crash on save"].Uploaded {
+		t.Error("ticket whose attachments were all skipped is not marked uploaded")
+	}
+}
+
+// TestTicketLeavesFailedTicketPending fails an upload partway through a
+// ticket and checks the ticket stays pending with only the attachments not
+// yet attempted.
+func TestTicketLeavesFailedTicketPending(t *testing.T) {
+	jira := fake.NewJIRA()
+	defer jira.Close()
+	jira.AddTicket("PROJ-1", "Crash on save")
+	jira.UploadLimit = 10
+
+	db := newWorkspace(t, map[string]string{"Crash on save": "PROJ-1"}, map[int]map[string]string{
+		1: {
+			"attachments/1/a.txt": "small",
+			"attachments/2/b.txt": "over the upload limit",
+			"attachments/3/c.txt": "small",
+		},
+	})
+	err := uploadPending(t, jira, db, &Options{OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256})
+	if err == nil {
+		t.Fatal("upload of an oversize file succeeded")
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Tickets["Crash on save"].Uploaded {
+		t.Error("ticket with a failed attachment is marked uploaded")
+	}
+	if saved.Attachments[1].Failure == nil {
+		t.Error("failed attachment has no failure recorded")
+	}
+	filter, err := store.ParseIssueFilter("all", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := Pending(saved, filter)["Crash on save"]
+	if len(pending) != 1 || pending[0].Path != "attachments/3/c.txt" {
+		t.Errorf("pending attachments are %v, want attachments/3/c.txt", pending)
+	}
+}
+
+// TestTicketAtomicRollback fails an atomic upload partway through a ticket
+// and checks the attachments posted are removed and every attachment is
+// quarantined, so the ticket is retried as a whole.
+func TestTicketAtomicRollback(t *testing.T) {
+	jira := fake.NewJIRA()
+	defer jira.Close()
+	jira.AddTicket("PROJ-1", "Crash on save")
+	jira.UploadLimit = 10
+
+	db := newWorkspace(t, map[string]string{"Crash on save": "PROJ-1"}, map[int]map[string]string{
+		1: {
+			"attachments/1/a.txt": "small",
+			"attachments/2/b.txt": "over the upload limit",
+			"attachments/3/c.txt": "small",
+		},
+	})
+	err := uploadPending(t, jira, db, &Options{Atomic: true, OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256})
+	if err == nil {
+		t.Fatal("upload of an oversize file succeeded")
+	}
+	if received := jira.Attachments("PROJ-1"); len(received) != 0 {
+		t.Errorf("PROJ-1 holds %d attachments after the rollback, want none", len(received))
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Tickets["Crash on save"].Uploaded {
+		t.Error("rolled back ticket is marked uploaded")
+	}
+	for _, attachment := range saved.Attachments {
+		if attachment.Failure == nil || len(attachment.JIRAIDs) > 0 || len(attachment.Sent) > 0 {
+			t.Errorf("attachment %s records failure %v, IDs %v, and %d sent files, want a failure only", attachment.Path, attachment.Failure, attachment.JIRAIDs, len(attachment.Sent))
+		}
+	}
+	filter, err := store.ParseIssueFilter("all", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := Failed(saved, filter)["Crash on save"]; len(failed) != 3 {
+		t.Errorf("%d attachments are quarantined, want 3", len(failed))
+	}
+}
+
+// TestTicketAtomicRollbackKeepsSkipped fails an atomic upload to a ticket
+// already holding its other attachments and checks the rollback leaves the
+// attachments skipped as already attached alone, recording the existing
+// attachments.
+func TestTicketAtomicRollbackKeepsSkipped(t *testing.T) {
+	jira := fake.NewJIRA()
+	defer jira.Close()
+	jira.AddTicket("PROJ-1", "Crash on save")
+	jira.UploadLimit = 10
+
+	db := newWorkspace(t, map[string]string{"Crash on save": "PROJ-1"}, map[int]map[string]string{
+		1: {
+			"attachments/1/a.txt": "small",
+			"attachments/2/b.txt": "small",
+			"attachments/3/c.txt": "over the upload limit",
+		},
+	})
+	err := uploadPending(t, jira, db, &Options{OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256, ContinueOnError: true})
+	if err == nil {
+		t.Fatal("upload of an oversize file succeeded")
+	}
+	existing := jira.Attachments("PROJ-1")
+	if len(existing) != 2 {
+		t.Fatalf("PROJ-1 holds %d attachments, want 2", len(existing))
+	}
+	for _, attachment := range db.Attachments {
+		attachment.JIRAIDs = nil
+		attachment.Failure = nil
+	}
+
+	err = uploadPending(t, jira, db, &Options{Atomic: true, OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256})
+	if err == nil {
+		t.Fatal("upload of an oversize file succeeded")
+	}
+	if received := jira.Attachments("PROJ-1"); len(received) != 2 {
+		t.Errorf("PROJ-1 holds %d attachments after the rollback, want the 2 already attached", len(received))
+	}
+	for _, attachment := range db.Attachments {
+		if attachment.Path == "attachments/3/c.txt" {
+			if attachment.Failure == nil {
+				t.Errorf("attachment %s records no failure", attachment.Path)
+			}
+			continue
+		}
+		if attachment.Failure != nil || len(attachment.JIRAIDs) != 1 {
+			t.Errorf("skipped attachment %s records failure %v and IDs %v, want the existing attachment only", attachment.Path, attachment.Failure, attachment.JIRAIDs)
+		}
+	}
+}
+
+// TestTicketKeepsQuarantinedTicketPending uploads a ticket one of whose
+// attachments was quarantined by an earlier failure, and checks the ticket is
+// only marked uploaded once the quarantined attachment is retried.
+func TestTicketKeepsQuarantinedTicketPending(t *testing.T) {
+	jira := fake.NewJIRA()
+	defer jira.Close()
+	jira.AddTicket("PROJ-1", "Crash on save")
+
+	db := newWorkspace(t, map[string]string{"Crash on save": "PROJ-1"}, map[int]map[string]string{
+		1: {
+			"attachments/1/a.txt": "uploaded now",
+			"attachments/2/b.txt": "failed before",
+		},
+	})
+	quarantined := db.Attachments[1]
+	RecordFailure(quarantined, fmt.Errorf("503 Service Unavailable"))
+	opts := &Options{OnConflict: ConflictSkip, HashAlgorithm: checksum.SHA256}
+	err := uploadPending(t, jira, db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received := jira.Attachments("PROJ-1"); len(received) != 1 {
+		t.Errorf("PROJ-1 holds %d attachments, want 1", len(received))
+	}
+	if db.Tickets["Crash on save"].Uploaded {
+		t.Fatal("ticket with a quarantined attachment is marked uploaded")
+	}
+
+	filter, err := store.ParseIssueFilter("all", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := Failed(db, filter)["Crash on save"]
+	if len(failed) != 1 || failed[0] != quarantined {
+		t.Fatalf("failed attachments are %v, want %s", failed, quarantined.Path)
+	}
+	target := client.NewJIRATarget(client.NewJIRA(jira.Client()))
+	err = Ticket(target, db, "Crash on save", failed, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.Tickets["Crash on save"].Uploaded {
+		t.Error("ticket is not marked uploaded once its quarantined attachment is retried")
+	}
+}
+
+// openStaged opens the staged file at path for the rest of the test.
+func openStaged(t *testing.T, path string) *os.File {
+	file, err := os.Open(filepath.Join(store.StageDir, filepath.FromSlash(path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
@@ -0,0 +1,127 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// progressTracker records when bytes last moved over a request.
+type progressTracker struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{last: time.Now()}
+}
+
+func (p *progressTracker) touch() {
+	p.mu.Lock()
+	p.last = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *progressTracker) idle() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Since(p.last)
+}
+
+type progressKey struct{}
+
+// withProgress attaches the tracker to the context so ProgressTransport
+// records the progress of requests made with it.
+func withProgress(ctx context.Context, p *progressTracker) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// ProgressTransport wraps the request and response bodies of requests
+// carrying a progress tracker so every read counts as progress. The JIRA
+// client used with a Watchdog must send its requests through it.
+type ProgressTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *ProgressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p, ok := req.Context().Value(progressKey{}).(*progressTracker)
+	if !ok {
+		return t.Base.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body = &progressReader{ReadCloser: req.Body, progress: p}
+	}
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	p.touch()
+	resp.Body = &progressReader{ReadCloser: resp.Body, progress: p}
+	return resp, nil
+}
+
+type progressReader struct {
+	io.ReadCloser
+	progress *progressTracker
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if n > 0 {
+		r.progress.touch()
+	}
+	return n, err
+}
+
+// Watchdog cancels a transfer that makes no byte progress for the timeout.
+// A zero timeout disables the watchdog.
+type Watchdog struct {
+	Timeout time.Duration
+}
+
+// Run calls fn with a context derived from parent whose requests are tracked
+// and cancelled once they stall, and reports whether the transfer was
+// cancelled for stalling.
+func (w *Watchdog) Run(parent context.Context, fn func(ctx context.Context) error) (bool, error) {
+	if w == nil || w.Timeout <= 0 {
+		return false, fn(parent)
+	}
+
+	p := newProgressTracker()
+	ctx, cancel := context.WithCancel(withProgress(parent, p))
+	defer cancel()
+
+	done := make(chan struct{})
+	stalled := make(chan bool, 1)
+	go func() {
+		interval := w.Timeout / 10
+		if interval < time.Second {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				stalled <- false
+				return
+			case <-ticker.C:
+				if p.idle() >= w.Timeout {
+					cancel()
+					stalled <- true
+					return
+				}
+			}
+		}
+	}()
+
+	err := fn(ctx)
+	close(done)
+	if <-stalled {
+		return true, fmt.Errorf("transfer stalled with no progress for %s", w.Timeout)
+	}
+	return false, err
+}
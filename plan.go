@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/upload"
+	"github.com/thatisuday/commando"
+)
+
+// planVersion is the layout of the plan files written by plan, which upload
+// --plan refuses to execute when it does not recognize it.
+const planVersion = 1
+
+// uploadPlan is the set of uploads a later upload performs, written by plan
+// for review and executed verbatim by upload --plan.
+type uploadPlan struct {
+	Version   int              `json:"version"`
+	Created   time.Time        `json:"created"`
+	Database  string           `json:"database"`
+	Target    string           `json:"target"`
+	Algorithm string           `json:"algorithm"`
+	Uploads   int              `json:"uploads"`
+	Bytes     int64            `json:"bytes"`
+	Tickets   []*plannedTicket `json:"tickets"`
+	Skipped   []*plannedSkip   `json:"skipped"`
+	Unmatched []*plannedSkip   `json:"unmatched"`
+}
+
+// plannedTicket lists the attachments uploaded to a ticket, in the order
+// they are uploaded.
+type plannedTicket struct {
+	Ticket      string               `json:"ticket"`
+	Title       string               `json:"title"`
+	Issue       int                  `json:"issue"`
+	Bytes       int64                `json:"bytes"`
+	Attachments []*plannedAttachment `json:"attachments"`
+}
+
+// plannedAttachment identifies an attachment by its path and checksum, so
+// upload --plan notices when it changed after the plan was approved.
+type plannedAttachment struct {
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// plannedSkip is an attachment the upload leaves alone, with the reason.
+type plannedSkip struct {
+	Path   string `json:"path"`
+	Issue  int    `json:"issue"`
+	Reason string `json:"reason"`
+}
+
+// plan prints the uploads a subsequent upload with the same selection flags
+// would perform, and writes them to --output for upload --plan.
+func plan(flags map[string]commando.FlagValue) error {
+	output := flags["output"].Value.(string)
+	order := flags["order"].Value.(string)
+	retryFailed := flags["retry-failed"].Value.(bool)
+
+	err := validOrder(order)
+	if err != nil {
+		return configError(err)
+	}
+	filter, err := store.ParseIssueFilter(flags["only-issues"].Value.(string), flags["skip-issues"].Value.(string))
+	if err != nil {
+		return configError(err)
+	}
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if db.Unstaged && order != orderTitle {
+		return configError(fmt.Errorf("--order needs staged attachments and cannot be used with a database collected with --no-stage, which uploads in archive order"))
+	}
+	err = selectUploads(db, filter, flags["ticket"].Value.(string), flags["issue"].Value.(string))
+	if err != nil {
+		return configError(err)
+	}
+
+	p, err := computePlan(db, filter, order, retryFailed)
+	if err != nil {
+		return err
+	}
+	p.print()
+	if output == "none" {
+		return nil
+	}
+	bytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshalling plan: %w", err)
+	}
+	err = os.WriteFile(output, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing plan: %w", err)
+	}
+	fmt.Printf("\nSaved the plan to %s, execute it with upload --plan %s\n", output, output)
+	return nil
+}
+
+// computePlan lists the attachments upload would post to each ticket, in the
+// order it would post them, and the attachments of the selected issues it
+// would skip or has no ticket for. With retryFailed only the attachments that
+// failed in an earlier run are planned, as with upload --retry-failed.
+func computePlan(db *store.Database, filter *store.IssueFilter, order string, retryFailed bool) (*uploadPlan, error) {
+	pending := upload.Pending(db, filter)
+	if retryFailed {
+		pending = upload.Failed(db, filter)
+	}
+	titles, err := orderUploads(db, pending, order, db.Unstaged)
+	if err != nil {
+		return nil, fmt.Errorf("failed ordering uploads: %w", err)
+	}
+
+	p := &uploadPlan{
+		Version:   planVersion,
+		Created:   time.Now().UTC(),
+		Database:  store.DatabaseFile,
+		Target:    db.TargetName(),
+		Algorithm: db.Algorithm(),
+		Tickets:   []*plannedTicket{},
+		Skipped:   []*plannedSkip{},
+		Unmatched: []*plannedSkip{},
+	}
+	for _, title := range titles {
+		planned := &plannedTicket{
+			Ticket: db.Tickets[title].Key,
+			Title:  title,
+			Issue:  db.Issues[title].Number,
+		}
+		for _, attachment := range pending[title] {
+			size, err := attachmentSize(attachment, db.Unstaged)
+			if err != nil {
+				return nil, err
+			}
+			planned.Attachments = append(planned.Attachments, &plannedAttachment{
+				Path:     attachment.Path,
+				Checksum: attachment.Checksum,
+				Bytes:    size,
+			})
+			planned.Bytes += size
+		}
+		p.Tickets = append(p.Tickets, planned)
+		p.Uploads += len(planned.Attachments)
+		p.Bytes += planned.Bytes
+	}
+
+	linked := make(map[int]bool)
+	for title, issue := range db.Issues {
+		if _, ok := db.Tickets[title]; ok {
+			linked[issue.Number] = true
+		}
+	}
+	for _, attachment := range db.Attachments {
+		if !filter.Allows(attachment.IssueNumber) || len(attachment.JIRAIDs) > 0 {
+			continue
+		}
+		reason := ""
+		switch {
+		case attachment.Deleted:
+			reason = "deleted"
+		case attachment.DuplicateOf != "":
+			reason = fmt.Sprintf("content is uploaded from %s on issue %d", attachment.DuplicateOf, attachment.DuplicateOfIssue)
+		case attachment.Flagged != nil:
+			reason = fmt.Sprintf("quarantined by screening, %s", attachment.Flagged.Reason)
+		case attachment.Failure != nil && !retryFailed:
+			reason = "failed to upload previously, plan with --retry-failed to reattempt it"
+		case !linked[attachment.IssueNumber]:
+			p.Unmatched = append(p.Unmatched, &plannedSkip{Path: attachment.Path, Issue: attachment.IssueNumber, Reason: "no matching ticket"})
+			continue
+		default:
+			continue
+		}
+		p.Skipped = append(p.Skipped, &plannedSkip{Path: attachment.Path, Issue: attachment.IssueNumber, Reason: reason})
+	}
+	sort.Slice(p.Skipped, func(i, j int) bool {
+		return p.Skipped[i].Path < p.Skipped[j].Path
+	})
+	sort.Slice(p.Unmatched, func(i, j int) bool {
+		return p.Unmatched[i].Path < p.Unmatched[j].Path
+	})
+	return p, nil
+}
+
+func (p *uploadPlan) print() {
+	for _, ticket := range p.Tickets {
+		fmt.Printf("Upload %d attachments (%s) to %s from issue #%d %q\n", len(ticket.Attachments), formatSize(ticket.Bytes), ticket.Ticket, ticket.Issue, ticket.Title)
+		for _, attachment := range ticket.Attachments {
+			fmt.Printf("  + %s (%s)\n", attachment.Path, formatSize(attachment.Bytes))
+		}
+	}
+	if len(p.Skipped) > 0 {
+		fmt.Printf("\nSkip %d attachments:\n", len(p.Skipped))
+		for _, skip := range p.Skipped {
+			fmt.Printf("  - %s (issue #%d): %s\n", skip.Path, skip.Issue, skip.Reason)
+		}
+	}
+	if len(p.Unmatched) > 0 {
+		fmt.Printf("\nLeave %d attachments of issues without a matching ticket:\n", len(p.Unmatched))
+		for _, skip := range p.Unmatched {
+			fmt.Printf("  ? %s (issue #%d)\n", skip.Path, skip.Issue)
+		}
+	}
+	fmt.Printf("\nPlan: %d uploads to %d tickets totaling %s, %d skipped, %d unmatched\n", p.Uploads, len(p.Tickets), formatSize(p.Bytes), len(p.Skipped), len(p.Unmatched))
+}
+
+// formatSize formats a byte count in the largest unit it has one of, where
+// KB, MB, and GB are multiples of 1024 as with parseSize.
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", bytes)
+}
+
+// loadPlan reads the plan written by plan --output.
+func loadPlan(path string) (*uploadPlan, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading plan: %w", err)
+	}
+	p := &uploadPlan{}
+	err = json.Unmarshal(bytes, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing plan: %w", err)
+	}
+	if p.Version != planVersion {
+		return nil, fmt.Errorf("plan %s has version %d, this version of the migrator executes version %d plans", path, p.Version, planVersion)
+	}
+	return p, nil
+}
+
+// apply returns the attachments of the plan still awaiting upload keyed by
+// ticket title, and the titles in the order they are uploaded in. Planned
+// attachments uploaded since, by an interrupted execution of the plan, are
+// left out so the plan can be executed again to finish it. Every difference
+// between the plan and the database, such as a ticket relinked or an
+// attachment changed or deleted, is an error, as the plan approved is no
+// longer the one that would run.
+func (p *uploadPlan) apply(db *store.Database) (map[string][]*store.Attachment, []string, error) {
+	if p.Target != db.TargetName() {
+		return nil, nil, fmt.Errorf("the plan uploads to the %s target but the database was collected for %s", p.Target, db.TargetName())
+	}
+	if p.Algorithm != db.Algorithm() {
+		return nil, nil, fmt.Errorf("the plan's checksums use %s but the database's use %s", p.Algorithm, db.Algorithm())
+	}
+
+	type issuePath struct {
+		issue int
+		path  string
+	}
+	attachments := make(map[issuePath]*store.Attachment)
+	for _, attachment := range db.Attachments {
+		attachments[issuePath{attachment.IssueNumber, attachment.Path}] = attachment
+	}
+	var changes []string
+	pending := make(map[string][]*store.Attachment)
+	var titles []string
+	for _, planned := range p.Tickets {
+		ticket, ok := db.Tickets[planned.Title]
+		issue := db.Issues[planned.Title]
+		switch {
+		case !ok || issue == nil:
+			changes = append(changes, fmt.Sprintf("issue #%d %q is no longer linked to %s", planned.Issue, planned.Title, planned.Ticket))
+			continue
+		case ticket.Key != planned.Ticket:
+			changes = append(changes, fmt.Sprintf("issue #%d is now linked to %s instead of %s", planned.Issue, ticket.Key, planned.Ticket))
+			continue
+		}
+		for _, entry := range planned.Attachments {
+			attachment, ok := attachments[issuePath{issue.Number, entry.Path}]
+			switch {
+			case !ok:
+				changes = append(changes, fmt.Sprintf("attachment %s of issue #%d is no longer in the database", entry.Path, planned.Issue))
+			case attachment.Deleted:
+				changes = append(changes, fmt.Sprintf("attachment %s was deleted", entry.Path))
+			case attachment.Checksum != entry.Checksum:
+				changes = append(changes, fmt.Sprintf("attachment %s changed, its checksum is %s instead of %s", entry.Path, attachment.Checksum, entry.Checksum))
+			case len(attachment.JIRAIDs) > 0:
+				output.Detailf("Skipping attachment %s, already uploaded to %s\n", entry.Path, ticket.Key)
+			default:
+				if len(pending[planned.Title]) == 0 {
+					titles = append(titles, planned.Title)
+				}
+				pending[planned.Title] = append(pending[planned.Title], attachment)
+			}
+		}
+	}
+	if len(changes) > 0 {
+		return nil, nil, fmt.Errorf("the database no longer matches the plan, run plan again:\n  %s", strings.Join(changes, "\n  "))
+	}
+	return pending, titles, nil
+}
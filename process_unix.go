@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// processRunning reports whether a process with the PID is running on this
+// host.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const processQueryLimitedInformation = 0x1000
+
+// processRunning reports whether a process with the PID is running on this
+// host.
+func processRunning(pid int) bool {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+	var code uint32
+	err = syscall.GetExitCodeProcess(handle, &code)
+	// STILL_ACTIVE is reported for processes that have not exited.
+	return err == nil && code == 259
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// migrationProperty is the entity property recording on a ticket where its
+// attachments were migrated from, for automation and audits querying the
+// JIRA API.
+type migrationProperty struct {
+	Issue       migrationIssue         `json:"issue"`
+	RunID       string                 `json:"run_id"`
+	Updated     time.Time              `json:"updated"`
+	Algorithm   string                 `json:"algorithm"`
+	Attachments []*migrationAttachment `json:"attachments"`
+}
+
+type migrationIssue struct {
+	URL    string `json:"url"`
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+}
+
+// migrationAttachment is an attachment migrated to the ticket, by any run.
+type migrationAttachment struct {
+	Path     string   `json:"path"`
+	Source   string   `json:"source"`
+	Checksum string   `json:"checksum"`
+	Files    []string `json:"files,omitempty"`
+	JIRAIDs  []string `json:"jira_ids"`
+}
+
+// setMigrationProperty writes the entity property under the key on the
+// ticket, listing the GitHub issue it was migrated from, every attachment of
+// the issue on the ticket, and the run writing it. The property is replaced
+// on each run, so it always holds the full inventory.
+func setMigrationProperty(client *jira.Client, db *store.Database, title, key, runID string) error {
+	ticket := db.Tickets[title]
+	issue := db.Issues[title]
+	property := &migrationProperty{
+		Issue:       migrationIssue{URL: issue.URL, Number: issue.Number, Title: title},
+		RunID:       runID,
+		Updated:     time.Now().UTC(),
+		Algorithm:   db.Algorithm(),
+		Attachments: []*migrationAttachment{},
+	}
+	for _, attachment := range db.Attachments {
+		if attachment.IssueNumber != issue.Number || attachment.Deleted || len(attachment.JIRAIDs) == 0 {
+			continue
+		}
+		entry := &migrationAttachment{
+			Path:     attachment.Path,
+			Source:   attachment.URL,
+			Checksum: attachment.Checksum,
+			JIRAIDs:  attachment.JIRAIDs,
+		}
+		for _, sent := range attachment.Sent {
+			entry.Files = append(entry.Files, sent.Name)
+		}
+		property.Attachments = append(property.Attachments, entry)
+	}
+	sort.Slice(property.Attachments, func(i, j int) bool {
+		return property.Attachments[i].Path < property.Attachments[j].Path
+	})
+
+	endpoint := fmt.Sprintf("rest/api/2/issue/%s/properties/%s", url.PathEscape(ticket.Key), url.PathEscape(key))
+	req, err := client.NewRequest("PUT", endpoint, property)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+	fmt.Printf("Setting entity property %s on %s\n", key, ticket.Key)
+	resp, err := client.Do(req, nil)
+	if err != nil {
+		return fmt.Errorf("failed setting entity property %s on %s: %w", key, ticket.Key, jiraRefused(resp, err))
+	}
+	return audit.Record(&audit.Entry{Action: audit.ActionPropertySet, Ticket: ticket.Key, Detail: key})
+}
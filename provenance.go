@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/upload"
+)
+
+// postProvenance leaves one comment on the ticket per run listing the
+// attachments uploaded in the run with the GitHub issue or comment they came
+// from and when they were uploaded, in server time. With update set, a
+// single comment listing every migrated attachment is kept on the ticket and
+// updated in place on later runs instead.
+func postProvenance(client *jira.Client, ticket *store.Ticket, attachments []*store.Attachment, clock *store.ServerClock, opts *upload.Options) error {
+	var listed []*store.Attachment
+	for _, attachment := range attachments {
+		if opts.ProvenanceUpdate || attachment.UploadedSince(opts.Started) {
+			listed = append(listed, attachment)
+		}
+	}
+	body := provenanceBody(listed, clock)
+	if body == "" {
+		return nil
+	}
+
+	comment := &jira.Comment{Body: body}
+	if opts.ProvenanceUpdate && ticket.ProvenanceComment != "" {
+		comment.ID = ticket.ProvenanceComment
+		fmt.Printf("Updating provenance comment on %s\n", ticket.Key)
+		_, resp, err := client.Issue.UpdateComment(ticket.Key, comment)
+		if err != nil {
+			return fmt.Errorf("failed updating provenance comment on %s: %w", ticket.Key, jiraRefused(resp, err))
+		}
+		return audit.Record(&audit.Entry{Action: audit.ActionCommentUpdated, Ticket: ticket.Key, ID: comment.ID, Detail: "provenance"})
+	}
+
+	fmt.Printf("Adding provenance comment to %s\n", ticket.Key)
+	posted, resp, err := client.Issue.AddComment(ticket.Key, comment)
+	if err != nil {
+		return fmt.Errorf("failed adding provenance comment to %s: %w", ticket.Key, jiraRefused(resp, err))
+	}
+	ticket.ProvenanceComment = posted.ID
+	return audit.Record(&audit.Entry{Action: audit.ActionCommented, Ticket: ticket.Key, ID: posted.ID, Detail: "provenance"})
+}
+
+// provenanceBody renders the comment in JIRA wiki markup, linking each file
+// posted for an attachment with the run that posted it. Attachments that
+// were already on the ticket are listed without an upload time.
+func provenanceBody(attachments []*store.Attachment, clock *store.ServerClock) string {
+	var lines []string
+	for _, attachment := range attachments {
+		if len(attachment.JIRAIDs) == 0 {
+			continue
+		}
+		if len(attachment.Sent) == 0 {
+			lines = append(lines, fmt.Sprintf("* %s from %s, already attached", attachment.Name(), attachment.URL))
+			continue
+		}
+		var files []string
+		for _, sent := range attachment.Sent {
+			files = append(files, fmt.Sprintf("[^%s]", sent.Name))
+		}
+		last := attachment.Sent[len(attachment.Sent)-1]
+		uploaded := fmt.Sprintf("uploaded %s", clock.ServerTime(last.Time).Format(time.RFC3339))
+		if last.RunID != "" {
+			uploaded += " by run " + last.RunID
+		}
+		lines = append(lines, fmt.Sprintf("* %s from %s, %s", strings.Join(files, " "), attachment.URL, uploaded))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Attachments migrated from GitHub, as of run %s:\n", audit.RunID) + strings.Join(lines, "\n")
+}
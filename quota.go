@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// attachmentMeta is the attachment configuration reported by
+// /rest/api/2/attachment/meta.
+type attachmentMeta struct {
+	Enabled     bool  `json:"enabled"`
+	UploadLimit int64 `json:"uploadLimit"`
+}
+
+func getAttachmentMeta(client *jira.Client) (*attachmentMeta, error) {
+	req, err := client.NewRequest("GET", "rest/api/2/attachment/meta", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request: %w", err)
+	}
+	meta := &attachmentMeta{}
+	resp, err := client.Do(req, meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving attachment settings: %w", jiraRefused(resp, err))
+	}
+	return meta, nil
+}
+
+// estimateQuota totals the bytes each JIRA project will receive from the
+// pending uploads and warns when the migration is expected to exceed the
+// available attachment storage. JIRA does not report the remaining storage
+// through its REST API, so the headroom is supplied by the operator; a
+// headroom of zero skips the comparison.
+func estimateQuota(meta *attachmentMeta, db *store.Database, pending map[string][]*store.Attachment, headroom int64) error {
+	totals := make(map[string]int64)
+	for title, attachments := range pending {
+		project := strings.Split(db.Tickets[title].Key, "-")[0]
+		for _, attachment := range attachments {
+			size, err := attachmentSize(attachment, db.Unstaged)
+			if err != nil {
+				return err
+			}
+			totals[project] += size
+		}
+	}
+
+	if !meta.Enabled {
+		fmt.Println("Warning: attachments are disabled on the JIRA instance")
+	}
+
+	projects := make([]string, 0, len(totals))
+	for project := range totals {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	var total int64
+	for _, project := range projects {
+		fmt.Printf("Project %s will receive %d bytes of attachments\n", project, totals[project])
+		total += totals[project]
+	}
+	fmt.Printf("Total pending upload: %d bytes\n", total)
+
+	if headroom > 0 && total > headroom {
+		fmt.Printf("Warning: pending uploads exceed the available attachment storage by %d bytes\n", total-headroom)
+	}
+
+	return nil
+}
+
+// applySizeLimit handles pending attachments larger than the JIRA upload
+// limit. When split is set they are zipped into volumes under the limit and
+// kept; otherwise a skip reason is recorded and they are removed from the
+// pending uploads and returned. Reasons left by earlier runs are cleared for
+// files that now fit, so raising the limit brings them back.
+func applySizeLimit(pending map[string][]*store.Attachment, limit int64, split, unstaged bool) ([]*store.Attachment, error) {
+	var skipped []*store.Attachment
+	for title, attachments := range pending {
+		var allowed []*store.Attachment
+		for _, attachment := range attachments {
+			size, err := attachmentSize(attachment, unstaged)
+			if err != nil {
+				return nil, err
+			}
+			if limit <= 0 || size <= limit {
+				attachment.SkipReason = ""
+				attachment.Parts = nil
+				allowed = append(allowed, attachment)
+				continue
+			}
+			if split {
+				output.Detailf("Splitting attachment %s into volumes of at most %d bytes\n", attachment.Path, limit)
+				parts, err := splitAttachment(attachment, limit)
+				if err != nil {
+					return nil, fmt.Errorf("failed splitting %s: %w", attachment.Path, err)
+				}
+				attachment.SkipReason = ""
+				attachment.Parts = parts
+				allowed = append(allowed, attachment)
+				continue
+			}
+			attachment.SkipReason = fmt.Sprintf("%d bytes exceeds the JIRA upload limit of %d bytes", size, limit)
+			skipped = append(skipped, attachment)
+		}
+		if len(allowed) == 0 {
+			delete(pending, title)
+			continue
+		}
+		pending[title] = allowed
+	}
+	sort.Slice(skipped, func(i, j int) bool {
+		return skipped[i].Path < skipped[j].Path
+	})
+	return skipped, nil
+}
+
+// attachmentSize returns the size of the staged attachment, or of its
+// downscaled copy when it has one, or the size of its archive member recorded
+// during collection when it was not staged.
+func attachmentSize(attachment *store.Attachment, unstaged bool) (int64, error) {
+	if unstaged {
+		return attachment.Size, nil
+	}
+	path := attachment.Path
+	if attachment.Downscaled != "" {
+		path = attachment.Downscaled
+	}
+	info, err := os.Stat(filepath.Join(store.StageDir, path))
+	if err != nil {
+		return 0, fmt.Errorf("failed getting file stats: %w", err)
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// linkGitHubIssue adds a remote link from the ticket back to the GitHub
+// issue it was migrated from. The issue URL is used as the link's global ID,
+// so JIRA updates the existing link rather than adding another when a ticket
+// is uploaded again.
+func linkGitHubIssue(client *jira.Client, ticket *store.Ticket, title string, issue *store.Issue) error {
+	link := &jira.RemoteLink{
+		GlobalID: issue.URL,
+		Application: &jira.RemoteLinkApplication{
+			Type: "com.github",
+			Name: "GitHub",
+		},
+		Relationship: "migrated from",
+		Object: &jira.RemoteLinkObject{
+			URL:   issue.URL,
+			Title: fmt.Sprintf("GitHub issue #%d", issue.Number),
+			Icon: &jira.RemoteLinkIcon{
+				Url16x16: "https://github.com/favicon.ico",
+				Title:    "GitHub",
+			},
+			Summary: title,
+		},
+	}
+
+	fmt.Printf("Linking %s to %s\n", ticket.Key, issue.URL)
+	_, resp, err := client.Issue.AddRemoteLink(ticket.Key, link)
+	if err != nil {
+		return fmt.Errorf("failed linking %s to %s: %w", ticket.Key, issue.URL, jiraRefused(resp, err))
+	}
+	return audit.Record(&audit.Entry{Action: audit.ActionLinked, Ticket: ticket.Key, Detail: issue.URL})
+}
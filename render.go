@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// renderPolicy lists the file extensions JIRA cannot preview and those the
+// target instance refuses by policy.
+type renderPolicy struct {
+	Unpreviewable []string `json:"unpreviewable"`
+	Blocked       []string `json:"blocked"`
+}
+
+// defaultRenderPolicy covers the formats JIRA stores but shows as plain
+// downloads rather than inline previews.
+var defaultRenderPolicy = &renderPolicy{
+	Unpreviewable: []string{
+		".avi", ".flv", ".heic", ".heif", ".mkv", ".psd", ".raw", ".tif", ".tiff", ".webm", ".webp", ".wmv",
+	},
+}
+
+func loadRenderPolicy(path string) (*renderPolicy, error) {
+	if path == "none" {
+		return defaultRenderPolicy, nil
+	}
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading render policy: %w", err)
+	}
+	policy := &renderPolicy{}
+	err = json.Unmarshal(bytes, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling render policy: %w", err)
+	}
+	return policy, nil
+}
+
+// check returns a warning for every pending attachment whose extension the
+// policy lists, sorted for stable output.
+func (p *renderPolicy) check(pending map[string][]*store.Attachment) []string {
+	var warnings []string
+	for _, attachments := range pending {
+		for _, attachment := range attachments {
+			ext := strings.ToLower(filepath.Ext(attachment.Name()))
+			if ext == "" {
+				continue
+			}
+			switch {
+			case containsExtension(p.Blocked, ext):
+				warnings = append(warnings, fmt.Sprintf("%s is a %s file, which the JIRA instance blocks", attachment.Path, ext))
+			case containsExtension(p.Unpreviewable, ext):
+				warnings = append(warnings, fmt.Sprintf("%s is a %s file, which JIRA cannot preview", attachment.Path, ext))
+			}
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+func containsExtension(extensions []string, ext string) bool {
+	for _, candidate := range extensions {
+		if !strings.HasPrefix(candidate, ".") {
+			candidate = "." + candidate
+		}
+		if strings.EqualFold(candidate, ext) {
+			return true
+		}
+	}
+	return false
+}
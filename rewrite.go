@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// rewritesFile records every ticket description and comment edited by the
+// rewrite command, alongside the database, so the edits can be rolled back.
+const rewritesFile = "rewrites.json"
+
+// rewriteEdit is a single description or comment edit. Comment is empty for
+// ticket descriptions.
+type rewriteEdit struct {
+	Ticket  string    `json:"ticket"`
+	Comment string    `json:"comment,omitempty"`
+	Before  string    `json:"before"`
+	After   string    `json:"after"`
+	Time    time.Time `json:"time"`
+}
+
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".bmp"}
+
+func rewrite(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) error {
+	action := args["action"].Value
+	jiraURL := flags["jira-url"].Value.(string)
+	_ = flags["jira-username"].Value.(string)
+	jiraSecret := flags["jira-secret"].Value.(string)
+	dryRun := flags["dry-run"].Value.(bool)
+	yes := flags["yes"].Value.(bool)
+
+	jira, err := newJIRAClient(jiraSecret, jiraURL)
+	if err != nil {
+		return configError(fmt.Errorf("failed creating JIRA client: %w", err))
+	}
+
+	// approve asks to confirm the edits, which a dry run does not make.
+	approve := func(change string) error {
+		if dryRun {
+			return nil
+		}
+		return confirmChanges(&changeSummary{instance: jiraURL, changes: []string{change}}, yes)
+	}
+	switch action {
+	case "apply":
+		return applyRewrites(jira, dryRun, approve)
+	case "rollback":
+		return rollbackRewrites(jira, dryRun, approve)
+	}
+	return configError(fmt.Errorf("unknown rewrite action %s, must be apply or rollback", action))
+}
+
+// assetReferences maps the GitHub asset URL of every uploaded attachment to
+// the JIRA markup referencing the uploaded file, grouped by ticket key.
+func assetReferences(db *store.Database) map[string]map[string]string {
+	references := make(map[string]map[string]string)
+	for title, ticket := range db.Tickets {
+		issue := db.Issues[title]
+		if issue == nil {
+			continue
+		}
+		for _, attachment := range db.Attachments {
+			if attachment.IssueNumber != issue.Number || attachment.AssetURL == "" || len(attachment.JIRAIDs) == 0 || attachment.Deleted {
+				continue
+			}
+			if references[ticket.Key] == nil {
+				references[ticket.Key] = make(map[string]string)
+			}
+			references[ticket.Key][attachment.AssetURL] = attachmentMarkup(attachment)
+		}
+	}
+	return references
+}
+
+// attachmentMarkup embeds images and links other files. Split attachments
+// link every volume.
+func attachmentMarkup(attachment *store.Attachment) string {
+	var names []string
+	for _, sent := range attachment.Sent {
+		names = append(names, sent.Name)
+	}
+	if len(names) == 0 {
+		for _, file := range attachment.StagedFiles() {
+			names = append(names, file.Name)
+		}
+	}
+	if len(names) == 1 && containsExtension(imageExtensions, filepath.Ext(names[0])) {
+		return fmt.Sprintf("!%s!", names[0])
+	}
+	var links []string
+	for _, name := range names {
+		links = append(links, fmt.Sprintf("[^%s]", name))
+	}
+	return strings.Join(links, " ")
+}
+
+// replaceAssetURLs replaces each asset URL in the text, including the wiki
+// and Markdown image and link syntax wrapped around it, with the markup of
+// the uploaded attachment.
+func replaceAssetURLs(text string, references map[string]string) string {
+	for _, url := range sortedKeys(references) {
+		if !strings.Contains(text, url) {
+			continue
+		}
+		quoted := regexp.QuoteMeta(url)
+		markup := strings.ReplaceAll(references[url], "$", "$$")
+		for _, pattern := range []string{
+			`!\[[^\]]*\]\(` + quoted + `\)`,
+			`\[[^\]]*\]\(` + quoted + `\)`,
+			`!` + quoted + `(\|[^!]*)?!`,
+			`\[[^\]|]*\|` + quoted + `\]`,
+			quoted,
+		} {
+			text = regexp.MustCompile(pattern).ReplaceAllString(text, markup)
+		}
+	}
+	return text
+}
+
+func applyRewrites(client *jira.Client, dryRun bool, approve func(string) error) error {
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+	edits, err := loadRewrites()
+	if err != nil {
+		return err
+	}
+
+	references := assetReferences(db)
+	if len(references) > 0 {
+		err = approve(fmt.Sprintf("rewrite GitHub asset URLs in the descriptions and comments of %d tickets", len(references)))
+		if err != nil {
+			return err
+		}
+	}
+	count := 0
+	for _, key := range sortedKeys(references) {
+		issue, resp, err := client.Issue.Get(key, &jira.GetQueryOptions{Fields: "description,comment"})
+		if err != nil {
+			return fmt.Errorf("failed retrieving ticket %s: %w", key, jiraRefused(resp, err))
+		}
+		if issue.Fields == nil {
+			continue
+		}
+
+		var pending []*rewriteEdit
+		if after := replaceAssetURLs(issue.Fields.Description, references[key]); after != issue.Fields.Description {
+			pending = append(pending, &rewriteEdit{Ticket: key, Before: issue.Fields.Description, After: after})
+		}
+		if issue.Fields.Comments != nil {
+			for _, comment := range issue.Fields.Comments.Comments {
+				if after := replaceAssetURLs(comment.Body, references[key]); after != comment.Body {
+					pending = append(pending, &rewriteEdit{Ticket: key, Comment: comment.ID, Before: comment.Body, After: after})
+				}
+			}
+		}
+
+		for _, edit := range pending {
+			fmt.Printf("Rewriting %s\n", edit.target())
+			count++
+			if dryRun {
+				continue
+			}
+			err = edit.apply(client, edit.After)
+			if err != nil {
+				return err
+			}
+			edit.Time = time.Now().UTC()
+			edits = append(edits, edit)
+			err = saveRewrites(edits)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("Would rewrite %d descriptions and comments\n", count)
+		return nil
+	}
+	fmt.Printf("Rewrote %d descriptions and comments\n", count)
+	return nil
+}
+
+// rollbackRewrites restores the recorded edits newest first. Text changed
+// since it was rewritten is left alone and reported.
+func rollbackRewrites(client *jira.Client, dryRun bool, approve func(string) error) error {
+	edits, err := loadRewrites()
+	if err != nil {
+		return err
+	}
+	if len(edits) > 0 {
+		err = approve(fmt.Sprintf("restore %d rewritten descriptions and comments", len(edits)))
+		if err != nil {
+			return err
+		}
+	}
+
+	var remaining []*rewriteEdit
+	restored, changed := 0, 0
+	for i := len(edits) - 1; i >= 0; i-- {
+		edit := edits[i]
+		current, err := edit.current(client)
+		if err != nil {
+			return err
+		}
+		if current != edit.After {
+			fmt.Printf("Skipping %s, it has changed since it was rewritten\n", edit.target())
+			remaining = append([]*rewriteEdit{edit}, remaining...)
+			changed++
+			continue
+		}
+		fmt.Printf("Restoring %s\n", edit.target())
+		restored++
+		if dryRun {
+			remaining = append([]*rewriteEdit{edit}, remaining...)
+			continue
+		}
+		err = edit.apply(client, edit.Before)
+		if err != nil {
+			return err
+		}
+		err = saveRewrites(append(edits[:i:i], remaining...))
+		if err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("Would restore %d descriptions and comments\n", restored)
+	} else {
+		fmt.Printf("Restored %d descriptions and comments\n", restored)
+	}
+	if changed > 0 {
+		return fmt.Errorf("%d descriptions and comments changed since they were rewritten", changed)
+	}
+	return nil
+}
+
+func (e *rewriteEdit) target() string {
+	if e.Comment == "" {
+		return fmt.Sprintf("description of %s", e.Ticket)
+	}
+	return fmt.Sprintf("comment %s on %s", e.Comment, e.Ticket)
+}
+
+func (e *rewriteEdit) apply(client *jira.Client, text string) error {
+	if e.Comment == "" {
+		resp, err := client.Issue.UpdateIssue(e.Ticket, map[string]interface{}{
+			"fields": map[string]interface{}{"description": text},
+		})
+		if err != nil {
+			return fmt.Errorf("failed updating %s: %w", e.target(), jiraRefused(resp, err))
+		}
+		return audit.Record(&audit.Entry{Action: audit.ActionDescriptionUpdated, Ticket: e.Ticket})
+	}
+	_, resp, err := client.Issue.UpdateComment(e.Ticket, &jira.Comment{ID: e.Comment, Body: text})
+	if err != nil {
+		return fmt.Errorf("failed updating %s: %w", e.target(), jiraRefused(resp, err))
+	}
+	return audit.Record(&audit.Entry{Action: audit.ActionCommentUpdated, Ticket: e.Ticket, ID: e.Comment})
+}
+
+func (e *rewriteEdit) current(client *jira.Client) (string, error) {
+	issue, resp, err := client.Issue.Get(e.Ticket, &jira.GetQueryOptions{Fields: "description,comment"})
+	if err != nil {
+		return "", fmt.Errorf("failed retrieving ticket %s: %w", e.Ticket, jiraRefused(resp, err))
+	}
+	if issue.Fields == nil {
+		return "", nil
+	}
+	if e.Comment == "" {
+		return issue.Fields.Description, nil
+	}
+	if issue.Fields.Comments != nil {
+		for _, comment := range issue.Fields.Comments.Comments {
+			if comment.ID == e.Comment {
+				return comment.Body, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func loadRewrites() ([]*rewriteEdit, error) {
+	bytes, err := os.ReadFile(store.StatePath(rewritesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading rewrites: %w", err)
+	}
+	var edits []*rewriteEdit
+	err = json.Unmarshal(bytes, &edits)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling rewrites: %w", err)
+	}
+	return edits, nil
+}
+
+func saveRewrites(edits []*rewriteEdit) error {
+	bytes, err := json.Marshal(edits)
+	if err != nil {
+		return fmt.Errorf("failed marshalling rewrites: %w", err)
+	}
+	err = os.WriteFile(store.StatePath(rewritesFile), bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing rewrites: %w", err)
+	}
+	return nil
+}
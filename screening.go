@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/screen"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// screening checks attachments for executables and malware before they are
+// uploaded.
+type screening struct {
+	blockExecutables bool
+	scanners         []screen.Scanner
+}
+
+// newScreening builds the screening from the --block-executables, --clamd,
+// and --scan-command flags of upload.
+func newScreening(flags map[string]commando.FlagValue) (*screening, error) {
+	s := &screening{blockExecutables: flags["block-executables"].Value.(bool)}
+	if clamd := flags["clamd"].Value.(string); clamd != "none" {
+		s.scanners = append(s.scanners, screen.NewClamd(clamd))
+	}
+	if command := flags["scan-command"].Value.(string); command != "none" {
+		scanner, err := screen.ParseCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		s.scanners = append(s.scanners, scanner)
+	}
+	return s, nil
+}
+
+// enabled reports whether any screening was requested.
+func (s *screening) enabled() bool {
+	return s.blockExecutables || len(s.scanners) > 0
+}
+
+// check screens the pending attachments, quarantining the flagged ones by
+// recording the finding on them, and removes them from the pending uploads.
+// It returns the flagged attachments. A file that cannot be scanned fails the
+// check rather than being uploaded unscreened.
+func (s *screening) check(pending map[string][]*store.Attachment, unstaged bool) ([]*store.Attachment, error) {
+	var flagged []*store.Attachment
+	for title, attachments := range pending {
+		var kept []*store.Attachment
+		for _, attachment := range attachments {
+			finding, err := s.screen(attachment, unstaged)
+			if err != nil {
+				return nil, err
+			}
+			if finding != nil {
+				attachment.Flagged = finding
+				flagged = append(flagged, attachment)
+				continue
+			}
+			kept = append(kept, attachment)
+		}
+		if len(kept) == 0 {
+			delete(pending, title)
+			continue
+		}
+		pending[title] = kept
+	}
+	sort.Slice(flagged, func(i, j int) bool {
+		return flagged[i].Path < flagged[j].Path
+	})
+	return flagged, nil
+}
+
+// screen returns what flagged the attachment, or nil when it is clean.
+func (s *screening) screen(attachment *store.Attachment, unstaged bool) (*store.ScreenFinding, error) {
+	if s.blockExecutables {
+		err := detectType(attachment, unstaged)
+		if err != nil {
+			return nil, err
+		}
+		if screen.IsExecutable(attachment.ContentType) {
+			return &store.ScreenFinding{
+				Scanner: "executable-type",
+				Reason:  fmt.Sprintf("%s content is blocked", attachment.ContentType),
+				Time:    time.Now().UTC(),
+			}, nil
+		}
+	}
+	for _, scanner := range s.scanners {
+		reason, err := scanner.Scan(filepath.Join(store.StageDir, attachment.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed scanning %s with %s: %w", attachment.Path, scanner.Name(), err)
+		}
+		if reason != "" {
+			return &store.ScreenFinding{
+				Scanner: scanner.Name(),
+				Reason:  reason,
+				Time:    time.Now().UTC(),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// printFlagged itemizes the attachments quarantined by screening.
+func printFlagged(flagged []*store.Attachment) {
+	if len(flagged) == 0 {
+		return
+	}
+	fmt.Printf("Quarantined %d attachments flagged by screening, rescreen them with --rescreen:\n", len(flagged))
+	for _, attachment := range flagged {
+		fmt.Printf("  %s (%s: %s)\n", attachment.Path, attachment.Flagged.Scanner, attachment.Flagged.Reason)
+	}
+}
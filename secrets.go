@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/secret"
+	"github.com/thatisuday/commando"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// keyringService is the service the secrets are stored under in the OS
+// credential store: the macOS Keychain, the Windows Credential Manager, or
+// the Secret Service through libsecret on Linux.
+const keyringService = "jira-attachment-migrator"
+
+// secretFlags are the secret flags that can be read from files or the
+// credential store, where they are stored under their flag name.
+var secretFlags = []string{"github-token", "jira-secret"}
+
+// unset reports whether a string flag was left at one of its defaults.
+func unset(value string) bool {
+	return value == "" || value == "none"
+}
+
+// referenceFlags are the flags whose values may be references to a secret
+// manager, such as vault://secret/data/migrator#jira, resolved before the
+// command runs.
+var referenceFlags = []string{"github-token", "jira-secret", "gitlab-token", "bitbucket-secret", "azure-devops-token", "confluence-token"}
+
+// redactedFlags are the flags whose values are masked wherever they would be
+// printed or logged.
+var redactedFlags = append([]string{"token"}, referenceFlags...)
+
+// secretEnvPrefix starts the names of the environment variables the secret
+// flags of JSON-RPC requests and serve jobs are passed to the child process
+// in, as its command line can be read by every user of the machine.
+const secretEnvPrefix = "JIRA_ATTACHMENT_MIGRATOR_"
+
+// secretEnv returns the name of the environment variable passing the value
+// of the secret flag, such as JIRA_ATTACHMENT_MIGRATOR_JIRA_SECRET.
+func secretEnv(name string) string {
+	return secretEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// withRedaction wraps a command action so everything it prints is redacted.
+// Redaction only starts once the action runs, as commando exits straight
+// after printing the help or an invalid flag, which would leave what it
+// printed in the pipes.
+func withRedaction(action func(map[string]commando.ArgValue, map[string]commando.FlagValue)) func(map[string]commando.ArgValue, map[string]commando.FlagValue) {
+	if action == nil {
+		return nil
+	}
+	return func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+		err := output.StartRedaction()
+		if err != nil {
+			fmt.Printf("Warning: secrets cannot be redacted from the output: %s\n", err)
+		}
+		action(args, flags)
+	}
+}
+
+// withSecrets wraps a command action so the secret flags it was not given
+// are read first from the environment variables a parent process passes
+// them in, then from the files named by their -file flags, then, with
+// --use-keyring, from the OS credential store. Secret manager references
+// are then resolved to the secrets they point at, and every secret is
+// redacted from the output.
+func withSecrets(action func(map[string]commando.ArgValue, map[string]commando.FlagValue)) func(map[string]commando.ArgValue, map[string]commando.FlagValue) {
+	return func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+		readSecretEnv(flags)
+		err := readSecretFiles(flags)
+		if err != nil {
+			output.Errorf("Failed reading secret files: %s\n", err)
+			exit(exitConfig)
+		}
+		err = readKeyring(flags)
+		if err != nil {
+			output.Errorf("Failed reading the OS keyring: %s\n", err)
+			exit(exitConfig)
+		}
+		err = resolveReferences(flags)
+		if err != nil {
+			output.Errorf("Failed reading secrets: %s\n", err)
+			exit(exitCode(err))
+		}
+		for _, name := range redactedFlags {
+			if flag, ok := flags[name]; ok && !unset(flag.Value.(string)) {
+				output.AddSecret(flag.Value.(string))
+			}
+		}
+		action(args, flags)
+	}
+}
+
+// readSecretFiles sets each secret flag from the file named by its -file
+// flag, such as a secret mounted into a container, or from standard input
+// when the file is -. Surrounding whitespace, including the trailing newline
+// most secret files end with, is trimmed.
+func readSecretFiles(flags map[string]commando.FlagValue) error {
+	stdin := ""
+	for _, name := range secretFlags {
+		file, ok := flags[name+"-file"]
+		if !ok || unset(file.Value.(string)) {
+			continue
+		}
+		path := file.Value.(string)
+		flag := flags[name]
+		if !unset(flag.Value.(string)) {
+			return fmt.Errorf("--%s and --%s-file cannot both be specified", name, name)
+		}
+
+		var bytes []byte
+		var err error
+		if path == "-" {
+			if stdin != "" {
+				return fmt.Errorf("--%s-file and --%s-file cannot both read standard input", stdin, name)
+			}
+			stdin = name
+			bytes, err = io.ReadAll(os.Stdin)
+		} else {
+			bytes, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed reading --%s-file: %w", name, err)
+		}
+		secret := strings.TrimSpace(string(bytes))
+		if secret == "" {
+			return fmt.Errorf("--%s-file %s is empty", name, path)
+		}
+		flag.Value = secret
+		flags[name] = flag
+	}
+	return nil
+}
+
+func resolveReferences(flags map[string]commando.FlagValue) error {
+	for _, name := range referenceFlags {
+		flag, ok := flags[name]
+		if !ok || !secret.IsReference(flag.Value.(string)) {
+			continue
+		}
+		value, err := secret.Resolve(context.Background(), flag.Value.(string))
+		if err != nil {
+			return fmt.Errorf("--%s: %w", name, err)
+		}
+		flag.Value = value
+		flags[name] = flag
+	}
+	return nil
+}
+
+// readSecretEnv sets each secret flag that was not given from its
+// environment variable, as set by the JSON-RPC server and serve for their
+// child processes. The variables are removed once read so the commands the
+// child runs, such as a --scan-command, do not inherit them.
+func readSecretEnv(flags map[string]commando.FlagValue) {
+	for _, name := range referenceFlags {
+		value, ok := os.LookupEnv(secretEnv(name))
+		if !ok {
+			continue
+		}
+		os.Unsetenv(secretEnv(name))
+		flag, ok := flags[name]
+		if !ok || !unset(flag.Value.(string)) {
+			continue
+		}
+		flag.Value = value
+		flags[name] = flag
+	}
+}
+
+func readKeyring(flags map[string]commando.FlagValue) error {
+	if !flags["use-keyring"].Value.(bool) {
+		return nil
+	}
+	for _, name := range secretFlags {
+		flag, ok := flags[name]
+		if !ok || !unset(flag.Value.(string)) {
+			continue
+		}
+		secret, err := keyring.Get(keyringService, name)
+		if err == keyring.ErrNotFound {
+			return fmt.Errorf("no --%s in the OS keyring, store it with login --use-keyring", name)
+		}
+		if err != nil {
+			return err
+		}
+		flag.Value = secret
+		flags[name] = flag
+	}
+	return nil
+}
+
+// storeKeyring prompts for each secret and stores it in the OS credential
+// store, leaving a stored secret unchanged when the prompt is left empty.
+func storeKeyring() error {
+	reader := bufio.NewReader(os.Stdin)
+	stored := 0
+	for _, name := range secretFlags {
+		fmt.Printf("Enter the %s to store, or leave it empty to skip: ", strings.ReplaceAll(name, "-", " "))
+		secret, err := readSecret(reader)
+		if err != nil {
+			return fmt.Errorf("failed reading %s: %w", name, err)
+		}
+		if secret == "" {
+			continue
+		}
+		err = keyring.Set(keyringService, name, secret)
+		if err != nil {
+			return fmt.Errorf("failed storing %s: %w", name, err)
+		}
+		stored++
+	}
+	fmt.Printf("Stored %d secrets in the OS keyring, pass --use-keyring to use them\n", stored)
+	return nil
+}
+
+// readSecret reads a line from the terminal without echoing it, or from
+// standard input when it is not a terminal.
+func readSecret(reader *bufio.Reader) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		secret, err := term.ReadPassword(fd)
+		fmt.Println()
+		return strings.TrimSpace(string(secret)), err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// selectUploads restricts the filter to the issues given by --issue and the
+// issues linked to the tickets given by --ticket, both comma separated, so a
+// single ticket can be retried or an issue migrated ahead of the rest. Issues
+// and tickets not in the database are an error rather than an empty run.
+func selectUploads(db *store.Database, filter *store.IssueFilter, tickets, issues string) error {
+	if tickets == "none" && issues == "none" {
+		return nil
+	}
+
+	var numbers []int
+	if issues != "none" {
+		known := make(map[int]bool)
+		for _, issue := range db.Issues {
+			known[issue.Number] = true
+		}
+		for _, token := range splitList(issues) {
+			number, err := strconv.Atoi(strings.TrimPrefix(token, "#"))
+			if err != nil {
+				return fmt.Errorf("invalid issue number %q", token)
+			}
+			if !known[number] {
+				return fmt.Errorf("issue %d is not in the database", number)
+			}
+			numbers = append(numbers, number)
+		}
+	}
+	if tickets != "none" {
+		linked := make(map[string]int)
+		for title, ticket := range db.Tickets {
+			if issue, ok := db.Issues[title]; ok {
+				linked[strings.ToUpper(ticket.Key)] = issue.Number
+			}
+		}
+		for _, key := range splitList(tickets) {
+			number, ok := linked[strings.ToUpper(key)]
+			if !ok {
+				return fmt.Errorf("ticket %s is not linked to an issue in the database", key)
+			}
+			numbers = append(numbers, number)
+		}
+	}
+
+	filter.Select(numbers)
+	sort.Ints(numbers)
+	for _, number := range numbers {
+		if !filter.Allows(number) {
+			fmt.Printf("Warning: issue %d is excluded by --only-issues or --skip-issues\n", number)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/secret"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// The states of a job run by serve.
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// job is a command started through the serve API, run as a child process of
+// this executable like the JSON-RPC requests. Its flags are not kept, as
+// they can hold secrets.
+type job struct {
+	ID        int        `json:"id"`
+	Command   string     `json:"command"`
+	Args      []string   `json:"args"`
+	Workspace string     `json:"workspace"`
+	State     string     `json:"state"`
+	ExitCode  *int       `json:"exit_code,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+
+	// output is every line the job wrote, and changed is closed and replaced
+	// whenever a line is added or the job ends, waking the event streams.
+	output  []*jobLine
+	changed chan struct{}
+}
+
+// jobLine is a line of job output, streamed as a server-sent event.
+type jobLine struct {
+	Stream string `json:"stream"`
+	Line   string `json:"line"`
+}
+
+// jobServer serves the HTTP API starting jobs, streaming their output, and
+// reporting the progress recorded in the databases they work on, and the
+// dashboard built on it. Jobs are kept in memory and forgotten when the
+// server stops.
+type jobServer struct {
+	executable string
+	token      string
+	// hosts are the Host headers requests may carry, and root the directory
+	// the workspaces of requests and jobs must lie in.
+	hosts map[string]bool
+	root  string
+	// jira holds the --jira-url, --jira-username, and --jira-secret flags
+	// the dashboard links tickets with and retries failed uploads with.
+	jira map[string]string
+	mu   sync.Mutex
+	jobs []*job
+}
+
+func serve(flags map[string]commando.FlagValue) error {
+	listen := flags["listen"].Value.(string)
+	token := flags["token"].Value.(string)
+	allowed := flags["allowed-hosts"].Value.(string)
+	root, err := filepath.Abs(flags["workspace-root"].Value.(string))
+	if err != nil {
+		return fmt.Errorf("failed resolving workspace root: %w", err)
+	}
+	var extra []string
+	if !unset(allowed) {
+		extra = strings.Split(allowed, ",")
+	}
+	hosts, err := allowedHosts(listen, extra)
+	if err != nil {
+		return configError(err)
+	}
+	if unset(token) {
+		token, err = generateToken()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generated the API token %s, pass --token to choose one\n", token)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed locating executable: %w", err)
+	}
+	s := &jobServer{executable: executable, token: token, hosts: hosts, root: root, jira: make(map[string]string)}
+	for _, name := range []string{"jira-url", "jira-username", "jira-secret"} {
+		if value := flags[name].Value.(string); !unset(value) {
+			s.jira[name] = value
+		}
+	}
+	fmt.Printf("Serving the migration API on http://%s for the workspaces in %s\n", listen, root)
+	err = http.ListenAndServe(listen, s.routes())
+	if err != nil {
+		return fmt.Errorf("failed serving: %w", err)
+	}
+	return nil
+}
+
+func (s *jobServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleDashboard)
+	mux.HandleFunc("/issues", s.handleIssues)
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/tickets", s.handleTickets)
+	mux.HandleFunc("/tickets/", s.handleTickets)
+	return s.checkOrigin(s.authorize(mux))
+}
+
+// generateToken returns a random bearer token for a server started without
+// --token.
+func generateToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", fmt.Errorf("failed generating API token: %w", err)
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// allowedHosts returns the Host headers of requests to the listen address:
+// the address itself, the loopback names when it listens on a loopback or
+// unspecified address, and the extra host names, all on the listen port.
+// Requests naming any other host are refused, as a web page can reach the
+// server under a host name of its own by DNS rebinding.
+func allowedHosts(listen string, extra []string) (map[string]bool, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %w", listen, err)
+	}
+	hosts := map[string]bool{strings.ToLower(listen): true}
+	ip := net.ParseIP(host)
+	if host == "" || host == "localhost" || ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		extra = append(extra, "localhost", "127.0.0.1", "::1")
+	}
+	for _, name := range extra {
+		hosts[strings.ToLower(net.JoinHostPort(strings.TrimSpace(name), port))] = true
+	}
+	return hosts, nil
+}
+
+// checkOrigin refuses requests addressed to a host the server does not
+// answer to, cross-origin requests from browsers, and posts whose body is not
+// JSON, which browsers would otherwise send cross-origin without asking.
+func (s *jobServer) checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.hosts[strings.ToLower(r.Host)] {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("host %s is not served, add it to --allowed-hosts", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme != "http" || !strings.EqualFold(u.Host, r.Host) {
+				writeError(w, http.StatusForbidden, fmt.Sprintf("cross-origin requests from %s are refused", origin))
+				return
+			}
+		}
+		if r.Method == http.MethodPost {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, "requests must be posted as application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorize requires the bearer token on every request but those for the
+// dashboard page, which asks for the token itself.
+func (s *jobServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleJobs lists the jobs on GET and starts one on POST, from a body of
+// the command and its params as in a JSON-RPC request.
+func (s *jobServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		jobs := make([]job, 0, len(s.jobs))
+		for _, j := range s.jobs {
+			jobs = append(jobs, j.snapshot())
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var req struct {
+			Command string `json:"command"`
+			rpcParams
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed parsing job: %s", err))
+			return
+		}
+		if !rpcMethods[req.Command] {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown command %q", req.Command))
+			return
+		}
+		args, env, err := req.commandLine(req.Command)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		err = s.checkJob(req.Command, &req.rpcParams)
+		if err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		j := s.start(req.Command, req.Args, req.Flags, args, env)
+		s.mu.Lock()
+		snapshot := j.snapshot()
+		s.mu.Unlock()
+		writeJSON(w, http.StatusAccepted, snapshot)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET to list jobs or POST to start one")
+	}
+}
+
+// The kinds of value a job flag takes, which decide how it is checked.
+const (
+	// jobValue flags are passed as given.
+	jobValue = iota
+	// jobPath flags name a file or directory, which must lie in the
+	// workspace root.
+	jobPath
+	// jobLocation flags name a file or directory in the workspace root, or a
+	// URL.
+	jobLocation
+)
+
+// jobFlags are the flags a job may pass. Flags reading secrets from files or
+// the OS keyring, running commands, and changing how traffic leaves the
+// machine are left out, so a job cannot read files outside the workspace
+// root or run anything but the migrator.
+var jobFlags = map[string]int{
+	"archive":               jobLocation,
+	"archive-sha256":        jobValue,
+	"atomic":                jobValue,
+	"attachments-only":      jobValue,
+	"azure-devops-project":  jobValue,
+	"azure-devops-token":    jobValue,
+	"azure-devops-url":      jobValue,
+	"bitbucket-secret":      jobValue,
+	"bitbucket-url":         jobValue,
+	"bitbucket-username":    jobValue,
+	"block-executables":     jobValue,
+	"bundle-per-ticket":     jobValue,
+	"ca-cert":               jobPath,
+	"chain":                 jobValue,
+	"checksums":             jobValue,
+	"comment-markers":       jobValue,
+	"compression":           jobValue,
+	"confluence-parent":     jobValue,
+	"confluence-space":      jobValue,
+	"confluence-token":      jobValue,
+	"confluence-url":        jobValue,
+	"confluence-username":   jobValue,
+	"continue-on-error":     jobValue,
+	"create-missing":        jobValue,
+	"database":              jobValue,
+	"db-path":               jobPath,
+	"debug":                 jobValue,
+	"dedup":                 jobValue,
+	"dry-run":               jobValue,
+	"edit-history":          jobValue,
+	"entity-property":       jobValue,
+	"exclude-ext":           jobValue,
+	"exclude-type":          jobValue,
+	"fips":                  jobValue,
+	"force-unlock":          jobValue,
+	"github-api":            jobValue,
+	"github-token":          jobValue,
+	"gitlab-token":          jobValue,
+	"gitlab-url":            jobValue,
+	"hash":                  jobValue,
+	"include-ext":           jobValue,
+	"issue":                 jobValue,
+	"issue-type":            jobValue,
+	"issues-file":           jobPath,
+	"jira-keys":             jobValue,
+	"jira-search-workers":   jobValue,
+	"jira-secret":           jobValue,
+	"jira-url":              jobValue,
+	"jira-username":         jobValue,
+	"labels":                jobValue,
+	"layout":                jobValue,
+	"lock-source":           jobValue,
+	"mark-comment":          jobValue,
+	"mark-source":           jobValue,
+	"match-field":           jobValue,
+	"match-mapping-file":    jobPath,
+	"match-pattern":         jobValue,
+	"match-strategy":        jobValue,
+	"max-image-bytes":       jobValue,
+	"migrate-content":       jobValue,
+	"name-template":         jobValue,
+	"no-github-cache":       jobValue,
+	"no-sanitize-names":     jobValue,
+	"no-space-check":        jobValue,
+	"no-stage":              jobValue,
+	"on-conflict":           jobValue,
+	"only-issues":           jobValue,
+	"order":                 jobValue,
+	"org":                   jobValue,
+	"output":                jobLocation,
+	"plan":                  jobPath,
+	"project":               jobValue,
+	"provenance-comment":    jobValue,
+	"provenance-update":     jobValue,
+	"quiet":                 jobValue,
+	"reason":                jobValue,
+	"record":                jobPath,
+	"relock-transition":     jobValue,
+	"remote-link":           jobValue,
+	"render-policy":         jobPath,
+	"replay":                jobPath,
+	"repo":                  jobValue,
+	"rescreen":              jobValue,
+	"retries":               jobValue,
+	"retry-failed":          jobValue,
+	"simulate":              jobValue,
+	"simulate-failure-rate": jobValue,
+	"simulate-upload-limit": jobValue,
+	"since":                 jobValue,
+	"skip-archive":          jobValue,
+	"skip-github":           jobValue,
+	"skip-issues":           jobValue,
+	"skip-jira":             jobValue,
+	"source":                jobValue,
+	"split-oversize":        jobValue,
+	"split-size":            jobValue,
+	"stage-dir":             jobPath,
+	"stall-timeout":         jobValue,
+	"state":                 jobValue,
+	"statement":             jobPath,
+	"storage-headroom":      jobValue,
+	"summary-file":          jobPath,
+	"target":                jobValue,
+	"template":              jobPath,
+	"ticket":                jobValue,
+	"ticket-key-range":      jobValue,
+	"ticket-keys-file":      jobPath,
+	"tickets-file":          jobPath,
+	"unlock-transition":     jobValue,
+	"until":                 jobValue,
+	"user-map":              jobPath,
+	"verbose":               jobValue,
+	"verified":              jobValue,
+	"yes":                   jobValue,
+}
+
+// jobCommandFlags are the kinds of the flags whose values mean something
+// else to a command than to the others, such as the file plan saves to with
+// --output, which names an archive or object storage URL for archive.
+var jobCommandFlags = map[string]map[string]int{
+	"plan": {"output": jobPath},
+}
+
+// jobPathArgs are the commands whose arguments name files, by the index of
+// the first such argument and whether it may be a URL instead. The paths
+// delete and restore take are only looked up in the database.
+var jobPathArgs = map[string]struct {
+	first int
+	kind  int
+}{
+	"db":       {1, jobPath},
+	"snapshot": {1, jobPath},
+	"extract":  {0, jobLocation},
+}
+
+// checkJob refuses a job passing a flag jobFlags leaves out, a flag as an
+// argument, a secret manager reference, or a file outside the workspace root.
+func (s *jobServer) checkJob(command string, params *rpcParams) error {
+	for _, arg := range params.Args {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("argument %s of a job cannot be a flag", arg)
+		}
+	}
+	for _, name := range sortedKeys(params.Flags) {
+		kind, ok := jobFlags[name]
+		if !ok {
+			return fmt.Errorf("flag %s cannot be passed to a job", name)
+		}
+		if override, ok := jobCommandFlags[command][name]; ok {
+			kind = override
+		}
+		value, ok := params.Flags[name].(string)
+		if !ok {
+			continue
+		}
+		if secret.IsReference(value) {
+			return fmt.Errorf("flag %s of a job cannot reference a secret manager", name)
+		}
+		err := s.checkPath(value, kind)
+		if err != nil {
+			return fmt.Errorf("flag %s: %w", name, err)
+		}
+	}
+	if paths, ok := jobPathArgs[command]; ok {
+		for i := paths.first; i < len(params.Args); i++ {
+			// commando joins the values of variadic arguments with commas,
+			// which db splits them on again.
+			for _, path := range strings.Split(params.Args[i], ",") {
+				err := s.checkPath(path, paths.kind)
+				if err != nil {
+					return fmt.Errorf("argument %s: %w", params.Args[i], err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkPath refuses a path or location value outside the workspace root.
+// Unset values and, for locations, URLs are left alone.
+func (s *jobServer) checkPath(value string, kind int) error {
+	if kind == jobValue || unset(value) || kind == jobLocation && strings.Contains(value, "://") {
+		return nil
+	}
+	_, err := s.workspacePath(value)
+	return err
+}
+
+// workspacePath resolves the path against the working directory and refuses
+// it unless it lies in the workspace root.
+func (s *jobServer) workspacePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed resolving %s: %w", path, err)
+	}
+	rel, err := filepath.Rel(s.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s lies outside the workspace root %s", path, s.root)
+	}
+	return abs, nil
+}
+
+// start runs the command line in the background as a new job, with env
+// added to its environment.
+func (s *jobServer) start(command string, positional []string, flags map[string]interface{}, args, env []string) *job {
+	workspace, ok := flags["db-path"].(string)
+	if !ok {
+		workspace = "database.json"
+	}
+	if positional == nil {
+		positional = []string{}
+	}
+	s.mu.Lock()
+	j := &job{
+		ID:        len(s.jobs) + 1,
+		Command:   command,
+		Args:      positional,
+		Workspace: workspace,
+		State:     jobRunning,
+		Started:   time.Now().UTC(),
+		changed:   make(chan struct{}),
+	}
+	s.jobs = append(s.jobs, j)
+	s.mu.Unlock()
+
+	go func() {
+		code, err := runCommand(s.executable, args, env, func(stream, line string) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			j.output = append(j.output, &jobLine{Stream: stream, Line: line})
+			j.notify()
+		})
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		now := time.Now().UTC()
+		j.Finished = &now
+		j.State = jobSucceeded
+		switch {
+		case err != nil:
+			j.State = jobFailed
+			j.Error = err.Error()
+		case code != 0:
+			j.State = jobFailed
+			j.ExitCode = &code
+		default:
+			j.ExitCode = &code
+		}
+		j.notify()
+	}()
+	return j
+}
+
+// notify wakes the event streams of the job. The server mutex must be held.
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// snapshot copies the job for encoding. The server mutex must be held.
+func (j *job) snapshot() job {
+	return job{
+		ID:        j.ID,
+		Command:   j.Command,
+		Args:      j.Args,
+		Workspace: j.Workspace,
+		State:     j.State,
+		ExitCode:  j.ExitCode,
+		Error:     j.Error,
+		Started:   j.Started,
+		Finished:  j.Finished,
+	}
+}
+
+// handleJob reports a job at /jobs/<id> and streams its output at
+// /jobs/<id>/events.
+func (s *jobServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET to read a job")
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	id, events := path, false
+	if strings.HasSuffix(path, "/events") {
+		id, events = strings.TrimSuffix(path, "/events"), true
+	}
+	number, err := strconv.Atoi(id)
+	s.mu.Lock()
+	if err != nil || number < 1 || number > len(s.jobs) {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no job %s", id))
+		return
+	}
+	j := s.jobs[number-1]
+	snapshot := j.snapshot()
+	s.mu.Unlock()
+	if !events {
+		writeJSON(w, http.StatusOK, snapshot)
+		return
+	}
+	s.streamEvents(w, r, j)
+}
+
+// streamEvents sends every output line of the job as a server-sent output
+// event numbered by its line, followed by an exit event holding the job once
+// it ends. A client reconnecting with Last-Event-ID resumes after that line.
+func (s *jobServer) streamEvents(w http.ResponseWriter, r *http.Request, j *job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	next := 0
+	if last, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		next = last + 1
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		s.mu.Lock()
+		var lines []*jobLine
+		if next < len(j.output) {
+			lines = j.output[next:]
+		}
+		running := j.State == jobRunning
+		snapshot := j.snapshot()
+		changed := j.changed
+		s.mu.Unlock()
+
+		for _, line := range lines {
+			data, _ := json.Marshal(line)
+			fmt.Fprintf(w, "id: %d\nevent: output\ndata: %s\n\n", next, data)
+			next++
+		}
+		if !running {
+			data, _ := json.Marshal(snapshot)
+			fmt.Fprintf(w, "event: exit\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleStatus reports the migration status of the database given by the
+// db-path query parameter, as status --output json does.
+func (s *jobServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	db, err := s.loadWorkspace(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, summarizeStatus(db))
+}
+
+// handleTickets reports the status of every ticket at /tickets, or of one
+// ticket at /tickets/<key>, in the database given by the db-path query
+// parameter. POST /tickets/<key>/retry retries the ticket's failed uploads.
+func (s *jobServer) handleTickets(w http.ResponseWriter, r *http.Request) {
+	key := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tickets"), "/")
+	if strings.HasSuffix(key, "/retry") {
+		s.handleRetry(w, r, strings.TrimSuffix(key, "/retry"))
+		return
+	}
+	db, err := s.loadWorkspace(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	tickets := summarizeTickets(db)
+	if jiraURL, ok := s.jira["jira-url"]; ok && db.TargetName() == store.TargetJIRA {
+		for _, ticket := range tickets {
+			ticket.TicketURL = fmt.Sprintf("%s/browse/%s", strings.TrimSuffix(jiraURL, "/"), ticket.Ticket)
+		}
+	}
+	if key == "" {
+		writeJSON(w, http.StatusOK, tickets)
+		return
+	}
+	for _, ticket := range tickets {
+		if ticket.Ticket == key {
+			writeJSON(w, http.StatusOK, ticket)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("no ticket %s in the database", key))
+}
+
+// loadWorkspace reads the database given by the db-path query parameter,
+// database.json by default as with the commands, refusing databases outside
+// the workspace root.
+func (s *jobServer) loadWorkspace(r *http.Request) (*store.Database, error) {
+	path := r.URL.Query().Get("db-path")
+	if path == "" {
+		path = "database.json"
+	}
+	path, err := s.workspacePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return store.LoadFile(path)
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
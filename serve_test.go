@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves the API of a job server for the workspaces in a
+// temporary directory, answering to the test server's address.
+func newTestServer(t *testing.T) (*jobServer, *httptest.Server) {
+	s := &jobServer{token: "secret", root: t.TempDir(), jira: make(map[string]string)}
+	server := httptest.NewServer(nil)
+	t.Cleanup(server.Close)
+	hosts, err := allowedHosts(server.Listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.hosts = hosts
+	server.Config.Handler = s.routes()
+	return s, server
+}
+
+// TestServeRefusesUntrustedRequests sends requests without the token, under
+// another host name, from another origin, or with a body browsers post
+// cross-origin, and checks each is refused before it is handled.
+func TestServeRefusesUntrustedRequests(t *testing.T) {
+	_, server := newTestServer(t)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		body    string
+		code    int
+	}{
+		{"no token", http.MethodGet, "/jobs", nil, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/jobs", map[string]string{"Authorization": "Bearer guess"}, "", http.StatusUnauthorized},
+		{"rebound host", http.MethodGet, "/jobs", map[string]string{"Authorization": "Bearer secret", "Host": "attacker.example"}, "", http.StatusForbidden},
+		{"rebound dashboard", http.MethodGet, "/", map[string]string{"Host": "attacker.example"}, "", http.StatusForbidden},
+		{"cross origin", http.MethodGet, "/jobs", map[string]string{"Authorization": "Bearer secret", "Origin": "http://attacker.example"}, "", http.StatusForbidden},
+		{"plain text job", http.MethodPost, "/jobs", map[string]string{"Authorization": "Bearer secret", "Content-Type": "text/plain"}, `{"command": "status"}`, http.StatusUnsupportedMediaType},
+		{"secret file job", http.MethodPost, "/jobs", map[string]string{"Authorization": "Bearer secret", "Content-Type": "application/json"}, `{"command": "upload", "flags": {"jira-secret-file": "/etc/passwd", "jira-url": "https://attacker.example"}}`, http.StatusForbidden},
+		{"flag in arguments", http.MethodPost, "/jobs", map[string]string{"Authorization": "Bearer secret", "Content-Type": "application/json"}, `{"command": "upload", "args": ["--scan-command", "sh -c 'id' {file}", "--jira-secret-file=/etc/shadow"]}`, http.StatusBadRequest},
+		{"database outside root", http.MethodGet, "/status?db-path=/etc/passwd", map[string]string{"Authorization": "Bearer secret"}, "", http.StatusNotFound},
+		{"trusted", http.MethodGet, "/jobs", map[string]string{"Authorization": "Bearer secret", "Origin": server.URL}, "", http.StatusOK},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, server.URL+test.path, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+		if host, ok := test.headers["Host"]; ok {
+			req.Host = host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Errorf("%s: answered %d, want %d", test.name, resp.StatusCode, test.code)
+		}
+	}
+}
+
+// TestCheckJob checks jobs may only pass the allowed flags, without secret
+// manager references, naming files in the workspace root.
+func TestCheckJob(t *testing.T) {
+	s, _ := newTestServer(t)
+	inRoot := filepath.Join(s.root, "acme", "database.json")
+
+	tests := []struct {
+		name    string
+		command string
+		params  rpcParams
+		allowed bool
+	}{
+		{"workspace flags", "upload", rpcParams{Flags: map[string]interface{}{"db-path": inRoot, "stage-dir": filepath.Join(s.root, "acme", "stage"), "jira-url": "https://jira.example", "yes": true}}, true},
+		{"archive URL", "collect", rpcParams{Flags: map[string]interface{}{"archive": "s3://bucket/export.tar.gz"}}, true},
+		{"unknown flag", "upload", rpcParams{Flags: map[string]interface{}{"scan-command": "sh -c id"}}, false},
+		{"secret file", "upload", rpcParams{Flags: map[string]interface{}{"jira-secret-file": filepath.Join(s.root, "secret")}}, false},
+		{"secret reference", "upload", rpcParams{Flags: map[string]interface{}{"jira-secret": "vault://secret/data/migrator#jira"}}, false},
+		{"database outside root", "status", rpcParams{Flags: map[string]interface{}{"db-path": "/etc/database.json"}}, false},
+		{"climbing out of root", "status", rpcParams{Flags: map[string]interface{}{"db-path": filepath.Join(s.root, "..", "database.json")}}, false},
+		{"plan in root", "plan", rpcParams{Flags: map[string]interface{}{"db-path": inRoot, "output": filepath.Join(s.root, "acme", "plan.json"), "order": "size-desc"}}, true},
+		{"plan outside root", "plan", rpcParams{Flags: map[string]interface{}{"output": "/etc/plan.json"}}, false},
+		{"plan to a URL", "plan", rpcParams{Flags: map[string]interface{}{"output": "s3://bucket/plan.json"}}, false},
+		{"gc", "gc", rpcParams{Flags: map[string]interface{}{"db-path": inRoot, "verified": true, "dry-run": true}}, true},
+		{"archive outside root", "extract", rpcParams{Args: []string{"/etc/processed_archive.tar.gz"}}, false},
+		{"scanner in arguments", "upload", rpcParams{Args: []string{"--scan-command", "sh -c id {file}"}}, false},
+		{"flag assigned in arguments", "db", rpcParams{Args: []string{"validate", "--clamd=tcp://evil:1"}}, false},
+		{"database list outside root", "db", rpcParams{Args: []string{"merge", inRoot + ",/etc/database.json"}}, false},
+		{"merged database outside root", "db", rpcParams{Args: []string{"merge", inRoot, "/etc/database.json"}}, false},
+	}
+	for _, test := range tests {
+		err := s.checkJob(test.command, &test.params)
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: checked with error %v, want allowed %t", test.name, err, test.allowed)
+		}
+	}
+}
+
+// TestAllowedHosts checks the loopback names are only served when the
+// server listens on a loopback or unspecified address.
+func TestAllowedHosts(t *testing.T) {
+	tests := []struct {
+		listen string
+		extra  []string
+		host   string
+		served bool
+	}{
+		{"localhost:8080", nil, "127.0.0.1:8080", true},
+		{"localhost:8080", nil, "LOCALHOST:8080", true},
+		{"localhost:8080", nil, "attacker.example:8080", false},
+		{"0.0.0.0:8080", []string{"migrator.example"}, "migrator.example:8080", true},
+		{"10.0.0.5:8080", nil, "localhost:8080", false},
+		{"10.0.0.5:8080", nil, "10.0.0.5:8080", true},
+	}
+	for _, test := range tests {
+		hosts, err := allowedHosts(test.listen, test.extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if served := hosts[strings.ToLower(test.host)]; served != test.served {
+			t.Errorf("listening on %s, host %s served %t, want %t", test.listen, test.host, served, test.served)
+		}
+	}
+}
+
+// TestCommandLinePassesSecretsInEnvironment checks the secret flags of a job
+// are left off its command line, which every user of the machine can read,
+// and reach the child process through its environment.
+func TestCommandLinePassesSecretsInEnvironment(t *testing.T) {
+	params := &rpcParams{Flags: map[string]interface{}{
+		"jira-url":     "https://jira.example",
+		"jira-secret":  "s3cr3t-jira",
+		"github-token": "s3cr3t-github",
+		"yes":          true,
+	}}
+	args, env, err := params.commandLine("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range args {
+		if strings.Contains(arg, "s3cr3t") {
+			t.Errorf("command line %v holds a secret", args)
+		}
+	}
+	want := []string{"JIRA_ATTACHMENT_MIGRATOR_GITHUB_TOKEN=s3cr3t-github", "JIRA_ATTACHMENT_MIGRATOR_JIRA_SECRET=s3cr3t-jira"}
+	if strings.Join(env, " ") != strings.Join(want, " ") {
+		t.Errorf("environment is %v, want %v", env, want)
+	}
+
+	t.Setenv("JIRA_ATTACHMENT_MIGRATOR_JIRA_SECRET", "s3cr3t-jira")
+	flags := flagValues(map[string]interface{}{"jira-secret": "none", "github-token": "given"})
+	readSecretEnv(flags)
+	if flags["jira-secret"].Value != "s3cr3t-jira" {
+		t.Errorf("--jira-secret is %v, want it read from the environment", flags["jira-secret"].Value)
+	}
+	if _, ok := os.LookupEnv("JIRA_ATTACHMENT_MIGRATOR_JIRA_SECRET"); ok {
+		t.Error("environment variable is left for the commands the child runs")
+	}
+}
+
+// TestRetryKeepsSecretOffCommandLine checks the upload job a dashboard retry
+// starts is given the JIRA secret of serve in its environment rather than
+// on its command line.
+func TestRetryKeepsSecretOffCommandLine(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.jira["jira-url"] = "https://jira.example"
+	s.jira["jira-secret"] = "s3cr3t-jira"
+
+	params := s.retryParams("PROJ-1", url.Values{"db-path": {filepath.Join(s.root, "acme", "database.json")}})
+	err := s.checkJob("upload", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, env, err := params.commandLine("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range args {
+		if strings.Contains(arg, "s3cr3t") {
+			t.Errorf("command line %v holds the JIRA secret", args)
+		}
+	}
+	if len(env) != 1 || env[0] != "JIRA_ATTACHMENT_MIGRATOR_JIRA_SECRET=s3cr3t-jira" {
+		t.Errorf("environment is %v, want the JIRA secret", env)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/fake"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// simulatedSecret is the JIRA secret presented to the fake JIRA of a
+// simulation, which accepts any.
+const simulatedSecret = "simulated"
+
+// simulation is an upload rehearsed against an in-process fake JIRA, on a
+// copy of the workspace state so the real database is left as it was.
+type simulation struct {
+	jira     *fake.JIRA
+	dir      string
+	database string
+	audit    string
+}
+
+// startSimulation starts the fake JIRA of upload --simulate, holding the
+// tickets of the database, and switches the run to a copy of the database
+// and its state files. The fake rejects attachments over
+// --simulate-upload-limit and fails --simulate-failure-rate percent of the
+// uploads at random, so retries and failure handling can be rehearsed.
+func startSimulation(flags map[string]commando.FlagValue) (*simulation, error) {
+	rate := flags["simulate-failure-rate"].Value.(int)
+	if rate < 0 || rate > 100 {
+		return nil, fmt.Errorf("invalid --simulate-failure-rate %d, must be a percentage from 0 to 100", rate)
+	}
+	limit, err := parseSize(flags["simulate-upload-limit"].Value.(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --simulate-upload-limit: %w", err)
+	}
+	if flags["record"].Value.(string) != "none" || flags["replay"].Value.(string) != "none" {
+		return nil, fmt.Errorf("--simulate cannot be used with --record or --replay")
+	}
+
+	db, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "simulate-")
+	if err != nil {
+		return nil, fmt.Errorf("failed creating simulation directory: %w", err)
+	}
+	for _, name := range []string{filepath.Base(store.DatabaseFile), renamesFile} {
+		err = copyStateFile(store.StatePath(name), filepath.Join(dir, name))
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+
+	s := &simulation{jira: fake.NewJIRA(), dir: dir, database: store.DatabaseFile, audit: audit.File}
+	s.jira.UploadLimit = limit
+	s.jira.FailureRate = float64(rate) / 100
+	for _, title := range sortedKeys(db.Tickets) {
+		s.jira.AddTicket(db.Tickets[title].Key, title)
+	}
+	store.DatabaseFile = filepath.Join(dir, filepath.Base(store.DatabaseFile))
+	audit.File = store.StatePath(auditFile)
+	jiraTransport = s.jira.Server.Client().Transport
+	if output.Verbosity >= output.LevelDebug {
+		jiraTransport = &debugTransport{base: jiraTransport}
+	}
+
+	fmt.Printf("Simulating the upload against a fake JIRA at %s holding %d tickets, rejecting attachments over %s and failing %d%% of uploads\n", s.jira.URL, len(db.Tickets), formatSize(limit), rate)
+	return s, nil
+}
+
+// stop reports what the fake JIRA received, shuts it down, and switches the
+// run back to the real database.
+func (s *simulation) stop() {
+	stats := s.jira.Stats()
+	s.jira.Close()
+	fmt.Printf("Simulation finished: %d attachments (%s) uploaded, %d rejected as too large, %d failed by simulated outages\n", stats.Uploaded, formatSize(stats.Bytes), stats.Rejected, stats.Failed)
+	fmt.Printf("The simulated database was discarded, %s is unchanged\n", s.database)
+	store.DatabaseFile = s.database
+	audit.File = s.audit
+	os.RemoveAll(s.dir)
+}
+
+// copyStateFile copies the workspace state file to the simulation, if it
+// exists.
+func copyStateFile(source, target string) error {
+	bytes, err := os.ReadFile(source)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading %s: %w", source, err)
+	}
+	err = os.WriteFile(target, bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("failed copying %s: %w", source, err)
+	}
+	return nil
+}
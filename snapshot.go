@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// snapshotVersion is incremented whenever the snapshot layout changes.
+const snapshotVersion = 1
+
+const snapshotDir = "snapshots"
+
+// snapshotFiles are the workspace state files captured by a snapshot. The
+// staging directory is deliberately left out since it can be rebuilt from
+// the archive.
+var snapshotFiles = []string{
+	"database.json",
+	collect.ArchiveChecksumsFile,
+	rewritesFile,
+}
+
+// snapshotPath returns where a workspace state file captured by snapshots is
+// kept. Snapshots record the files by name so they can be restored into a
+// workspace whose database is configured elsewhere.
+func snapshotPath(name string) string {
+	if name == "database.json" {
+		return store.DatabaseFile
+	}
+	return store.StatePath(name)
+}
+
+// snapshot is a versioned copy of the workspace state.
+type snapshot struct {
+	Version int                        `json:"version"`
+	Created time.Time                  `json:"created"`
+	User    string                     `json:"user,omitempty"`
+	Reason  string                     `json:"reason,omitempty"`
+	Files   map[string]json.RawMessage `json:"files"`
+}
+
+func snapshotCommand(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) error {
+	action := args["action"].Value
+	file := args["file"].Value
+	reason := flags["reason"].Value.(string)
+	if reason == "none" {
+		reason = ""
+	}
+
+	switch action {
+	case "create":
+		path, err := takeSnapshot(file, reason)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			return fmt.Errorf("no workspace state to snapshot")
+		}
+		fmt.Printf("Wrote snapshot %s\n", path)
+		return nil
+	case "list":
+		return listSnapshots()
+	case "restore":
+		if file == "none" {
+			return configError(fmt.Errorf("the snapshot file to restore must be specified"))
+		}
+		err := acquireLock("snapshot restore", flags["force-unlock"].Value.(bool))
+		if err != nil {
+			return err
+		}
+		defer releaseLock()
+		return restoreSnapshot(file)
+	}
+	return configError(fmt.Errorf("unknown snapshot action %s, must be one of create, list, or restore", action))
+}
+
+// takeSnapshot writes the workspace state to path, or to a timestamped file
+// in the snapshots directory when path is "none". An empty path is returned
+// when there is no state to capture yet.
+func takeSnapshot(path, reason string) (string, error) {
+	snap := &snapshot{
+		Version: snapshotVersion,
+		Created: time.Now().UTC(),
+		User:    currentUser(),
+		Reason:  reason,
+		Files:   make(map[string]json.RawMessage),
+	}
+	for _, name := range snapshotFiles {
+		bytes, err := os.ReadFile(snapshotPath(name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed reading %s: %w", snapshotPath(name), err)
+		}
+		snap.Files[name] = bytes
+	}
+	if len(snap.Files) == 0 {
+		return "", nil
+	}
+
+	if path == "none" {
+		err := os.MkdirAll(store.StatePath(snapshotDir), 0755)
+		if err != nil {
+			return "", fmt.Errorf("failed creating snapshot directory: %w", err)
+		}
+		path = filepath.Join(store.StatePath(snapshotDir), fmt.Sprintf("snapshot-%s.json", snap.Created.Format("20060102T150405.000000000Z")))
+	}
+
+	bytes, err := json.Marshal(snap)
+	if err != nil {
+		return "", fmt.Errorf("failed marshalling snapshot: %w", err)
+	}
+	err = os.WriteFile(path, bytes, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed writing snapshot: %w", err)
+	}
+	return path, nil
+}
+
+func loadSnapshot(path string) (*snapshot, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading snapshot: %w", err)
+	}
+	snap := &snapshot{}
+	err = json.Unmarshal(bytes, snap)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling snapshot: %w", err)
+	}
+	if snap.Version > snapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d is newer than the supported version %d", snap.Version, snapshotVersion)
+	}
+	return snap, nil
+}
+
+func listSnapshots() error {
+	entries, err := os.ReadDir(store.StatePath(snapshotDir))
+	if os.IsNotExist(err) {
+		fmt.Println("No snapshots found")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading snapshot directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(store.StatePath(snapshotDir), name)
+		snap, err := loadSnapshot(path)
+		if err != nil {
+			fmt.Printf("%s: %s\n", path, err)
+			continue
+		}
+		fmt.Printf("%s: %s by %s", path, snap.Created.Format(time.RFC3339), snap.User)
+		if snap.Reason != "" {
+			fmt.Printf(" (%s)", snap.Reason)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// restoreSnapshot replaces the workspace state with the snapshot contents.
+// The current state is snapshotted first so the restore can be undone.
+func restoreSnapshot(path string) error {
+	snap, err := loadSnapshot(path)
+	if err != nil {
+		return err
+	}
+
+	current, err := takeSnapshot("none", fmt.Sprintf("before restoring %s", path))
+	if err != nil {
+		return fmt.Errorf("failed snapshotting current state: %w", err)
+	}
+	if current != "" {
+		fmt.Printf("Saved current state to %s\n", current)
+	}
+
+	for _, name := range snapshotFiles {
+		bytes, ok := snap.Files[name]
+		path := snapshotPath(name)
+		if !ok {
+			err := os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed removing %s: %w", path, err)
+			}
+			continue
+		}
+		err := os.WriteFile(path, bytes, 0644)
+		if err != nil {
+			return fmt.Errorf("failed writing %s: %w", path, err)
+		}
+	}
+	fmt.Printf("Restored snapshot %s taken %s\n", path, snap.Created.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+func softDelete(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) error {
+	return setDeleted(args["paths"].Value, flags["reason"].Value.(string), true)
+}
+
+func restore(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) error {
+	return setDeleted(args["paths"].Value, flags["reason"].Value.(string), false)
+}
+
+// setDeleted marks every attachment staged at one of the comma separated
+// paths as deleted or restored, recording the decision in its history.
+func setDeleted(paths, reason string, deleted bool) error {
+	if paths == "" {
+		return configError(fmt.Errorf("at least one attachment path must be specified"))
+	}
+
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	action := "restore"
+	if deleted {
+		action = "delete"
+	}
+	entry := &store.Decision{
+		Action: action,
+		User:   currentUser(),
+		Time:   time.Now().UTC(),
+	}
+	if reason != "none" {
+		entry.Reason = reason
+	}
+
+	for _, path := range strings.Split(paths, ",") {
+		found := false
+		for _, attachment := range db.Attachments {
+			if attachment.Path != path {
+				continue
+			}
+			found = true
+			if attachment.Deleted == deleted {
+				fmt.Printf("Attachment %s on issue %d is already %sd\n", path, attachment.IssueNumber, action)
+				continue
+			}
+			attachment.Deleted = deleted
+			attachment.History = append(attachment.History, entry)
+			fmt.Printf("Attachment %s on issue %d %sd\n", path, attachment.IssueNumber, action)
+		}
+		if !found {
+			return configError(fmt.Errorf("no attachment is staged at %s", path))
+		}
+	}
+
+	return store.Save(db)
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// splitAttachment zips the staged attachment and cuts the zip into volumes no
+// larger than limit, returning their staged paths. Volumes are named
+// <name>.zip.001, <name>.zip.002 and so on, the raw split layout 7-Zip and
+// `cat` can reassemble; a zip that already fits is kept whole as <name>.zip.
+func splitAttachment(attachment *store.Attachment, limit int64) ([]string, error) {
+	zipPath := "split/" + attachment.Path + ".zip"
+	target := filepath.Join(store.StageDir, filepath.FromSlash(zipPath))
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating directory %s: %w", filepath.Dir(target), err)
+	}
+
+	err = zipFile(filepath.Join(store.StageDir, attachment.Path), attachment.Name(), target)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting file stats: %w", err)
+	}
+	if info.Size() <= limit {
+		return []string{zipPath}, nil
+	}
+
+	source, err := os.Open(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening zip: %w", err)
+	}
+	defer source.Close()
+
+	var parts []string
+	for number := 1; ; number++ {
+		partPath := fmt.Sprintf("%s.%03d", zipPath, number)
+		part, err := os.Create(filepath.Join(store.StageDir, filepath.FromSlash(partPath)))
+		if err != nil {
+			return nil, fmt.Errorf("failed creating volume %s: %w", partPath, err)
+		}
+		written, err := io.CopyN(part, source, limit)
+		part.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed writing volume %s: %w", partPath, err)
+		}
+		if written == 0 {
+			os.Remove(filepath.Join(store.StageDir, filepath.FromSlash(partPath)))
+			break
+		}
+		parts = append(parts, partPath)
+		if err == io.EOF {
+			break
+		}
+	}
+	source.Close()
+	os.Remove(target)
+
+	return parts, nil
+}
+
+func zipFile(src, name, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed opening source file: %w", err)
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed creating zip: %w", err)
+	}
+	defer destination.Close()
+
+	zw := zip.NewWriter(destination)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+	if err != nil {
+		return fmt.Errorf("failed creating zip entry: %w", err)
+	}
+	_, err = io.Copy(w, source)
+	if err != nil {
+		return fmt.Errorf("failed compressing file: %w", err)
+	}
+	return zw.Close()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// lockFile returns the path of the lock held while a command changes the
+// workspace state, so two runs against the same database cannot overwrite
+// each other's progress or upload the same attachments twice.
+func lockFile() string {
+	return store.DatabaseFile + ".lock"
+}
+
+// lockOwner is written to the lock file to identify the run holding it.
+type lockOwner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	User    string    `json:"user,omitempty"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// heldLock is the lock file while this process holds it, so exit can
+// release it even after the run switches to another database, as upload
+// --simulate does.
+var heldLock string
+
+// withStateLock wraps a command action so it holds the workspace lock while
+// it runs. --force-unlock takes over a lock left behind by a run on another
+// host or one that was killed.
+func withStateLock(command string, action func(map[string]commando.ArgValue, map[string]commando.FlagValue)) func(map[string]commando.ArgValue, map[string]commando.FlagValue) {
+	return func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+		err := acquireLock(command, flags["force-unlock"].Value.(bool))
+		if err != nil {
+			output.Errorf("Failed running %s: %s\n", command, err)
+			exit(exitFailure)
+		}
+		defer releaseLock()
+		audit.Command = command
+		action(args, flags)
+	}
+}
+
+// acquireLock creates the lock file, failing when another run holds it. A
+// lock left by a process on this host that is no longer running is taken
+// over, as is any lock when forced. The lock is released if the run is
+// interrupted.
+func acquireLock(command string, force bool) error {
+	host, _ := os.Hostname()
+	owner := &lockOwner{
+		PID:     os.Getpid(),
+		Host:    host,
+		User:    currentUser(),
+		Command: command,
+		Started: time.Now().UTC(),
+	}
+	bytes, err := json.Marshal(owner)
+	if err != nil {
+		return fmt.Errorf("failed marshalling lock: %w", err)
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(lockFile(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.Write(bytes)
+			closeErr := file.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lockFile())
+				return fmt.Errorf("failed writing %s: %w", lockFile(), err)
+			}
+			heldLock = lockFile()
+			interrupted := make(chan os.Signal, 1)
+			signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-interrupted
+				exit(130)
+			}()
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed creating %s: %w", lockFile(), err)
+		}
+
+		holder, err := readLock()
+		if err != nil {
+			return err
+		}
+		switch {
+		case force:
+			fmt.Printf("Forcing the lock held by %s\n", holder)
+		case holder.Host == host && !processRunning(holder.PID):
+			fmt.Printf("Taking over the stale lock of %s, which is no longer running\n", holder)
+		default:
+			return fmt.Errorf("the workspace is locked by %s; if that run is no longer running, pass --force-unlock to take over the lock", holder)
+		}
+		err = os.Remove(lockFile())
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed removing %s: %w", lockFile(), err)
+		}
+	}
+	return fmt.Errorf("the workspace was locked by another run while taking over the lock")
+}
+
+func readLock() (*lockOwner, error) {
+	bytes, err := os.ReadFile(lockFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed reading %s: %w", lockFile(), err)
+	}
+	holder := &lockOwner{}
+	if json.Unmarshal(bytes, holder) != nil {
+		// A lock that cannot be read was left by a run killed while writing
+		// it, so it is reported as held by nobody and can only be forced.
+		return &lockOwner{Command: "an unknown run"}, nil
+	}
+	return holder, nil
+}
+
+func (o *lockOwner) String() string {
+	if o.PID == 0 {
+		return o.Command
+	}
+	started := o.Started.Local().Format(time.RFC1123)
+	if o.User != "" {
+		started = fmt.Sprintf("by %s at %s", o.User, started)
+	}
+	return fmt.Sprintf("%s (pid %d on %s, started %s)", o.Command, o.PID, o.Host, started)
+}
+
+func releaseLock() {
+	if heldLock == "" {
+		return
+	}
+	path := heldLock
+	heldLock = ""
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Failed removing %s: %s\n", path, err)
+	}
+}
+
+// exit exports the buffered trace spans, releases the workspace lock, if
+// held, and writes out the redacted output before exiting, since deferred
+// releases do not run on os.Exit.
+func exit(code int) {
+	flushTraces()
+	releaseLock()
+	output.Flush()
+	os.Exit(code)
+}
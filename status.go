@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+// migrationStatus summarises the progress of the migration recorded in the
+// database.
+type migrationStatus struct {
+	Collected        int             `json:"collected"`
+	Uploaded         int             `json:"uploaded"`
+	Failed           int             `json:"failed"`
+	Pending          int             `json:"pending"`
+	Unmatched        int             `json:"unmatched"`
+	Skipped          map[string]int  `json:"skipped"`
+	BytesTransferred int64           `json:"bytes_transferred"`
+	Tickets          int             `json:"tickets"`
+	TicketsComplete  int             `json:"tickets_complete"`
+	PendingWork      []*pendingEntry `json:"pending_work"`
+	Failures         []*failureEntry `json:"failures"`
+}
+
+// failureEntry is a quarantined attachment with its last upload error.
+type failureEntry struct {
+	Path     string `json:"path"`
+	Issue    int    `json:"issue"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+}
+
+// pendingEntry lists the attachments of a ticket still waiting to upload.
+type pendingEntry struct {
+	Ticket      string   `json:"ticket"`
+	Issue       int      `json:"issue"`
+	Attachments []string `json:"attachments"`
+}
+
+func status(flags map[string]commando.FlagValue) error {
+	output := flags["output"].Value.(string)
+	if output != "text" && output != "json" {
+		return configError(fmt.Errorf("invalid output format %q, must be text or json", output))
+	}
+
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	s := summarizeStatus(db)
+	if output == "json" {
+		bytes, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed marshalling status: %w", err)
+		}
+		fmt.Println(string(bytes))
+		return nil
+	}
+	s.print()
+	return nil
+}
+
+// summarizeStatus classifies every attachment. Quarantined attachments and
+// attachments without JIRA IDs on a ticket already marked uploaded count as
+// failed, those on tickets not
+// yet uploaded as pending, and those whose issue matched no ticket as
+// unmatched.
+func summarizeStatus(db *store.Database) *migrationStatus {
+	s := &migrationStatus{
+		Collected: len(db.Attachments),
+		Skipped:   make(map[string]int),
+	}
+
+	ticketsByIssue := make(map[int]*store.Ticket)
+	for title, issue := range db.Issues {
+		if ticket, ok := db.Tickets[title]; ok {
+			ticketsByIssue[issue.Number] = ticket
+		}
+	}
+
+	pending := make(map[string]*pendingEntry)
+	incomplete := make(map[string]bool)
+	withAttachments := make(map[string]bool)
+	for _, attachment := range db.Attachments {
+		for _, sent := range attachment.Sent {
+			s.BytesTransferred += sent.Bytes
+		}
+		ticket := ticketsByIssue[attachment.IssueNumber]
+		if ticket != nil {
+			withAttachments[ticket.Key] = true
+		}
+
+		switch {
+		case attachment.Deleted:
+			s.Skipped["deleted"]++
+		case attachment.DuplicateOf != "":
+			s.Skipped["duplicate"]++
+		case len(attachment.JIRAIDs) > 0:
+			s.Uploaded++
+		case attachment.Flagged != nil:
+			s.Skipped["flagged"]++
+		case attachment.Failure != nil:
+			s.Failed++
+			if ticket != nil {
+				incomplete[ticket.Key] = true
+			}
+			s.Failures = append(s.Failures, &failureEntry{
+				Path:     attachment.Path,
+				Issue:    attachment.IssueNumber,
+				Error:    attachment.Failure.Error,
+				Attempts: attachment.Failure.Attempts,
+			})
+		case attachment.Excluded != "":
+			s.Skipped["excluded"]++
+		case attachment.SkipReason != "":
+			s.Skipped["oversize"]++
+		case ticket == nil:
+			s.Unmatched++
+		case ticket.Uploaded:
+			s.Failed++
+			incomplete[ticket.Key] = true
+		default:
+			s.Pending++
+			incomplete[ticket.Key] = true
+			entry, ok := pending[ticket.Key]
+			if !ok {
+				entry = &pendingEntry{Ticket: ticket.Key, Issue: attachment.IssueNumber}
+				pending[ticket.Key] = entry
+			}
+			entry.Attachments = append(entry.Attachments, attachment.Path)
+		}
+	}
+
+	s.Tickets = len(withAttachments)
+	for key := range withAttachments {
+		if !incomplete[key] {
+			s.TicketsComplete++
+		}
+	}
+	for _, key := range sortedKeys(pending) {
+		s.PendingWork = append(s.PendingWork, pending[key])
+	}
+	sort.SliceStable(s.PendingWork, func(i, j int) bool {
+		return s.PendingWork[i].Issue < s.PendingWork[j].Issue
+	})
+	return s
+}
+
+func (s *migrationStatus) print() {
+	fmt.Printf("Attachments collected: %d\n", s.Collected)
+	fmt.Printf("Attachments uploaded: %d\n", s.Uploaded)
+	fmt.Printf("Attachments failed: %d\n", s.Failed)
+	fmt.Printf("Attachments pending: %d\n", s.Pending)
+	fmt.Printf("Attachments without a matching ticket: %d\n", s.Unmatched)
+	for _, reason := range sortedKeys(s.Skipped) {
+		fmt.Printf("Attachments skipped as %s: %d\n", reason, s.Skipped[reason])
+	}
+	fmt.Printf("Bytes transferred: %d\n", s.BytesTransferred)
+	fmt.Printf("Tickets complete: %d of %d\n", s.TicketsComplete, s.Tickets)
+
+	if len(s.PendingWork) > 0 {
+		fmt.Printf("\nPending work:\n")
+		for _, entry := range s.PendingWork {
+			fmt.Printf("  %s (issue #%d): %d attachments\n", entry.Ticket, entry.Issue, len(entry.Attachments))
+			for _, path := range entry.Attachments {
+				fmt.Printf("    %s\n", path)
+			}
+		}
+	}
+
+	if len(s.Failures) > 0 {
+		fmt.Printf("\nFailed uploads, reattempt with upload --retry-failed:\n")
+		for _, failure := range s.Failures {
+			fmt.Printf("  %s (issue #%d, %d attempts): %s\n", failure.Path, failure.Issue, failure.Attempts, failure.Error)
+		}
+	}
+}
+
+// ticketStatus is the progress of the attachments of one ticket, classified
+// as summarizeStatus classifies them.
+type ticketStatus struct {
+	Ticket string `json:"ticket"`
+	// TicketURL links to the ticket when serve knows the JIRA URL.
+	TicketURL string          `json:"ticket_url,omitempty"`
+	Title     string          `json:"title"`
+	Issue     int             `json:"issue"`
+	IssueURL  string          `json:"issue_url"`
+	Complete  bool            `json:"complete"`
+	Uploaded  int             `json:"uploaded"`
+	Failed    int             `json:"failed"`
+	Pending   int             `json:"pending"`
+	Skipped   int             `json:"skipped"`
+	Failures  []*failureEntry `json:"failures,omitempty"`
+}
+
+// summarizeTickets returns the status of every ticket matched to an issue,
+// ordered by issue number.
+func summarizeTickets(db *store.Database) []*ticketStatus {
+	byIssue := make(map[int]*ticketStatus)
+	uploaded := make(map[int]bool)
+	for title, issue := range db.Issues {
+		if ticket, ok := db.Tickets[title]; ok {
+			byIssue[issue.Number] = &ticketStatus{Ticket: ticket.Key, Title: title, Issue: issue.Number, IssueURL: issue.URL}
+			uploaded[issue.Number] = ticket.Uploaded
+		}
+	}
+	for _, attachment := range db.Attachments {
+		s, ok := byIssue[attachment.IssueNumber]
+		if !ok {
+			continue
+		}
+		switch {
+		case attachment.Deleted, attachment.DuplicateOf != "":
+			s.Skipped++
+		case len(attachment.JIRAIDs) > 0:
+			s.Uploaded++
+		case attachment.Flagged != nil:
+			s.Skipped++
+		case attachment.Failure != nil:
+			s.Failed++
+			s.Failures = append(s.Failures, &failureEntry{
+				Path:     attachment.Path,
+				Issue:    attachment.IssueNumber,
+				Error:    attachment.Failure.Error,
+				Attempts: attachment.Failure.Attempts,
+			})
+		case attachment.Excluded != "", attachment.SkipReason != "":
+			s.Skipped++
+		case uploaded[attachment.IssueNumber]:
+			s.Failed++
+		default:
+			s.Pending++
+		}
+	}
+
+	tickets := make([]*ticketStatus, 0, len(byIssue))
+	for _, s := range byIssue {
+		s.Complete = s.Failed == 0 && s.Pending == 0
+		tickets = append(tickets, s)
+	}
+	sort.Slice(tickets, func(i, j int) bool {
+		return tickets[i].Issue < tickets[j].Issue
+	})
+	return tickets
+}
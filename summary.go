@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/store"
+)
+
+// runSummary is the machine readable record of a command run written with
+// --summary-file, for pipelines orchestrating a migration.
+type runSummary struct {
+	// RunID identifies the run wherever it records what it did, such as in
+	// the entity properties written on tickets.
+	RunID     string            `json:"run_id"`
+	Command   string            `json:"command"`
+	Started   time.Time         `json:"started"`
+	Finished  time.Time         `json:"finished"`
+	Duration  float64           `json:"duration_seconds"`
+	Succeeded bool              `json:"succeeded"`
+	Error     string            `json:"error,omitempty"`
+	Database  string            `json:"database"`
+	Counts    map[string]int64  `json:"counts"`
+	Failures  []*summaryFailure `json:"failures,omitempty"`
+}
+
+// summaryFailure is a failure recorded in the run summary. Ticket and
+// attachment are empty when the failure is not tied to either.
+type summaryFailure struct {
+	Ticket     string `json:"ticket,omitempty"`
+	Attachment string `json:"attachment,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+func newRunSummary(command string) *runSummary {
+	return &runSummary{
+		RunID:    audit.RunID,
+		Command:  command,
+		Started:  time.Now().UTC(),
+		Database: store.DatabaseFile,
+		Counts:   make(map[string]int64),
+	}
+}
+
+// write records the outcome of the run and writes the summary to path,
+// unless path is "none". The run's error is returned unchanged, or joined
+// with the write error, keeping its exit code, if the summary cannot be
+// written.
+func (s *runSummary) write(path string, runErr error) error {
+	if path == "none" {
+		return runErr
+	}
+	s.Finished = time.Now().UTC()
+	s.Duration = s.Finished.Sub(s.Started).Seconds()
+	s.Succeeded = runErr == nil
+	if runErr != nil {
+		s.Error = output.Redact(runErr.Error())
+	}
+
+	bytes, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, bytes, 0644)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed writing summary file: %w", err)
+		if runErr != nil {
+			return &exitError{code: exitCode(runErr), err: fmt.Errorf("%s\n%w", runErr, err)}
+		}
+		return err
+	}
+	return runErr
+}
+
+// summarizeUpload records the counts and failures of an upload run.
+func summarizeUpload(summary *runSummary, pending map[string][]*store.Attachment, oversize []*store.Attachment, blocked, warnings []string, failures []*runFailure, started time.Time) {
+	for _, attachments := range pending {
+		summary.Counts["tickets"]++
+		for _, attachment := range attachments {
+			summary.Counts["attachments"]++
+			if !attachment.UploadedSince(started) {
+				continue
+			}
+			summary.Counts["uploaded"]++
+			for _, sent := range attachment.Sent {
+				summary.Counts["bytes_sent"] += sent.Bytes
+			}
+		}
+	}
+	summary.Counts["oversize"] = int64(len(oversize))
+	summary.Counts["blocked_tickets"] = int64(len(blocked))
+	summary.Counts["render_warnings"] = int64(len(warnings))
+	summary.Counts["failed"] = int64(len(failures))
+	for _, failure := range failures {
+		summary.Failures = append(summary.Failures, &summaryFailure{
+			Ticket:     failure.ticket,
+			Attachment: failure.path,
+			Reason:     output.Redact(failure.err),
+		})
+	}
+}
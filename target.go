@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/collect"
+	"github.com/lindluni/attachment-processor/pkg/match"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/upload"
+	"github.com/thatisuday/commando"
+)
+
+// ticketLister lists the tickets of the target issues are matched to.
+type ticketLister struct {
+	// name describes the tickets in progress messages.
+	name string
+	list func() ([]*store.TicketEntry, error)
+	// perIssue gives every issue a ticket of its own rather than matching
+	// issues to listed tickets, for targets whose tickets are created by
+	// upload.
+	perIssue bool
+	// matcher links issues to tickets in place of the matching strategy,
+	// for tickets carried over from the existing database.
+	matcher match.Matcher
+}
+
+// newTicketLister returns the lister of the target selected by the flags:
+// the JIRA tickets found by the ticket source, the work items of the Azure
+// DevOps project, or a Confluence page per issue.
+func newTicketLister(flags map[string]commando.FlagValue, matching *match.Config) (*ticketLister, error) {
+	target := flags["target"].Value.(string)
+	err := store.ValidTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if target == store.TargetConfluence {
+		if matching.Strategy != match.StrategyTitleExact {
+			return nil, fmt.Errorf("issues are given a page each rather than matched for the %s target, --match-strategy cannot be used", target)
+		}
+		return &ticketLister{name: "Confluence pages", perIssue: true}, nil
+	}
+
+	if target == store.TargetAzureDevOps {
+		if matching.Strategy == match.StrategyCustomField {
+			return nil, fmt.Errorf("the %s strategy reads JIRA custom fields and cannot be used with the %s target", matching.Strategy, target)
+		}
+		ado, err := newAzureDevOpsClient(flags)
+		if err != nil {
+			return nil, err
+		}
+		return &ticketLister{
+			name: "Azure DevOps work items",
+			list: func() ([]*store.TicketEntry, error) {
+				return collect.ListWorkItems(ado)
+			},
+		}, nil
+	}
+
+	source, err := collect.NewTicketSource(flags["jira-keys"].Value.(string), flags["ticket-keys-file"].Value.(string), flags["ticket-key-range"].Value.(string), flags["jira-search-workers"].Value.(int))
+	if err != nil {
+		return nil, err
+	}
+	jira, err := newJIRAClient(flags["jira-secret"].Value.(string), flags["jira-url"].Value.(string))
+	if err != nil {
+		return nil, fmt.Errorf("failed creating JIRA client: %w", err)
+	}
+	return &ticketLister{
+		name: "JIRA tickets",
+		list: func() ([]*store.TicketEntry, error) {
+			return source.List(client.NewJIRA(jira), matching.Field)
+		},
+	}, nil
+}
+
+// newAzureDevOpsClient returns a client of the Azure DevOps project named by
+// the flags. Uploads go through the progress transport so the stall watchdog
+// sees them.
+func newAzureDevOpsClient(flags map[string]commando.FlagValue) (client.AzureDevOps, error) {
+	orgURL := flags["azure-devops-url"].Value.(string)
+	project := flags["azure-devops-project"].Value.(string)
+	token := flags["azure-devops-token"].Value.(string)
+	if orgURL == "none" || project == "none" || token == "none" {
+		return nil, fmt.Errorf("--azure-devops-url, --azure-devops-project, and --azure-devops-token must be specified for the %s target", store.TargetAzureDevOps)
+	}
+	httpClient := &http.Client{Transport: &upload.ProgressTransport{Base: http.DefaultTransport}}
+	return client.NewAzureDevOps(httpClient, orgURL, project, token), nil
+}
+
+// newConfluenceClient returns a client of the Confluence space named by the
+// flags, which upload checks are given before anything else. Uploads go
+// through the progress transport so the stall watchdog sees them.
+func newConfluenceClient(flags map[string]commando.FlagValue) client.Confluence {
+	baseURL := flags["confluence-url"].Value.(string)
+	space := flags["confluence-space"].Value.(string)
+	parent := flags["confluence-parent"].Value.(string)
+	username := flags["confluence-username"].Value.(string)
+	token := flags["confluence-token"].Value.(string)
+	if parent == "none" {
+		parent = ""
+	}
+	if username == "none" {
+		username = ""
+	}
+	httpClient := &http.Client{Transport: &upload.ProgressTransport{Base: http.DefaultTransport}}
+	return client.NewConfluence(httpClient, baseURL, space, parent, username, token)
+}
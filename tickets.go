@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/upload"
+)
+
+// ticketUploads holds what upload needs for each ticket beyond its pending
+// attachments. jira is only set for the JIRA target and pages only for the
+// Confluence target.
+type ticketUploads struct {
+	db             *store.Database
+	target         client.Target
+	pages          client.Confluence
+	jira           *jira.Client
+	lock           *workflowLock
+	bundle         bool
+	uploadLimit    int64
+	content        *contentMigration
+	remoteLink     bool
+	entityProperty string
+	correlation    *commentCorrelation
+	provenance     bool
+	clock          *store.ServerClock
+	runID          string
+	opts           *upload.Options
+}
+
+// run uploads the pending attachments of each ticket in the order of titles.
+// It returns the tickets skipped as blocked by their workflow status and,
+// with --continue-on-error, the error each failed ticket ended with by its
+// title, as a Confluence page that failed to be created has no key.
+func (u *ticketUploads) run(titles []string, pending map[string][]*store.Attachment) ([]string, map[string]error, error) {
+	var blocked []string
+	ticketErrors := make(map[string]error)
+	for _, title := range titles {
+		attachments := pending[title]
+		ticket := u.db.Tickets[title]
+		// Each ticket starts without an error, so one left by the ticket
+		// before cannot fail it.
+		var err error
+		if u.pages != nil {
+			err = upload.Page(u.pages, u.db, title)
+			if err != nil {
+				if !u.opts.ContinueOnError {
+					return nil, nil, err
+				}
+				fmt.Printf("Continuing after failure on %q: %s\n", title, err)
+				ticketErrors[title] = err
+				continue
+			}
+		}
+		status, relock := "", false
+		if u.jira != nil {
+			status, relock, err = prepareTicket(u.jira, ticket.Key, u.lock)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed checking ticket %s: %w", ticket.Key, err)
+			if !u.opts.ContinueOnError {
+				return nil, nil, err
+			}
+			fmt.Printf("Continuing after failure on %s: %s\n", ticket.Key, err)
+			ticketErrors[title] = err
+			continue
+		}
+		if status != "" {
+			fmt.Printf("Ticket %s is blocked in status %s, skipping\n", ticket.Key, status)
+			blocked = append(blocked, fmt.Sprintf("%s (%s)", ticket.Key, status))
+			continue
+		}
+
+		switch {
+		case u.bundle:
+			err = upload.Bundle(u.target, u.db, title, attachments, u.uploadLimit, u.opts)
+		case u.content != nil:
+			err = u.content.migrate(u.jira, u.target, u.db, title, attachments, u.opts)
+		default:
+			err = upload.Ticket(u.target, u.db, title, attachments, u.opts)
+		}
+		if err == nil && u.remoteLink {
+			err = linkGitHubIssue(u.jira, ticket, title, u.db.Issues[title])
+		}
+		if err == nil && u.entityProperty != "none" {
+			err = setMigrationProperty(u.jira, u.db, title, u.entityProperty, u.runID)
+		}
+		if err == nil && u.correlation != nil {
+			err = markComments(u.jira, ticket, attachments, u.correlation)
+		}
+		if err == nil && u.provenance {
+			err = postProvenance(u.jira, ticket, attachments, u.clock, u.opts)
+			if err == nil {
+				err = store.Save(u.db)
+			}
+		}
+		if relock {
+			fmt.Printf("Transitioning %s through %s\n", ticket.Key, u.lock.relock)
+			relockErr := transitionTicket(u.jira, ticket.Key, u.lock.relock)
+			if relockErr != nil && err == nil {
+				err = fmt.Errorf("failed relocking ticket %s: %w", ticket.Key, relockErr)
+			}
+		}
+		if err != nil {
+			if !u.opts.ContinueOnError {
+				return nil, nil, err
+			}
+			fmt.Printf("Continuing after failure on %s: %s\n", ticket.Key, err)
+			ticketErrors[title] = err
+		}
+	}
+	return blocked, ticketErrors, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/client"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/lindluni/attachment-processor/pkg/upload"
+)
+
+// failingTarget is a target refusing every attachment posted to the tickets
+// in fail, and accepting the rest.
+type failingTarget struct {
+	fail   map[string]bool
+	posted map[string][]string
+}
+
+func (t *failingTarget) ListAttachments(ctx context.Context, key string) ([]*client.TargetAttachment, error) {
+	return nil, nil
+}
+
+func (t *failingTarget) PostAttachment(ctx context.Context, key string, r io.Reader, name string) (*client.TargetAttachment, error) {
+	if t.fail[key] {
+		return nil, fmt.Errorf("work item %s refused %s", key, name)
+	}
+	t.posted[key] = append(t.posted[key], name)
+	return &client.TargetAttachment{ID: strconv.Itoa(len(t.posted[key])), Name: name}, nil
+}
+
+func (t *failingTarget) DeleteAttachment(ctx context.Context, key, id string) error {
+	return nil
+}
+
+// newTicketWorkspace stages a file for the issue of each ticket in a
+// temporary directory, pointing the database and staging directory at it
+// for the test.
+func newTicketWorkspace(t *testing.T, titles []string, keys []string) *store.Database {
+	dir := t.TempDir()
+	databaseFile, stageDir := store.DatabaseFile, store.StageDir
+	store.DatabaseFile = filepath.Join(dir, "database.json")
+	store.StageDir = filepath.Join(dir, "stage")
+	t.Cleanup(func() {
+		store.DatabaseFile, store.StageDir = databaseFile, stageDir
+	})
+
+	db := store.New(checksum.SHA256)
+	for i, title := range titles {
+		number := i + 1
+		db.Issues[title] = &store.Issue{Number: number}
+		db.Tickets[title] = &store.Ticket{Key: keys[i]}
+		path := filepath.Join("attachments", strconv.Itoa(number), "log.txt")
+		err := os.MkdirAll(filepath.Join(store.StageDir, filepath.Dir(path)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(store.StageDir, path), []byte("log"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Attachments = append(db.Attachments, &store.Attachment{Type: "issue", IssueNumber: number, Path: path})
+	}
+	err := store.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// TestTicketUploadsContinueAfterFailure fails the upload of the first of two
+// work items with --continue-on-error and checks the second is still
+// uploaded, rather than failed with the error the first left behind.
+func TestTicketUploadsContinueAfterFailure(t *testing.T) {
+	titles := []string{"Crash on save", "Slow search"}
+	db := newTicketWorkspace(t, titles, []string{"101", "102"})
+	target := &failingTarget{fail: map[string]bool{"101": true}, posted: make(map[string][]string)}
+	filter, err := store.ParseIssueFilter("all", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &ticketUploads{
+		db:             db,
+		target:         target,
+		entityProperty: "none",
+		opts:           &upload.Options{OnConflict: upload.ConflictSkip, HashAlgorithm: checksum.SHA256, ContinueOnError: true},
+	}
+	_, ticketErrors, err := u.run(titles, upload.Pending(db, filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(target.posted["102"]) != 1 {
+		t.Errorf("second work item received %v, want its attachment", target.posted["102"])
+	}
+	if ticketErrors["Crash on save"] == nil {
+		t.Error("first work item has no error recorded")
+	}
+	if err, ok := ticketErrors["Slow search"]; ok {
+		t.Errorf("second work item failed with %v", err)
+	}
+	if !db.Tickets["Slow search"].Uploaded {
+		t.Error("second ticket is not marked uploaded")
+	}
+}
+
+// TestRunFailuresReportsTicketErrors checks the errors of failed tickets are
+// reported under their key, or under their title when, like a Confluence
+// page that failed to be created, they have none.
+func TestRunFailuresReportsTicketErrors(t *testing.T) {
+	db := store.New(checksum.SHA256)
+	db.Tickets["Crash on save"] = &store.Ticket{}
+	db.Tickets["Slow search"] = &store.Ticket{Key: "PROJ-2"}
+	ticketErrors := map[string]error{
+		"Crash on save": fmt.Errorf("failed creating page"),
+		"Slow search":   fmt.Errorf("failed checking ticket PROJ-2"),
+	}
+
+	failures := runFailures(db, nil, ticketErrors, time.Now())
+	if len(failures) != 2 || failures[0].ticket != "Crash on save" || failures[1].ticket != "PROJ-2" {
+		t.Errorf("reported %v, want the page by its title and the ticket by its key", failures)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/lindluni/attachment-processor/pkg/tracing"
+	"github.com/thatisuday/commando"
+)
+
+// flushTraces exports the spans still buffered when the run ends. It does
+// nothing unless --otlp-endpoint is given.
+var flushTraces = func() {}
+
+// withTracing wraps a command action so its GitHub and JIRA requests, pages,
+// extracted files, and uploaded attachments are exported as OpenTelemetry
+// spans to the OTLP/HTTP collector given by --otlp-endpoint, labelled with
+// the command and run ID of the audit log.
+func withTracing(action func(map[string]commando.ArgValue, map[string]commando.FlagValue)) func(map[string]commando.ArgValue, map[string]commando.FlagValue) {
+	return func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+		endpoint := flags["otlp-endpoint"].Value.(string)
+		if endpoint == "none" {
+			action(args, flags)
+			return
+		}
+		shutdown, err := tracing.Setup(endpoint, audit.Command, audit.RunID)
+		if err != nil {
+			output.Errorf("Failed configuring tracing: %s\n", err)
+			exit(exitConfig)
+		}
+		flushTraces = func() {
+			flushTraces = func() {}
+			err := shutdown()
+			if err != nil {
+				fmt.Printf("Warning: %s\n", err)
+			}
+		}
+		defer flushTraces()
+		action(args, flags)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/thatisuday/clapper"
+	"github.com/thatisuday/commando"
+)
+
+// checkUsage parses the command line as commando would and fails on the
+// mistakes commando reports, such as an unknown flag or a required flag
+// left out. commando exits with code 0 after reporting them, so they are
+// caught first to exit with exitConfig instead. Help and version requests
+// are left to commando.
+func checkUsage(registry *commando.CommandRegistry, args []string) error {
+	parser := clapper.NewRegistry()
+	for name, command := range registry.Commands {
+		config, _ := parser.Register(name)
+		for _, flag := range command.Flags {
+			name := flag.ClpFlag.Name
+			if flag.ClpFlag.IsInverted {
+				name = "no-" + name
+			}
+			config.AddFlag(name, flag.ClpFlag.ShortName, flag.ClpFlag.IsBoolean, flag.ClpFlag.DefaultValue)
+		}
+		for _, arg := range orderedArgs(command) {
+			name := arg.ClpArg.Name
+			if arg.ClpArg.IsVariadic {
+				name += "..."
+			}
+			config.AddArg(name, arg.ClpArg.DefaultValue)
+		}
+	}
+
+	result, err := parser.Parse(args)
+	switch err := err.(type) {
+	case nil:
+	case clapper.ErrorUnknownCommand:
+		return fmt.Errorf("%s is not a valid command", err.Name)
+	case clapper.ErrorUnknownFlag:
+		return fmt.Errorf("%s is not a valid flag", err.Name)
+	case clapper.ErrorUnsupportedFlag:
+		return fmt.Errorf("%s is not a supported flag", err.Name)
+	default:
+		return err
+	}
+	if result.Name == "help" || result.Name == "version" || result.Flags["help"].Value == "true" || result.Flags["version"] != nil && result.Flags["version"].Value == "true" {
+		return nil
+	}
+
+	command := registry.Commands[result.Name]
+	for name, arg := range command.Args {
+		if arg.IsRequired && result.Args[name].Value == "" && result.Args[name].DefaultValue == "" {
+			return fmt.Errorf("value of the %s argument can not be empty", name)
+		}
+	}
+	for _, name := range sortedFlagNames(command) {
+		flag := command.Flags[name]
+		value := result.Flags[name].Value
+		if value == "" {
+			value = result.Flags[name].DefaultValue
+		}
+		if flag.IsRequired && value == "" {
+			return fmt.Errorf("value of the --%s flag can not be empty", name)
+		}
+		if flag.DataType == commando.Int {
+			_, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("value of the --%s flag must be an integer", name)
+			}
+		}
+	}
+	return nil
+}
+
+// orderedArgs returns the arguments of the command in the order commando
+// requires them to be registered: required ones, then those with a
+// default, then the variadic one.
+func orderedArgs(command *commando.Command) []*commando.Arg {
+	var args []*commando.Arg
+	for _, arg := range command.Args {
+		args = append(args, arg)
+	}
+	rank := func(arg *commando.Arg) int {
+		switch {
+		case arg.ClpArg.IsVariadic:
+			return 2
+		case arg.IsRequired:
+			return 0
+		}
+		return 1
+	}
+	sort.Slice(args, func(i, j int) bool {
+		if rank(args[i]) != rank(args[j]) {
+			return rank(args[i]) < rank(args[j])
+		}
+		return args[i].ClpArg.Name < args[j].ClpArg.Name
+	})
+	return args
+}
+
+// sortedFlagNames returns the names of the command's flags in order, so the
+// same mistake is reported first on every run.
+func sortedFlagNames(command *commando.Command) []string {
+	var names []string
+	for name := range command.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// accountIDPrefix marks a JIRA Cloud account ID in the user map, as in JIRA's
+// own mention markup, where a bare value is a JIRA Server username.
+const accountIDPrefix = "accountid:"
+
+// userMap maps GitHub logins to the JIRA users migrated content is attributed
+// to. A nil userMap maps no one.
+type userMap map[string]string
+
+// loadUserMap reads the CSV file of GitHub logins and JIRA users at path,
+// one pair per row, with an optional github,jira header row. JIRA Cloud users
+// are given as accountid:<account-id> and JIRA Server users by username.
+func loadUserMap(path string) (userMap, error) {
+	if path == "none" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading user map: %w", err)
+	}
+	defer file.Close()
+
+	users := make(userMap)
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed reading user map: %w", err)
+		}
+		login, user := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if row == 1 && strings.EqualFold(login, "github") && strings.EqualFold(user, "jira") {
+			continue
+		}
+		if login == "" || user == "" || user == accountIDPrefix {
+			return nil, fmt.Errorf("invalid user map row %d: both a GitHub login and a JIRA user are required", row)
+		}
+		users[strings.ToLower(login)] = user
+	}
+	return users, nil
+}
+
+// mention returns JIRA markup naming the GitHub user: a mention of the JIRA
+// user they map to, or their login in bold when they map to no one.
+func (u userMap) mention(login string) string {
+	user, ok := u[strings.ToLower(login)]
+	if !ok {
+		return fmt.Sprintf("*%s*", login)
+	}
+	return fmt.Sprintf("[~%s]", user)
+}
+
+// reporter returns the reporter field naming the JIRA user the GitHub user
+// maps to, or nil when they map to no one. The field is built by hand as
+// go-jira's User marshals fields JIRA does not expect.
+func (u userMap) reporter(login string) map[string]string {
+	user, ok := u[strings.ToLower(login)]
+	if !ok {
+		return nil
+	}
+	if strings.HasPrefix(user, accountIDPrefix) {
+		return map[string]string{"accountId": strings.TrimPrefix(user, accountIDPrefix)}
+	}
+	return map[string]string{"name": user}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/output"
+	"github.com/thatisuday/commando"
+)
+
+// withOutput wraps a command action so it prints at the level chosen with
+// --quiet, --verbose, or --debug, which -q, -v, and -vv stand for, with
+// every line started by the run ID when the output is not a terminal.
+// Commands printing nothing more with -v declare --quiet alone.
+func withOutput(action func(map[string]commando.ArgValue, map[string]commando.FlagValue)) func(map[string]commando.ArgValue, map[string]commando.FlagValue) {
+	return func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+		quiet := flags["quiet"].Value.(bool)
+		verbose, _ := flags["verbose"].Value.(bool)
+		debug, _ := flags["debug"].Value.(bool)
+		switch {
+		case quiet && (verbose || debug):
+			output.Errorf("Failed configuring output: -q cannot be used with -v or -vv\n")
+			exit(exitConfig)
+		case quiet:
+			err := output.Quiet()
+			if err != nil {
+				output.Errorf("Failed configuring output: %s\n", err)
+				exit(exitConfig)
+			}
+		case debug:
+			output.Verbosity = output.LevelDebug
+		case verbose:
+			output.Verbosity = output.LevelVerbose
+		}
+		// Logs collected from automation tell the lines of concurrent runs
+		// apart by their run ID, which a terminal only shows with -v.
+		if output.Interactive() {
+			output.Detailf("Run %s\n", audit.RunID)
+		} else {
+			output.SetPrefix(audit.RunID + " ")
+		}
+		action(args, flags)
+	}
+}
+
+// expandVerbosity replaces -vv in the arguments with --debug, as commando
+// only takes single letter short flags, for the commands declaring --debug.
+// The other commands print all they have to say already, so -v and -vv are
+// dropped for them rather than refused as unknown flags.
+func expandVerbosity(registry *commando.CommandRegistry, args []string) []string {
+	if len(args) < 2 {
+		return args
+	}
+	command, ok := registry.Commands[args[1]]
+	if !ok {
+		return args
+	}
+	_, declared := command.Flags["debug"]
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i > 1 && (arg == "-v" || arg == "-vv") {
+			switch {
+			case !declared:
+				continue
+			case arg == "-vv":
+				arg = "--debug"
+			}
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// debugTransport prints every request and the status of its response, for
+// -vv.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	u.User = nil
+	started := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		output.Debugf("%s %s failed after %s: %s\n", req.Method, u.String(), elapsed, err)
+		return nil, err
+	}
+	output.Debugf("%s %s %s in %s\n", req.Method, u.String(), resp.Status, elapsed)
+	return resp, nil
+}
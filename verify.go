@@ -0,0 +1,297 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/lindluni/attachment-processor/pkg/audit"
+	"github.com/lindluni/attachment-processor/pkg/checksum"
+	"github.com/lindluni/attachment-processor/pkg/store"
+	"github.com/thatisuday/commando"
+)
+
+func verify(flags map[string]commando.FlagValue) error {
+	jiraURL := flags["jira-url"].Value.(string)
+	_ = flags["jira-username"].Value.(string)
+	jiraSecret := flags["jira-secret"].Value.(string)
+	checksums := flags["checksums"].Value.(bool)
+	chain := flags["chain"].Value.(bool)
+	statementPath := flags["statement"].Value.(string)
+	fips := flags["fips"].Value.(bool)
+
+	marker, err := newSourceMarker(flags, jiraURL)
+	if err != nil {
+		return configError(err)
+	}
+
+	jira, err := newJIRAClient(jiraSecret, jiraURL)
+	if err != nil {
+		return configError(fmt.Errorf("failed creating JIRA client: %w", err))
+	}
+
+	db, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	algorithm := db.Algorithm()
+	if fips {
+		err = checksum.Valid(algorithm, fips)
+		if err != nil {
+			return configError(err)
+		}
+	}
+
+	statement := &integrityStatement{
+		Algorithm: algorithm,
+		Generated: time.Now().UTC(),
+		Chain:     chain,
+		Checksums: checksums,
+	}
+	failedIssues := make(map[int]bool)
+	for _, attachment := range db.Attachments {
+		if len(attachment.JIRAIDs) == 0 || attachment.Deleted {
+			continue
+		}
+		var err error
+		switch {
+		case chain:
+			err = verifyChain(jira, attachment, checksums, algorithm)
+		case checksums:
+			err = verifyChecksum(jira, attachment, algorithm)
+		default:
+			err = verifyExists(jira, attachment)
+		}
+		statement.add(attachment, err)
+		if err != nil {
+			fmt.Printf("[%s] %s: %s\n", checkFail, attachment.Path, err)
+			failedIssues[attachment.IssueNumber] = true
+			attachment.Verified = nil
+			continue
+		}
+		attachment.Verified = &store.Verification{
+			Time:      time.Now().UTC(),
+			Checksums: checksums,
+			RunID:     audit.RunID,
+		}
+	}
+	verified, failed := statement.Verified, statement.Failed
+	// The results are recorded for gc --verified, which only prunes the
+	// staged files of attachments found intact.
+	err = store.Save(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Verified %d attachments\n", verified)
+	if statementPath != "none" {
+		err = statement.write(statementPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote integrity statement to %s\n", statementPath)
+	}
+	if marker != nil {
+		marked, err := marker.markVerified(db, failedIssues)
+		if marked > 0 {
+			fmt.Printf("Marked %d GitHub issues as migrated\n", marked)
+		}
+		if err != nil {
+			return fmt.Errorf("failed marking GitHub issues: %w", err)
+		}
+	}
+	if failed > 0 {
+		return partialFailure(fmt.Errorf("%d attachments failed verification", failed))
+	}
+
+	return nil
+}
+
+// verifyExists checks every JIRA attachment recorded for the attachment is
+// still present.
+func verifyExists(client *jira.Client, attachment *store.Attachment) error {
+	for _, id := range attachment.JIRAIDs {
+		req, err := client.NewRequest("GET", fmt.Sprintf("rest/api/2/attachment/%s", id), nil)
+		if err != nil {
+			return fmt.Errorf("failed creating request: %w", err)
+		}
+		resp, err := client.Do(req, nil)
+		if err != nil {
+			return fmt.Errorf("attachment %s not found: %w", id, jiraRefused(resp, err))
+		}
+	}
+	return nil
+}
+
+// verifyChecksum downloads the uploaded content and compares its checksum with
+// the one recorded during collection, or when the image was downscaled with
+// that of the copy uploaded. Split attachments are reassembled and the
+// original file is read back out of the zip before hashing, as is the entry
+// of an attachment uploaded in a per-ticket bundle.
+func verifyChecksum(client *jira.Client, attachment *store.Attachment, algorithm string) error {
+	if attachment.Checksum == "" {
+		return fmt.Errorf("no checksum recorded during collection")
+	}
+
+	var content bytes.Buffer
+	for _, id := range attachment.JIRAIDs {
+		resp, err := client.Issue.DownloadAttachment(id)
+		if err != nil {
+			return fmt.Errorf("failed downloading attachment %s: %w", id, jiraRefused(resp, err))
+		}
+		_, err = io.Copy(&content, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed reading attachment %s: %w", id, err)
+		}
+	}
+
+	var r io.Reader = &content
+	if attachment.Bundled != "" {
+		zr, err := zip.NewReader(bytes.NewReader(content.Bytes()), int64(content.Len()))
+		if err != nil {
+			return fmt.Errorf("failed reading bundle: %w", err)
+		}
+		entry, err := zr.Open(attachment.Bundled)
+		if err != nil {
+			return fmt.Errorf("failed reading %s from bundle: %w", attachment.Bundled, err)
+		}
+		defer entry.Close()
+		r = entry
+	} else if len(attachment.Parts) > 0 {
+		zr, err := zip.NewReader(bytes.NewReader(content.Bytes()), int64(content.Len()))
+		if err != nil {
+			return fmt.Errorf("failed reading split volumes: %w", err)
+		}
+		if len(zr.File) != 1 {
+			return fmt.Errorf("expected one file in split volumes, found %d", len(zr.File))
+		}
+		rc, err := zr.File[0].Open()
+		if err != nil {
+			return fmt.Errorf("failed reading split volumes: %w", err)
+		}
+		defer rc.Close()
+		r = rc
+	}
+
+	sum, err := checksum.Sum(r, algorithm)
+	if err != nil {
+		return fmt.Errorf("failed hashing downloaded content: %w", err)
+	}
+	if sum != attachment.UploadChecksum() {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", attachment.UploadChecksum(), sum)
+	}
+	return nil
+}
+
+// verifyChain checks every recorded hop of the attachment agrees with the
+// next: the archive member with the staged file, the staged file with the
+// bytes sent, the bytes sent with the size JIRA reported on upload and with
+// the size JIRA reports now. When download is set the content held by JIRA
+// is also hashed and compared with the bytes sent.
+func verifyChain(client *jira.Client, attachment *store.Attachment, download bool, algorithm string) error {
+	if len(attachment.Sent) == 0 {
+		return fmt.Errorf("no upload record, the attachment was uploaded before sent checksums were recorded")
+	}
+	if attachment.ArchiveChecksum != "" && attachment.ArchiveChecksum != attachment.Checksum {
+		return fmt.Errorf("staged file %s differs from archive member %s", attachment.Checksum, attachment.ArchiveChecksum)
+	}
+	if len(attachment.Parts) == 0 && attachment.Bundled == "" && attachment.Sent[0].Checksum != attachment.UploadChecksum() {
+		return fmt.Errorf("bytes sent %s differ from staged file %s", attachment.Sent[0].Checksum, attachment.UploadChecksum())
+	}
+
+	for _, sent := range attachment.Sent {
+		if sent.JIRASize != sent.Bytes {
+			return fmt.Errorf("JIRA reported %d bytes for %s on upload but %d were sent", sent.JIRASize, sent.Name, sent.Bytes)
+		}
+		req, err := client.NewRequest("GET", fmt.Sprintf("rest/api/2/attachment/%s", sent.JIRAID), nil)
+		if err != nil {
+			return fmt.Errorf("failed creating request: %w", err)
+		}
+		var meta jira.Attachment
+		resp, err := client.Do(req, &meta)
+		if err != nil {
+			return fmt.Errorf("attachment %s not found: %w", sent.JIRAID, jiraRefused(resp, err))
+		}
+		if int64(meta.Size) != sent.Bytes {
+			return fmt.Errorf("JIRA reports %d bytes for %s but %d were sent", meta.Size, sent.Name, sent.Bytes)
+		}
+		if !download {
+			continue
+		}
+		resp, err = client.Issue.DownloadAttachment(sent.JIRAID)
+		if err != nil {
+			return fmt.Errorf("failed downloading attachment %s: %w", sent.JIRAID, jiraRefused(resp, err))
+		}
+		sum, err := checksum.Sum(resp.Body, algorithm)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed hashing downloaded content: %w", err)
+		}
+		if sum != sent.Checksum {
+			return fmt.Errorf("content held by JIRA for %s is %s but %s was sent", sent.Name, sum, sent.Checksum)
+		}
+	}
+
+	if download && (len(attachment.Parts) > 0 || attachment.Bundled != "") {
+		return verifyChecksum(client, attachment, algorithm)
+	}
+	return nil
+}
+
+// integrityStatement is the record of a verify run written with --statement.
+type integrityStatement struct {
+	Generated   time.Time    `json:"generated"`
+	Algorithm   string       `json:"algorithm"`
+	Chain       bool         `json:"chain"`
+	Checksums   bool         `json:"checksums"`
+	Verified    int          `json:"verified"`
+	Failed      int          `json:"failed"`
+	Attachments []*chainLink `json:"attachments"`
+}
+
+// chainLink is one attachment's entry in the integrity statement.
+type chainLink struct {
+	Path            string            `json:"path"`
+	ArchiveChecksum string            `json:"archive_checksum,omitempty"`
+	StagedChecksum  string            `json:"staged_checksum,omitempty"`
+	Sent            []*store.SentFile `json:"sent,omitempty"`
+	Status          string            `json:"status"`
+	Detail          string            `json:"detail,omitempty"`
+}
+
+func (s *integrityStatement) add(attachment *store.Attachment, err error) {
+	link := &chainLink{
+		Path:            attachment.Path,
+		ArchiveChecksum: attachment.ArchiveChecksum,
+		StagedChecksum:  attachment.Checksum,
+		Sent:            attachment.Sent,
+		Status:          checkPass,
+	}
+	if err != nil {
+		link.Status = checkFail
+		link.Detail = err.Error()
+		s.Failed++
+	} else {
+		s.Verified++
+	}
+	s.Attachments = append(s.Attachments, link)
+}
+
+func (s *integrityStatement) write(path string) error {
+	bytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshalling integrity statement: %w", err)
+	}
+	err = os.WriteFile(path, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing integrity statement: %w", err)
+	}
+	return nil
+}